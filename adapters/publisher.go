@@ -2,49 +2,100 @@ package adapters
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/hublabs/common/auth"
 	"github.com/pangpanglabs/goutils/behaviorlog"
+	"github.com/pangpanglabs/goutils/jwtutil"
 	"github.com/pangpanglabs/goutils/kafka"
 )
 
 var eventMessagePublisher *MessagePublisher
 
+var ErrMessagePublisherNotConfigured = errors.New("message publisher is not configured")
+
 const (
-	EventProductCreated      = "ProductCreated"
-	EventProductChanged      = "ProductChanged"
-	EventProductPriceChanged = "ProductPriceChanged"
-	EventProductUidChanged   = "ProductUidChanged"
-	EventSkuAdded            = "SkuAdded"
-	EventSkuChanged          = "SkuChanged"
-	EventSkuUidChanged       = "SkuUidChanged"
+	EventProductCreated       = "ProductCreated"
+	EventProductChanged       = "ProductChanged"
+	EventProductDeleted       = "ProductDeleted"
+	EventProductRestored      = "ProductRestored"
+	EventProductPriceChanged  = "ProductPriceChanged"
+	EventProductPricesChanged = "ProductPricesChanged"
+	EventProductUidChanged    = "ProductUidChanged"
+	EventSkuAdded             = "SkuAdded"
+	EventSkuChanged           = "SkuChanged"
+	EventSkuRemoved           = "SkuRemoved"
+	EventSkuRestored          = "SkuRestored"
+	EventSkuUidChanged        = "SkuUidChanged"
 )
 
 type MessagePublisher struct {
-	producer *kafka.Producer
+	topic    string
+	producer sarama.SyncProducer
 }
 
 type Payload interface {
 	ToEvent(ctx context.Context) interface{}
 }
 
+// EventMessage is the envelope sent to the event broker.
+// Payload is kept as raw json so that a message rebuilt from the outbox is byte-identical to the original one.
+// AuthToken is signed by Send for the tenant and the colleague of the write, so that no token of a request is kept.
+type EventMessage struct {
+	AuthToken   string          `json:"authToken"`
+	TenantCode  string          `json:"-"`
+	ColleagueId int64           `json:"-"`
+	RequestId   string          `json:"requestId"`
+	Status      string          `json:"status"`
+	Payload     json.RawMessage `json:"payload"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+func NewEventMessage(ctx context.Context, payload Payload, status string) (EventMessage, error) {
+	b, err := json.Marshal(payload.ToEvent(ctx))
+	if err != nil {
+		return EventMessage{}, err
+	}
+	user := auth.UserClaim{}.FromCtx(ctx)
+	return EventMessage{
+		TenantCode:  user.TenantCode,
+		ColleagueId: user.ColleagueId,
+		RequestId:   behaviorlog.FromCtx(ctx).RequestID,
+		Status:      status,
+		Payload:     b,
+		CreatedAt:   time.Now().UTC(),
+	}, nil
+}
+
+// serviceToken is the token of the service acting for the colleague of tenant, signed with the jwt secret.
+func serviceToken(tenantCode string, colleagueId int64) (string, error) {
+	return jwtutil.NewToken(map[string]interface{}{
+		"aud":         "colleague",
+		"iss":         "product-api",
+		"tenantCode":  tenantCode,
+		"colleagueId": colleagueId,
+	})
+}
+
 func SetupMessagePublisher(kafkaConfig kafka.Config) error {
 	if len(kafkaConfig.Brokers) == 0 {
 		return nil
 	}
 
-	producer, err := kafka.NewProducer(kafkaConfig.Brokers, kafkaConfig.Topic, func(c *sarama.Config) {
-		c.Producer.RequiredAcks = sarama.WaitForLocal       // Only wait for the leader to ack
-		c.Producer.Compression = sarama.CompressionGZIP     // Compress messages
-		c.Producer.Flush.Frequency = 500 * time.Millisecond // Flush batches every 500ms
-	})
-
+	c := sarama.NewConfig()
+	c.Producer.RequiredAcks = sarama.WaitForLocal   // Only wait for the leader to ack
+	c.Producer.Compression = sarama.CompressionGZIP // Compress messages
+	c.Producer.Return.Successes = true              // Required by sync producer
+	producer, err := sarama.NewSyncProducer(kafkaConfig.Brokers, c)
 	if err != nil {
 		return err
 	}
 
 	eventMessagePublisher = &MessagePublisher{
+		topic:    kafkaConfig.Topic,
 		producer: producer,
 	}
 	return nil
@@ -56,16 +107,27 @@ func (MessagePublisher) Close() {
 	}
 }
 
-func (MessagePublisher) Publish(ctx context.Context, payload Payload, status string) error {
+func (MessagePublisher) Configured() bool {
+	return eventMessagePublisher != nil
+}
+
+// Send delivers the message synchronously, so a nil error means the broker has acknowledged it.
+func (MessagePublisher) Send(m EventMessage) error {
 	if eventMessagePublisher == nil {
-		return nil
+		return ErrMessagePublisherNotConfigured
+	}
+	token, err := serviceToken(m.TenantCode, m.ColleagueId)
+	if err != nil {
+		return err
 	}
-	m := map[string]interface{}{
-		"authToken": behaviorlog.FromCtx(ctx).AuthToken,
-		"requestId": behaviorlog.FromCtx(ctx).RequestID,
-		"status":    status,
-		"payload":   payload.ToEvent(ctx),
-		"createdAt": time.Now().UTC(),
+	m.AuthToken = token
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
 	}
-	return eventMessagePublisher.producer.Send(m)
+	_, _, err = eventMessagePublisher.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: eventMessagePublisher.topic,
+		Value: sarama.ByteEncoder(b),
+	})
+	return err
 }
@@ -18,7 +18,9 @@ func Init(appEnv string, options ...func(*C)) C {
 
 	if s := os.Getenv("JWT_SECRET"); s != "" {
 		config.JwtSecret = s
-		jwtutil.SetJwtSecret(s)
+	}
+	if config.JwtSecret != "" {
+		jwtutil.SetJwtSecret(config.JwtSecret)
 	}
 
 	for _, option := range options {
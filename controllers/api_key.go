@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/hublabs/common/api"
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/echoswagger"
+)
+
+type ApiKeyController struct{}
+
+func (c ApiKeyController) Init(g echoswagger.ApiGroup) {
+	g.SetSecurity("Authorization")
+
+	// ERP同步、POS终端等系统调用使用的API Key, 只在创建时返回一次
+	permit(g.GET("", c.GetAll), models.PermissionApiKeyManage).
+		AddParamQueryNested(PagingInput{})
+	permit(g.GET("/:id", c.GetOne), models.PermissionApiKeyManage).
+		AddParamPath(0, "id", "Id of ApiKey")
+	permit(g.POST("", c.Create), models.PermissionApiKeyManage).
+		AddParamBody(ApiKeyInput{}, "body", "ApiKeyInput model", true)
+	permit(g.DELETE("/:id", c.Revoke), models.PermissionApiKeyManage).
+		AddParamPath(0, "id", "Id of ApiKey")
+}
+
+func (ApiKeyController) GetAll(c echo.Context) error {
+	var v PagingInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if err := c.Validate(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if v.MaxResultCount == 0 {
+		v.MaxResultCount = defaultMaxResultCount
+	}
+	totalCount, keys, err := models.ApiKey{}.GetAll(c.Request().Context(), v.SkipCount, v.MaxResultCount)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, totalCount, keys)
+}
+
+func (ApiKeyController) GetOne(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	k, err := models.ApiKey{}.Get(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if k == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderSucc(c, http.StatusOK, k)
+}
+
+func (ApiKeyController) Create(c echo.Context) error {
+	var v ApiKeyInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	k := v.ToModel()
+	if err := k.Create(c.Request().Context()); errors.Is(err, models.ErrApiKeyNameRequired) ||
+		errors.Is(err, models.ErrInvalidApiKeyScope) || errors.Is(err, models.ErrApiKeyExpiresAt) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if errors.Is(err, models.ErrApiKeyScopeDenied) {
+		return renderFail(c, api.ErrorPermissionDenied.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, k)
+}
+
+func (ApiKeyController) Revoke(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	k, err := models.ApiKey{}.Revoke(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if k == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderSucc(c, http.StatusOK, k)
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hublabs/common/api"
+	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/echoswagger"
+	"github.com/pangpanglabs/goutils/jwtutil"
+)
+
+// TenantRequiredMiddleware rejects the requests whose token names no tenant, except those under skipPaths.
+// The models fail closed on them as well, this turns them away before a handler runs.
+func TenantRequiredMiddleware(skipPaths ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, p := range skipPaths {
+				if strings.HasPrefix(c.Path(), p) {
+					return next(c)
+				}
+			}
+			userClaim := auth.UserClaim{}.FromCtx(c.Request().Context())
+			if userClaim.TenantCode == "" {
+				return renderFail(c, api.ErrorPermissionDenied.New(models.ErrTenantRequired))
+			}
+			return next(c)
+		}
+	}
+}
+
+// ApiKeyHeader carries the api key of a machine client, which is accepted instead of a token.
+const ApiKeyHeader = "X-Api-Key"
+
+// ApiKeyMiddleware authenticates the requests carrying an api key, putting the claim and the permissions of the key
+// into the context where a token would put them. The requests without one are handed to bearer, which authenticates by token.
+// It runs before echomiddleware.ContextDB, as the key is looked up on a session of its own.
+func ApiKeyMiddleware(bearer echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		byToken := bearer(next)
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(ApiKeyHeader)
+			if key == "" {
+				return byToken(c)
+			}
+			req := c.Request()
+			apiKey, err := models.ApiKey{}.Authenticate(req.Context(), key)
+			if errors.Is(err, models.ErrInvalidApiKey) || errors.Is(err, models.ErrApiKeyExpired) {
+				return renderFail(c, api.ErrorTokenInvaild.New(err))
+			} else if err != nil {
+				return renderFail(c, api.ErrorDB.New(err))
+			}
+			ctx := models.WithUserClaim(req.Context(), apiKey.Claim())
+			ctx = models.WithPermissions(ctx, apiKey.Permissions())
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// UserRolesMiddleware reads the roles claim of the token into the context of the request,
+// with the permissions the roles grant and those of the permissions claim.
+// auth.UserClaimMiddleware only decodes the token, so the signature is verified here before any claim is trusted.
+func UserRolesMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// the permissions of an api key are in the context already
+			if models.PermissionsGranted(c.Request().Context()) {
+				return next(c)
+			}
+			claims, err := claimsFromToken(c.Request().Header.Get(echo.HeaderAuthorization))
+			if err != nil {
+				return renderFail(c, api.ErrorTokenInvaild.New(err))
+			}
+			req := c.Request()
+			ctx := req.Context()
+			if len(claims.Roles) != 0 {
+				ctx = models.WithRoles(ctx, claims.Roles)
+			}
+			ctx = models.WithPermissions(ctx, models.GrantPermissions(claims.Roles, claims.Permissions))
+			c.SetRequest(req.WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+type tokenClaims struct {
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+// claimsFromToken reads the claims of a token whose signature it has verified by the jwt secret.
+// A request without a token, on a path auth.UserClaimMiddleware skips, has no claims.
+func claimsFromToken(header string) (tokenClaims, error) {
+	var claims tokenClaims
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer"))
+	if token == "" {
+		return claims, nil
+	}
+	verified, err := jwtutil.Extract(token)
+	if err != nil {
+		return claims, err
+	}
+	b, err := json.Marshal(verified)
+	if err != nil {
+		return claims, err
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return tokenClaims{}, err
+	}
+	return claims, nil
+}
+
+// routePermissions holds the permission each route needs by method and path, as declared by permit.
+var routePermissions = map[string]models.Permission{}
+
+// permit declares that the route of a needs permission p, which PermissionMiddleware enforces and the Swagger of the route documents.
+func permit(a echoswagger.Api, p models.Permission) echoswagger.Api {
+	route := a.Route()
+	routePermissions[route.Method+" "+routePath(route.Path)] = p
+	return a.SetDescription(fmt.Sprintf("Requires the `%s` permission.", p)).
+		AddResponse(http.StatusForbidden, fmt.Sprintf("The caller lacks the `%s` permission", p), api.Result{}, nil)
+}
+
+// routePath is path as the router matches it, the paths of the routes of an echoswagger group have no leading slash.
+func routePath(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
+
+// PermissionMiddleware rejects the requests of callers lacking the permission declared for their route by permit,
+// and those of the routes which declare none, except under skipPaths.
+// It runs after UserRolesMiddleware, which puts the permissions of the caller into the context.
+func PermissionMiddleware(skipPaths ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for _, p := range skipPaths {
+				if strings.HasPrefix(c.Path(), p) {
+					return next(c)
+				}
+			}
+			p, ok := routePermissions[c.Request().Method+" "+c.Path()]
+			if !ok {
+				return renderFail(c, api.ErrorPermissionDenied.New(fmt.Errorf("%s %s declares no permission", c.Request().Method, c.Path())))
+			}
+			if !models.HasPermission(c.Request().Context(), p) {
+				return renderFail(c, api.ErrorPermissionDenied.New(fmt.Errorf("%s permission required", p)))
+			}
+			return next(c)
+		}
+	}
+}
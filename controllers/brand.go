@@ -1,7 +1,7 @@
 package controllers
 
 import (
-	"net/http"
+	"errors"
 	"strconv"
 
 	"github.com/hublabs/common/api"
@@ -15,17 +15,19 @@ import (
 type BrandController struct{}
 
 func (c BrandController) Init(g echoswagger.ApiGroup) {
-	g.SetSecurity("Authorization")
+	g.SetSecurity("Authorization").SetSecurity(ApiKeyHeader)
 
-	g.GET("", c.GetAll).
+	permit(g.GET("", c.GetAll), models.PermissionCatalogRead).
 		AddParamQueryNested(GetAllBrandInput{})
-	g.GET("/:id", c.GetOne).
-		AddParamPath(0, "id", "Id of Brand")
-	g.POST("", c.Create).
-		AddParamBody(models.Brand{}, "body", "Brand model", true)
-	g.PUT("/:id", c.Update).
+	permit(g.GET("/:id", c.GetOne), models.PermissionCatalogRead).
 		AddParamPath(0, "id", "Id of Brand").
+		AddParamHeader("", "If-None-Match", "ETag of the cached Brand", false)
+	permit(g.POST("", c.Create), models.PermissionCatalogWrite).
 		AddParamBody(models.Brand{}, "body", "Brand model", true)
+	permit(g.PUT("/:id", c.Update), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Brand").
+		AddParamBody(models.Brand{}, "body", "Brand model", true).
+		AddParamHeader("", "If-Match", "ETag of the Brand being updated", true)
 }
 
 func (BrandController) GetAll(c echo.Context) error {
@@ -61,7 +63,7 @@ func (BrandController) GetOne(c echo.Context) error {
 	if brand == nil {
 		return renderFail(c, api.ErrorNotFound.New(err))
 	}
-	return renderSucc(c, http.StatusOK, brand)
+	return renderSuccWithETag(c, brand.Version, brand)
 }
 
 func (BrandController) Create(c echo.Context) error {
@@ -69,10 +71,12 @@ func (BrandController) Create(c echo.Context) error {
 	if err := c.Bind(&brand); err != nil {
 		return renderFail(c, api.ErrorParameter.New(err))
 	}
-	if err := brand.Create(c.Request().Context()); err != nil {
+	if err := brand.Create(c.Request().Context()); errors.Is(err, models.ErrBrandExists) {
+		return renderFail(c, api.ErrorHasExisted.New(err))
+	} else if err != nil {
 		return renderFail(c, api.ErrorDB.New(err))
 	}
-	return renderSucc(c, http.StatusOK, brand)
+	return renderSuccWithETag(c, brand.Version, brand)
 }
 
 func (BrandController) Update(c echo.Context) error {
@@ -81,14 +85,38 @@ func (BrandController) Update(c echo.Context) error {
 		return renderFail(c, api.ErrorParameter.New(err))
 	}
 
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return renderFail(c, err)
+	}
+
 	var brand models.Brand
 	if err := c.Bind(&brand); err != nil {
 		return renderFail(c, api.ErrorParameter.New(err))
 	}
 
 	brand.Id = id
-	if err := brand.Update(c.Request().Context()); err != nil {
+	brand.Version = version
+	if err := brand.Update(c.Request().Context()); errors.Is(err, models.ErrVersionConflict) {
+		return renderBrandConflict(c, err, id)
+	} else if errors.Is(err, models.ErrBrandExists) {
+		return renderFail(c, api.ErrorHasExisted.New(err))
+	} else if err != nil {
 		return renderFail(c, api.ErrorDB.New(err))
 	}
-	return renderSucc(c, http.StatusOK, brand)
+	return renderSuccWithETag(c, brand.Version, brand)
+}
+
+func renderBrandConflict(c echo.Context, conflict error, id int64) error {
+	if err := rollback(c); err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	current, err := models.Brand{}.GetById(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if current == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderConflict(c, conflict, current.Version, current)
 }
@@ -73,4 +73,49 @@ func TestBrandCRUD(t *testing.T) {
 		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
 		test.Equals(t, v.Result.Code, "EE")
 	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		pb, _ := json.Marshal(inputs[0])
+		req := httptest.NewRequest(echo.POST, "/v1/brands", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(BrandController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("OtherTenant", func(t *testing.T) {
+		pb, _ := json.Marshal(inputs[0])
+		req := httptest.NewRequest(echo.POST, "/v1/brands", bytes.NewReader(pb))
+		setHeaderWithTenant(req, "other")
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(BrandController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+		var created struct {
+			Result models.Brand `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+		req = httptest.NewRequest(echo.GET, "/v1/brands", nil)
+		setHeaderWithTenant(req, "other")
+		rec = httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(BrandController{}.GetAll, echoApp.NewContext(req, rec)))
+		var v struct {
+			Result struct {
+				TotalCount int `json:"totalCount"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.TotalCount, 1)
+
+		// the brand of the other tenant is not found by tenant test
+		req = httptest.NewRequest(echo.GET, fmt.Sprintf("/v1/brands/%d", created.Result.Id), nil)
+		setHeader(req)
+		rec = httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/brands/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprint(created.Result.Id))
+		test.Ok(t, handleWithFilter(BrandController{}.GetOne, c))
+		test.Equals(t, http.StatusNotFound, rec.Code)
+	})
 }
@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hublabs/common/api"
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/echoswagger"
+)
+
+type CurrencyController struct{}
+
+func (c CurrencyController) Init(g echoswagger.ApiGroup) {
+	g.SetSecurity("Authorization").SetSecurity(ApiKeyHeader)
+
+	permit(g.GET("/default", c.GetDefault), models.PermissionCatalogRead)
+	permit(g.PUT("/default", c.SetDefault), models.PermissionPriceWrite).
+		AddParamBody(DefaultCurrencyInput{}, "body", "DefaultCurrencyInput model", true)
+	permit(g.GET("/exchange-rates", c.GetExchangeRates), models.PermissionCatalogRead)
+	// 导入汇率
+	permit(g.PUT("/exchange-rates", c.SaveExchangeRates), models.PermissionPriceWrite).
+		AddParamBody([]ExchangeRateInput{}, "body", "ExchangeRateInput models", true)
+}
+
+func (CurrencyController) GetDefault(c echo.Context) error {
+	result, err := models.TenantCurrency{}.Load(c.Request().Context())
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (CurrencyController) SetDefault(c echo.Context) error {
+	var v DefaultCurrencyInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	result, err := models.TenantCurrency{}.Set(c.Request().Context(), v.Currency, v.Rounding)
+	if errors.Is(err, models.ErrInvalidCurrency) || errors.Is(err, models.ErrInvalidRoundingMode) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (CurrencyController) GetExchangeRates(c echo.Context) error {
+	rates, err := models.ExchangeRate{}.GetAll(c.Request().Context())
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, int64(len(rates)), rates)
+}
+
+func (CurrencyController) SaveExchangeRates(c echo.Context) error {
+	var v []ExchangeRateInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if len(v) == 0 {
+		return renderFail(c, api.ErrorMissParameter.New(errors.New("rates")))
+	}
+	rates := make([]models.ExchangeRate, len(v))
+	for i := range v {
+		rates[i] = v[i].ToModel()
+	}
+	result, err := models.ExchangeRate{}.Save(c.Request().Context(), rates)
+	if errors.Is(err, models.ErrInvalidCurrency) || errors.Is(err, models.ErrInvalidExchangeRate) {
+		if err := rollback(c); err != nil {
+			return renderFail(c, api.ErrorDB.New(err))
+		}
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, int64(len(result)), result)
+}
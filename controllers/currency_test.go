@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestCurrency(t *testing.T) {
+	t.Run("SetDefault", func(t *testing.T) {
+		req := httptest.NewRequest(echo.PUT, "/v1/currencies/default", bytes.NewReader([]byte(`{"currency":"cny"}`)))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(CurrencyController{}.SetDefault, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result models.TenantCurrency `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Currency, "CNY")
+	})
+
+	t.Run("SetInvalidDefault", func(t *testing.T) {
+		req := httptest.NewRequest(echo.PUT, "/v1/currencies/default", bytes.NewReader([]byte(`{"currency":"yuan"}`)))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(CurrencyController{}.SetDefault, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("SaveExchangeRates", func(t *testing.T) {
+		pb, _ := json.Marshal([]ExchangeRateInput{
+			{FromCurrency: "USD", ToCurrency: "CNY", Rate: 7.1},
+			{FromCurrency: "EUR", ToCurrency: "CNY", Rate: 7.8},
+		})
+		req := httptest.NewRequest(echo.PUT, "/v1/currencies/exchange-rates", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(CurrencyController{}.SaveExchangeRates, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("GetExchangeRates", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/currencies/exchange-rates", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(CurrencyController{}.GetExchangeRates, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				TotalCount int                   `json:"totalCount"`
+				Items      []models.ExchangeRate `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.TotalCount, 2)
+		test.Equals(t, v.Result.Items[0].FromCurrency, "EUR")
+	})
+}
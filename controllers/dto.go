@@ -1,7 +1,9 @@
 package controllers
 
 import (
+	"context"
 	"strconv"
+	"time"
 
 	"github.com/hublabs/product-api/models"
 )
@@ -28,7 +30,42 @@ type SearchInput struct {
 type FieldAndStoreInput struct {
 	Fields    models.FieldTypeList `json:"fields" query:"fields"`
 	StoreId   int64                `json:"storeId" query:"storeId"`
+	Channel   string               `json:"channel" query:"channel"`
 	WithOffer bool                 `json:"withOffer" query:"withOffer"`
+	AsOf      string               `json:"asOf" query:"asOf"`
+	Currency  string               `json:"currency" query:"currency"`
+}
+
+// pricesVary tells whether prices are read for another time, currency or store than the current regular ones,
+// or with offers which come and go.
+func (v FieldAndStoreInput) pricesVary() bool {
+	return v.AsOf != "" || v.Currency != "" || v.StoreId != 0 || v.Channel != "" || v.WithOffer
+}
+
+// Context resolves prices as of the time given by asOf, in RFC 3339, and in the store and the channel,
+// and converts them into currency. withOffer adds the offers running in the store.
+func (v FieldAndStoreInput) Context(ctx context.Context) (context.Context, error) {
+	if v.StoreId != 0 || v.Channel != "" {
+		ctx = models.WithPriceScope(ctx, models.PriceScope{StoreId: v.StoreId, Channel: v.Channel})
+	}
+	if v.WithOffer {
+		ctx = models.WithOffers(ctx)
+	}
+	if v.AsOf != "" {
+		asOf, err := time.Parse(time.RFC3339, v.AsOf)
+		if err != nil {
+			return nil, err
+		}
+		ctx = models.WithPriceAsOf(ctx, asOf)
+	}
+	if v.Currency != "" {
+		currency, err := models.NormalizeCurrency(v.Currency)
+		if err != nil {
+			return nil, err
+		}
+		ctx = models.WithPriceCurrency(ctx, currency)
+	}
+	return ctx, nil
 }
 
 type GetAllBrandInput struct {
@@ -94,6 +131,14 @@ type SearchSkuInput struct {
 	SearchInput
 }
 
+type SkuEnableInput struct {
+	Enable *bool `json:"enable"`
+}
+
+type SkuSaleableInput struct {
+	Saleable *bool `json:"saleable"`
+}
+
 type SearchSkuByUidInput struct {
 	Uids   []string             `json:"uids"`
 	Source string               `json:"source"`
@@ -101,22 +146,201 @@ type SearchSkuByUidInput struct {
 }
 
 type PriceInput struct {
-	ProductId int64   `json:"productId"`
-	Barcode   string  `json:"barcode"`
-	SalePrice float64 `json:"salePrice"`
-	Name      string  `json:"name"`
+	ProductId     int64        `json:"productId"`
+	SkuId         int64        `json:"skuId"`
+	Barcode       string       `json:"barcode"`
+	PriceListId   int64        `json:"priceListId"`
+	MinQuantity   int          `json:"minQuantity"`
+	CustomerGroup string       `json:"customerGroup"`
+	SalePrice     models.Money `json:"salePrice"`
+	Currency      string       `json:"currency"`
+	Name          string       `json:"name"`
+	StartAt       time.Time    `json:"startAt"`
+	EndAt         *time.Time   `json:"endAt"`
+}
+
+type PriceChangeInput struct {
+	PriceInput
+	Submit bool `json:"submit"`
+}
+
+type GetAllPriceChangeInput struct {
+	Status     models.PriceChangeStatus `query:"status"`
+	TargetType models.PriceTargetType   `query:"targetType"`
+	TargetId   string                   `query:"targetId"`
+	PagingInput
+}
+
+type ReviewInput struct {
+	Comment string `json:"comment"`
+}
+
+type PriceApprovalPolicyInput struct {
+	DiscountThreshold int `json:"discountThreshold"`
+}
+
+type GetAllBarcodeInput struct {
+	Mapped string `query:"mapped"`
+	SearchInput
+}
+
+type MapBarcodeInput struct {
+	SkuId int64 `json:"skuId"`
+}
+
+type BarcodeAllocatorInput struct {
+	Prefix string `json:"prefix"`
+	Next   int64  `json:"next"`
+}
+
+type LabelInput struct {
+	Format   models.LabelFormat `json:"format" query:"format"`
+	Template string             `json:"template" query:"template"`
+	Copies   int                `json:"copies" query:"copies"`
+	FieldAndStoreInput
+}
+
+type LabelsInput struct {
+	Format   models.LabelFormat    `json:"format" query:"format"`
+	Template string                `json:"template" query:"template"`
+	Items    []models.LabelRequest `json:"items"`
+	FieldAndStoreInput
+}
+
+type EffectivePriceInput struct {
+	ProductIds string `json:"productIds" query:"productIds"`
+	At         string `json:"at" query:"at"`
+}
+
+type QuoteInput struct {
+	CustomerGroup string           `json:"customerGroup"`
+	StoreId       int64            `json:"storeId"`
+	Channel       string           `json:"channel"`
+	Currency      string           `json:"currency"`
+	AsOf          string           `json:"asOf"`
+	Lines         []QuoteLineInput `json:"lines"`
+}
+
+type QuoteLineInput struct {
+	SkuId    int64 `json:"skuId"`
+	Quantity int   `json:"quantity"`
+}
+
+// Context prices the cart as a read of the same store, channel, currency and time does.
+func (v QuoteInput) Context(ctx context.Context) (context.Context, error) {
+	return FieldAndStoreInput{StoreId: v.StoreId, Channel: v.Channel, Currency: v.Currency, AsOf: v.AsOf}.Context(ctx)
+}
+
+type DefaultCurrencyInput struct {
+	Currency string              `json:"currency"`
+	Rounding models.RoundingMode `json:"rounding"`
+}
+
+type ExchangeRateInput struct {
+	FromCurrency string  `json:"fromCurrency"`
+	ToCurrency   string  `json:"toCurrency"`
+	Rate         float64 `json:"rate"`
+}
+
+func (r ExchangeRateInput) ToModel() models.ExchangeRate {
+	return models.ExchangeRate{
+		FromCurrency: r.FromCurrency,
+		ToCurrency:   r.ToCurrency,
+		Rate:         r.Rate,
+	}
+}
+
+type GetAllPriceListInput struct {
+	Scope models.PriceListScope `query:"scope"`
+	PagingInput
+}
+
+type PriceListInput struct {
+	Code     string                `json:"code"`
+	Name     string                `json:"name"`
+	Scope    models.PriceListScope `json:"scope"`
+	StoreIds []int64               `json:"storeIds"`
+	Channel  string                `json:"channel"`
+	Priority int                   `json:"priority"`
+	Enable   bool                  `json:"enable"`
+}
+
+func (v PriceListInput) ToModel() models.PriceList {
+	return models.PriceList{
+		Code:     v.Code,
+		Name:     v.Name,
+		Scope:    v.Scope,
+		StoreIds: v.StoreIds,
+		Channel:  v.Channel,
+		Priority: v.Priority,
+		Enable:   v.Enable,
+	}
+}
+
+type GetAllOfferInput struct {
+	At string `query:"at"`
+	PagingInput
+}
+
+type OfferInput struct {
+	Code         string              `json:"code"`
+	Name         string              `json:"name"`
+	Type         models.OfferType    `json:"type"`
+	PercentOff   int                 `json:"percentOff"`
+	AmountOff    models.Money        `json:"amountOff"`
+	Quantity     int                 `json:"quantity"`
+	BundlePrice  models.Money        `json:"bundlePrice"`
+	Currency     string              `json:"currency"`
+	BrandCodes   []string            `json:"brandCodes"`
+	ProductCodes []string            `json:"productCodes"`
+	Attributes   map[string][]string `json:"attributes"`
+	Filter       models.Filter       `json:"filter"`
+	StoreIds     []int64             `json:"storeIds"`
+	StartAt      time.Time           `json:"startAt"`
+	EndAt        time.Time           `json:"endAt"`
+	Enable       bool                `json:"enable"`
+}
+
+func (v OfferInput) ToModel() models.Offer {
+	return models.Offer{
+		Code:         v.Code,
+		Name:         v.Name,
+		Type:         v.Type,
+		PercentOff:   v.PercentOff,
+		AmountOff:    v.AmountOff,
+		Quantity:     v.Quantity,
+		BundlePrice:  v.BundlePrice,
+		Currency:     v.Currency,
+		BrandCodes:   v.BrandCodes,
+		ProductCodes: v.ProductCodes,
+		Attributes:   v.Attributes,
+		Filter:       v.Filter,
+		StoreIds:     v.StoreIds,
+		StartAt:      v.StartAt,
+		EndAt:        v.EndAt,
+		Enable:       v.Enable,
+	}
 }
 
 type ItemInput struct {
-	Barcode   string  `json:"barcode"`
-	SalePrice float64 `json:"salePrice"`
+	Barcode   string       `json:"barcode"`
+	SalePrice models.Money `json:"salePrice"`
 }
 
 func (p PriceInput) ToModel() models.Price {
 	v := models.Price{
-		SalePrice: p.SalePrice,
+		PriceListId:   p.PriceListId,
+		MinQuantity:   p.MinQuantity,
+		CustomerGroup: p.CustomerGroup,
+		SalePrice:     p.SalePrice,
+		Currency:      p.Currency,
+		StartAt:       p.StartAt,
+		EndAt:         p.EndAt,
 	}
 	switch {
+	case p.SkuId != 0:
+		v.TargetType = models.PriceTargetTypeSku
+		v.TargetId = strconv.FormatInt(p.SkuId, 10)
 	case p.ProductId != 0:
 		v.TargetType = models.PriceTargetTypeProduct
 		v.TargetId = strconv.FormatInt(p.ProductId, 10)
@@ -126,3 +350,17 @@ func (p PriceInput) ToModel() models.Price {
 	}
 	return v
 }
+
+type ApiKeyInput struct {
+	Name      string              `json:"name"`
+	Scopes    []models.Permission `json:"scopes"`
+	ExpiresAt *time.Time          `json:"expiresAt"`
+}
+
+func (v ApiKeyInput) ToModel() models.ApiKey {
+	return models.ApiKey{
+		Name:      v.Name,
+		Scopes:    v.Scopes,
+		ExpiresAt: v.ExpiresAt,
+	}
+}
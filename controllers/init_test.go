@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/factory"
 	"github.com/hublabs/product-api/models"
 
 	"github.com/asaskevich/govalidator"
@@ -15,6 +16,7 @@ import (
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/pangpanglabs/echoswagger"
 	"github.com/pangpanglabs/goutils/behaviorlog"
 	"github.com/pangpanglabs/goutils/echomiddleware"
 	"github.com/pangpanglabs/goutils/jwtutil"
@@ -23,6 +25,8 @@ import (
 var (
 	echoApp          *echo.Echo
 	handleWithFilter func(handlerFunc echo.HandlerFunc, c echo.Context) error
+	// echoRouter serves the routes of the controllers behind the middlewares of the api server
+	echoRouter *echo.Echo
 )
 
 func TestMain(m *testing.M) {
@@ -44,6 +48,7 @@ func enterTest() *xorm.Engine {
 	if err = models.Init(xormEngine); err != nil {
 		panic(err)
 	}
+	factory.InitDB(xormEngine)
 
 	echoApp = echo.New()
 	echoApp.Validator = &Validator{}
@@ -61,8 +66,24 @@ func enterTest() *xorm.Engine {
 	}
 	jwtutil.SetJwtSecret(os.Getenv("JWT_SECRET"))
 	handleWithFilter = func(handlerFunc echo.HandlerFunc, c echo.Context) error {
-		return behaviorlogger(jwt(auth.UserClaimMiddleware()(db(handlerFunc))))(c)
+		return behaviorlogger(jwt(auth.UserClaimMiddleware()(TenantRequiredMiddleware()(UserRolesMiddleware()(db(handlerFunc))))))(c)
 	}
+
+	echoRouter = echo.New()
+	echoRouter.Validator = &Validator{}
+	r := echoswagger.New(echoRouter, "doc", &echoswagger.Info{Title: "Product API", Version: "1.0.0"})
+	r.AddSecurityAPIKey("Authorization", "JWT token", echoswagger.SecurityInHeader)
+	r.AddSecurityAPIKey(ApiKeyHeader, "API key of a machine client", echoswagger.SecurityInHeader)
+	BrandController{}.Init(r.Group("Brands", "v1/brands"))
+	ProductController{}.Init(r.Group("Products", "v1/products"))
+	SkuController{}.Init(r.Group("Skus", "v1/skus"))
+	PriceController{}.Init(r.Group("Prices", "v1/prices"))
+	CurrencyController{}.Init(r.Group("Currencies", "v1/currencies"))
+	PriceListController{}.Init(r.Group("PriceLists", "v1/price-lists"))
+	PriceChangeController{}.Init(r.Group("PriceChanges", "v1/price-changes"))
+	OfferController{}.Init(r.Group("Offers", "v1/offers"))
+	ApiKeyController{}.Init(r.Group("ApiKeys", "v1/api-keys"))
+	echoRouter.Use(behaviorlogger, ApiKeyMiddleware(auth.UserClaimMiddleware("/doc")), db, TenantRequiredMiddleware("/doc"), UserRolesMiddleware(), PermissionMiddleware("/doc"))
 	return xormEngine
 }
 
@@ -78,6 +99,30 @@ func setHeader(r *http.Request) {
 	r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
 }
 
+func setHeaderWithRoles(r *http.Request, roles ...string) {
+	token, _ := jwtutil.NewToken(map[string]interface{}{"aud": "colleague", "tenantCode": "test", "iss": "colleague", "roles": roles})
+	r.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+}
+
+func setHeaderWithColleague(r *http.Request, colleagueId int64, roles ...string) {
+	token, _ := jwtutil.NewToken(map[string]interface{}{"aud": "colleague", "tenantCode": "test", "iss": "colleague", "colleagueId": colleagueId, "roles": roles})
+	r.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+}
+
+func setHeaderWithTenant(r *http.Request, tenantCode string) {
+	token, _ := jwtutil.NewToken(map[string]interface{}{"aud": "colleague", "tenantCode": tenantCode, "iss": "colleague"})
+	r.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+}
+
+func setHeaderWithPermissions(r *http.Request, permissions ...string) {
+	token, _ := jwtutil.NewToken(map[string]interface{}{"aud": "colleague", "tenantCode": "test", "iss": "colleague", "permissions": permissions})
+	r.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	r.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+}
+
 type Validator struct{}
 
 func (v *Validator) Validate(i interface{}) error {
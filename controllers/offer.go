@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hublabs/common/api"
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/echoswagger"
+)
+
+type OfferController struct{}
+
+func (c OfferController) Init(g echoswagger.ApiGroup) {
+	g.SetSecurity("Authorization").SetSecurity(ApiKeyHeader)
+
+	permit(g.GET("", c.GetAll), models.PermissionCatalogRead).
+		AddParamQueryNested(GetAllOfferInput{})
+	permit(g.GET("/:id", c.GetOne), models.PermissionCatalogRead).
+		AddParamPath(0, "id", "Id of Offer").
+		AddParamHeader("", "If-None-Match", "ETag of the cached Offer", false)
+	// 促销活动
+	permit(g.POST("", c.Create), models.PermissionPriceWrite).
+		AddParamBody(OfferInput{}, "body", "OfferInput model", true)
+	permit(g.PUT("/:id", c.Update), models.PermissionPriceWrite).
+		AddParamPath(0, "id", "Id of Offer").
+		AddParamBody(OfferInput{}, "body", "OfferInput model", true).
+		AddParamHeader("", "If-Match", "ETag of the Offer being updated", true)
+}
+
+func (OfferController) GetAll(c echo.Context) error {
+	var v GetAllOfferInput
+	var err error
+	if err = c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if v.MaxResultCount == 0 {
+		v.MaxResultCount = defaultMaxResultCount
+	}
+	var at time.Time
+	if v.At != "" {
+		if at, err = time.Parse(time.RFC3339, v.At); err != nil {
+			return renderFail(c, api.ErrorParameter.New(err))
+		}
+	}
+	totalCount, offers, err := models.Offer{}.GetAll(c.Request().Context(), at, v.SkipCount, v.MaxResultCount)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, totalCount, offers)
+}
+
+func (OfferController) GetOne(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	o, err := models.Offer{}.Get(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if o == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderSuccWithETag(c, o.Version, o)
+}
+
+func (OfferController) Create(c echo.Context) error {
+	var v OfferInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	o := v.ToModel()
+	if err := o.Create(c.Request().Context()); isInvalidOffer(err) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccWithETag(c, o.Version, o)
+}
+
+func (OfferController) Update(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return renderFail(c, err)
+	}
+	var v OfferInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+
+	o := v.ToModel()
+	o.Id = id
+	o.Version = version
+	if err := o.Update(c.Request().Context()); errors.Is(err, models.ErrOfferNotFound) {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	} else if isInvalidOffer(err) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if errors.Is(err, models.ErrVersionConflict) {
+		return renderOfferConflict(c, err, id)
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccWithETag(c, o.Version, o)
+}
+
+func isInvalidOffer(err error) bool {
+	return errors.Is(err, models.ErrInvalidOfferType) ||
+		errors.Is(err, models.ErrInvalidOfferPeriod) ||
+		errors.Is(err, models.ErrInvalidOfferDiscount) ||
+		errors.Is(err, models.ErrInvalidOfferTarget) ||
+		errors.Is(err, models.ErrOfferCodeExists) ||
+		errors.Is(err, models.ErrInvalidCurrency)
+}
+
+func renderOfferConflict(c echo.Context, conflict error, id int64) error {
+	if err := rollback(c); err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	current, err := models.Offer{}.Get(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if current == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderConflict(c, conflict, current.Version, current)
+}
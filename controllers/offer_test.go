@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestOffer(t *testing.T) {
+	now := time.Now()
+	var created []models.Offer
+
+	for i, input := range []OfferInput{
+		{Code: "year#2018", Type: models.OfferTypePercentageOff, PercentOff: 20, Attributes: map[string][]string{"Year": {"2018"}}, StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Enable: true},
+		{Code: "buy#2", Type: models.OfferTypeBuyNPrice, Quantity: 2, BundlePrice: 300 * models.MajorUnit, StoreIds: []int64{1}, StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Enable: true},
+		{Code: "later", Type: models.OfferTypeAmountOff, AmountOff: 50 * models.MajorUnit, StartAt: now.Add(time.Hour), EndAt: now.Add(2 * time.Hour), Enable: true},
+	} {
+		pb, _ := json.Marshal(input)
+		t.Run(fmt.Sprint("Create#", i+1), func(t *testing.T) {
+			req := httptest.NewRequest(echo.POST, "/v1/offers", bytes.NewReader(pb))
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			test.Ok(t, handleWithFilter(OfferController{}.Create, echoApp.NewContext(req, rec)))
+			test.Equals(t, http.StatusOK, rec.Code)
+
+			var v struct {
+				Result models.Offer `json:"result"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+			test.Equals(t, v.Result.Currency, "CNY")
+			created = append(created, v.Result)
+		})
+	}
+
+	for _, body := range []string{
+		`{"code":"x","type":"percentage_off","percentOff":100,"startAt":"2020-01-01T00:00:00Z","endAt":"2020-02-01T00:00:00Z"}`,
+		`{"code":"x","type":"amount_off","amountOff":10,"startAt":"2020-02-01T00:00:00Z","endAt":"2020-01-01T00:00:00Z"}`,
+		`{"code":"x","type":"buy_n_price","quantity":1,"bundlePrice":10,"startAt":"2020-01-01T00:00:00Z","endAt":"2020-02-01T00:00:00Z"}`,
+		`{"code":"x","type":"amount_off","amountOff":10,"filter":{"color":{"values":["red"]}},"startAt":"2020-01-01T00:00:00Z","endAt":"2020-02-01T00:00:00Z"}`,
+		`{"code":"year#2018","type":"amount_off","amountOff":10,"startAt":"2020-01-01T00:00:00Z","endAt":"2020-02-01T00:00:00Z"}`,
+		`{"code":"x","type":"free_gift","startAt":"2020-01-01T00:00:00Z","endAt":"2020-02-01T00:00:00Z"}`,
+	} {
+		t.Run("CreateInvalid "+body, func(t *testing.T) {
+			req := httptest.NewRequest(echo.POST, "/v1/offers", bytes.NewReader([]byte(body)))
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			test.Ok(t, handleWithFilter(OfferController{}.Create, echoApp.NewContext(req, rec)))
+			test.Equals(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+
+	t.Run("Update", func(t *testing.T) {
+		o := created[2]
+		pb, _ := json.Marshal(OfferInput{Code: o.Code, Type: o.Type, AmountOff: 40 * models.MajorUnit, StartAt: o.StartAt, EndAt: o.EndAt, Enable: true})
+		req := httptest.NewRequest(echo.PUT, "/v1/offers/"+fmt.Sprint(o.Id), bytes.NewReader(pb))
+		setHeader(req)
+		req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, o.Version))
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/offers/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprint(o.Id))
+		test.Ok(t, handleWithFilter(OfferController{}.Update, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result models.Offer `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.AmountOff, 40*models.MajorUnit)
+		test.Equals(t, v.Result.Version, o.Version+1)
+	})
+
+	t.Run("GetAllRunning", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/offers?at="+now.UTC().Format(time.RFC3339), nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(OfferController{}.GetAll, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				TotalCount int            `json:"totalCount"`
+				Items      []models.Offer `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.TotalCount, 2)
+		test.Equals(t, v.Result.Items[0].Code, "buy#2")
+	})
+}
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/goutils/jwtutil"
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestPermissions(t *testing.T) {
+	for _, c := range []struct {
+		name        string
+		method      string
+		target      string
+		body        string
+		roles       []string
+		permissions []string
+		status      int
+	}{
+		{"ReadWithoutGrant", echo.GET, "/v1/brands", "", nil, nil, http.StatusOK},
+		{"SearchWithoutGrant", echo.POST, "/v1/skus/searches", `{"q":"S203"}`, nil, nil, http.StatusOK},
+		{"WriteWithoutGrant", echo.POST, "/v1/brands", `{"code":"perm#1","name":"perm#1"}`, nil, nil, http.StatusForbidden},
+		{"WriteWithRole", echo.POST, "/v1/brands", `{"code":"perm#1","name":"perm#1"}`, []string{models.RoleCatalogEditor}, nil, http.StatusOK},
+		{"WriteWithPermission", echo.POST, "/v1/brands", `{"code":"perm#2","name":"perm#2"}`, nil, []string{"catalog:write"}, http.StatusOK},
+		{"WriteWithOtherRole", echo.POST, "/v1/brands", `{"code":"perm#3","name":"perm#3"}`, []string{models.RolePriceEditor}, nil, http.StatusForbidden},
+		{"PriceWithoutGrant", echo.PUT, "/v1/currencies/default", `{"currency":"CNY"}`, []string{models.RoleCatalogEditor}, nil, http.StatusForbidden},
+		{"PriceWithRole", echo.PUT, "/v1/currencies/default", `{"currency":"CNY"}`, []string{models.RolePriceEditor}, nil, http.StatusOK},
+		{"ImportWithoutGrant", echo.POST, "/v1/products/batch", `[]`, []string{models.RoleCatalogEditor}, nil, http.StatusForbidden},
+		{"ImportWithRole", echo.POST, "/v1/products/batch", `[]`, []string{models.RoleImporter}, nil, http.StatusOK},
+		{"Admin", echo.PUT, "/v1/currencies/default", `{"currency":"CNY"}`, []string{models.RoleAdmin}, nil, http.StatusOK},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest(c.method, c.target, strings.NewReader(c.body))
+			if c.permissions != nil {
+				setHeaderWithPermissions(req, c.permissions...)
+			} else {
+				setHeaderWithRoles(req, c.roles...)
+			}
+			rec := httptest.NewRecorder()
+			echoRouter.ServeHTTP(rec, req)
+			test.Assert(t, rec.Code == c.status, rec.Body.String())
+		})
+	}
+}
+
+func TestForgedPermissions(t *testing.T) {
+	// permissions claimed by a token which is not signed by the jwt secret grant nothing
+	forged, _ := jwtutil.NewTokenWithSecret(map[string]interface{}{"aud": "colleague", "tenantCode": "test", "iss": "colleague", "permissions": []string{"price:write"}}, "forged")
+	req := httptest.NewRequest(echo.PUT, "/v1/currencies/default", strings.NewReader(`{"currency":"CNY"}`))
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+forged)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	echoRouter.ServeHTTP(rec, req)
+	test.Equals(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestUndeclaredPermission(t *testing.T) {
+	e := echo.New()
+	e.GET("/v1/undeclared", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.GET("/ping", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.Use(UserRolesMiddleware(), PermissionMiddleware("/ping"))
+	for target, status := range map[string]int{
+		"/v1/undeclared": http.StatusForbidden,
+		"/ping":          http.StatusOK,
+	} {
+		req := httptest.NewRequest(echo.GET, target, nil)
+		setHeaderWithRoles(req, models.RoleAdmin)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		test.Assert(t, rec.Code == status, target+": "+rec.Body.String())
+	}
+}
+
+func TestRoutePermissions(t *testing.T) {
+	for _, route := range echoRouter.Routes() {
+		// the doc, and the routes echo adds for a group to answer not found
+		if strings.HasPrefix(route.Path, "/doc") || strings.HasPrefix(route.Name, "github.com/labstack/echo.") {
+			continue
+		}
+		_, ok := routePermissions[route.Method+" "+routePath(route.Path)]
+		test.Assert(t, ok, route.Method+" "+route.Path+" declares no permission")
+	}
+}
+
+func TestPermissionDocs(t *testing.T) {
+	req := httptest.NewRequest(echo.GET, "/doc/swagger.json", nil)
+	rec := httptest.NewRecorder()
+	echoRouter.ServeHTTP(rec, req)
+	test.Equals(t, http.StatusOK, rec.Code)
+
+	var v struct {
+		Paths map[string]map[string]struct {
+			Description string                     `json:"description"`
+			Responses   map[string]json.RawMessage `json:"responses"`
+		} `json:"paths"`
+	}
+	test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+	operation := v.Paths["/v1/brands"]["post"]
+	test.Equals(t, operation.Description, "Requires the `catalog:write` permission.")
+	_, ok := operation.Responses["403"]
+	test.Assert(t, ok, "no 403 response documented")
+	test.Equals(t, v.Paths["/v1/products/batch"]["post"].Description, "Requires the `import:run` permission.")
+}
+
+func TestApiKeys(t *testing.T) {
+	var key struct {
+		Id     int64  `json:"id"`
+		Prefix string `json:"prefix"`
+		Key    string `json:"key"`
+	}
+	t.Run("Create", func(t *testing.T) {
+		status, body := requestWithRoles(echo.POST, "/v1/api-keys", `{"name":"erp","scopes":["catalog:read","catalog:write"]}`, models.RoleAdmin)
+		test.Equals(t, http.StatusOK, status)
+		var v struct {
+			Result json.RawMessage `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(body, &v))
+		test.Ok(t, json.Unmarshal(v.Result, &key))
+		test.Assert(t, strings.HasPrefix(key.Key, key.Prefix+"."), string(body))
+		test.Assert(t, !strings.Contains(string(body), `"hash"`), string(body))
+	})
+
+	t.Run("CreateInvalid", func(t *testing.T) {
+		status, _ := requestWithRoles(echo.POST, "/v1/api-keys", `{"name":"erp","scopes":["api-key:manage"]}`, models.RoleAdmin)
+		test.Equals(t, http.StatusBadRequest, status)
+		status, _ = requestWithRoles(echo.POST, "/v1/api-keys", `{"name":"erp","scopes":["catalog:read"]}`, models.RoleCatalogEditor)
+		test.Equals(t, http.StatusForbidden, status)
+	})
+
+	t.Run("UseFailed", func(t *testing.T) {
+		// the transaction of the request is rolled back, the use of the key is recorded all the same
+		status, _ := requestWithApiKey(echo.POST, "/v1/skus", `{"productId":99999,"code":"key#1"}`, key.Key)
+		test.Assert(t, status >= http.StatusBadRequest, fmt.Sprint(status))
+		status, body := requestWithRoles(echo.GET, fmt.Sprintf("/v1/api-keys/%d", key.Id), "", models.RoleAdmin)
+		test.Equals(t, http.StatusOK, status)
+		test.Assert(t, !strings.Contains(string(body), `"lastUsedAt":null`), "the use of the key is not recorded")
+	})
+
+	t.Run("Use", func(t *testing.T) {
+		status, _ := requestWithApiKey(echo.GET, "/v1/brands", "", key.Key)
+		test.Equals(t, http.StatusOK, status)
+		status, body := requestWithApiKey(echo.POST, "/v1/brands", `{"code":"key#1","name":"key#1"}`, key.Key)
+		test.Equals(t, http.StatusOK, status)
+		var v struct {
+			Result struct {
+				Id int64 `json:"id"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(body, &v))
+		status, _ = requestWithRoles(echo.GET, fmt.Sprintf("/v1/brands/%d", v.Result.Id), "")
+		test.Equals(t, http.StatusOK, status)
+
+		// the key has no price:write, nor may it manage keys
+		status, _ = requestWithApiKey(echo.PUT, "/v1/currencies/default", `{"currency":"CNY"}`, key.Key)
+		test.Equals(t, http.StatusForbidden, status)
+		status, _ = requestWithApiKey(echo.GET, "/v1/api-keys", "", key.Key)
+		test.Equals(t, http.StatusForbidden, status)
+	})
+
+	t.Run("UseInvalid", func(t *testing.T) {
+		status, _ := requestWithApiKey(echo.GET, "/v1/brands", "", key.Prefix+".secret")
+		test.Equals(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("GetAll", func(t *testing.T) {
+		status, body := requestWithRoles(echo.GET, "/v1/api-keys", "", models.RoleAdmin)
+		test.Equals(t, http.StatusOK, status)
+		test.Assert(t, strings.Contains(string(body), `"prefix":"`+key.Prefix+`"`), string(body))
+		test.Assert(t, !strings.Contains(string(body), key.Key), "the key is listed")
+		test.Assert(t, !strings.Contains(string(body), `"lastUsedAt":null`), "the use of the key is not recorded")
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		target := fmt.Sprintf("/v1/api-keys/%d", key.Id)
+		status, _ := requestWithRoles(echo.DELETE, target, "", models.RoleAdmin)
+		test.Equals(t, http.StatusOK, status)
+		status, _ = requestWithRoles(echo.GET, target, "", models.RoleAdmin)
+		test.Equals(t, http.StatusNotFound, status)
+		status, _ = requestWithApiKey(echo.GET, "/v1/brands", "", key.Key)
+		test.Equals(t, http.StatusUnauthorized, status)
+	})
+
+	t.Run("CreateNotGranted", func(t *testing.T) {
+		// a caller managing keys without being an admin gives a key only its own permissions
+		req := httptest.NewRequest(echo.POST, "/v1/api-keys", strings.NewReader(`{"name":"pos","scopes":["catalog:read","price:write"]}`))
+		setHeaderWithPermissions(req, "api-key:manage")
+		rec := httptest.NewRecorder()
+		echoRouter.ServeHTTP(rec, req)
+		test.Equals(t, http.StatusForbidden, rec.Code)
+
+		req = httptest.NewRequest(echo.POST, "/v1/api-keys", strings.NewReader(`{"name":"pos","scopes":["catalog:read"]}`))
+		setHeaderWithPermissions(req, "api-key:manage")
+		rec = httptest.NewRecorder()
+		echoRouter.ServeHTTP(rec, req)
+		test.Equals(t, http.StatusOK, rec.Code)
+	})
+}
+
+func requestWithRoles(method, target, body string, roles ...string) (int, []byte) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	setHeaderWithRoles(req, roles...)
+	rec := httptest.NewRecorder()
+	echoRouter.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.Bytes()
+}
+
+// requestWithApiKey makes a request of a machine client, which sends the key instead of a token.
+func requestWithApiKey(method, target, body, key string) (int, []byte) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(ApiKeyHeader, key)
+	rec := httptest.NewRecorder()
+	echoRouter.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.Bytes()
+}
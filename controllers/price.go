@@ -1,26 +1,52 @@
 package controllers
 
 import (
+	"encoding/csv"
+	"errors"
+	"io"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/hublabs/common/api"
 	"github.com/hublabs/product-api/models"
 
+	"github.com/360EntSecGroup-Skylar/excelize/v2"
 	"github.com/labstack/echo"
 	"github.com/pangpanglabs/echoswagger"
+	"github.com/pangpanglabs/goutils/converter"
 )
 
 type PriceController struct{}
 
 func (c PriceController) Init(g echoswagger.ApiGroup) {
-	g.SetSecurity("Authorization")
+	g.SetSecurity("Authorization").SetSecurity(ApiKeyHeader)
 
 	// Portal商品页创建销售价需要
-	g.POST("", c.Create).
+	permit(g.POST("", c.Create), models.PermissionPriceWrite).
 		AddParamBody(PriceInput{}, "body", "PriceInput model", true)
+	// 批量调价: 先上传价格表(Excel/CSV)或提交价格预览校验结果, 再提交生效
+	permit(g.POST("/validate-excel", c.ValidatePriceSheet), models.PermissionPriceWrite).
+		AddParamFile("file", "excel or csv", true)
+	permit(g.POST("/bulk/validate", c.ValidateBulk), models.PermissionPriceWrite).
+		AddParamBody([]models.PriceSheetRow{}, "body", "PriceSheetRow model", true)
+	permit(g.POST("/bulk", c.Bulk), models.PermissionPriceWrite).
+		AddParamBody([]models.PriceSheetRow{}, "body", "PriceSheetRow model", true)
 	// 查询未登记商品
-	g.GET("/barcode", c.GetAllBarcode).
-		AddParamQueryNested(SearchInput{})
+	permit(g.GET("/barcode", c.GetAllBarcode), models.PermissionCatalogRead).
+		AddParamQueryNested(GetAllBarcodeInput{})
+	// 未登记商品条码关联到商品, 价格转为该商品的价格
+	permit(g.POST("/barcode/:barcode/map", c.MapBarcode), models.PermissionPriceWrite).
+		AddParamPath("", "barcode", "Barcode of the prices").
+		AddParamBody(MapBarcodeInput{}, "body", "MapBarcodeInput model", true)
+	permit(g.POST("/barcode/auto-map", c.AutoMapBarcodes), models.PermissionPriceWrite)
+	// 购物车按数量阶梯价、会员价报价
+	permit(g.POST("/quote", c.Quote), models.PermissionCatalogRead).
+		AddParamBody(QuoteInput{}, "body", "QuoteInput model", true)
+	// 退货按原价退款需要
+	permit(g.GET("/effective", c.GetEffective), models.PermissionCatalogRead).
+		AddParamQueryNested(EffectivePriceInput{})
 }
 
 func (PriceController) Create(c echo.Context) error {
@@ -30,24 +56,236 @@ func (PriceController) Create(c echo.Context) error {
 	}
 
 	p := v.ToModel()
-	if err := p.Create(c.Request().Context()); err != nil {
+	if err := p.Create(c.Request().Context()); errors.Is(err, models.ErrInvalidPriceSchedule) || errors.Is(err, models.ErrInvalidCurrency) ||
+		errors.Is(err, models.ErrPriceListNotFound) || errors.Is(err, models.ErrInvalidPriceTier) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
 		return renderFail(c, api.ErrorDB.New(err))
 	}
 
+	// the price waits for approval as a pending change
+	if p.PendingChangeId != 0 {
+		return renderSucc(c, http.StatusAccepted, p)
+	}
 	return renderSucc(c, http.StatusOK, p)
 }
 
+func (PriceController) Quote(c echo.Context) error {
+	var v QuoteInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if len(v.Lines) == 0 {
+		return renderFail(c, api.ErrorMissParameter.New(errors.New("lines")))
+	}
+	ctx, err := v.Context(c.Request().Context())
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	lines := make([]models.QuoteLine, len(v.Lines))
+	for i, l := range v.Lines {
+		lines[i] = models.QuoteLine{SkuId: l.SkuId, Quantity: l.Quantity}
+	}
+	result, err := models.Price{}.Quote(ctx, v.CustomerGroup, lines)
+	if errors.Is(err, models.ErrInvalidQuoteQuantity) || errors.Is(err, models.ErrQuoteSkuNotFound) ||
+		errors.Is(err, models.ErrExchangeRateNotFound) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, int64(len(result)), result)
+}
+
+func (PriceController) GetEffective(c echo.Context) error {
+	var v EffectivePriceInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	productIds := converter.StringToIntSlice(v.ProductIds)
+	if len(productIds) == 0 {
+		return renderFail(c, api.ErrorMissParameter.New(errors.New("productIds")))
+	}
+	at := time.Now()
+	if v.At != "" {
+		t, err := time.Parse(time.RFC3339, v.At)
+		if err != nil {
+			return renderFail(c, api.ErrorParameter.New(err))
+		}
+		at = t
+	}
+	prices, err := models.Price{}.GetEffective(c.Request().Context(), productIds, at)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, int64(len(prices)), prices)
+}
+
 func (PriceController) GetAllBarcode(c echo.Context) error {
-	var v SearchInput
+	var v GetAllBarcodeInput
 	if err := c.Bind(&v); err != nil {
 		return renderFail(c, api.ErrorParameter.New(err))
 	}
 	if v.MaxResultCount == 0 {
 		v.MaxResultCount = defaultMaxResultCount
 	}
-	totalCount, prices, err := models.Price{}.GetAllBarcode(c.Request().Context(), v.SkipCount, v.MaxResultCount, v.Sortby, v.Order)
+	totalCount, prices, err := models.Price{}.GetAllBarcode(c.Request().Context(), v.Mapped, v.SkipCount, v.MaxResultCount, v.Sortby, v.Order)
 	if err != nil {
 		return renderFail(c, api.ErrorDB.New(err))
 	}
 	return renderSuccArray(c, false, false, totalCount, prices)
 }
+
+func (PriceController) MapBarcode(c echo.Context) error {
+	var v MapBarcodeInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if v.SkuId == 0 {
+		return renderFail(c, api.ErrorMissParameter.New(errors.New("skuId")))
+	}
+	result, err := models.Price{}.MapBarcode(c.Request().Context(), c.Param("barcode"), v.SkuId)
+	if errors.Is(err, models.ErrBarcodePriceNotFound) {
+		return renderFail(c, api.ErrorNotFound.New(err))
+	} else if errors.Is(err, models.ErrMapSkuNotFound) || errors.Is(err, models.ErrIdentifierExist) || errors.Is(err, models.ErrInvalidGTIN) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (PriceController) AutoMapBarcodes(c echo.Context) error {
+	result, err := models.Price{}.AutoMapBarcodes(c.Request().Context())
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+// priceSheetTimeLayouts are the layouts a start or an end time may be written in, in the local time of the service.
+var priceSheetTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+func parsePriceSheetTime(s string) (*time.Time, error) {
+	for _, layout := range priceSheetTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, errors.New("invalid time " + s)
+}
+
+// readPriceSheet reads the rows of the sheet "价格" of an Excel file, or of a CSV file, header included.
+func readPriceSheet(name string, r io.Reader) ([][]string, error) {
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		return reader.ReadAll()
+	}
+	xlsx, err := excelize.OpenReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return xlsx.GetRows("价格")
+}
+
+// ValidatePriceSheet previews the prices of an uploaded sheet, whose columns are
+// product code, sku code, barcode, brand code, sale price, currency, start time and end time.
+func (PriceController) ValidatePriceSheet(c echo.Context) error {
+	file, err := c.FormFile("file")
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	data, err := file.Open()
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	defer data.Close()
+
+	rows, err := readPriceSheet(file.Filename, data)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	rounding, err := models.TenantCurrency{}.GetRounding(c.Request().Context())
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	var list []models.PriceSheetRow
+	// Validate starts the errors of a row afresh, so the cells which are not times are marked afterwards
+	var invalidTimes []int
+	for i := range rows {
+		if i == 0 {
+			continue
+		}
+		cell := func(n int) string {
+			if n < len(rows[i]) {
+				return strings.TrimSpace(rows[i][n])
+			}
+			return ""
+		}
+		r := models.PriceSheetRow{
+			ProductCode: cell(0),
+			SkuCode:     cell(1),
+			Barcode:     cell(2),
+			BrandCode:   cell(3),
+			Currency:    cell(5),
+		}
+		if r.ProductCode == "" && r.SkuCode == "" && r.Barcode == "" && cell(4) == "" {
+			continue
+		}
+		// a cell may hold a float like 99.9900000001, so it is rounded as the tenant rounds
+		r.SalePrice, _ = models.ParseMoneyRounded(cell(4), rounding)
+		var startErr, endErr error
+		if s := cell(6); s != "" {
+			r.StartAt, startErr = parsePriceSheetTime(s)
+		}
+		if s := cell(7); s != "" {
+			r.EndAt, endErr = parsePriceSheetTime(s)
+		}
+		if startErr != nil || endErr != nil {
+			invalidTimes = append(invalidTimes, len(list))
+		}
+		list = append(list, r)
+	}
+	list, err = models.PriceSheetRow{}.Validate(c.Request().Context(), list)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	for _, i := range invalidTimes {
+		list[i].ErrorList = append(list[i].ErrorList, 10023) //时间
+		list[i].Status = ""
+	}
+	return renderSucc(c, http.StatusOK, list)
+}
+
+func (PriceController) ValidateBulk(c echo.Context) error {
+	var list []models.PriceSheetRow
+	if err := c.Bind(&list); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	list, err := models.PriceSheetRow{}.Validate(c.Request().Context(), list)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, list)
+}
+
+// Bulk enters the prices of the rows in one transaction, none of them when a row is invalid.
+func (PriceController) Bulk(c echo.Context) error {
+	var list []models.PriceSheetRow
+	if err := c.Bind(&list); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if len(list) == 0 {
+		return renderFail(c, api.ErrorMissParameter.New(errors.New("body")))
+	}
+	prices, err := models.PriceSheetRow{}.Apply(c.Request().Context(), list)
+	if errors.Is(err, models.ErrInvalidPriceSheet) {
+		if err := rollback(c); err != nil {
+			return renderFail(c, api.ErrorDB.New(err))
+		}
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, int64(len(prices)), prices)
+}
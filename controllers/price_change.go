@@ -0,0 +1,209 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/hublabs/common/api"
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/echoswagger"
+)
+
+type PriceChangeController struct{}
+
+func (c PriceChangeController) Init(g echoswagger.ApiGroup) {
+	g.SetSecurity("Authorization").SetSecurity(ApiKeyHeader)
+
+	// 调价审批: 折扣超过阈值的调价需审批后生效
+	permit(g.GET("/policy", c.GetPolicy), models.PermissionCatalogRead)
+	permit(g.PUT("/policy", c.SetPolicy), models.PermissionPriceWrite).
+		AddParamBody(PriceApprovalPolicyInput{}, "body", "PriceApprovalPolicyInput model", true)
+	permit(g.GET("", c.GetAll), models.PermissionCatalogRead).
+		AddParamQueryNested(GetAllPriceChangeInput{})
+	permit(g.GET("/:id", c.GetOne), models.PermissionCatalogRead).
+		AddParamPath(0, "id", "Id of PriceChange")
+	permit(g.POST("", c.Create), models.PermissionPriceWrite).
+		AddParamBody(PriceChangeInput{}, "body", "PriceChangeInput model", true)
+	permit(g.PUT("/:id", c.Update), models.PermissionPriceWrite).
+		AddParamPath(0, "id", "Id of PriceChange").
+		AddParamBody(PriceChangeInput{}, "body", "PriceChangeInput model", true).
+		AddParamHeader("", "If-Match", "ETag of the PriceChange being updated", true)
+	permit(g.POST("/:id/submit", c.Submit), models.PermissionPriceWrite).
+		AddParamPath(0, "id", "Id of PriceChange").
+		AddParamHeader("", "If-Match", "ETag of the PriceChange being submitted", true)
+	// 审批人通过、驳回
+	permit(g.POST("/:id/approve", c.Approve), models.PermissionPriceWrite).
+		AddParamPath(0, "id", "Id of PriceChange").
+		AddParamBody(ReviewInput{}, "body", "ReviewInput model", false).
+		AddParamHeader("", "If-Match", "ETag of the PriceChange being approved", true)
+	permit(g.POST("/:id/reject", c.Reject), models.PermissionPriceWrite).
+		AddParamPath(0, "id", "Id of PriceChange").
+		AddParamBody(ReviewInput{}, "body", "ReviewInput model", false).
+		AddParamHeader("", "If-Match", "ETag of the PriceChange being rejected", true)
+}
+
+func (PriceChangeController) GetPolicy(c echo.Context) error {
+	result, err := models.PriceApprovalPolicy{}.Load(c.Request().Context())
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (PriceChangeController) SetPolicy(c echo.Context) error {
+	if !models.HasRole(c.Request().Context(), models.RolePriceApprover) {
+		return renderFail(c, api.ErrorPermissionDenied.New(nil))
+	}
+	var v PriceApprovalPolicyInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	result, err := models.PriceApprovalPolicy{}.Set(c.Request().Context(), v.DiscountThreshold)
+	if errors.Is(err, models.ErrInvalidDiscountThreshold) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (PriceChangeController) GetAll(c echo.Context) error {
+	var v GetAllPriceChangeInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if v.MaxResultCount == 0 {
+		v.MaxResultCount = defaultMaxResultCount
+	}
+	totalCount, changes, err := models.PriceChange{}.GetAll(c.Request().Context(), v.Status, v.TargetType, v.TargetId, v.SkipCount, v.MaxResultCount)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, totalCount, changes)
+}
+
+func (PriceChangeController) GetOne(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	change, err := models.PriceChange{}.Get(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if change == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderSuccWithETag(c, change.Version, change)
+}
+
+func (PriceChangeController) Create(c echo.Context) error {
+	var v PriceChangeInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	change := models.PriceChange{Price: v.ToModel()}
+	if err := change.Create(c.Request().Context(), v.Submit); err != nil {
+		return renderPriceChangeFail(c, err, 0)
+	}
+	return renderSuccWithETag(c, change.Version, change)
+}
+
+func (PriceChangeController) Update(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return renderFail(c, err)
+	}
+	var v PriceChangeInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	change := models.PriceChange{Id: id, Price: v.ToModel(), Version: version}
+	if err := change.Update(c.Request().Context()); err != nil {
+		return renderPriceChangeFail(c, err, id)
+	}
+	return renderSuccWithETag(c, change.Version, change)
+}
+
+func (PriceChangeController) Submit(c echo.Context) error {
+	return reviewPriceChange(c, false, func(id int64, version int, _ string) (*models.PriceChange, error) {
+		return models.PriceChange{}.Submit(c.Request().Context(), id, version)
+	})
+}
+
+func (PriceChangeController) Approve(c echo.Context) error {
+	return reviewPriceChange(c, true, func(id int64, version int, comment string) (*models.PriceChange, error) {
+		return models.PriceChange{}.Approve(c.Request().Context(), id, version, comment)
+	})
+}
+
+func (PriceChangeController) Reject(c echo.Context) error {
+	return reviewPriceChange(c, true, func(id int64, version int, comment string) (*models.PriceChange, error) {
+		return models.PriceChange{}.Reject(c.Request().Context(), id, version, comment)
+	})
+}
+
+// reviewPriceChange moves a change on from its status, which only an approver may do when approverOnly.
+func reviewPriceChange(c echo.Context, approverOnly bool, move func(id int64, version int, comment string) (*models.PriceChange, error)) error {
+	if approverOnly && !models.HasRole(c.Request().Context(), models.RolePriceApprover) {
+		return renderFail(c, api.ErrorPermissionDenied.New(nil))
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return renderFail(c, err)
+	}
+	var v ReviewInput
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&v); err != nil {
+			return renderFail(c, api.ErrorParameter.New(err))
+		}
+	}
+	change, err := move(id, version, v.Comment)
+	if err != nil {
+		return renderPriceChangeFail(c, err, id)
+	}
+	return renderSuccWithETag(c, change.Version, change)
+}
+
+func renderPriceChangeFail(c echo.Context, err error, id int64) error {
+	switch {
+	case errors.Is(err, models.ErrPriceChangeNotFound):
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	case errors.Is(err, models.ErrVersionConflict):
+		if err := rollback(c); err != nil {
+			return renderFail(c, api.ErrorDB.New(err))
+		}
+		current, getErr := models.PriceChange{}.Get(c.Request().Context(), id)
+		if getErr != nil {
+			return renderFail(c, api.ErrorDB.New(getErr))
+		}
+		if current == nil {
+			return renderFail(c, api.ErrorNotFound.New(nil))
+		}
+		return renderConflict(c, err, current.Version, current)
+	case errors.Is(err, models.ErrPriceChangeSelfApproval):
+		if err := rollback(c); err != nil {
+			return renderFail(c, api.ErrorDB.New(err))
+		}
+		return renderFail(c, api.ErrorPermissionDenied.New(err))
+	case errors.Is(err, models.ErrInvalidPriceChangeStatus), errors.Is(err, models.ErrPriceChangeTargetRequired),
+		errors.Is(err, models.ErrInvalidPriceSchedule), errors.Is(err, models.ErrInvalidCurrency),
+		errors.Is(err, models.ErrPriceListNotFound), errors.Is(err, models.ErrInvalidPriceTier):
+		if err := rollback(c); err != nil {
+			return renderFail(c, api.ErrorDB.New(err))
+		}
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	return renderFail(c, api.ErrorDB.New(err))
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/goutils/test"
+)
+
+// TestPriceApproval prices a product which is not created, and has no list price to compare with,
+// so that its prices need approval under any policy.
+func TestPriceApproval(t *testing.T) {
+	do := func(t *testing.T, method, target string, body interface{}, version int, h echo.HandlerFunc, colleagueId int64, roles ...string) (int, models.PriceChange) {
+		var pb []byte
+		if body != nil {
+			pb, _ = json.Marshal(body)
+		}
+		req := httptest.NewRequest(method, target, bytes.NewReader(pb))
+		setHeaderWithColleague(req, colleagueId, roles...)
+		if version != 0 {
+			req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, version))
+		}
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		if parts := strings.Split(target, "/"); len(parts) > 3 {
+			c.SetParamNames("id")
+			c.SetParamValues(parts[3])
+		}
+		test.Ok(t, handleWithFilter(h, c))
+
+		var v struct {
+			Result models.PriceChange `json:"result"`
+		}
+		json.Unmarshal(rec.Body.Bytes(), &v)
+		return rec.Code, v.Result
+	}
+	priced := func(t *testing.T, group string) bool {
+		req := httptest.NewRequest(echo.GET, "/v1/products/9001/prices", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("9001")
+		test.Ok(t, handleWithFilter(ProductController{}.GetPrices, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+		var v struct {
+			Result struct {
+				Items []models.Price `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		for _, p := range v.Result.Items {
+			if p.CustomerGroup == group {
+				return true
+			}
+		}
+		return false
+	}
+
+	t.Run("Policy", func(t *testing.T) {
+		code, _ := do(t, echo.PUT, "/v1/price-changes/policy", PriceApprovalPolicyInput{DiscountThreshold: 30}, 0, PriceChangeController{}.SetPolicy, 0)
+		test.Equals(t, code, http.StatusForbidden)
+		code, _ = do(t, echo.PUT, "/v1/price-changes/policy", PriceApprovalPolicyInput{DiscountThreshold: 130}, 0, PriceChangeController{}.SetPolicy, 0, models.RolePriceApprover)
+		test.Equals(t, code, http.StatusBadRequest)
+		code, _ = do(t, echo.PUT, "/v1/price-changes/policy", PriceApprovalPolicyInput{DiscountThreshold: 30}, 0, PriceChangeController{}.SetPolicy, 0, models.RolePriceApprover)
+		test.Equals(t, code, http.StatusOK)
+	})
+
+	var pending models.PriceChange
+	t.Run("PendingPrice", func(t *testing.T) {
+		pb, _ := json.Marshal(PriceInput{ProductId: 9001, CustomerGroup: "silver", SalePrice: 60 * models.MajorUnit})
+		req := httptest.NewRequest(echo.POST, "/v1/prices", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusAccepted, rec.Code)
+
+		req = httptest.NewRequest(echo.GET, "/v1/price-changes?status=pending", nil)
+		setHeader(req)
+		rec = httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceChangeController{}.GetAll, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+		var v struct {
+			Result struct {
+				TotalCount int                  `json:"totalCount"`
+				Items      []models.PriceChange `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.TotalCount, 1)
+		pending = v.Result.Items[0]
+		test.Equals(t, pending.Discount, float64(0))
+		test.Equals(t, pending.PriceId, int64(0))
+
+		// the price is not entered until it is approved
+		test.Equals(t, priced(t, "silver"), false)
+	})
+
+	t.Run("Approve", func(t *testing.T) {
+		target := fmt.Sprintf("/v1/price-changes/%d/approve", pending.Id)
+		code, _ := do(t, echo.POST, target, ReviewInput{}, pending.Version, PriceChangeController{}.Approve, 0)
+		test.Equals(t, code, http.StatusForbidden)
+
+		// the price was submitted by a caller of no colleague id, who may not approve it either
+		code, _ = do(t, echo.POST, target, ReviewInput{}, pending.Version, PriceChangeController{}.Approve, 0, models.RolePriceApprover)
+		test.Equals(t, code, http.StatusForbidden)
+
+		code, approved := do(t, echo.POST, target, ReviewInput{Comment: "clearance"}, pending.Version, PriceChangeController{}.Approve, 2, models.RolePriceApprover)
+		test.Equals(t, code, http.StatusOK)
+		test.Equals(t, approved.Status, models.PriceChangeStatusApproved)
+		test.Equals(t, approved.PriceId != 0, true)
+		test.Equals(t, priced(t, "silver"), true)
+
+		code, _ = do(t, echo.POST, target, ReviewInput{}, approved.Version, PriceChangeController{}.Approve, 2, models.RolePriceApprover)
+		test.Equals(t, code, http.StatusBadRequest)
+	})
+
+	t.Run("DraftRejected", func(t *testing.T) {
+		code, draft := do(t, echo.POST, "/v1/price-changes", PriceChangeInput{PriceInput: PriceInput{ProductId: 9001, CustomerGroup: "bronze", SalePrice: 50 * models.MajorUnit}}, 0, PriceChangeController{}.Create, 0)
+		test.Equals(t, code, http.StatusOK)
+		test.Equals(t, draft.Status, models.PriceChangeStatusDraft)
+
+		code, submitted := do(t, echo.POST, fmt.Sprintf("/v1/price-changes/%d/submit", draft.Id), nil, draft.Version, PriceChangeController{}.Submit, 0)
+		test.Equals(t, code, http.StatusOK)
+		test.Equals(t, submitted.Status, models.PriceChangeStatusPending)
+
+		code, _ = do(t, echo.POST, fmt.Sprintf("/v1/price-changes/%d/reject", draft.Id), nil, draft.Version, PriceChangeController{}.Reject, 0, models.RolePriceApprover)
+		test.Equals(t, code, http.StatusPreconditionFailed)
+		code, rejected := do(t, echo.POST, fmt.Sprintf("/v1/price-changes/%d/reject", draft.Id), ReviewInput{Comment: "too deep"}, submitted.Version, PriceChangeController{}.Reject, 0, models.RolePriceApprover)
+		test.Equals(t, code, http.StatusOK)
+		test.Equals(t, rejected.Status, models.PriceChangeStatusRejected)
+		test.Equals(t, rejected.Comment, "too deep")
+		test.Equals(t, priced(t, "bronze"), false)
+	})
+
+	code, _ := do(t, echo.PUT, "/v1/price-changes/policy", PriceApprovalPolicyInput{}, 0, PriceChangeController{}.SetPolicy, 0, models.RolePriceApprover)
+	test.Equals(t, code, http.StatusOK)
+}
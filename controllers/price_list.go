@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/hublabs/common/api"
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/echoswagger"
+)
+
+type PriceListController struct{}
+
+func (c PriceListController) Init(g echoswagger.ApiGroup) {
+	g.SetSecurity("Authorization").SetSecurity(ApiKeyHeader)
+
+	permit(g.GET("", c.GetAll), models.PermissionCatalogRead).
+		AddParamQueryNested(GetAllPriceListInput{})
+	permit(g.GET("/:id", c.GetOne), models.PermissionCatalogRead).
+		AddParamPath(0, "id", "Id of PriceList").
+		AddParamHeader("", "If-None-Match", "ETag of the cached PriceList", false)
+	// 门店、门店组、渠道价目表
+	permit(g.POST("", c.Create), models.PermissionPriceWrite).
+		AddParamBody(PriceListInput{}, "body", "PriceListInput model", true)
+	permit(g.PUT("/:id", c.Update), models.PermissionPriceWrite).
+		AddParamPath(0, "id", "Id of PriceList").
+		AddParamBody(PriceListInput{}, "body", "PriceListInput model", true).
+		AddParamHeader("", "If-Match", "ETag of the PriceList being updated", true)
+}
+
+func (PriceListController) GetAll(c echo.Context) error {
+	var v GetAllPriceListInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if v.MaxResultCount == 0 {
+		v.MaxResultCount = defaultMaxResultCount
+	}
+	totalCount, lists, err := models.PriceList{}.GetAll(c.Request().Context(), v.Scope, v.SkipCount, v.MaxResultCount)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, totalCount, lists)
+}
+
+func (PriceListController) GetOne(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	l, err := models.PriceList{}.Get(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if l == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderSuccWithETag(c, l.Version, l)
+}
+
+func (PriceListController) Create(c echo.Context) error {
+	var v PriceListInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	l := v.ToModel()
+	if err := l.Create(c.Request().Context()); isInvalidPriceList(err) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccWithETag(c, l.Version, l)
+}
+
+func (PriceListController) Update(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return renderFail(c, err)
+	}
+	var v PriceListInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+
+	l := v.ToModel()
+	l.Id = id
+	l.Version = version
+	if err := l.Update(c.Request().Context()); errors.Is(err, models.ErrPriceListNotFound) {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	} else if isInvalidPriceList(err) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if errors.Is(err, models.ErrVersionConflict) {
+		return renderPriceListConflict(c, err, id)
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccWithETag(c, l.Version, l)
+}
+
+func isInvalidPriceList(err error) bool {
+	return errors.Is(err, models.ErrInvalidPriceListScope) ||
+		errors.Is(err, models.ErrInvalidPriceListStores) ||
+		errors.Is(err, models.ErrPriceListChannelNeeded) ||
+		errors.Is(err, models.ErrPriceListCodeExists)
+}
+
+func renderPriceListConflict(c echo.Context, conflict error, id int64) error {
+	if err := rollback(c); err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	current, err := models.PriceList{}.Get(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if current == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderConflict(c, conflict, current.Version, current)
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hublabs/product-api/models"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestPriceList(t *testing.T) {
+	var created models.PriceList
+
+	t.Run("Create", func(t *testing.T) {
+		pb, _ := json.Marshal(PriceListInput{Code: "group#1", Name: "East", Scope: models.PriceListScopeStoreGroup, StoreIds: []int64{11, 12}, Enable: true})
+		req := httptest.NewRequest(echo.POST, "/v1/price-lists", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceListController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result models.PriceList `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.StoreIds, []int64{11, 12})
+		created = v.Result
+	})
+
+	for _, body := range []string{
+		`{"code":"store#1","scope":"store"}`,
+		`{"code":"store#1","scope":"store","storeIds":[1,2]}`,
+		`{"code":"channel#1","scope":"channel"}`,
+		`{"code":"region#1","scope":"region"}`,
+		`{"code":"group#1","scope":"store_group","storeIds":[13]}`,
+	} {
+		t.Run("CreateInvalid "+body, func(t *testing.T) {
+			req := httptest.NewRequest(echo.POST, "/v1/price-lists", bytes.NewReader([]byte(body)))
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			test.Ok(t, handleWithFilter(PriceListController{}.Create, echoApp.NewContext(req, rec)))
+			test.Equals(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+
+	t.Run("Update", func(t *testing.T) {
+		pb, _ := json.Marshal(PriceListInput{Code: "group#1", Name: "East", Scope: models.PriceListScopeStoreGroup, StoreIds: []int64{11, 12, 13}, Enable: true})
+		req := httptest.NewRequest(echo.PUT, "/v1/price-lists/1", bytes.NewReader(pb))
+		setHeader(req)
+		req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, created.Version))
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/price-lists/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprint(created.Id))
+		test.Ok(t, handleWithFilter(PriceListController{}.Update, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result models.PriceList `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.StoreIds, []int64{11, 12, 13})
+		test.Equals(t, v.Result.Version, created.Version+1)
+	})
+
+	t.Run("GetAll", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/price-lists?scope=store_group", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceListController{}.GetAll, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				TotalCount int                `json:"totalCount"`
+				Items      []models.PriceList `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.TotalCount, 1)
+		test.Equals(t, v.Result.Items[0].Code, "group#1")
+	})
+}
@@ -18,17 +18,17 @@ func TestPriceCRUD(t *testing.T) {
 		{
 			Name:      "price#1",
 			Barcode:   "barcode#1",
-			SalePrice: 198.05,
+			SalePrice: 19805 * models.MinorUnit,
 		},
 		{
 			Name:      "price#2",
 			ProductId: 1,
-			SalePrice: 160,
+			SalePrice: 160 * models.MajorUnit,
 		},
 		{
 			Name:      "price#3",
 			Barcode:   "barcode#2",
-			SalePrice: 179.02,
+			SalePrice: 17902 * models.MinorUnit,
 		},
 	}
 
@@ -60,8 +60,70 @@ func TestPriceCRUD(t *testing.T) {
 		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
 		test.Equals(t, v.Result.TotalCount, 2)
 		test.Equals(t, v.Result.Items[0].TargetId, "barcode#2")
-		test.Equals(t, v.Result.Items[0].SalePrice, 179.02)
+		test.Equals(t, v.Result.Items[0].SalePrice, 17902*models.MinorUnit)
 		test.Equals(t, v.Result.Items[1].TargetId, "barcode#1")
-		test.Equals(t, v.Result.Items[1].SalePrice, 198.05)
+		test.Equals(t, v.Result.Items[1].SalePrice, 19805*models.MinorUnit)
+	})
+}
+
+func TestPriceBulk(t *testing.T) {
+	post := func(h echo.HandlerFunc, rows []models.PriceSheetRow) *httptest.ResponseRecorder {
+		pb, _ := json.Marshal(rows)
+		req := httptest.NewRequest(echo.POST, "/v1/prices/bulk", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(h, echoApp.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Validate", func(t *testing.T) {
+		rec := post(PriceController{}.ValidateBulk, []models.PriceSheetRow{
+			{Barcode: "barcode#1", SalePrice: 190 * models.MajorUnit},
+			{Barcode: "barcode#3", SalePrice: 20 * models.MajorUnit},
+			{Barcode: "barcode#4", SkuCode: "sku#4", SalePrice: 20 * models.MajorUnit},
+		})
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result []models.PriceSheetRow `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result[0].Status, models.PriceSheetStatusUpdate)
+		test.Equals(t, *v.Result[0].CurrentPrice, 19805*models.MinorUnit)
+		test.Equals(t, v.Result[1].Status, models.PriceSheetStatusInsert)
+		test.Equals(t, v.Result[2].ErrorList, []int{10018})
+	})
+
+	t.Run("ApplyInvalid", func(t *testing.T) {
+		rec := post(PriceController{}.Bulk, []models.PriceSheetRow{
+			{Barcode: "barcode#3", SalePrice: 20 * models.MajorUnit},
+			{Barcode: "barcode#4"},
+		})
+		test.Equals(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		rec := post(PriceController{}.Bulk, []models.PriceSheetRow{
+			{Barcode: "barcode#1", SalePrice: 190 * models.MajorUnit},
+			{Barcode: "barcode#3", SalePrice: 20 * models.MajorUnit},
+		})
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		req := httptest.NewRequest(echo.GET, "/v1/prices/barcode", nil)
+		setHeader(req)
+		rec = httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.GetAllBarcode, echoApp.NewContext(req, rec)))
+		var v struct {
+			Result struct {
+				TotalCount int                   `json:"totalCount"`
+				Items      []models.PriceSkuInfo `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		// barcode#3 is priced once, the invalid sheet entered nothing
+		test.Equals(t, v.Result.TotalCount, 4)
+		test.Equals(t, v.Result.Items[0].TargetId, "barcode#3")
+		test.Equals(t, v.Result.Items[1].TargetId, "barcode#1")
+		test.Equals(t, v.Result.Items[1].SalePrice, 190*models.MajorUnit)
 	})
 }
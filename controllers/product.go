@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
@@ -19,24 +20,46 @@ import (
 type ProductController struct{}
 
 func (c ProductController) Init(g echoswagger.ApiGroup) {
-	g.SetSecurity("Authorization")
+	g.SetSecurity("Authorization").SetSecurity(ApiKeyHeader)
 
-	g.GET("", c.GetAll).
+	permit(g.GET("", c.GetAll), models.PermissionCatalogRead).
 		AddParamQueryNested(GetAllProductInput{})
-	g.GET("/:id", c.GetOne).
+	permit(g.GET("/:id", c.GetOne), models.PermissionCatalogRead).
 		AddParamPath(0, "id", "Id of Product").
-		AddParamQueryNested(FieldAndStoreInput{})
-	g.POST("", c.CreateOrUpdate).
-		AddParamBody(models.Product{}, "body", "Product model", true)
-	g.GET("/searches", c.SearchAll).
+		AddParamQueryNested(FieldAndStoreInput{}).
+		AddParamHeader("", "If-None-Match", "ETag of the cached Product", false)
+	permit(g.POST("", c.CreateOrUpdate), models.PermissionCatalogWrite).
+		AddParamBody(models.Product{}, "body", "Product model", true).
+		AddParamHeader("", "If-Match", "ETag of the Product being updated, required when the Product exists", false)
+	permit(g.PATCH("/:id", c.Patch), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Product").
+		AddParamBody(models.Product{}, "body", "Merge patch (RFC 7396) or json patch (RFC 6902) of Product model", true).
+		AddParamHeader("", "If-Match", "ETag of the Product being updated", true).
+		SetRequestContentType(string(models.PatchTypeMergePatch), string(models.PatchTypeJSONPatch), echo.MIMEApplicationJSON)
+	permit(g.DELETE("/:id", c.Delete), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Product")
+	permit(g.POST("/:id/restore", c.Restore), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Product")
+	permit(g.GET("/:id/history", c.GetHistory), models.PermissionCatalogRead).
+		AddParamPath(0, "id", "Id of Product").
+		AddParamQueryNested(PagingInput{})
+	permit(g.GET("/:id/prices", c.GetPrices), models.PermissionCatalogRead).
+		AddParamPath(0, "id", "Id of Product").
+		AddParamQueryNested(PagingInput{})
+	permit(g.GET("/searches", c.SearchAll), models.PermissionCatalogRead).
 		AddParamBody(SearchProductInput{}, "body", "", true)
-	g.POST("/searches", c.SearchAll).
+	permit(g.POST("/searches", c.SearchAll), models.PermissionCatalogRead).
 		AddParamBody(SearchProductInput{}, "body", "", true)
-	g.POST("/validate-excel", c.ValidateImportExcel).
+	permit(g.POST("/validate-excel", c.ValidateImportExcel), models.PermissionImportRun).
 		AddParamFile("file", "excel", true)
-	g.POST("/batch", c.BatchImport).
-		AddParamBody([]models.ProductImportTemplate{}, "body", "ProductImportTemplate model", true)
-	g.GET("/statistics", c.StatisticsData)
+	// generateBarcodes: 没有条形码的SKU由条码分配器生成店内码或GS1码
+	permit(g.POST("/batch", c.BatchImport), models.PermissionImportRun).
+		AddParamBody([]models.ProductImportTemplate{}, "body", "ProductImportTemplate model", true).
+		AddParamQuery(false, "generateBarcodes", "Issue barcodes for skus without one", false)
+	permit(g.GET("/statistics", c.StatisticsData), models.PermissionCatalogRead)
+	// 毛利报表, 仅限财务角色
+	permit(g.GET("/margin-report", c.MarginReport), models.PermissionCatalogRead).
+		AddParamQueryNested(FieldAndStoreInput{})
 }
 
 func (ProductController) GetAll(c echo.Context) error {
@@ -59,8 +82,14 @@ func (ProductController) GetAll(c echo.Context) error {
 	if v.MaxResultCount == 0 {
 		v.MaxResultCount = defaultMaxResultCount
 	}
-	hasMore, totalCount, products, err := models.Product{}.GetAll(c.Request().Context(), v.Q, v.HasDigital, v.HasTitleImage, v.BrandCode, v.Enable, codes, ids, brandIds, v.SkipCount, v.MaxResultCount, v.Sortby, v.Order, v.Fields, v.WithHasMore)
+	ctx, err := v.Context(c.Request().Context())
 	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	hasMore, totalCount, products, err := models.Product{}.GetAll(ctx, v.Q, v.HasDigital, v.HasTitleImage, v.BrandCode, v.Enable, codes, ids, brandIds, v.SkipCount, v.MaxResultCount, v.Sortby, v.Order, v.Fields, v.WithHasMore)
+	if errors.Is(err, models.ErrExchangeRateNotFound) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
 		return renderFail(c, api.ErrorDB.New(err))
 	}
 	return renderSuccArray(c, v.WithHasMore, hasMore, totalCount, products)
@@ -75,14 +104,24 @@ func (ProductController) GetOne(c echo.Context) error {
 	if err := c.Bind(&v); err != nil {
 		return renderFail(c, api.ErrorParameter.New(err))
 	}
-	product, err := models.Product{}.GetOne(c.Request().Context(), id, v.Fields)
+	ctx, err := v.Context(c.Request().Context())
 	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	product, err := models.Product{}.GetOne(ctx, id, v.Fields)
+	if errors.Is(err, models.ErrExchangeRateNotFound) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
 		return renderFail(c, api.ErrorDB.New(err))
 	}
 	if product == nil {
 		return renderFail(c, api.ErrorNotFound.New(nil))
 	}
-	return renderSucc(c, http.StatusOK, product)
+	// the version does not tell the prices of another time, currency or store, or offers, apart
+	if v.pricesVary() {
+		return renderSucc(c, http.StatusOK, product)
+	}
+	return renderSuccWithETag(c, product.Version, product)
 }
 
 func (ProductController) CreateOrUpdate(c echo.Context) error {
@@ -90,11 +129,164 @@ func (ProductController) CreateOrUpdate(c echo.Context) error {
 	if err := c.Bind(&product); err != nil {
 		return renderFail(c, api.ErrorParameter.New(err))
 	}
+	if product.Id != 0 {
+		exist, err := models.Product{}.Exist(c.Request().Context(), product.Id)
+		if err != nil {
+			return renderFail(c, api.ErrorDB.New(err))
+		}
+		if exist {
+			version, err := ifMatchVersion(c)
+			if err != nil {
+				return renderFail(c, err)
+			}
+			product.Version = version
+		}
+	}
 	result, err := models.Product{}.CreateOrUpdate(c.Request().Context(), product)
+	if errors.Is(err, models.ErrVersionConflict) {
+		return renderProductConflict(c, err, product.Id)
+	} else if errors.Is(err, models.ErrInvalidPriceSchedule) || errors.Is(err, models.ErrInvalidCurrency) || errors.Is(err, models.ErrInvalidGTIN) {
+		return renderInvalidProduct(c, err)
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccWithETag(c, result.Version, result)
+}
+
+func (ProductController) Patch(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return renderFail(c, err)
+	}
+	patch, err := ioutil.ReadAll(c.Request().Body)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	patchType := models.PatchTypeMergePatch
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), string(models.PatchTypeJSONPatch)) {
+		patchType = models.PatchTypeJSONPatch
+	}
+
+	product, err := models.Product{}.Patch(c.Request().Context(), id, version, patchType, patch)
+	if errors.Is(err, models.ErrVersionConflict) {
+		return renderProductConflict(c, err, id)
+	} else if errors.Is(err, models.ErrInvalidPatch) || errors.Is(err, models.ErrInvalidPriceSchedule) ||
+		errors.Is(err, models.ErrInvalidCurrency) || errors.Is(err, models.ErrInvalidGTIN) {
+		return renderInvalidProduct(c, err)
+	} else if errors.Is(err, models.ErrIdentifierExist) {
+		if err := rollback(c); err != nil {
+			return renderFail(c, api.ErrorDB.New(err))
+		}
+		return renderFail(c, api.ErrorHasExisted.New(err))
+	} else if err != nil {
 		return renderFail(c, api.ErrorDB.New(err))
 	}
-	return renderSucc(c, http.StatusOK, result)
+	if product == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderSuccWithETag(c, product.Version, product)
+}
+
+// renderInvalidProduct discards what was written of the product before the invalid part was met.
+func renderInvalidProduct(c echo.Context, invalid error) error {
+	if err := rollback(c); err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderFail(c, api.ErrorParameter.New(invalid))
+}
+
+func renderProductConflict(c echo.Context, conflict error, id int64) error {
+	if err := rollback(c); err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	current, err := models.Product{}.GetOne(c.Request().Context(), id, models.FieldTypeList{models.FieldTypeAttribute})
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if current == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderConflict(c, conflict, current.Version, current)
+}
+
+func (ProductController) Delete(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	product, err := models.Product{}.Delete(c.Request().Context(), id)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if product == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderSucc(c, http.StatusOK, product)
+}
+
+func (ProductController) Restore(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	product, err := models.Product{}.Restore(c.Request().Context(), id)
+	if errors.Is(err, models.ErrIdentifierExist) {
+		return renderFail(c, api.ErrorHasExisted.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	if product == nil {
+		return renderFail(c, api.ErrorNotFound.New(nil))
+	}
+	return renderSucc(c, http.StatusOK, product)
+}
+
+func (ProductController) GetPrices(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	var v PagingInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if err := c.Validate(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if v.MaxResultCount == 0 {
+		v.MaxResultCount = defaultMaxResultCount
+	}
+	totalCount, prices, err := models.Price{}.GetByTarget(c.Request().Context(), models.PriceTargetTypeProduct, strconv.FormatInt(id, 10), v.SkipCount, v.MaxResultCount)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, totalCount, prices)
+}
+
+func (ProductController) GetHistory(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	var v PagingInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if err := c.Validate(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	if v.MaxResultCount == 0 {
+		v.MaxResultCount = defaultMaxResultCount
+	}
+	totalCount, logs, err := models.AuditLog{}.GetBySubject(c.Request().Context(), models.AuditEntityProduct, id, v.SkipCount, v.MaxResultCount)
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSuccArray(c, false, false, totalCount, logs)
 }
 
 func (ProductController) SearchAll(c echo.Context) error {
@@ -140,13 +332,17 @@ func (ProductController) ValidateImportExcel(c echo.Context) error {
 	if err != nil {
 		return renderFail(c, api.ErrorParameter.New(err))
 	}
+	rounding, err := models.TenantCurrency{}.GetRounding(c.Request().Context())
+	if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
 	var pList []models.ProductImportTemplate
 	for i := range rows {
 		if i == 0 {
 			continue
 		}
 		var p models.ProductImportTemplate
-		var listPrice, salePrice float64
+		var listPrice, salePrice models.Money
 		if rows[i][0] == "" {
 			p.ErrorList = append(p.ErrorList, 10001) //商品编码
 		}
@@ -162,11 +358,12 @@ func (ProductController) ValidateImportExcel(c echo.Context) error {
 		if rows[i][4] == "" {
 			p.ErrorList = append(p.ErrorList, 10003) //商品名称
 		}
-		listPrice, _ = strconv.ParseFloat(rows[i][7], 64)
+		// a cell may hold a float like 99.9900000001, so it is rounded as the tenant rounds
+		listPrice, _ = models.ParseMoneyRounded(rows[i][7], rounding)
 		if listPrice <= 0 {
 			p.ErrorList = append(p.ErrorList, 10006) //吊牌价
 		}
-		salePrice, _ = strconv.ParseFloat(rows[i][8], 64)
+		salePrice, _ = models.ParseMoneyRounded(rows[i][8], rounding)
 		if salePrice <= 0 {
 			p.ErrorList = append(p.ErrorList, 10007) //销售价
 		}
@@ -189,13 +386,31 @@ func (ProductController) ValidateImportExcel(c echo.Context) error {
 		if listPrice < salePrice {
 			p.ErrorList = append(p.ErrorList, 10008)
 		}
+		// the sale price of a sku is optional, the sku is sold at the sale price of its product without it
+		if len(rows[i]) > 9 && rows[i][9] != "" {
+			p.SkuSalePrice, _ = models.ParseMoneyRounded(rows[i][9], rounding)
+			if p.SkuSalePrice <= 0 {
+				p.ErrorList = append(p.ErrorList, 10016) //SKU销售价
+			} else if listPrice < p.SkuSalePrice {
+				p.ErrorList = append(p.ErrorList, 10017)
+			}
+		}
+		// the barcode is optional too, and is kept as its GTIN-14 so the variants of one code are seen as one
+		if len(rows[i]) > 10 && rows[i][10] != "" {
+			p.BarCode = rows[i][10]
+			if gtin, err := models.NormalizeGTIN(p.BarCode); err != nil {
+				p.ErrorList = append(p.ErrorList, 10027) //条形码
+			} else {
+				p.BarCode = gtin
+			}
+		}
 		if p.SkuCode == "" && p.ProductCode == "" && p.ProductName == "" && p.Color == "" && p.Size == "" && p.BrandCode == "" && p.BrandName == "" {
 			continue
 		}
 		pList = append(pList, p)
 	}
 	for i := range pList {
-		var lcount, scount, rcount int
+		var lcount, scount, rcount, bcount int
 		for j := range pList {
 			if pList[i].BrandCode == pList[j].BrandCode && pList[i].ProductCode == pList[j].ProductCode && pList[i].ListPrice != pList[j].ListPrice {
 				lcount++
@@ -206,6 +421,9 @@ func (ProductController) ValidateImportExcel(c echo.Context) error {
 			if pList[i].BrandCode == pList[j].BrandCode && pList[i].SkuCode == pList[j].SkuCode && i != j {
 				rcount++
 			}
+			if pList[i].BarCode != "" && pList[i].BarCode == pList[j].BarCode && i != j {
+				bcount++
+			}
 		}
 		if lcount > 0 {
 			pList[i].ErrorList = append(pList[i].ErrorList, 10011)
@@ -216,6 +434,9 @@ func (ProductController) ValidateImportExcel(c echo.Context) error {
 		if rcount > 0 {
 			pList[i].ErrorList = append(pList[i].ErrorList, 10013)
 		}
+		if bcount > 0 {
+			pList[i].ErrorList = append(pList[i].ErrorList, 10010)
+		}
 	}
 
 	list, err := models.ProductImportTemplate{}.ValidateImport(c.Request().Context(), pList)
@@ -231,8 +452,11 @@ func (ProductController) BatchImport(c echo.Context) error {
 		return renderFail(c, api.ErrorParameter.New(err))
 	}
 	ctx := context.WithValue(c.Request().Context(), models.DataSourceContext, models.DataSourceExcel)
-	result, err := models.ProductImportTemplate{}.BatchImport(ctx, list)
-	if err != nil {
+	generateBarcodes, _ := strconv.ParseBool(c.QueryParam("generateBarcodes"))
+	result, err := models.ProductImportTemplate{}.BatchImport(ctx, list, generateBarcodes)
+	if errors.Is(err, models.ErrBarcodeAllocatorNotSet) || errors.Is(err, models.ErrBarcodeRangeExhausted) {
+		return renderInvalidProduct(c, err)
+	} else if err != nil {
 		return renderFail(c, api.ErrorDB.New(err))
 	}
 	return renderSucc(c, http.StatusOK, result)
@@ -245,3 +469,24 @@ func (ProductController) StatisticsData(c echo.Context) error {
 	}
 	return renderSucc(c, http.StatusOK, result)
 }
+
+func (ProductController) MarginReport(c echo.Context) error {
+	if !models.CostVisible(c.Request().Context()) {
+		return renderFail(c, api.ErrorPermissionDenied.New(nil))
+	}
+	var v FieldAndStoreInput
+	if err := c.Bind(&v); err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	ctx, err := v.Context(c.Request().Context())
+	if err != nil {
+		return renderFail(c, api.ErrorParameter.New(err))
+	}
+	result, err := (models.Product{}).MarginReport(ctx)
+	if errors.Is(err, models.ErrExchangeRateNotFound) {
+		return renderFail(c, api.ErrorParameter.New(err))
+	} else if err != nil {
+		return renderFail(c, api.ErrorDB.New(err))
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/hublabs/product-api/models"
 
 	"github.com/labstack/echo"
+	"github.com/pangpanglabs/goutils/jwtutil"
 	"github.com/pangpanglabs/goutils/test"
 )
 
@@ -122,7 +123,10 @@ func TestProductCRUD(t *testing.T) {
 		test.Equals(t, len(v.Result.Items[1].Skus), 1)
 	})
 
-	var product models.Product
+	var (
+		product models.Product
+		etag    string
+	)
 
 	t.Run("GetOne", func(t *testing.T) {
 		req := httptest.NewRequest(echo.GET, "/v1/products/1", nil)
@@ -141,7 +145,200 @@ func TestProductCRUD(t *testing.T) {
 		}
 		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
 		test.Equals(t, v.Result.Name, "product#1")
+		test.Equals(t, rec.Header().Get("ETag"), fmt.Sprintf("%q", fmt.Sprint(v.Result.Version)))
 		product = v.Result
+		etag = rec.Header().Get("ETag")
+	})
+
+	t.Run("GetOneNotModified", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/products/1", nil)
+		setHeader(req)
+		req.Header.Set("If-None-Match", etag)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.GetOne, c))
+		test.Equals(t, http.StatusNotModified, rec.Code)
+	})
+
+	t.Run("GetOneAsOf", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/products/1?asOf=2000-01-01T00:00:00Z", nil)
+		setHeader(req)
+		req.Header.Set("If-None-Match", etag)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.GetOne, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result  models.Product `json:"result"`
+			Success bool           `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Prices[0].SalePrice, 200*models.MajorUnit)
+	})
+
+	t.Run("GetOneInCurrency", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/products/1?currency=USD", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.GetOne, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result models.Product `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Currency, "CNY")
+		test.Equals(t, v.Result.ConvertedListPrice.Currency, "USD")
+		test.Equals(t, v.Result.ConvertedListPrice.Amount, 2817*models.MinorUnit)
+	})
+
+	t.Run("GetOneInStore", func(t *testing.T) {
+		for _, l := range []struct {
+			list  PriceListInput
+			price models.Money
+		}{
+			{PriceListInput{Code: "store#1", Scope: models.PriceListScopeStore, StoreIds: []int64{1}, Enable: true}, 90 * models.MajorUnit},
+			{PriceListInput{Code: "online", Scope: models.PriceListScopeChannel, Channel: "online", Enable: true}, 80 * models.MajorUnit},
+		} {
+			pb, _ := json.Marshal(l.list)
+			req := httptest.NewRequest(echo.POST, "/v1/price-lists", bytes.NewReader(pb))
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			test.Ok(t, handleWithFilter(PriceListController{}.Create, echoApp.NewContext(req, rec)))
+			test.Equals(t, http.StatusOK, rec.Code)
+			var v struct {
+				Result models.PriceList `json:"result"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+
+			pb, _ = json.Marshal(PriceInput{ProductId: 2, PriceListId: v.Result.Id, SalePrice: l.price})
+			req = httptest.NewRequest(echo.POST, "/v1/prices", bytes.NewReader(pb))
+			setHeader(req)
+			rec = httptest.NewRecorder()
+			test.Ok(t, handleWithFilter(PriceController{}.Create, echoApp.NewContext(req, rec)))
+			test.Equals(t, http.StatusOK, rec.Code)
+		}
+
+		for query, salePrice := range map[string]models.Money{
+			"storeId=1":                90 * models.MajorUnit,
+			"storeId=1&channel=online": 90 * models.MajorUnit,
+			"storeId=2&channel=online": 80 * models.MajorUnit,
+			"storeId=2":                100 * models.MajorUnit,
+		} {
+			req := httptest.NewRequest(echo.GET, "/v1/products/2?"+query, nil)
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			c := echoApp.NewContext(req, rec)
+			c.SetPath("/v1/products/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("2")
+			test.Ok(t, handleWithFilter(ProductController{}.GetOne, c))
+			test.Equals(t, http.StatusOK, rec.Code)
+
+			var v struct {
+				Result models.Product `json:"result"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+			test.Equals(t, v.Result.Prices[0].SalePrice, salePrice)
+		}
+	})
+
+	t.Run("GetOneWithOffer", func(t *testing.T) {
+		for query, offers := range map[string]int{"withOffer=true": 1, "withOffer=true&storeId=1": 2} {
+			req := httptest.NewRequest(echo.GET, "/v1/products/1?"+query, nil)
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			c := echoApp.NewContext(req, rec)
+			c.SetPath("/v1/products/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			test.Ok(t, handleWithFilter(ProductController{}.GetOne, c))
+			test.Equals(t, http.StatusOK, rec.Code)
+
+			var v struct {
+				Result models.Product `json:"result"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+			test.Equals(t, v.Result.Prices[0].SalePrice, 160*models.MajorUnit)
+			test.Equals(t, len(v.Result.Offers), offers)
+			test.Equals(t, v.Result.Offers[0].Price, 128*models.MajorUnit)
+			// a price for two items does not make the price of one
+			test.Equals(t, *v.Result.OfferPrice, 128*models.MajorUnit)
+			if offers == 2 {
+				test.Equals(t, v.Result.Offers[1].Quantity, 2)
+				test.Equals(t, v.Result.Offers[1].Price, 150*models.MajorUnit)
+			}
+		}
+	})
+
+	t.Run("CreatePriceInUnknownPriceList", func(t *testing.T) {
+		pb, _ := json.Marshal(PriceInput{ProductId: 2, PriceListId: 999, SalePrice: 70 * models.MajorUnit})
+		req := httptest.NewRequest(echo.POST, "/v1/prices", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("GetPrices", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/products/1/prices", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id/prices")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.GetPrices, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				TotalCount int            `json:"totalCount"`
+				Items      []models.Price `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.TotalCount, 1)
+		test.Equals(t, v.Result.Items[0].SalePrice, 160*models.MajorUnit)
+	})
+
+	t.Run("GetEffectivePrices", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/prices/effective?productIds=1,2", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.GetEffective, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				Items []models.Price `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, len(v.Result.Items), 2)
+		test.Equals(t, v.Result.Items[0].SalePrice, 160*models.MajorUnit)
+		test.Equals(t, v.Result.Items[1].TargetId, "2")
+		test.Equals(t, v.Result.Items[1].SalePrice, 100*models.MajorUnit)
+	})
+
+	t.Run("UpdateWithoutIfMatch", func(t *testing.T) {
+		pb, _ := json.Marshal(product)
+		req := httptest.NewRequest(echo.POST, "/v1/products", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(ProductController{}.CreateOrUpdate, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusPreconditionRequired, rec.Code)
 	})
 
 	t.Run("Update", func(t *testing.T) {
@@ -149,6 +346,7 @@ func TestProductCRUD(t *testing.T) {
 		pb, _ := json.Marshal(product)
 		req := httptest.NewRequest(echo.POST, "/v1/products", bytes.NewReader(pb))
 		setHeader(req)
+		req.Header.Set("If-Match", etag)
 		rec := httptest.NewRecorder()
 		c := echoApp.NewContext(req, rec)
 		c.SetPath("/v1/products/:id")
@@ -163,5 +361,279 @@ func TestProductCRUD(t *testing.T) {
 		}
 		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
 		test.Equals(t, v.Result.Name, "product#updated")
+		test.Equals(t, v.Result.Version, product.Version+1)
+		test.Equals(t, rec.Header().Get("ETag") != etag, true)
+	})
+
+	t.Run("UpdateConflict", func(t *testing.T) {
+		product.Name = "product#stale"
+		pb, _ := json.Marshal(product)
+		req := httptest.NewRequest(echo.POST, "/v1/products", bytes.NewReader(pb))
+		setHeader(req)
+		req.Header.Set("If-Match", etag)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(ProductController{}.CreateOrUpdate, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusPreconditionFailed, rec.Code)
+
+		var v struct {
+			Result  models.Product `json:"result"`
+			Success bool           `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Success, false)
+		test.Equals(t, v.Result.Name, "product#updated")
+		etag = rec.Header().Get("ETag")
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		for _, p := range []struct {
+			contentType string
+			body        string
+			code        int
+			name        string
+		}{
+			{string(models.PatchTypeMergePatch), `{"name":"product#patched","attributes":{"Year":"2019"}}`, http.StatusOK, "product#patched"},
+			{string(models.PatchTypeJSONPatch), `[{"op":"replace","path":"/name","value":"product#updated"}]`, http.StatusOK, "product#updated"},
+			{string(models.PatchTypeJSONPatch), `[{"op":"test","path":"/name","value":"product#1"}]`, http.StatusBadRequest, ""},
+			{string(models.PatchTypeMergePatch), `{"id":100}`, http.StatusBadRequest, ""},
+		} {
+			req := httptest.NewRequest(echo.PATCH, "/v1/products/1", bytes.NewReader([]byte(p.body)))
+			setHeader(req)
+			req.Header.Set(echo.HeaderContentType, p.contentType)
+			req.Header.Set("If-Match", etag)
+			rec := httptest.NewRecorder()
+			c := echoApp.NewContext(req, rec)
+			c.SetPath("/v1/products/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			test.Ok(t, handleWithFilter(ProductController{}.Patch, c))
+			test.Equals(t, p.code, rec.Code)
+			if p.code != http.StatusOK {
+				continue
+			}
+
+			var v struct {
+				Result  models.Product `json:"result"`
+				Success bool           `json:"success"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+			test.Equals(t, v.Result.Name, p.name)
+			test.Equals(t, v.Result.Attributes["Year"], "2019")
+			etag = rec.Header().Get("ETag")
+		}
+	})
+
+	t.Run("PatchRejected", func(t *testing.T) {
+		get := func(t *testing.T) (models.Product, int) {
+			req := httptest.NewRequest(echo.GET, "/v1/products/1?fields=attribute&fields=sku", nil)
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			c := echoApp.NewContext(req, rec)
+			c.SetPath("/v1/products/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			test.Ok(t, handleWithFilter(ProductController{}.GetOne, c))
+			test.Equals(t, http.StatusOK, rec.Code)
+			var p struct {
+				Result models.Product `json:"result"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &p))
+
+			req = httptest.NewRequest(echo.GET, "/v1/products/1/history", nil)
+			setHeader(req)
+			rec = httptest.NewRecorder()
+			c = echoApp.NewContext(req, rec)
+			c.SetPath("/v1/products/:id/history")
+			c.SetParamNames("id")
+			c.SetParamValues("1")
+			test.Ok(t, handleWithFilter(ProductController{}.GetHistory, c))
+			test.Equals(t, http.StatusOK, rec.Code)
+			var h struct {
+				Result struct {
+					TotalCount int `json:"totalCount"`
+				} `json:"result"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &h))
+			return p.Result, h.Result.TotalCount
+		}
+		before, history := get(t)
+		test.Equals(t, len(before.Skus) > 0, true)
+
+		// attributes and skus sort before the readonly createdAt, and the product row after it
+		body := `{"name":"product#rejected","attributes":{"Year":"2020"},"skus":[],"createdAt":"2000-01-01T00:00:00Z"}`
+		req := httptest.NewRequest(echo.PATCH, "/v1/products/1", bytes.NewReader([]byte(body)))
+		setHeader(req)
+		req.Header.Set(echo.HeaderContentType, string(models.PatchTypeMergePatch))
+		req.Header.Set("If-Match", etag)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.Patch, c))
+		test.Equals(t, http.StatusBadRequest, rec.Code)
+
+		after, afterHistory := get(t)
+		test.Equals(t, after.Name, before.Name)
+		test.Equals(t, after.Version, before.Version)
+		test.Equals(t, after.Attributes["Year"], "2019")
+		test.Equals(t, len(after.Skus), len(before.Skus))
+		test.Equals(t, afterHistory, history)
+	})
+
+	t.Run("History", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/products/1/history", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id/history")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.GetHistory, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				TotalCount int               `json:"totalCount"`
+				Items      []models.AuditLog `json:"items"`
+			} `json:"result"`
+			Success bool `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		// latest first: the json patch of name, then the merge patch of name and attribute Year
+		test.Equals(t, v.Result.Items[0].EntityType, models.AuditEntityProduct)
+		test.Equals(t, v.Result.Items[0].Changes, []models.FieldChange{{Field: "name", Before: "product#patched", After: "product#updated"}})
+		test.Equals(t, v.Result.Items[1].Changes, []models.FieldChange{{Field: "name", Before: "product#updated", After: "product#patched"}})
+		test.Equals(t, v.Result.Items[2].EntityType, models.AuditEntityAttributeValue)
+		test.Equals(t, v.Result.Items[2].Label, "Year")
+		test.Equals(t, v.Result.Items[2].Changes[len(v.Result.Items[2].Changes)-1], models.FieldChange{Field: "value", After: "2019"})
+		last := v.Result.Items[len(v.Result.Items)-1]
+		test.Equals(t, last.Action, models.AuditActionCreated)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := httptest.NewRequest(echo.DELETE, "/v1/products/1", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.Delete, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		req = httptest.NewRequest(echo.GET, "/v1/products/1", nil)
+		setHeader(req)
+		rec = httptest.NewRecorder()
+		c = echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.GetOne, c))
+		test.Equals(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/v1/products/1/restore", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/products/:id/restore")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(ProductController{}.Restore, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result  models.Product `json:"result"`
+			Success bool           `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Name, "product#updated")
+		test.Equals(t, len(v.Result.Skus), 1)
+	})
+
+	t.Run("CostPrice", func(t *testing.T) {
+		getOne := func(setHeader func(*http.Request)) (models.Product, string) {
+			req := httptest.NewRequest(echo.GET, "/v1/products/2", nil)
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			c := echoApp.NewContext(req, rec)
+			c.SetPath("/v1/products/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("2")
+			test.Ok(t, handleWithFilter(ProductController{}.GetOne, c))
+			test.Equals(t, http.StatusOK, rec.Code)
+			var v struct {
+				Result models.Product `json:"result"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+			return v.Result, rec.Header().Get("ETag")
+		}
+		finance := func(r *http.Request) { setHeaderWithRoles(r, models.RoleFinance) }
+
+		// only a caller with the finance role may write the cost
+		for _, h := range []struct {
+			setHeader func(*http.Request)
+			code      int
+		}{
+			{setHeader, http.StatusBadRequest},
+			{finance, http.StatusOK},
+		} {
+			_, etag := getOne(setHeader)
+			req := httptest.NewRequest(echo.PATCH, "/v1/products/2", bytes.NewReader([]byte(`{"costPrice":60}`)))
+			h.setHeader(req)
+			req.Header.Set(echo.HeaderContentType, string(models.PatchTypeMergePatch))
+			req.Header.Set("If-Match", etag)
+			rec := httptest.NewRecorder()
+			c := echoApp.NewContext(req, rec)
+			c.SetPath("/v1/products/:id")
+			c.SetParamNames("id")
+			c.SetParamValues("2")
+			test.Ok(t, handleWithFilter(ProductController{}.Patch, c))
+			test.Equals(t, h.code, rec.Code)
+		}
+
+		p, _ := getOne(finance)
+		test.Equals(t, p.CostPrice, models.Money(6000))
+		test.Equals(t, p.Margin.Amount, p.Prices[0].SalePrice-p.CostPrice)
+
+		p, _ = getOne(setHeader)
+		test.Equals(t, p.CostPrice, models.Money(0))
+		test.Equals(t, p.Margin == nil, true)
+	})
+
+	t.Run("MarginReport", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/products/margin-report", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(ProductController{}.MarginReport, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusForbidden, rec.Code)
+
+		req = httptest.NewRequest(echo.GET, "/v1/products/margin-report", nil)
+		setHeaderWithRoles(req, models.RoleFinance)
+		rec = httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(ProductController{}.MarginReport, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				Data       []models.MarginReportData `json:"data"`
+				TotalCount int                       `json:"totalCount"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.TotalCount, 1)
+		test.Equals(t, len(v.Result.Data), 1)
+		test.Equals(t, v.Result.Data[0].CostTotal, models.Money(6000))
+		test.Equals(t, v.Result.Data[0].Margin, v.Result.Data[0].SaleTotal-models.Money(6000))
+
+		// a token claiming the finance role is refused unless signed by the jwt secret
+		forged, _ := jwtutil.NewTokenWithSecret(map[string]interface{}{"aud": "colleague", "tenantCode": "test", "iss": "colleague", "roles": []string{models.RoleFinance}}, "forged")
+		req = httptest.NewRequest(echo.GET, "/v1/products/margin-report", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+forged)
+		rec = httptest.NewRecorder()
+		echoRouter.ServeHTTP(rec, req)
+		test.Equals(t, http.StatusUnauthorized, rec.Code)
 	})
 }
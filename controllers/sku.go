@@ -17,26 +17,63 @@ import (
 type SkuController struct{}
 
 func (c SkuController) Init(g echoswagger.ApiGroup) {
-	g.SetSecurity("Authorization")
+	g.SetSecurity("Authorization").SetSecurity(ApiKeyHeader)
 
-	g.GET("", c.GetAll).
+	permit(g.GET("", c.GetAll), models.PermissionCatalogRead).
 		AddParamQueryNested(GetAllSkuInput{})
-	g.GET("/:id", c.GetOne).
+	permit(g.GET("/:id", c.GetOne), models.PermissionCatalogRead).
 		AddParamPath(0, "id", "Id of Sku").
-		AddParamQueryNested(FieldAndStoreInput{})
+		AddParamQueryNested(FieldAndStoreInput{}).
+		AddParamHeader("", "If-None-Match", "ETag of the cached Sku", false)
+	permit(g.POST("", c.Create), models.PermissionCatalogWrite).
+		AddParamBody(models.Sku{}, "body", "Sku model", true)
+	permit(g.PUT("/:id", c.Update), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Sku").
+		AddParamBody(models.Sku{}, "body", "Sku model", true).
+		AddParamHeader("", "If-Match", "ETag of the Sku being updated", true)
+	permit(g.PATCH("/:id/enable", c.UpdateEnable), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Sku").
+		AddParamBody(SkuEnableInput{}, "body", "", true).
+		AddParamHeader("", "If-Match", "ETag of the Sku being updated", true)
+	permit(g.PATCH("/:id/saleable", c.UpdateSaleable), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Sku").
+		AddParamBody(SkuSaleableInput{}, "body", "", true).
+		AddParamHeader("", "If-Match", "ETag of the Sku being updated", true)
+	permit(g.DELETE("/:id", c.Delete), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Sku")
+	permit(g.POST("/:id/restore", c.Restore), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Sku")
+	permit(g.GET("/:id/history", c.GetHistory), models.PermissionCatalogRead).
+		AddParamPath(0, "id", "Id of Sku").
+		AddParamQueryNested(PagingInput{})
+	// 自有品牌SKU没有厂商条码时, 按GS1厂商识别码或店内码(2开头)生成EAN-13
+	permit(g.GET("/barcode-allocator", c.GetBarcodeAllocator), models.PermissionCatalogRead)
+	permit(g.PUT("/barcode-allocator", c.SetBarcodeAllocator), models.PermissionCatalogWrite).
+		AddParamBody(BarcodeAllocatorInput{}, "body", "BarcodeAllocatorInput model", true)
+	permit(g.POST("/:id/barcodes/generate", c.GenerateBarcode), models.PermissionCatalogWrite).
+		AddParamPath(0, "id", "Id of Sku")
+	// 门店打印价签/吊牌, ZPL用于热敏打印机, PDF用于办公打印机
+	permit(g.GET("/:id/label", c.GetLabel), models.PermissionCatalogRead).
+		AddParamPath(0, "id", "Id of Sku").
+		AddParamQueryNested(LabelInput{})
+	permit(g.POST("/labels", c.GetLabels), models.PermissionCatalogRead).
+		AddParamBody(LabelsInput{}, "body", "LabelsInput model", true)
+	permit(g.GET("/label-templates", c.GetLabelTemplates), models.PermissionCatalogRead)
+	permit(g.PUT("/label-templates", c.SaveLabelTemplate), models.PermissionCatalogWrite).
+		AddParamBody(models.LabelTemplate{}, "body", "LabelTemplate model", true)
 	// According to https://stackoverflow.com/questions/5020704/how-to-design-restful-search-filtering
 	// `/searches` with POST method should be a standard of search/filter resources with long parameter.
 
 	// [Updated 20190724] After discuss with jang.jaehue, add a GET method for same API
 	// refer to https://gitlab.srxcloud.com/github.com/hublabs/product-api/merge_requests/64
 	// https://www.elastic.co/guide/en/elasticsearch/reference/current/search-request-body.html
-	g.GET("/uids", c.GetByUids).
+	permit(g.GET("/uids", c.GetByUids), models.PermissionCatalogRead).
 		AddParamBody(SearchSkuByUidInput{}, "body", "", true)
-	g.POST("/uids", c.GetByUids).
+	permit(g.POST("/uids", c.GetByUids), models.PermissionCatalogRead).
 		AddParamBody(SearchSkuByUidInput{}, "body", "", true)
-	g.GET("/searches", c.SearchAll).
+	permit(g.GET("/searches", c.SearchAll), models.PermissionCatalogRead).
 		AddParamBody(SearchSkuInput{}, "body", "", true)
-	g.POST("/searches", c.SearchAll).
+	permit(g.POST("/searches", c.SearchAll), models.PermissionCatalogRead).
 		AddParamBody(SearchSkuInput{}, "body", "", true)
 }
 
@@ -61,8 +98,14 @@ func (SkuController) GetAll(c echo.Context) error {
 	if v.MaxResultCount == 0 {
 		v.MaxResultCount = defaultMaxResultCount
 	}
-	hasMore, totalCount, skus, err := models.Sku{}.GetAll(c.Request().Context(), v.Q, v.ProductCode, v.Barcode, v.BrandCode, v.Enable, v.Saleable, codes, ids, brandIds, v.SkipCount, v.MaxResultCount, v.Sortby, v.Order, v.Fields, v.WithHasMore)
+	ctx, err := v.Context(c.Request().Context())
 	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	hasMore, totalCount, skus, err := models.Sku{}.GetAll(ctx, v.Q, v.ProductCode, v.Barcode, v.BrandCode, v.Enable, v.Saleable, codes, ids, brandIds, v.SkipCount, v.MaxResultCount, v.Sortby, v.Order, v.Fields, v.WithHasMore)
+	if errors.Is(err, models.ErrExchangeRateNotFound) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
 		return api.ErrorDB.New(err)
 	}
 	return renderSuccArray(c, v.WithHasMore, hasMore, totalCount, skus)
@@ -78,8 +121,193 @@ func (SkuController) GetOne(c echo.Context) error {
 		return api.ErrorParameter.New(err)
 	}
 
-	sku, err := models.Sku{}.GetOne(c.Request().Context(), id, v.Fields)
+	ctx, err := v.Context(c.Request().Context())
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	sku, err := models.Sku{}.GetOne(ctx, id, v.Fields)
+	if errors.Is(err, models.ErrExchangeRateNotFound) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	if sku == nil {
+		return api.ErrorNotFound.New(nil)
+	}
+	// the version does not tell the prices of another time, currency or store, or offers, apart
+	if v.pricesVary() {
+		return renderSucc(c, http.StatusOK, sku)
+	}
+	return renderSuccWithETag(c, sku.Version, sku)
+}
+
+func (SkuController) Create(c echo.Context) error {
+	var sku models.Sku
+	if err := c.Bind(&sku); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	if sku.ProductId == 0 {
+		return api.ErrorMissParameter.New(errors.New("productId"))
+	}
+	ctx := c.Request().Context()
+	exist, err := models.Product{}.Exist(ctx, sku.ProductId)
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	if !exist {
+		return api.ErrorNotFound.New(errors.New("product not found"))
+	}
+
+	sku.Id = 0
+	if err := sku.Create(ctx); errors.Is(err, models.ErrInvalidGTIN) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	result, err := models.Sku{}.GetOne(ctx, sku.Id, nil)
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (SkuController) Update(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+	var sku models.Sku
+	if err := c.Bind(&sku); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	ctx := c.Request().Context()
+	current, err := models.Sku{}.Get(ctx, id)
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	if current == nil {
+		return api.ErrorNotFound.New(nil)
+	}
+
+	sku.Id = current.Id
+	sku.ProductId = current.ProductId
+	sku.Version = version
+	if err := sku.Update(ctx); errors.Is(err, models.ErrVersionConflict) {
+		return renderSkuConflict(c, err, id)
+	} else if errors.Is(err, models.ErrIdentifierExist) {
+		return api.ErrorHasExisted.New(err)
+	} else if errors.Is(err, models.ErrInvalidGTIN) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	result, err := models.Sku{}.GetOne(ctx, id, nil)
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	return renderSuccWithETag(c, result.Version, result)
+}
+
+func (SkuController) UpdateEnable(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+	var v SkuEnableInput
+	if err := c.Bind(&v); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	if v.Enable == nil {
+		return api.ErrorMissParameter.New(errors.New("enable"))
+	}
+	sku, err := models.Sku{}.UpdateEnable(c.Request().Context(), id, version, *v.Enable)
+	if errors.Is(err, models.ErrVersionConflict) {
+		return renderSkuConflict(c, err, id)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	if sku == nil {
+		return api.ErrorNotFound.New(nil)
+	}
+	return renderSuccWithETag(c, sku.Version, sku)
+}
+
+func (SkuController) UpdateSaleable(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+	var v SkuSaleableInput
+	if err := c.Bind(&v); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	if v.Saleable == nil {
+		return api.ErrorMissParameter.New(errors.New("saleable"))
+	}
+	sku, err := models.Sku{}.UpdateSaleable(c.Request().Context(), id, version, *v.Saleable)
+	if errors.Is(err, models.ErrVersionConflict) {
+		return renderSkuConflict(c, err, id)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	if sku == nil {
+		return api.ErrorNotFound.New(nil)
+	}
+	return renderSuccWithETag(c, sku.Version, sku)
+}
+
+func renderSkuConflict(c echo.Context, conflict error, id int64) error {
+	if err := rollback(c); err != nil {
+		return api.ErrorDB.New(err)
+	}
+	current, err := models.Sku{}.GetOne(c.Request().Context(), id, nil)
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	if current == nil {
+		return api.ErrorNotFound.New(nil)
+	}
+	return renderConflict(c, conflict, current.Version, current)
+}
+
+func (SkuController) Delete(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	sku, err := models.Sku{}.Delete(c.Request().Context(), id)
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	if sku == nil {
+		return api.ErrorNotFound.New(nil)
+	}
+	return renderSucc(c, http.StatusOK, sku)
+}
+
+func (SkuController) Restore(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	sku, err := models.Sku{}.Restore(c.Request().Context(), id)
+	if errors.Is(err, models.ErrIdentifierExist) {
+		return api.ErrorHasExisted.New(err)
+	} else if errors.Is(err, models.ErrProductDeleted) {
+		return api.ErrorNotUpdated.New(err)
+	} else if err != nil {
 		return api.ErrorDB.New(err)
 	}
 	if sku == nil {
@@ -88,6 +316,28 @@ func (SkuController) GetOne(c echo.Context) error {
 	return renderSucc(c, http.StatusOK, sku)
 }
 
+func (SkuController) GetHistory(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	var v PagingInput
+	if err := c.Bind(&v); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	if err := c.Validate(&v); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	if v.MaxResultCount == 0 {
+		v.MaxResultCount = defaultMaxResultCount
+	}
+	totalCount, logs, err := models.AuditLog{}.GetBySubject(c.Request().Context(), models.AuditEntitySku, id, v.SkipCount, v.MaxResultCount)
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	return renderSuccArray(c, false, false, totalCount, logs)
+}
+
 func (SkuController) SearchAll(c echo.Context) error {
 	var v SearchSkuInput
 	if err := c.Bind(&v); err != nil {
@@ -102,8 +352,14 @@ func (SkuController) SearchAll(c echo.Context) error {
 	if v.MaxResultCount == 0 {
 		v.MaxResultCount = defaultMaxResultCount
 	}
-	hasMore, totalCount, skus, err := models.Sku{}.SearchAll(c.Request().Context(), v.Q, v.Enable, v.Saleable, v.Filters, v.SkipCount, v.MaxResultCount, v.Sortby, v.Order, v.Fields, v.WithHasMore)
+	ctx, err := v.Context(c.Request().Context())
 	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	hasMore, totalCount, skus, err := models.Sku{}.SearchAll(ctx, v.Q, v.Enable, v.Saleable, v.Filters, v.SkipCount, v.MaxResultCount, v.Sortby, v.Order, v.Fields, v.WithHasMore)
+	if errors.Is(err, models.ErrExchangeRateNotFound) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
 		return api.ErrorDB.New(err)
 	}
 	return renderSuccArray(c, v.WithHasMore, hasMore, totalCount, skus)
@@ -122,3 +378,130 @@ func (SkuController) GetByUids(c echo.Context) error {
 
 	return renderSucc(c, http.StatusOK, result)
 }
+
+func (SkuController) GetBarcodeAllocator(c echo.Context) error {
+	result, err := models.BarcodeAllocator{}.Load(c.Request().Context())
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (SkuController) SetBarcodeAllocator(c echo.Context) error {
+	var v BarcodeAllocatorInput
+	if err := c.Bind(&v); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	result, err := models.BarcodeAllocator{}.Set(c.Request().Context(), v.Prefix, v.Next)
+	if errors.Is(err, models.ErrInvalidBarcodePrefix) || errors.Is(err, models.ErrInvalidBarcodeSequenceNext) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (SkuController) GenerateBarcode(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	sku, err := models.Sku{}.GenerateBarcode(c.Request().Context(), id)
+	if errors.Is(err, models.ErrSkuHasBarcode) || errors.Is(err, models.ErrBarcodeAllocatorNotSet) ||
+		errors.Is(err, models.ErrBarcodeRangeExhausted) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	if sku == nil {
+		return api.ErrorNotFound.New(nil)
+	}
+	return renderSuccWithETag(c, sku.Version, sku)
+}
+
+func (SkuController) GetLabel(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	var v LabelInput
+	if err := c.Bind(&v); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	return renderLabels(c, v.Format, v.Template, v.FieldAndStoreInput, []models.LabelRequest{{SkuId: id, Copies: v.Copies}})
+}
+
+func (SkuController) GetLabels(c echo.Context) error {
+	var v LabelsInput
+	if err := c.Bind(&v); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	if len(v.Items) == 0 {
+		return api.ErrorMissParameter.New(errors.New("items"))
+	}
+	return renderLabels(c, v.Format, v.Template, v.FieldAndStoreInput, v.Items)
+}
+
+func renderLabels(c echo.Context, format models.LabelFormat, code string, store FieldAndStoreInput, requests []models.LabelRequest) error {
+	if format == "" {
+		format = models.LabelFormatZPL
+	}
+	if format != models.LabelFormatZPL && format != models.LabelFormatPDF {
+		return api.ErrorParameter.New(models.ErrInvalidLabelFormat)
+	}
+	ctx, err := store.Context(c.Request().Context())
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	t, err := models.LabelTemplate{}.Get(ctx, code)
+	if errors.Is(err, models.ErrLabelTemplateNotFound) {
+		return api.ErrorNotFound.New(err)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	labels, err := models.LabelData{}.LoadLabels(ctx, requests)
+	if errors.Is(err, models.ErrLabelSkuNotFound) {
+		return api.ErrorNotFound.New(err)
+	} else if errors.Is(err, models.ErrLabelBarcodeNotFound) || errors.Is(err, models.ErrTooManyLabels) ||
+		errors.Is(err, models.ErrExchangeRateNotFound) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+
+	if format == models.LabelFormatPDF {
+		data, err := t.RenderPDF(labels)
+		if errors.Is(err, models.ErrUnprintableBarcode) {
+			return api.ErrorParameter.New(err)
+		} else if err != nil {
+			return api.ErrorDB.New(err)
+		}
+		return c.Blob(http.StatusOK, "application/pdf", data)
+	}
+	data, err := t.RenderZPL(labels)
+	if err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	return c.Blob(http.StatusOK, "text/plain; charset=utf-8", data)
+}
+
+func (SkuController) GetLabelTemplates(c echo.Context) error {
+	result, err := models.LabelTemplate{}.GetAll(c.Request().Context())
+	if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+func (SkuController) SaveLabelTemplate(c echo.Context) error {
+	var v models.LabelTemplate
+	if err := c.Bind(&v); err != nil {
+		return api.ErrorParameter.New(err)
+	}
+	if err := v.Save(c.Request().Context()); errors.Is(err, models.ErrInvalidLabelTemplate) {
+		return api.ErrorParameter.New(err)
+	} else if err != nil {
+		return api.ErrorDB.New(err)
+	}
+	return renderSucc(c, http.StatusOK, v)
+}
@@ -1,10 +1,14 @@
 package controllers
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/hublabs/product-api/models"
 
@@ -52,6 +56,54 @@ func TestSkuCRUD(t *testing.T) {
 		test.Equals(t, v.Result.Items[1].Name, "sku#1")
 	})
 
+	t.Run("GetAllInStore", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/skus?brandIds=1,2&fields=item&storeId=1", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(SkuController{}.GetAll, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				Items []models.Sku `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Items[0].Name, "sku#2")
+		test.Equals(t, v.Result.Items[0].Product.Prices[0].SalePrice, 90*models.MajorUnit)
+		test.Equals(t, v.Result.Items[0].Price.SalePrice, 90*models.MajorUnit)
+		test.Equals(t, v.Result.Items[1].Product.Prices[0].SalePrice, 160*models.MajorUnit)
+		test.Equals(t, v.Result.Items[1].PriceSource, models.PriceSourceProduct)
+	})
+
+	t.Run("GetOneWithSkuPrice", func(t *testing.T) {
+		pb, _ := json.Marshal(PriceInput{SkuId: 1, SalePrice: 170 * models.MajorUnit})
+		req := httptest.NewRequest(echo.POST, "/v1/prices", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		req = httptest.NewRequest(echo.GET, "/v1/skus/1", nil)
+		setHeader(req)
+		rec = httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/skus/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(SkuController{}.GetOne, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result models.Sku `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.PriceSource, models.PriceSourceSku)
+		test.Equals(t, v.Result.Price.SalePrice, 170*models.MajorUnit)
+		// the product keeps its own price
+		test.Equals(t, v.Result.Product.Prices[0].SalePrice, 160*models.MajorUnit)
+	})
+
 	t.Run("GetOne", func(t *testing.T) {
 		req := httptest.NewRequest(echo.GET, "/v1/skus/1", nil)
 		setHeader(req)
@@ -70,4 +122,543 @@ func TestSkuCRUD(t *testing.T) {
 		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
 		test.Equals(t, v.Result.Name, "sku#1")
 	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := httptest.NewRequest(echo.DELETE, "/v1/skus/1", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/skus/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(SkuController{}.Delete, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		req = httptest.NewRequest(echo.GET, "/v1/skus/1", nil)
+		setHeader(req)
+		rec = httptest.NewRecorder()
+		c = echoApp.NewContext(req, rec)
+		c.SetPath("/v1/skus/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Equals(t, handleWithFilter(SkuController{}.GetOne, c) != nil, true)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/v1/skus/1/restore", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/skus/:id/restore")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+		test.Ok(t, handleWithFilter(SkuController{}.Restore, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result  models.Sku `json:"result"`
+			Success bool       `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Name, "sku#1")
+	})
+}
+
+func TestSkuWrite(t *testing.T) {
+	var sku models.Sku
+	t.Run("Create", func(t *testing.T) {
+		pb, _ := json.Marshal(map[string]interface{}{
+			"productId": 2,
+			"code":      "S201",
+			"name":      "sku#201",
+			"identifiers": []map[string]interface{}{
+				{"uid": "2000002010012", "source": models.IdentifierSourceBarcode},
+			},
+			"options": []map[string]interface{}{
+				{"name": "size", "value": "XL"},
+			},
+		})
+		req := httptest.NewRequest(echo.POST, "/v1/skus", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(SkuController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result  models.Sku `json:"result"`
+			Success bool       `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.ProductId, int64(2))
+		test.Equals(t, v.Result.Identifiers[0].Uid, "02000002010012")
+		test.Equals(t, v.Result.Options[0].Value, "XL")
+		sku = v.Result
+	})
+
+	t.Run("CreateInvalidBarcode", func(t *testing.T) {
+		pb, _ := json.Marshal(map[string]interface{}{
+			"productId":   2,
+			"code":        "S202",
+			"identifiers": []map[string]interface{}{{"uid": "2000002010013", "source": models.IdentifierSourceBarcode}},
+		})
+		req := httptest.NewRequest(echo.POST, "/v1/skus", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Equals(t, handleWithFilter(SkuController{}.Create, echoApp.NewContext(req, rec)) != nil, true)
+	})
+
+	t.Run("CreateWithoutProduct", func(t *testing.T) {
+		pb, _ := json.Marshal(map[string]interface{}{"productId": 999, "code": "S202"})
+		req := httptest.NewRequest(echo.POST, "/v1/skus", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Equals(t, handleWithFilter(SkuController{}.Create, echoApp.NewContext(req, rec)) != nil, true)
+	})
+
+	t.Run("ValidateBulkPrice", func(t *testing.T) {
+		tomorrow := time.Now().Add(24 * time.Hour)
+		pb, _ := json.Marshal([]models.PriceSheetRow{
+			{Barcode: "2000002010012", SalePrice: 150 * models.MajorUnit},
+			{SkuCode: "S201", SalePrice: 95 * models.MajorUnit, StartAt: &tomorrow},
+		})
+		req := httptest.NewRequest(echo.POST, "/v1/prices/bulk/validate", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.ValidateBulk, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result []models.PriceSheetRow `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		// the barcode is the sku's, whose list price is the one of product#2
+		test.Equals(t, v.Result[0].TargetType, models.PriceTargetType(models.PriceTargetTypeSku))
+		test.Equals(t, v.Result[0].TargetId, fmt.Sprint(sku.Id))
+		test.Equals(t, *v.Result[0].ListPrice, 100*models.MajorUnit)
+		test.Equals(t, v.Result[0].ErrorList, []int{10008})
+		test.Equals(t, v.Result[1].TargetId, fmt.Sprint(sku.Id))
+		test.Equals(t, len(v.Result[1].ErrorList), 0)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		sku.Name = "sku#201-2"
+		sku.Options[0].Value = "XXL"
+		pb, _ := json.Marshal(sku)
+		req := httptest.NewRequest(echo.PUT, "/v1/skus", bytes.NewReader(pb))
+		setHeader(req)
+		req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, sku.Version))
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/skus/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprint(sku.Id))
+		test.Ok(t, handleWithFilter(SkuController{}.Update, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result  models.Sku `json:"result"`
+			Success bool       `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Name, "sku#201-2")
+		test.Equals(t, len(v.Result.Options), 1)
+		test.Equals(t, v.Result.Options[0].Value, "XXL")
+		test.Equals(t, v.Result.Version, sku.Version+1)
+		sku = v.Result
+	})
+
+	t.Run("UpdateConflict", func(t *testing.T) {
+		pb, _ := json.Marshal(map[string]interface{}{"name": "sku#stale"})
+		req := httptest.NewRequest(echo.PUT, "/v1/skus", bytes.NewReader(pb))
+		setHeader(req)
+		req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, sku.Version-1))
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/skus/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprint(sku.Id))
+		test.Ok(t, handleWithFilter(SkuController{}.Update, c))
+		test.Equals(t, http.StatusPreconditionFailed, rec.Code)
+		test.Equals(t, rec.Header().Get("ETag"), fmt.Sprintf(`"%d"`, sku.Version))
+
+		var v struct {
+			Result  models.Sku `json:"result"`
+			Success bool       `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Name, "sku#201-2")
+		test.Equals(t, len(v.Result.Options), 1)
+	})
+
+	for _, s := range []struct {
+		path    string
+		handler echo.HandlerFunc
+		body    string
+		check   func(models.Sku) bool
+	}{
+		{"enable", SkuController{}.UpdateEnable, `{"enable":true}`, func(s models.Sku) bool { return s.Enable }},
+		{"saleable", SkuController{}.UpdateSaleable, `{"saleable":true}`, func(s models.Sku) bool { return s.Saleable }},
+		{"saleable", SkuController{}.UpdateSaleable, `{"saleable":false}`, func(s models.Sku) bool { return !s.Saleable }},
+	} {
+		t.Run("Patch "+s.body, func(t *testing.T) {
+			req := httptest.NewRequest(echo.PATCH, "/v1/skus/"+fmt.Sprint(sku.Id)+"/"+s.path, strings.NewReader(s.body))
+			setHeader(req)
+			req.Header.Set("If-Match", fmt.Sprintf(`"%d"`, sku.Version))
+			rec := httptest.NewRecorder()
+			c := echoApp.NewContext(req, rec)
+			c.SetPath("/v1/skus/:id/" + s.path)
+			c.SetParamNames("id")
+			c.SetParamValues(fmt.Sprint(sku.Id))
+			test.Ok(t, handleWithFilter(s.handler, c))
+			test.Equals(t, http.StatusOK, rec.Code)
+
+			var v struct {
+				Result  models.Sku `json:"result"`
+				Success bool       `json:"success"`
+			}
+			test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+			test.Equals(t, s.check(v.Result), true)
+			sku = v.Result
+		})
+	}
+
+	t.Run("History", func(t *testing.T) {
+		req := httptest.NewRequest(echo.GET, "/v1/skus/"+fmt.Sprint(sku.Id)+"/history", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetPath("/v1/skus/:id/history")
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprint(sku.Id))
+		test.Ok(t, handleWithFilter(SkuController{}.GetHistory, c))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result struct {
+				TotalCount int               `json:"totalCount"`
+				Items      []models.AuditLog `json:"items"`
+			} `json:"result"`
+			Success bool `json:"success"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.TotalCount, 5)
+		test.Equals(t, v.Result.Items[0].Changes, []models.FieldChange{{Field: "saleable", Before: "1", After: "0"}})
+		test.Equals(t, v.Result.Items[3].Changes, []models.FieldChange{{Field: "name", Before: "sku#201", After: "sku#201-2"}})
+		test.Equals(t, v.Result.Items[4].Action, models.AuditActionCreated)
+	})
+}
+
+// TestPriceQuote and TestBarcodeMapping are of PriceController, they run here after the skus they price are created.
+func TestPriceQuote(t *testing.T) {
+	for _, p := range []PriceInput{
+		{ProductId: 2, MinQuantity: 12, SalePrice: 90 * models.MajorUnit},
+		{ProductId: 2, MinQuantity: 48, SalePrice: 80 * models.MajorUnit},
+		{ProductId: 2, CustomerGroup: "gold", SalePrice: 85 * models.MajorUnit},
+	} {
+		pb, _ := json.Marshal(p)
+		req := httptest.NewRequest(echo.POST, "/v1/prices", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+	}
+
+	t.Run("QuantityTiers", func(t *testing.T) {
+		lines := quoteLines(t, QuoteInput{Lines: []QuoteLineInput{{SkuId: 2, Quantity: 1}, {SkuId: 2, Quantity: 12}, {SkuId: 2, Quantity: 50}}})
+		test.Equals(t, len(lines), 3)
+		test.Equals(t, lines[0].PriceSource, models.PriceSourceListPrice)
+		test.Equals(t, lines[0].UnitPrice, 100*models.MajorUnit)
+		test.Equals(t, lines[1].UnitPrice, 90*models.MajorUnit)
+		test.Equals(t, lines[1].ExtendedPrice, 1080*models.MajorUnit)
+		test.Equals(t, lines[2].MinQuantity, 48)
+		test.Equals(t, lines[2].ExtendedPrice, 4000*models.MajorUnit)
+	})
+
+	t.Run("CustomerGroup", func(t *testing.T) {
+		lines := quoteLines(t, QuoteInput{CustomerGroup: "gold", Lines: []QuoteLineInput{{SkuId: 2, Quantity: 2}}})
+		test.Equals(t, lines[0].CustomerGroup, "gold")
+		test.Equals(t, lines[0].ExtendedPrice, 170*models.MajorUnit)
+	})
+
+	for _, body := range []string{`{"lines":[]}`, `{"lines":[{"skuId":2,"quantity":0}]}`, `{"lines":[{"skuId":999,"quantity":1}]}`} {
+		t.Run("Invalid "+body, func(t *testing.T) {
+			req := httptest.NewRequest(echo.POST, "/v1/prices/quote", bytes.NewReader([]byte(body)))
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			test.Ok(t, handleWithFilter(PriceController{}.Quote, echoApp.NewContext(req, rec)))
+			test.Equals(t, http.StatusBadRequest, rec.Code)
+		})
+	}
+}
+
+func TestBarcodeMapping(t *testing.T) {
+	for _, barcode := range []string{"2000002010012", "01234565"} {
+		pb, _ := json.Marshal(PriceInput{Barcode: barcode, SalePrice: 30 * models.MajorUnit})
+		req := httptest.NewRequest(echo.POST, "/v1/prices", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.Create, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+	}
+
+	barcodes := func(t *testing.T, mapped string) []models.PriceSkuInfo {
+		req := httptest.NewRequest(echo.GET, "/v1/prices/barcode?mapped="+mapped, nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.GetAllBarcode, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+		var v struct {
+			Result struct {
+				Items []models.PriceSkuInfo `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		return v.Result.Items
+	}
+	mapBarcode := func(t *testing.T, barcode string, skuId int64) *httptest.ResponseRecorder {
+		pb, _ := json.Marshal(MapBarcodeInput{SkuId: skuId})
+		req := httptest.NewRequest(echo.POST, "/v1/prices/barcode/"+barcode+"/map", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetParamNames("barcode")
+		c.SetParamValues(barcode)
+		test.Ok(t, handleWithFilter(PriceController{}.MapBarcode, c))
+		return rec
+	}
+
+	t.Run("Filter", func(t *testing.T) {
+		mapped := barcodes(t, "true")
+		test.Equals(t, len(mapped), 1)
+		test.Equals(t, mapped[0].TargetId, "02000002010012")
+		test.Equals(t, mapped[0].MappedAt != nil, true)
+		for _, p := range barcodes(t, "false") {
+			test.Equals(t, p.MappedAt == nil, true)
+		}
+	})
+
+	t.Run("AutoMap", func(t *testing.T) {
+		req := httptest.NewRequest(echo.POST, "/v1/prices/barcode/auto-map", nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(PriceController{}.AutoMapBarcodes, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+
+		var v struct {
+			Result models.BarcodeAutoMapResult `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, len(v.Result.Mapped), 1)
+		test.Equals(t, v.Result.Mapped[0].Barcode, "02000002010012")
+		test.Equals(t, v.Result.Mapped[0].ProductId, int64(2))
+		test.Equals(t, len(v.Result.Mapped[0].PriceIds), 1)
+		test.Equals(t, len(barcodes(t, "true")), 0)
+	})
+
+	t.Run("Map", func(t *testing.T) {
+		test.Equals(t, mapBarcode(t, "012345000065", 999).Code, http.StatusBadRequest)
+		// the price was entered for the UPC-E of the same code
+		test.Equals(t, mapBarcode(t, "012345000065", 2).Code, http.StatusOK)
+		test.Equals(t, mapBarcode(t, "012345000065", 2).Code, http.StatusNotFound)
+		for _, p := range barcodes(t, "") {
+			test.Equals(t, p.TargetId != "00012345000065", true)
+		}
+
+		// the price of the barcode is now the regular price of the sku
+		lines := quoteLines(t, QuoteInput{Lines: []QuoteLineInput{{SkuId: 2, Quantity: 1}}})
+		test.Equals(t, lines[0].UnitPrice, 30*models.MajorUnit)
+	})
+}
+
+func TestBarcodeGeneration(t *testing.T) {
+	generate := func(t *testing.T, id int64) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(echo.POST, fmt.Sprintf("/v1/skus/%d/barcodes/generate", id), nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprint(id))
+		return rec, handleWithFilter(SkuController{}.GenerateBarcode, c)
+	}
+	setAllocator := func(t *testing.T, input BarcodeAllocatorInput) error {
+		pb, _ := json.Marshal(input)
+		req := httptest.NewRequest(echo.PUT, "/v1/skus/barcode-allocator", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		return handleWithFilter(SkuController{}.SetBarcodeAllocator, echoApp.NewContext(req, rec))
+	}
+
+	pb, _ := json.Marshal(map[string]interface{}{"productId": 2, "code": "S203", "name": "private label"})
+	req := httptest.NewRequest(echo.POST, "/v1/skus", bytes.NewReader(pb))
+	setHeader(req)
+	rec := httptest.NewRecorder()
+	test.Ok(t, handleWithFilter(SkuController{}.Create, echoApp.NewContext(req, rec)))
+	var created struct {
+		Result models.Sku `json:"result"`
+	}
+	test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &created))
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		_, err := generate(t, created.Result.Id)
+		test.Equals(t, err != nil, true)
+	})
+
+	t.Run("Configure", func(t *testing.T) {
+		test.Equals(t, setAllocator(t, BarcodeAllocatorInput{Prefix: "1234"}) != nil, true)
+		test.Ok(t, setAllocator(t, BarcodeAllocatorInput{Prefix: "2001"}))
+	})
+
+	t.Run("Generate", func(t *testing.T) {
+		rec, err := generate(t, created.Result.Id)
+		test.Ok(t, err)
+		test.Equals(t, http.StatusOK, rec.Code)
+		var v struct {
+			Result models.Sku `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result.Identifiers[0].Uid, "02001000000005")
+
+		// the sku has a barcode now
+		_, err = generate(t, created.Result.Id)
+		test.Equals(t, err != nil, true)
+	})
+
+	t.Run("BatchImport", func(t *testing.T) {
+		pb, _ := json.Marshal([]models.ProductImportTemplate{{
+			ProductCode: "P900", ProductName: "private label", SkuCode: "S900", Color: "white", Size: "M",
+			BrandCode: "private", BrandName: "private", ListPrice: 10 * models.MajorUnit, SalePrice: 10 * models.MajorUnit,
+		}})
+		req := httptest.NewRequest(echo.POST, "/v1/products/batch?generateBarcodes=true", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(ProductController{}.BatchImport, echoApp.NewContext(req, rec)))
+		test.Equals(t, http.StatusOK, rec.Code)
+		var v struct {
+			Result []models.Product `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, v.Result[0].Skus[0].Identifiers[0].Uid, "02001000000012")
+
+		req = httptest.NewRequest(echo.GET, "/v1/skus/barcode-allocator", nil)
+		setHeader(req)
+		rec = httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(SkuController{}.GetBarcodeAllocator, echoApp.NewContext(req, rec)))
+		var a struct {
+			Result models.BarcodeAllocator `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &a))
+		test.Equals(t, a.Result.Next, int64(2))
+	})
+}
+
+func TestLabels(t *testing.T) {
+	req := httptest.NewRequest(echo.GET, "/v1/skus?barcode=2001000000005", nil)
+	setHeader(req)
+	rec := httptest.NewRecorder()
+	test.Ok(t, handleWithFilter(SkuController{}.GetAll, echoApp.NewContext(req, rec)))
+	var skus struct {
+		Result struct {
+			Items []models.Sku `json:"items"`
+		} `json:"result"`
+	}
+	test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &skus))
+	test.Equals(t, len(skus.Result.Items), 1)
+	id := skus.Result.Items[0].Id
+
+	label := func(t *testing.T, query string) (*httptest.ResponseRecorder, error) {
+		req := httptest.NewRequest(echo.GET, fmt.Sprintf("/v1/skus/%d/label?%s", id, query), nil)
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		c := echoApp.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(fmt.Sprint(id))
+		return rec, handleWithFilter(SkuController{}.GetLabel, c)
+	}
+
+	t.Run("ZPL", func(t *testing.T) {
+		rec, err := label(t, "copies=2")
+		test.Ok(t, err)
+		test.Equals(t, http.StatusOK, rec.Code)
+		zpl := rec.Body.String()
+		test.Equals(t, strings.HasPrefix(zpl, "^XA"), true)
+		test.Equals(t, strings.Contains(zpl, "^FD200100000000^FS"), true)
+		test.Equals(t, strings.Contains(zpl, "^PQ2"), true)
+	})
+
+	t.Run("PDF", func(t *testing.T) {
+		rec, err := label(t, "format=pdf")
+		test.Ok(t, err)
+		test.Equals(t, http.StatusOK, rec.Code)
+		test.Equals(t, rec.Header().Get(echo.HeaderContentType), "application/pdf")
+		test.Equals(t, strings.HasPrefix(rec.Body.String(), "%PDF-"), true)
+	})
+
+	t.Run("InvalidFormat", func(t *testing.T) {
+		_, err := label(t, "format=png")
+		test.Equals(t, err != nil, true)
+	})
+
+	t.Run("TemplateNotFound", func(t *testing.T) {
+		_, err := label(t, "template=hang-tag")
+		test.Equals(t, err != nil, true)
+	})
+
+	t.Run("Template", func(t *testing.T) {
+		pb, _ := json.Marshal(models.LabelTemplate{Code: "hang-tag", Name: "hang tag", Width: 40, Height: 60,
+			Zpl: "^XA^FO10,10^A0N,20,20^FD{{.SkuCode}} {{.Price}}^FS^XZ"})
+		req := httptest.NewRequest(echo.PUT, "/v1/skus/label-templates", bytes.NewReader(pb))
+		setHeader(req)
+		rec := httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(SkuController{}.SaveLabelTemplate, echoApp.NewContext(req, rec)))
+
+		req = httptest.NewRequest(echo.GET, "/v1/skus/label-templates", nil)
+		setHeader(req)
+		rec = httptest.NewRecorder()
+		test.Ok(t, handleWithFilter(SkuController{}.GetLabelTemplates, echoApp.NewContext(req, rec)))
+		var v struct {
+			Result []models.LabelTemplate `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+		test.Equals(t, len(v.Result), 2)
+		test.Equals(t, v.Result[1].Code, "hang-tag")
+
+		rec, err := label(t, "template=hang-tag")
+		test.Ok(t, err)
+		test.Equals(t, strings.HasPrefix(rec.Body.String(), "^XA^FO10,10^A0N,20,20^FDS203 "), true)
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		labels := func(input LabelsInput) (*httptest.ResponseRecorder, error) {
+			pb, _ := json.Marshal(input)
+			req := httptest.NewRequest(echo.POST, "/v1/skus/labels", bytes.NewReader(pb))
+			setHeader(req)
+			rec := httptest.NewRecorder()
+			return rec, handleWithFilter(SkuController{}.GetLabels, echoApp.NewContext(req, rec))
+		}
+		rec, err := labels(LabelsInput{Format: models.LabelFormatZPL, Items: []models.LabelRequest{{SkuId: id}, {SkuId: id, Copies: 3}}})
+		test.Ok(t, err)
+		test.Equals(t, strings.Count(rec.Body.String(), "^XA"), 2)
+
+		_, err = labels(LabelsInput{Items: []models.LabelRequest{{SkuId: id, Copies: models.MaxLabels + 1}}})
+		test.Equals(t, err != nil, true)
+		_, err = labels(LabelsInput{Items: []models.LabelRequest{{SkuId: 99999}}})
+		test.Equals(t, err != nil, true)
+	})
+}
+
+func quoteLines(t *testing.T, input QuoteInput) []models.QuoteLine {
+	pb, _ := json.Marshal(input)
+	req := httptest.NewRequest(echo.POST, "/v1/prices/quote", bytes.NewReader(pb))
+	setHeader(req)
+	rec := httptest.NewRecorder()
+	test.Ok(t, handleWithFilter(PriceController{}.Quote, echoApp.NewContext(req, rec)))
+	test.Equals(t, http.StatusOK, rec.Code)
+
+	var v struct {
+		Result struct {
+			Items []models.QuoteLine `json:"items"`
+		} `json:"result"`
+	}
+	test.Ok(t, json.Unmarshal(rec.Body.Bytes(), &v))
+	return v.Result.Items
 }
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hublabs/common/api"
+
+	"github.com/labstack/echo"
+	"github.com/pangpanglabs/goutils/test"
+)
+
+// TestTenantIsolation reads and writes the resources of tenant test, created by the tests before, as tenant other.
+func TestTenantIsolation(t *testing.T) {
+	ids, codes := map[string]int64{}, map[string]string{}
+	for name, list := range map[string]echo.HandlerFunc{
+		"brands":        BrandController{}.GetAll,
+		"offers":        OfferController{}.GetAll,
+		"price-lists":   PriceListController{}.GetAll,
+		"price-changes": PriceChangeController{}.GetAll,
+		"products":      ProductController{}.GetAll,
+		"skus":          SkuController{}.GetAll,
+	} {
+		status, body := requestAs(t, "test", echo.GET, "/v1/"+name+"?brandIds=1,2", list)
+		test.Equals(t, http.StatusOK, status)
+		var v struct {
+			Result struct {
+				Items []struct {
+					Id   int64  `json:"id"`
+					Code string `json:"code"`
+				} `json:"items"`
+			} `json:"result"`
+		}
+		test.Ok(t, json.Unmarshal(body, &v))
+		test.Assert(t, len(v.Result.Items) != 0, "tenant test has no "+name)
+		ids[name] = v.Result.Items[0].Id
+		for _, item := range v.Result.Items {
+			if item.Code != "" {
+				codes[name] = item.Code
+				break
+			}
+		}
+	}
+
+	for name, get := range map[string]echo.HandlerFunc{
+		"brands":        BrandController{}.GetOne,
+		"offers":        OfferController{}.GetOne,
+		"price-lists":   PriceListController{}.GetOne,
+		"price-changes": PriceChangeController{}.GetOne,
+		"products":      ProductController{}.GetOne,
+		"skus":          SkuController{}.GetOne,
+	} {
+		t.Run("GetOne "+name, func(t *testing.T) {
+			status, _ := requestAs(t, "test", echo.GET, fmt.Sprintf("/v1/%s/%d", name, ids[name]), get, "id", fmt.Sprint(ids[name]))
+			test.Equals(t, http.StatusOK, status)
+			status, _ = requestAs(t, "other", echo.GET, fmt.Sprintf("/v1/%s/%d", name, ids[name]), get, "id", fmt.Sprint(ids[name]))
+			test.Equals(t, http.StatusNotFound, status)
+		})
+	}
+
+	for name, list := range map[string]echo.HandlerFunc{
+		"/v1/offers":                    OfferController{}.GetAll,
+		"/v1/price-lists":               PriceListController{}.GetAll,
+		"/v1/price-changes":             PriceChangeController{}.GetAll,
+		"/v1/products?brandIds=1,2":     ProductController{}.GetAll,
+		"/v1/skus?brandIds=1,2":         SkuController{}.GetAll,
+		"/v1/prices/barcode":            PriceController{}.GetAllBarcode,
+		"/v1/currencies/exchange-rates": CurrencyController{}.GetExchangeRates,
+		fmt.Sprintf("/v1/products/%d/prices", ids["products"]): ProductController{}.GetPrices,
+	} {
+		t.Run("GetAll "+name, func(t *testing.T) {
+			status, body := requestAs(t, "other", echo.GET, name, list, "id", fmt.Sprint(ids["products"]))
+			test.Equals(t, http.StatusOK, status)
+			var v struct {
+				Result struct {
+					TotalCount int `json:"totalCount"`
+				} `json:"result"`
+			}
+			test.Ok(t, json.Unmarshal(body, &v))
+			test.Equals(t, 0, v.Result.TotalCount)
+		})
+	}
+
+	t.Run("SearchAll", func(t *testing.T) {
+		target := "/v1/skus/searches?q=" + codes["skus"]
+		status, body := requestAs(t, "test", echo.GET, target, SkuController{}.SearchAll)
+		test.Equals(t, http.StatusOK, status)
+		test.Assert(t, !strings.Contains(string(body), `"totalCount":0`), string(body))
+
+		status, body = requestAs(t, "other", echo.GET, target, SkuController{}.SearchAll)
+		test.Equals(t, http.StatusOK, status)
+		test.Assert(t, strings.Contains(string(body), `"totalCount":0`), string(body))
+	})
+
+	t.Run("GetByUids", func(t *testing.T) {
+		status, body := requestAs(t, "test", echo.GET, "/v1/skus/uids?source=Barcode&uids=2001000000005", SkuController{}.GetByUids)
+		test.Equals(t, http.StatusOK, status)
+		test.Assert(t, !strings.Contains(string(body), `"result":[]`), string(body))
+
+		status, body = requestAs(t, "other", echo.GET, "/v1/skus/uids?source=Barcode&uids=2001000000005", SkuController{}.GetByUids)
+		test.Equals(t, http.StatusOK, status)
+		test.Assert(t, strings.Contains(string(body), `"result":[]`), string(body))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		target := fmt.Sprintf("/v1/products/%d", ids["products"])
+		status, _ := requestAs(t, "other", echo.DELETE, target, ProductController{}.Delete, "id", fmt.Sprint(ids["products"]))
+		test.Equals(t, http.StatusNotFound, status)
+		status, _ = requestAs(t, "other", echo.DELETE, fmt.Sprintf("/v1/skus/%d", ids["skus"]), SkuController{}.Delete, "id", fmt.Sprint(ids["skus"]))
+		test.Equals(t, http.StatusNotFound, status)
+
+		status, _ = requestAs(t, "test", echo.GET, target, ProductController{}.GetOne, "id", fmt.Sprint(ids["products"]))
+		test.Equals(t, http.StatusOK, status)
+	})
+
+	t.Run("NoTenant", func(t *testing.T) {
+		for name, handler := range map[string]echo.HandlerFunc{
+			"/v1/brands":                    BrandController{}.GetAll,
+			"/v1/currencies/default":        CurrencyController{}.GetDefault,
+			"/v1/offers":                    OfferController{}.GetAll,
+			"/v1/price-lists":               PriceListController{}.GetAll,
+			"/v1/prices/barcode":            PriceController{}.GetAllBarcode,
+			"/v1/price-changes":             PriceChangeController{}.GetAll,
+			"/v1/products?brandIds=1,2":     ProductController{}.GetAll,
+			"/v1/skus?brandIds=1,2":         SkuController{}.GetAll,
+			"/v1/skus/label-templates":      SkuController{}.GetLabelTemplates,
+			"/v1/skus/barcode-allocator":    SkuController{}.GetBarcodeAllocator,
+			"/v1/currencies/exchange-rates": CurrencyController{}.GetExchangeRates,
+		} {
+			status, _ := requestAs(t, "", echo.GET, name, handler)
+			test.Equals(t, http.StatusForbidden, status)
+		}
+	})
+}
+
+// requestAs runs handler for a request of the tenant, or of no tenant when tenantCode is empty,
+// and returns the status of the response with its body. params are the names and values of the path parameters.
+func requestAs(t *testing.T, tenantCode, method, target string, handler echo.HandlerFunc, params ...string) (int, []byte) {
+	req := httptest.NewRequest(method, target, nil)
+	setHeaderWithTenant(req, tenantCode)
+	rec := httptest.NewRecorder()
+	c := echoApp.NewContext(req, rec)
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names, values = append(names, params[i]), append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	err := handleWithFilter(handler, c)
+	var apiError api.Error
+	if errors.As(err, &apiError) {
+		return apiError.Status(), nil
+	}
+	test.Ok(t, err)
+	return rec.Code, rec.Body.Bytes()
+}
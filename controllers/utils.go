@@ -3,15 +3,96 @@ package controllers
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/hublabs/common/api"
 	"github.com/hublabs/product-api/factory"
+	"github.com/hublabs/product-api/models"
 	"github.com/pangpanglabs/goutils/behaviorlog"
 
 	"github.com/go-xorm/xorm"
 	"github.com/labstack/echo"
 )
 
+var (
+	ErrorVersionConflict = api.NewTemplate(20003, "Resource has been modified", http.StatusPreconditionFailed)
+	ErrorMissIfMatch     = api.NewTemplate(20004, "Miss If-Match header", http.StatusPreconditionRequired)
+)
+
+const (
+	headerETag        = "ETag"
+	headerIfMatch     = "If-Match"
+	headerIfNoneMatch = "If-None-Match"
+)
+
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatchVersion reads the version the client expects from the If-Match header.
+func ifMatchVersion(c echo.Context) (int, error) {
+	v := strings.TrimSpace(c.Request().Header.Get(headerIfMatch))
+	if v == "" {
+		return 0, ErrorMissIfMatch.New(nil)
+	}
+	s, err := strconv.Unquote(v)
+	if err != nil {
+		return 0, api.ErrorParameter.New(errors.New("If-Match must be a strong entity tag"))
+	}
+	version, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, api.ErrorParameter.New(errors.New("If-Match must be an entity tag returned by this service"))
+	}
+	return version, nil
+}
+
+// noneMatch reports whether none of the entity tags in If-None-Match matches version.
+// Weak comparison is used as RFC 7232 requires for If-None-Match.
+func noneMatch(c echo.Context, version int) bool {
+	header := c.Request().Header.Get(headerIfNoneMatch)
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// renderSuccWithETag renders result with its version as ETag, or 304 when the client already has this version.
+func renderSuccWithETag(c echo.Context, version int, result interface{}) error {
+	c.Response().Header().Set(headerETag, etag(version))
+	if c.Request().Method == http.MethodGet && !noneMatch(c, version) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return renderSucc(c, http.StatusOK, result)
+}
+
+// rollback discards what the request has written so far.
+// ContextDB commits every response below 500, so a handler answering a failed precondition must roll back by itself.
+func rollback(c echo.Context) error {
+	if session, ok := factory.DB(c.Request().Context()).(*xorm.Session); ok {
+		return session.Rollback()
+	}
+	return nil
+}
+
+// renderConflict responds 412 with the current representation, so the client can merge and retry.
+// The request must have been rolled back before current was read.
+func renderConflict(c echo.Context, err error, version int, current interface{}) error {
+	behaviorlog.FromCtx(c.Request().Context()).WithError(err)
+	c.Response().Header().Set(headerETag, etag(version))
+	return c.JSON(http.StatusPreconditionFailed, api.Result{
+		Success: false,
+		Result:  models.HideCost(c.Request().Context(), current),
+		Error:   ErrorVersionConflict.New(err),
+	})
+}
+
 func renderFail(c echo.Context, err error) error {
 	if err == nil {
 		err = api.ErrorUnknown.New(nil)
@@ -28,6 +109,7 @@ func renderFail(c echo.Context, err error) error {
 }
 
 func renderSuccArray(c echo.Context, withHasMore, hasMore bool, totalCount int64, result interface{}) error {
+	result = models.HideCost(c.Request().Context(), result)
 	if withHasMore {
 		return renderSucc(c, http.StatusOK, api.ArrayResultMore{
 			HasMore: hasMore,
@@ -41,9 +123,10 @@ func renderSuccArray(c echo.Context, withHasMore, hasMore bool, totalCount int64
 	}
 }
 
+// renderSucc leaves cost prices out of the result for a caller who may not see them.
 func renderSucc(c echo.Context, status int, result interface{}) error {
 	req := c.Request()
-	if req.Method == "POST" || req.Method == "PUT" || req.Method == "DELETE" {
+	if req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" || req.Method == "DELETE" {
 		if session, ok := factory.DB(req.Context()).(*xorm.Session); ok {
 			if err := session.Commit(); err != nil {
 				return api.ErrorDB.New(err)
@@ -53,6 +136,6 @@ func renderSucc(c echo.Context, status int, result interface{}) error {
 
 	return c.JSON(status, api.Result{
 		Success: true,
-		Result:  result,
+		Result:  models.HideCost(req.Context(), result),
 	})
 }
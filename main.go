@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
@@ -58,6 +59,7 @@ func main() {
 				})
 
 				r.AddSecurityAPIKey("Authorization", "JWT token", echoswagger.SecurityInHeader)
+				r.AddSecurityAPIKey(controllers.ApiKeyHeader, "API key of a machine client", echoswagger.SecurityInHeader)
 				r.SetUI(echoswagger.UISetting{
 					HideTop: true,
 				})
@@ -70,13 +72,21 @@ func main() {
 				controllers.ProductController{}.Init(r.Group("Products", "v1/products"))
 				controllers.SkuController{}.Init(r.Group("Skus", "v1/skus"))
 				controllers.PriceController{}.Init(r.Group("Prices", "v1/prices"))
+				controllers.CurrencyController{}.Init(r.Group("Currencies", "v1/currencies"))
+				controllers.PriceListController{}.Init(r.Group("PriceLists", "v1/price-lists"))
+				controllers.PriceChangeController{}.Init(r.Group("PriceChanges", "v1/price-changes"))
+				controllers.OfferController{}.Init(r.Group("Offers", "v1/offers"))
+				controllers.ApiKeyController{}.Init(r.Group("ApiKeys", "v1/api-keys"))
 				e.Pre(middleware.RemoveTrailingSlash())
 				e.Pre(echomiddleware.ContextBase())
 				e.Use(middleware.Recover())
 				e.Use(middleware.CORS())
 				e.Use(echomiddleware.BehaviorLogger(c.ServiceName, c.BehaviorLog.Kafka))
+				e.Use(controllers.ApiKeyMiddleware(auth.UserClaimMiddleware("/ping", "/doc")))
 				e.Use(echomiddleware.ContextDB(c.ServiceName, db, c.Database.Logger.Kafka))
-				e.Use(auth.UserClaimMiddleware("/ping", "/doc"))
+				e.Use(controllers.TenantRequiredMiddleware("/ping", "/doc"))
+				e.Use(controllers.UserRolesMiddleware())
+				e.Use(controllers.PermissionMiddleware("/ping", "/doc"))
 
 				e.Validator = &Validator{}
 				e.Debug = c.Debug
@@ -92,6 +102,90 @@ func main() {
 				}
 				return nil
 			},
+		}, {
+			Name:  "outbox-relay",
+			Usage: "ship outbox events to event broker",
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:  "interval",
+					Value: time.Second,
+					Usage: "polling interval",
+				},
+				&cli.IntFlag{
+					Name:  "batch-size",
+					Value: 100,
+					Usage: "max events shipped per poll",
+				},
+				&cli.DurationFlag{
+					Name:  "max-backoff",
+					Value: 5 * time.Minute,
+					Usage: "longest wait before retrying an event which failed to ship",
+				},
+			},
+			Action: func(cliContext *cli.Context) error {
+				if !(adapters.MessagePublisher{}).Configured() {
+					return adapters.ErrMessagePublisherNotConfigured
+				}
+				ctx := context.WithValue(context.Background(), echomiddleware.ContextDBName, db)
+				send := adapters.MessagePublisher{}.Send
+				ticker := time.NewTicker(cliContext.Duration("interval"))
+				defer ticker.Stop()
+				for range ticker.C {
+					n, err := models.OutboxEvent{}.Relay(ctx, send, cliContext.Duration("max-backoff"), cliContext.Int("batch-size"))
+					if err != nil {
+						logrus.WithError(err).Error("Fail to relay outbox events")
+					}
+					if n > 0 {
+						logrus.WithField("count", n).Info("Relayed outbox events")
+					}
+				}
+				return nil
+			},
+		}, {
+			Name:  "price-scheduler",
+			Usage: "emit price changes of scheduled prices when they start or end",
+			Flags: []cli.Flag{
+				&cli.DurationFlag{
+					Name:  "interval",
+					Value: time.Minute,
+					Usage: "polling interval",
+				},
+			},
+			Action: func(cliContext *cli.Context) error {
+				ticker := time.NewTicker(cliContext.Duration("interval"))
+				defer ticker.Stop()
+				for now := range ticker.C {
+					n, err := runPriceSchedule(db, now)
+					if err != nil {
+						logrus.WithError(err).Error("Fail to run price schedule")
+					}
+					if n > 0 {
+						logrus.WithField("count", n).Info("Ran price schedule")
+					}
+				}
+				return nil
+			},
+		}, {
+			Name:  "migrate-brand-tenants",
+			Usage: "split brands shared across tenants into brands owned by each tenant",
+			Action: func(cliContext *cli.Context) error {
+				splits, err := models.MigrateBrandTenants(db)
+				if err != nil {
+					return err
+				}
+				for _, split := range splits {
+					entry := logrus.WithField("brandId", split.BrandId).WithField("code", split.Code)
+					switch {
+					case len(split.Tenants) == 0:
+						entry.Warn("Left brand without tenant, no product references it")
+					case split.Unassigned:
+						entry.WithField("tenants", split.Tenants).Warn("Left brand without tenant, the tenants referencing it own a brand of its code")
+					default:
+						entry.WithField("tenants", split.Tenants).Info("Split brand into tenants")
+					}
+				}
+				return nil
+			},
 		}, {
 			Name:  "export",
 			Usage: "export from 3rd part",
@@ -143,3 +237,20 @@ func (v *Validator) Validate(i interface{}) error {
 	_, err := govalidator.ValidateStruct(i)
 	return err
 }
+
+// runPriceSchedule marks prices and writes their events in one transaction,
+// so that a price is never marked without its event.
+func runPriceSchedule(db *xorm.Engine, now time.Time) (int, error) {
+	session := db.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return 0, err
+	}
+	ctx := context.WithValue(context.Background(), echomiddleware.ContextDBName, session)
+	n, err := models.Price{}.RunSchedule(ctx, now)
+	if err != nil {
+		session.Rollback()
+		return 0, err
+	}
+	return n, session.Commit()
+}
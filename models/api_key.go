@@ -0,0 +1,204 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/factory"
+
+	"github.com/go-xorm/xorm"
+)
+
+// apiKeyUseInterval is how long the last use of a key is kept before it is moved on, so that a busy key is not written on every request.
+const apiKeyUseInterval = time.Minute
+
+var (
+	ErrApiKeyNameRequired = errors.New("api key name is required")
+	ErrInvalidApiKeyScope = errors.New("invalid api key scope")
+	ErrApiKeyScopeDenied  = errors.New("api key scope is not granted to the caller")
+	ErrApiKeyExpiresAt    = errors.New("api key expires in the past")
+	ErrInvalidApiKey      = errors.New("invalid api key")
+	ErrApiKeyExpired      = errors.New("api key expired")
+)
+
+// apiKeyScopes are the permissions an api key may be given. A key does not manage api keys.
+var apiKeyScopes = []Permission{PermissionCatalogRead, PermissionCatalogWrite, PermissionPriceWrite, PermissionImportRun}
+
+// ApiKey lets a machine client of a tenant, an ERP sync or a POS terminal, call instead of with a token.
+// Only a hash of the key is kept, the key itself is returned once when it is created. Prefix tells keys apart.
+type ApiKey struct {
+	Id         int64        `json:"id"`
+	TenantCode string       `json:"-" xorm:"index varchar(16)"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix" xorm:"unique varchar(16)"`
+	Hash       string       `json:"-" xorm:"varchar(64)"`
+	Scopes     []Permission `json:"scopes" xorm:"json"`
+	ExpiresAt  *time.Time   `json:"expiresAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt"`
+	CreatedBy  int64        `json:"createdBy"`
+	CreatedAt  time.Time    `json:"createdAt" xorm:"created"`
+	UpdatedAt  time.Time    `json:"updatedAt" xorm:"updated"`
+	DeletedAt  time.Time    `json:"-" xorm:"deleted index"`
+	// Key is given only by Create
+	Key string `json:"key,omitempty" xorm:"-"`
+}
+
+func (k *ApiKey) validate() error {
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" {
+		return ErrApiKeyNameRequired
+	}
+	if len(k.Scopes) == 0 {
+		return fmt.Errorf("%w: none", ErrInvalidApiKeyScope)
+	}
+	for _, scope := range k.Scopes {
+		valid := false
+		for _, s := range apiKeyScopes {
+			valid = valid || scope == s
+		}
+		if !valid {
+			return fmt.Errorf("%w: %s", ErrInvalidApiKeyScope, scope)
+		}
+	}
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(time.Now()) {
+		return ErrApiKeyExpiresAt
+	}
+	return nil
+}
+
+// Create issues a key for the tenant, which is left in Key.
+// A key is given only permissions its creator has, so that no caller grants itself more through a key.
+func (k *ApiKey) Create(ctx context.Context) error {
+	if err := k.validate(); err != nil {
+		return err
+	}
+	for _, scope := range k.Scopes {
+		if !HasPermission(ctx, scope) {
+			return fmt.Errorf("%w: %s", ErrApiKeyScopeDenied, scope)
+		}
+	}
+	prefix, secret := make([]byte, 6), make([]byte, 32)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	k.Prefix = hex.EncodeToString(prefix)
+	key := k.Prefix + "." + base64.RawURLEncoding.EncodeToString(secret)
+	k.Hash = apiKeyHash(key)
+	k.CreatedBy = auth.UserClaim{}.FromCtx(ctx).ColleagueId
+	k.LastUsedAt = nil
+	if err := tenantInsert(ctx, k); err != nil {
+		return err
+	}
+	k.Key = key
+	return nil
+}
+
+func (ApiKey) Get(ctx context.Context, id int64) (*ApiKey, error) {
+	db, err := tenantDB(ctx, "api_key")
+	if err != nil {
+		return nil, err
+	}
+	var k ApiKey
+	exist, err := db.And("id = ?", id).Get(&k)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &k, nil
+}
+
+func (ApiKey) GetAll(ctx context.Context, skipCount, maxResultCount int) (int64, []ApiKey, error) {
+	db, err := tenantDB(ctx, "api_key")
+	if err != nil {
+		return 0, nil, err
+	}
+	var keys []ApiKey
+	totalCount, err := db.Desc("id").Limit(maxResultCount, skipCount).FindAndCount(&keys)
+	if err != nil {
+		return 0, nil, err
+	}
+	return totalCount, keys, nil
+}
+
+// Revoke stops the key from being accepted, it returns nil when the tenant has no such key.
+func (ApiKey) Revoke(ctx context.Context, id int64) (*ApiKey, error) {
+	k, err := ApiKey{}.Get(ctx, id)
+	if err != nil || k == nil {
+		return nil, err
+	}
+	db, err := tenantDB(ctx, "api_key")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ID(id).Delete(&ApiKey{}); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Authenticate finds the key of a request, which tells the tenant of the request, and records its use.
+// It runs on a session of its own rather than in the transaction of the request, so the use is recorded even when the request fails.
+// It gives ErrInvalidApiKey for a key which was not issued or has been revoked.
+func (ApiKey) Authenticate(ctx context.Context, key string) (*ApiKey, error) {
+	i := strings.Index(key, ".")
+	if i <= 0 {
+		return nil, ErrInvalidApiKey
+	}
+	db := factory.DBNewSession(ctx)
+	defer db.(*xorm.Session).Close()
+	var k ApiKey
+	exist, err := db.Where("prefix = ?", key[:i]).Get(&k)
+	if err != nil {
+		return nil, err
+	}
+	if !exist || subtle.ConstantTimeCompare([]byte(k.Hash), []byte(apiKeyHash(key))) != 1 {
+		return nil, ErrInvalidApiKey
+	}
+	now := time.Now()
+	if k.ExpiresAt != nil && !k.ExpiresAt.After(now) {
+		return nil, ErrApiKeyExpired
+	}
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyUseInterval {
+		k.LastUsedAt = &now
+		if _, err := db.ID(k.Id).Cols("last_used_at").NoAutoTime().Update(&k); err != nil {
+			return nil, err
+		}
+	}
+	return &k, nil
+}
+
+// Claim is the claim of the requests made with the key, as a token of its tenant would give.
+func (k ApiKey) Claim() auth.UserClaim {
+	return auth.UserClaim{
+		TenantCode: k.TenantCode,
+		Username:   "api-key:" + k.Prefix,
+	}
+}
+
+// Permissions are those of the requests made with the key.
+func (k ApiKey) Permissions() []Permission {
+	scopes := make([]string, len(k.Scopes))
+	for i, s := range k.Scopes {
+		scopes[i] = string(s)
+	}
+	return GrantPermissions(nil, scopes)
+}
+
+func apiKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hublabs/product-api/factory"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestApiKey(t *testing.T) {
+	ctx := WithPermissions(ctx, GrantPermissions([]string{RoleAdmin}, nil))
+	k := ApiKey{Name: "erp", Scopes: []Permission{PermissionCatalogRead, PermissionPriceWrite}}
+	test.Ok(t, k.Create(ctx))
+	test.Assert(t, strings.HasPrefix(k.Key, k.Prefix+"."), k.Key)
+	test.Equals(t, k.TenantCode, "test")
+
+	stored, err := ApiKey{}.Get(ctx, k.Id)
+	test.Ok(t, err)
+	test.Equals(t, stored.Key, "")
+	test.Assert(t, stored.Hash != "" && !strings.Contains(k.Key, stored.Hash), "the key is kept as it is")
+
+	t.Run("Authenticate", func(t *testing.T) {
+		found, err := ApiKey{}.Authenticate(ctx, k.Key)
+		test.Ok(t, err)
+		test.Equals(t, found.Id, k.Id)
+		test.Equals(t, found.Claim().TenantCode, "test")
+		test.Equals(t, found.Permissions(), []Permission{PermissionCatalogRead, PermissionCatalogRead, PermissionPriceWrite})
+
+		stored, err := ApiKey{}.Get(ctx, k.Id)
+		test.Ok(t, err)
+		test.Assert(t, stored.LastUsedAt != nil, "the use of the key is not recorded")
+	})
+
+	t.Run("AuthenticateInvalid", func(t *testing.T) {
+		for _, key := range []string{"", k.Prefix, k.Prefix + ".secret", "unknown." + strings.SplitN(k.Key, ".", 2)[1]} {
+			_, err := ApiKey{}.Authenticate(ctx, key)
+			test.Assert(t, errors.Is(err, ErrInvalidApiKey), key)
+		}
+	})
+
+	t.Run("CreateInvalid", func(t *testing.T) {
+		past := time.Now().Add(-time.Hour)
+		for _, invalid := range []struct {
+			key ApiKey
+			err error
+		}{
+			{ApiKey{Scopes: []Permission{PermissionCatalogRead}}, ErrApiKeyNameRequired},
+			{ApiKey{Name: "pos"}, ErrInvalidApiKeyScope},
+			{ApiKey{Name: "pos", Scopes: []Permission{PermissionApiKeyManage}}, ErrInvalidApiKeyScope},
+			{ApiKey{Name: "pos", Scopes: []Permission{"stock:write"}}, ErrInvalidApiKeyScope},
+			{ApiKey{Name: "pos", Scopes: []Permission{PermissionCatalogRead}, ExpiresAt: &past}, ErrApiKeyExpiresAt},
+		} {
+			test.Assert(t, errors.Is(invalid.key.Create(ctx), invalid.err), invalid.err.Error())
+		}
+	})
+
+	t.Run("CreateNotGranted", func(t *testing.T) {
+		// a caller managing keys without being an admin gives a key only its own permissions
+		manager := WithPermissions(ctx, GrantPermissions(nil, []string{string(PermissionApiKeyManage)}))
+		denied := ApiKey{Name: "pos", Scopes: []Permission{PermissionCatalogRead, PermissionPriceWrite}}
+		test.Assert(t, errors.Is(denied.Create(manager), ErrApiKeyScopeDenied), "a key is given a permission its creator lacks")
+		test.Equals(t, denied.Id, int64(0))
+
+		granted := ApiKey{Name: "pos", Scopes: []Permission{PermissionCatalogRead}}
+		test.Ok(t, granted.Create(manager))
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		soon := time.Now().Add(time.Hour)
+		expiring := ApiKey{Name: "pos", Scopes: []Permission{PermissionCatalogRead}, ExpiresAt: &soon}
+		test.Ok(t, expiring.Create(ctx))
+		expired := time.Now().Add(-time.Minute)
+		_, err := factory.DB(ctx).ID(expiring.Id).Cols("expires_at").Update(&ApiKey{ExpiresAt: &expired})
+		test.Ok(t, err)
+		_, err = ApiKey{}.Authenticate(ctx, expiring.Key)
+		test.Assert(t, errors.Is(err, ErrApiKeyExpired), "an expired key is accepted")
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		revoked, err := ApiKey{}.Revoke(ctx, k.Id)
+		test.Ok(t, err)
+		test.Equals(t, revoked.Id, k.Id)
+		_, err = ApiKey{}.Authenticate(ctx, k.Key)
+		test.Assert(t, errors.Is(err, ErrInvalidApiKey), "a revoked key is accepted")
+	})
+}
@@ -19,6 +19,8 @@ type AttributeValue struct {
 	Value       string    `json:"value" xorm:"index"`
 	CreatedAt   time.Time `json:"createdAt" xorm:"created"`
 	UpdatedAt   time.Time `json:"updatedAt" xorm:"updated"`
+	DeletedAt   time.Time `json:"-" xorm:"deleted index"`
+	DeleteBatch string    `json:"-" xorm:"index varchar(32)"`
 }
 
 type AttributeExtends struct {
@@ -53,6 +55,9 @@ func (AttributeValue) Create(ctx context.Context, productId int64, key, value st
 	if _, err := factory.DB(ctx).Insert(&attrValue); err != nil {
 		return nil, err
 	}
+	if err := writeAudit(ctx, AuditEntityAttributeValue, AuditActionCreated, nil, attrValue.Id); err != nil {
+		return nil, err
+	}
 	return &attrValue, nil
 }
 
@@ -104,18 +109,42 @@ func (AttributeExtends) getByProductIds(ctx context.Context, productId ...int64)
 	if len(productId) == 0 {
 		return
 	}
-	err = factory.DB(ctx).Table("attribute_value").Select("attribute.*, attribute_value.*").
+	db, err := tenantChildDB(ctx, "attribute_value", "product_id", "product")
+	if err != nil {
+		return
+	}
+	err = db.Table("attribute_value").Select("attribute.*, attribute_value.*").
 		Join("INNER", "attribute", "attribute_value.attribute_id = attribute.id").
 		In("attribute_value.product_id", productId).Find(&attrExtends)
 	return
 }
 
-func (av *AttributeValue) Update(ctx context.Context) (err error) {
-	_, err = factory.DB(ctx).ID(av.Id).Cols("value").Update(av)
-	return
+func (av *AttributeValue) Update(ctx context.Context) error {
+	before, err := auditSnapshot(ctx, AuditEntityAttributeValue, av.Id)
+	if err != nil {
+		return err
+	}
+	db, err := tenantChildDB(ctx, "attribute_value", "product_id", "product")
+	if err != nil {
+		return err
+	}
+	if _, err := db.ID(av.Id).Cols("value").Update(av); err != nil {
+		return err
+	}
+	return writeAudit(ctx, AuditEntityAttributeValue, AuditActionUpdated, before, av.Id)
 }
 
-func (av *AttributeValue) Delete(ctx context.Context) (err error) {
-	_, err = factory.DB(ctx).ID(av.Id).Delete(&AttributeValue{})
-	return
+func (av *AttributeValue) Delete(ctx context.Context) error {
+	before, err := auditSnapshot(ctx, AuditEntityAttributeValue, av.Id)
+	if err != nil {
+		return err
+	}
+	db, err := tenantChildDB(ctx, "attribute_value", "product_id", "product")
+	if err != nil {
+		return err
+	}
+	if _, err := db.Unscoped().ID(av.Id).Delete(&AttributeValue{}); err != nil {
+		return err
+	}
+	return writeAudit(ctx, AuditEntityAttributeValue, AuditActionDeleted, before, av.Id)
 }
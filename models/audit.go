@@ -0,0 +1,253 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/factory"
+
+	"github.com/pangpanglabs/goutils/behaviorlog"
+)
+
+// AuditEntity is an audited entity, named after its table.
+type AuditEntity string
+
+const (
+	AuditEntityProduct        AuditEntity = "product"
+	AuditEntitySku            AuditEntity = "sku"
+	AuditEntityBrand          AuditEntity = "brand"
+	AuditEntityPrice          AuditEntity = "price"
+	AuditEntityPriceList      AuditEntity = "price_list"
+	AuditEntityAttributeValue AuditEntity = "attribute_value"
+)
+
+type AuditAction string
+
+const (
+	AuditActionCreated  AuditAction = "created"
+	AuditActionUpdated  AuditAction = "updated"
+	AuditActionDeleted  AuditAction = "deleted"
+	AuditActionRestored AuditAction = "restored"
+)
+
+// auditIgnoredColumns change on every write, only tie the rows of a delete together or are not columns of the entity,
+// so they are left out of diffs.
+var auditIgnoredColumns = map[string]bool{
+	"created_at":     true,
+	"updated_at":     true,
+	"version":        true,
+	"delete_batch":   true,
+	"attribute_name": true,
+}
+
+// AuditLog is a before/after snapshot of one row written by one request.
+// Subject is the resource whose history shows the log, e.g. an attribute value belongs to the history of its product.
+type AuditLog struct {
+	Id          int64         `json:"id"`
+	TenantCode  string        `json:"-" xorm:"index varchar(16)"`
+	SubjectType AuditEntity   `json:"subjectType" xorm:"index(subject) varchar(32)"`
+	SubjectId   int64         `json:"subjectId" xorm:"index(subject)"`
+	EntityType  AuditEntity   `json:"entityType" xorm:"varchar(32)"`
+	EntityId    int64         `json:"entityId"`
+	Label       string        `json:"label,omitempty"`
+	Action      AuditAction   `json:"action" xorm:"varchar(16)"`
+	Before      string        `json:"-" xorm:"mediumtext"`
+	After       string        `json:"-" xorm:"mediumtext"`
+	Changes     []FieldChange `json:"changes" xorm:"-"`
+	ColleagueId int64         `json:"colleagueId" xorm:"index"`
+	Username    string        `json:"username"`
+	UserClaim   string        `json:"-" xorm:"text"`
+	RequestId   string        `json:"requestId" xorm:"varchar(64)"`
+	CreatedAt   time.Time     `json:"createdAt" xorm:"created index"`
+}
+
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type auditRows map[int64]map[string]string
+
+// auditSnapshot reads the current rows of entity, deleted ones included.
+func auditSnapshot(ctx context.Context, entity AuditEntity, ids ...int64) (auditRows, error) {
+	rows := make(auditRows)
+	if len(ids) == 0 {
+		return rows, nil
+	}
+	table := string(entity)
+	db, err := tenantTableDB(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	query := db.Table(table).In(table+".id", ids)
+	if entity == AuditEntityAttributeValue {
+		query.Join("INNER", "attribute", "attribute_value.attribute_id = attribute.id").
+			Select("attribute_value.*, attribute.name AS attribute_name")
+	}
+	result, err := query.QueryString()
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range result {
+		id, _ := strconv.ParseInt(row["id"], 10, 64)
+		// money reads the same whichever database it is stored in
+		for _, col := range moneyColumns[table] {
+			var m Money
+			if err := m.FromDB([]byte(row[col])); err == nil {
+				row[col] = m.String()
+			}
+		}
+		rows[id] = row
+	}
+	return rows, nil
+}
+
+// writeAudit compares the rows of entity taken before a write with their current state,
+// and records a log for every row which was changed.
+func writeAudit(ctx context.Context, entity AuditEntity, action AuditAction, before auditRows, ids ...int64) error {
+	after, err := auditSnapshot(ctx, entity, ids...)
+	if err != nil {
+		return err
+	}
+
+	user := auth.UserClaim{}.FromCtx(ctx)
+	claim, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		b, a := before[id], after[id]
+		if len(diffRows(b, a)) == 0 {
+			continue
+		}
+		row := a
+		if row == nil {
+			row = b
+		}
+		subjectType, subjectId, label := entity.subject(row)
+
+		bb, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		ab, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		l := AuditLog{
+			TenantCode:  tenantCode(ctx),
+			SubjectType: subjectType,
+			SubjectId:   subjectId,
+			EntityType:  entity,
+			EntityId:    id,
+			Label:       label,
+			Action:      action,
+			Before:      string(bb),
+			After:       string(ab),
+			ColleagueId: user.ColleagueId,
+			Username:    user.Username,
+			UserClaim:   string(claim),
+			RequestId:   behaviorlog.FromCtx(ctx).RequestID,
+		}
+		if _, err := factory.DB(ctx).Insert(&l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditEntityOf(bean interface{}) (AuditEntity, bool) {
+	switch bean.(type) {
+	case *Product:
+		return AuditEntityProduct, true
+	case *Sku:
+		return AuditEntitySku, true
+	case *Brand:
+		return AuditEntityBrand, true
+	case *Price:
+		return AuditEntityPrice, true
+	case *PriceList:
+		return AuditEntityPriceList, true
+	case *AttributeValue:
+		return AuditEntityAttributeValue, true
+	}
+	return "", false
+}
+
+func (e AuditEntity) subject(row map[string]string) (AuditEntity, int64, string) {
+	id, _ := strconv.ParseInt(row["id"], 10, 64)
+	switch e {
+	case AuditEntityAttributeValue:
+		productId, _ := strconv.ParseInt(row["product_id"], 10, 64)
+		return AuditEntityProduct, productId, row["attribute_name"]
+	case AuditEntityPrice:
+		targetId, _ := strconv.ParseInt(row["target_id"], 10, 64)
+		if row["target_type"] == PriceTargetTypeProduct {
+			return AuditEntityProduct, targetId, ""
+		}
+		return AuditEntity(row["target_type"]), targetId, row["target_id"]
+	}
+	return e, id, row["code"]
+}
+
+// diffRows lists the columns whose values differ, sorted by column name.
+func diffRows(before, after map[string]string) []FieldChange {
+	var changes []FieldChange
+	for k, v := range after {
+		if auditIgnoredColumns[k] {
+			continue
+		}
+		if before[k] != v {
+			changes = append(changes, FieldChange{Field: k, Before: before[k], After: v})
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok && v != "" && !auditIgnoredColumns[k] {
+			changes = append(changes, FieldChange{Field: k, Before: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// GetBySubject returns the history of a resource, the latest first.
+// Changes of the cost price are left out for a caller who may not see it.
+func (AuditLog) GetBySubject(ctx context.Context, subjectType AuditEntity, subjectId int64, skipCount, maxResultCount int) (int64, []AuditLog, error) {
+	var logs []AuditLog
+	db, err := tenantDB(ctx, "audit_log")
+	if err != nil {
+		return 0, nil, err
+	}
+	totalCount, err := db.
+		And("subject_type = ?", subjectType).
+		And("subject_id = ?", subjectId).
+		Desc("id").
+		Limit(maxResultCount, skipCount).
+		FindAndCount(&logs)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range logs {
+		var before, after map[string]string
+		if err := json.Unmarshal([]byte(logs[i].Before), &before); err != nil {
+			return 0, nil, err
+		}
+		if err := json.Unmarshal([]byte(logs[i].After), &after); err != nil {
+			return 0, nil, err
+		}
+		if !CostVisible(ctx) {
+			delete(before, "cost_price")
+			delete(after, "cost_price")
+		}
+		logs[i].Changes = diffRows(before, after)
+	}
+	return totalCount, logs, nil
+}
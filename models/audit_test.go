@@ -0,0 +1,16 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestDiffRows(t *testing.T) {
+	test.Equals(t, diffRows(
+		map[string]string{"id": "1", "name": "a", "code": "c", "updated_at": "t1"},
+		map[string]string{"id": "1", "name": "b", "code": "c", "updated_at": "t2"},
+	), []FieldChange{{Field: "name", Before: "a", After: "b"}})
+	test.Equals(t, diffRows(nil, map[string]string{"id": "1"}), []FieldChange{{Field: "id", After: "1"}})
+	test.Equals(t, diffRows(map[string]string{"id": "1"}, nil), []FieldChange{{Field: "id", Before: "1"}})
+}
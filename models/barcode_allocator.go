@@ -0,0 +1,245 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidBarcodePrefix       = errors.New("prefix must be a GS1 company prefix of 6 to 11 digits or an in-store prefix of 2 to 11 digits starting with 2")
+	ErrBarcodeAllocatorNotSet     = errors.New("no barcode prefix is set for the tenant")
+	ErrBarcodeRangeExhausted      = errors.New("no barcode is left under the prefix")
+	ErrInvalidBarcodeSequenceNext = errors.New("next must be within the range of the prefix")
+	ErrSkuHasBarcode              = errors.New("sku has a barcode already")
+)
+
+// BarcodeAllocator is the prefix under which EAN-13 codes are issued for the skus of a tenant.
+// A prefix starting with 2 is an in-store range, which is only scannable in the stores of the tenant.
+type BarcodeAllocator struct {
+	TenantCode string    `json:"-" xorm:"pk varchar(16)"`
+	Prefix     string    `json:"prefix" xorm:"varchar(11)"`
+	Next       int64     `json:"next" xorm:"-"`
+	UpdatedAt  time.Time `json:"updatedAt" xorm:"updated"`
+}
+
+// BarcodeSequence is the next item reference to issue under a prefix. Each prefix keeps its own,
+// so going back to a prefix used before does not issue its codes again.
+type BarcodeSequence struct {
+	TenantCode string `xorm:"pk varchar(16)"`
+	Prefix     string `xorm:"pk varchar(11)"`
+	Next       int64  `xorm:"notnull"`
+}
+
+// Load returns the prefix of the tenant with the next item reference under it, the prefix is empty when none is set.
+func (BarcodeAllocator) Load(ctx context.Context) (BarcodeAllocator, error) {
+	db, err := tenantDB(ctx, "barcode_allocator")
+	if err != nil {
+		return BarcodeAllocator{}, err
+	}
+	a := BarcodeAllocator{TenantCode: tenantCode(ctx)}
+	exist, err := db.Get(&a)
+	if err != nil || !exist {
+		return a, err
+	}
+	if db, err = tenantDB(ctx, "barcode_sequence"); err != nil {
+		return BarcodeAllocator{}, err
+	}
+	var s BarcodeSequence
+	if _, err := db.And("prefix = ?", a.Prefix).Get(&s); err != nil {
+		return BarcodeAllocator{}, err
+	}
+	a.Next = s.Next
+	return a, nil
+}
+
+// Set issues the codes of the tenant under prefix from now on. next moves the sequence of the prefix on,
+// which skips item references given out before the allocator was used. It is never moved back.
+func (BarcodeAllocator) Set(ctx context.Context, prefix string, next int64) (*BarcodeAllocator, error) {
+	prefix = strings.TrimSpace(prefix)
+	if !validBarcodePrefix(prefix) {
+		return nil, ErrInvalidBarcodePrefix
+	}
+	if next < 0 || next >= barcodeCapacity(prefix) {
+		return nil, ErrInvalidBarcodeSequenceNext
+	}
+	db, err := tenantDB(ctx, "barcode_allocator")
+	if err != nil {
+		return nil, err
+	}
+	a := BarcodeAllocator{TenantCode: tenantCode(ctx), Prefix: prefix}
+	exist, err := db.Exist(&BarcodeAllocator{})
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		if db, err = tenantDB(ctx, "barcode_allocator"); err != nil {
+			return nil, err
+		}
+		_, err = db.Cols("prefix").Update(&a)
+	} else {
+		err = tenantInsert(ctx, &a)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if db, err = tenantDB(ctx, "barcode_sequence"); err != nil {
+		return nil, err
+	}
+	s := BarcodeSequence{TenantCode: a.TenantCode, Prefix: prefix}
+	exist, err = db.And("prefix = ?", s.Prefix).Get(&s)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		s.Next = next
+		if err := tenantInsert(ctx, &s); err != nil {
+			return nil, err
+		}
+	} else if next > s.Next {
+		if db, err = tenantDB(ctx, "barcode_sequence"); err != nil {
+			return nil, err
+		}
+		if _, err := db.And("prefix = ? AND next < ?", s.Prefix, next).
+			Cols("next").Update(&BarcodeSequence{Next: next}); err != nil {
+			return nil, err
+		}
+		s.Next = next
+	}
+	a.Next = s.Next
+	return &a, nil
+}
+
+// Allocate issues count EAN-13 codes under the prefix of the tenant.
+// The sequence is moved on in the transaction of the request, which holds the row until it commits,
+// so concurrent requests wait for each other and the codes of a request rolled back are issued again.
+func (BarcodeAllocator) Allocate(ctx context.Context, count int) ([]string, error) {
+	a, err := BarcodeAllocator{}.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if a.Prefix == "" {
+		return nil, ErrBarcodeAllocatorNotSet
+	}
+	db, err := tenantDB(ctx, "barcode_sequence")
+	if err != nil {
+		return nil, err
+	}
+	affected, err := db.
+		And("prefix = ? AND next + ? <= ?", a.Prefix, count, barcodeCapacity(a.Prefix)).
+		Incr("next", count).
+		Update(&BarcodeSequence{})
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrBarcodeRangeExhausted
+	}
+	if db, err = tenantDB(ctx, "barcode_sequence"); err != nil {
+		return nil, err
+	}
+	var s BarcodeSequence
+	if _, err := db.And("prefix = ?", a.Prefix).Get(&s); err != nil {
+		return nil, err
+	}
+
+	width := 12 - len(a.Prefix)
+	codes := make([]string, count)
+	for i := range codes {
+		body := fmt.Sprintf("%s%0*d", a.Prefix, width, s.Next-int64(count)+int64(i))
+		codes[i] = body + checkDigit(body)
+	}
+	return codes, nil
+}
+
+func validBarcodePrefix(prefix string) bool {
+	for _, r := range prefix {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	if strings.HasPrefix(prefix, "2") {
+		return len(prefix) >= 2 && len(prefix) <= 11
+	}
+	return len(prefix) >= 6 && len(prefix) <= 11
+}
+
+// barcodeCapacity is the number of item references under prefix.
+func barcodeCapacity(prefix string) int64 {
+	capacity := int64(1)
+	for i := len(prefix); i < 12; i++ {
+		capacity *= 10
+	}
+	return capacity
+}
+
+// checkDigit is the check digit of the digits of a GTIN before it, weighted 3 and 1 from the right.
+func checkDigit(body string) string {
+	var sum int
+	for i := range body {
+		d := int(body[len(body)-1-i] - '0')
+		if i%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return strconv.Itoa((10 - sum%10) % 10)
+}
+
+// unusedBarcode issues a code under the prefix of the tenant which no sku has as a barcode.
+// Codes another sku has already, e.g. ones entered by hand under the prefix, are skipped for the next one.
+func unusedBarcode(ctx context.Context) (string, error) {
+	for {
+		codes, err := BarcodeAllocator{}.Allocate(ctx, 1)
+		if err != nil {
+			return "", err
+		}
+		gtin, err := NormalizeGTIN(codes[0])
+		if err != nil {
+			return "", err
+		}
+		db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+		if err != nil {
+			return "", err
+		}
+		exist, err := db.And("source = ? AND uid = ?", IdentifierSourceBarcode, gtin).Exist(&SkuIdentifier{})
+		if err != nil {
+			return "", err
+		}
+		if !exist {
+			return codes[0], nil
+		}
+	}
+}
+
+// GenerateBarcode issues a barcode for a sku which has none, and returns the sku.
+func (Sku) GenerateBarcode(ctx context.Context, id int64) (*Sku, error) {
+	s, err := Sku{}.Get(ctx, id)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return nil, err
+	}
+	exist, err := db.Where("sku_id = ? AND source = ?", s.Id, IdentifierSourceBarcode).Exist(&SkuIdentifier{})
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return nil, ErrSkuHasBarcode
+	}
+	code, err := unusedBarcode(ctx)
+	if err != nil {
+		return nil, err
+	}
+	identifier := SkuIdentifier{SkuId: s.Id, Uid: code, Source: IdentifierSourceBarcode, Enable: true}
+	if err := identifier.LoadOrCreate(ctx); err != nil {
+		return nil, err
+	}
+	return Sku{}.GetOne(ctx, id, nil)
+}
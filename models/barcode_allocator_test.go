@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/go-xorm/xorm"
+	"github.com/hublabs/common/auth"
+	"github.com/pangpanglabs/goutils/echomiddleware"
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestBarcodeAllocator(t *testing.T) {
+	_, err := BarcodeAllocator{}.Allocate(ctx, 1)
+	test.Equals(t, err, ErrBarcodeAllocatorNotSet)
+
+	for _, prefix := range []string{"", "2", "12345", "69012345678X", "690123456789"} {
+		_, err := BarcodeAllocator{}.Set(ctx, prefix, 0)
+		test.Equals(t, err, ErrInvalidBarcodePrefix)
+	}
+	_, err = BarcodeAllocator{}.Set(ctx, "29123456789", 10)
+	test.Equals(t, err, ErrInvalidBarcodeSequenceNext)
+
+	a, err := BarcodeAllocator{}.Set(ctx, "29123456789", 8)
+	test.Ok(t, err)
+	test.Equals(t, a.Next, int64(8))
+
+	codes, err := BarcodeAllocator{}.Allocate(ctx, 2)
+	test.Ok(t, err)
+	test.Equals(t, len(codes), 2)
+	for i, code := range codes {
+		test.Equals(t, code[:12], "29123456789"+string(rune('8'+i)))
+		gtin, err := NormalizeGTIN(code)
+		test.Ok(t, err)
+		test.Equals(t, gtin, "0"+code)
+	}
+
+	// the range of the prefix is used up, and nothing is issued beyond it
+	_, err = BarcodeAllocator{}.Allocate(ctx, 1)
+	test.Equals(t, err, ErrBarcodeRangeExhausted)
+
+	// another prefix has its own sequence, and going back to the first does not move it back
+	a, err = BarcodeAllocator{}.Set(ctx, "6901234", 0)
+	test.Ok(t, err)
+	test.Equals(t, a.Next, int64(0))
+	a, err = BarcodeAllocator{}.Set(ctx, "29123456789", 0)
+	test.Ok(t, err)
+	test.Equals(t, a.Next, int64(10))
+}
+
+// barcodeOf is the EAN-13 code of the item reference n under prefix.
+func barcodeOf(prefix string, n int64) string {
+	body := fmt.Sprintf("%s%0*d", prefix, 12-len(prefix), n)
+	return body + checkDigit(body)
+}
+
+// barcodeDB is a database of its own in a file, so that sessions of it may run side by side,
+// and the events its tests queue are relayed by no other test.
+func barcodeDB(t *testing.T) (*xorm.Engine, func()) {
+	dir, err := ioutil.TempDir("", "product")
+	test.Ok(t, err)
+	// an immediate transaction takes the write lock on begin, as the update of the sequence takes its row in MySQL
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "product.db")+"?_busy_timeout=10000&_txlock=immediate")
+	test.Ok(t, err)
+	test.Ok(t, Init(db))
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func barcodeContext(session *xorm.Session) context.Context {
+	ctx := context.WithValue(context.Background(), echomiddleware.ContextDBName, session)
+	return WithUserClaim(ctx, auth.UserClaim{TenantCode: "barcode"})
+}
+
+func TestGenerateBarcodeSkipsTaken(t *testing.T) {
+	db, cleanup := barcodeDB(t)
+	defer cleanup()
+	session := db.NewSession()
+	defer session.Close()
+	ctx := barcodeContext(session)
+	_, err := BarcodeAllocator{}.Set(ctx, "2950", 0)
+	test.Ok(t, err)
+	p, err := Product{}.CreateOrUpdate(ctx, Product{Code: "P951", Name: "product#951"})
+	test.Ok(t, err)
+	// the first code under the prefix was entered by hand before the allocator was used
+	taken := Sku{ProductId: p.Id, Code: "S951", Identifiers: []SkuIdentifier{{Uid: barcodeOf("2950", 0), Source: IdentifierSourceBarcode}}}
+	test.Ok(t, taken.Create(ctx))
+	s := Sku{ProductId: p.Id, Code: "S952"}
+	test.Ok(t, s.Create(ctx))
+
+	generated, err := Sku{}.GenerateBarcode(ctx, s.Id)
+	test.Ok(t, err)
+	test.Equals(t, len(generated.Identifiers), 1)
+	test.Equals(t, generated.Identifiers[0].Uid, "0"+barcodeOf("2950", 1))
+	a, err := BarcodeAllocator{}.Load(ctx)
+	test.Ok(t, err)
+	test.Equals(t, a.Next, int64(2))
+}
+
+func TestGenerateBarcodeConcurrently(t *testing.T) {
+	db, cleanup := barcodeDB(t)
+	defer cleanup()
+	setup := db.NewSession()
+	defer setup.Close()
+	ctx := barcodeContext(setup)
+	_, err := BarcodeAllocator{}.Set(ctx, "2951", 0)
+	test.Ok(t, err)
+	p, err := Product{}.CreateOrUpdate(ctx, Product{Code: "P953", Name: "product#953"})
+	test.Ok(t, err)
+	taken := Sku{ProductId: p.Id, Code: "S953", Identifiers: []SkuIdentifier{{Uid: barcodeOf("2951", 2), Source: IdentifierSourceBarcode}}}
+	test.Ok(t, taken.Create(ctx))
+	var ids []int64
+	for i := 0; i < 8; i++ {
+		s := Sku{ProductId: p.Id, Code: fmt.Sprintf("S96%d", i)}
+		test.Ok(t, s.Create(ctx))
+		ids = append(ids, s.Id)
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]string, len(ids))
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id int64) {
+			defer wg.Done()
+			// each request runs in a transaction of its own
+			session := db.NewSession()
+			defer session.Close()
+			if errs[i] = session.Begin(); errs[i] != nil {
+				return
+			}
+			s, err := Sku{}.GenerateBarcode(barcodeContext(session), id)
+			if err != nil {
+				errs[i] = err
+				session.Rollback()
+				return
+			}
+			codes[i] = s.Identifiers[0].Uid
+			errs[i] = session.Commit()
+		}(i, id)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		test.Ok(t, err)
+	}
+	// every sku has a code of its own, and the code taken already is given to none of them
+	sort.Strings(codes)
+	var expected []string
+	for n := int64(0); n <= int64(len(ids)); n++ {
+		if n != 2 {
+			expected = append(expected, "0"+barcodeOf("2951", n))
+		}
+	}
+	test.Equals(t, codes, expected)
+}
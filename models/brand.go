@@ -2,33 +2,83 @@ package models
 
 import (
 	"context"
+	"errors"
 	"strconv"
-
-	"github.com/hublabs/product-api/factory"
+	"strings"
 
 	"github.com/go-xorm/xorm"
 )
 
+var ErrBrandExists = errors.New("brand code exists")
+
+// Brand is owned by a tenant, whose codes are unique within it.
+// The unique index of the codes is added by uniqueBrandCodes, once brands shared across tenants are split.
 type Brand struct {
-	Id     int64  `json:"id,omitempty"`
-	Code   string `json:"code,omitempty" xorm:"index"`
-	Name   string `json:"name,omitempty"`
-	Enable bool   `json:"enable" xorm:"index"`
+	Id         int64  `json:"id,omitempty"`
+	TenantCode string `json:"-" xorm:"index varchar(16)"`
+	Code       string `json:"code,omitempty" xorm:"index varchar(32)"`
+	Name       string `json:"name,omitempty"`
+	Enable     bool   `json:"enable" xorm:"index"`
+	Version    int    `json:"version" xorm:"version"`
 }
 
-func (b *Brand) Create(ctx context.Context) (err error) {
-	_, err = factory.DB(ctx).Insert(b)
-	return
+func (b *Brand) Create(ctx context.Context) error {
+	b.Code = strings.TrimSpace(b.Code)
+	db, err := tenantDB(ctx, "brand")
+	if err != nil {
+		return err
+	}
+	exist, err := db.And("code = ?", b.Code).Exist(&Brand{})
+	if err != nil {
+		return err
+	}
+	if exist {
+		return ErrBrandExists
+	}
+	if err := tenantInsert(ctx, b); err != nil {
+		return err
+	}
+	return writeAudit(ctx, AuditEntityBrand, AuditActionCreated, nil, b.Id)
 }
 
-func (b *Brand) Update(ctx context.Context) (err error) {
-	_, err = factory.DB(ctx).ID(b.Id).Update(b)
-	return
+func (b *Brand) Update(ctx context.Context) error {
+	before, err := auditSnapshot(ctx, AuditEntityBrand, b.Id)
+	if err != nil {
+		return err
+	}
+	b.TenantCode = tenantCode(ctx)
+	b.Code = strings.TrimSpace(b.Code)
+	db, err := tenantDB(ctx, "brand")
+	if err != nil {
+		return err
+	}
+	exist, err := db.And("code = ?", b.Code).And("id <> ?", b.Id).Exist(&Brand{})
+	if err != nil {
+		return err
+	}
+	if exist {
+		return ErrBrandExists
+	}
+	if db, err = tenantDB(ctx, "brand"); err != nil {
+		return err
+	}
+	affected, err := db.ID(b.Id).Update(b)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	return writeAudit(ctx, AuditEntityBrand, AuditActionUpdated, before, b.Id)
 }
 
 func (Brand) GetById(ctx context.Context, id int64) (*Brand, error) {
-	b := Brand{Id: id}
-	exist, err := factory.DB(ctx).Get(&b)
+	db, err := tenantDB(ctx, "brand")
+	if err != nil {
+		return nil, err
+	}
+	var b Brand
+	exist, err := db.And("id = ?", id).Get(&b)
 	if err != nil {
 		return nil, err
 	}
@@ -39,8 +89,12 @@ func (Brand) GetById(ctx context.Context, id int64) (*Brand, error) {
 }
 
 func (Brand) GetByCode(ctx context.Context, code string) (*Brand, error) {
-	b := Brand{Code: code}
-	exist, err := factory.DB(ctx).Get(&b)
+	db, err := tenantDB(ctx, "brand")
+	if err != nil {
+		return nil, err
+	}
+	var b Brand
+	exist, err := db.And("code = ?", code).Get(&b)
 	if err != nil {
 		return nil, err
 	}
@@ -53,19 +107,22 @@ func (Brand) GetByCode(ctx context.Context, code string) (*Brand, error) {
 func (Brand) GetAll(ctx context.Context, q, code, enable string, ids []int64, codes []string, skipCount, maxResultCount int) (int64, []Brand, error) {
 	var (
 		brands     []Brand
-		err        error
 		totalCount int64
 	)
 
+	db, err := tenantDB(ctx, "brand")
+	if err != nil {
+		return 0, nil, err
+	}
 	query := func() xorm.Interface {
-		query := factory.DB(ctx)
+		query := db.session()
 
 		if q != "" {
-			query.Where("code LIKE ?", q+"%")
+			query.And("code LIKE ?", q+"%")
 		}
 
 		if code != "" {
-			query = query.Where("code = ?", code)
+			query = query.And("code = ?", code)
 		}
 
 		if len(codes) != 0 {
@@ -78,10 +135,10 @@ func (Brand) GetAll(ctx context.Context, q, code, enable string, ids []int64, co
 
 		if enable != "" {
 			b, _ := strconv.ParseBool(enable)
-			query.Where("enable = ?", b)
+			query.And("enable = ?", b)
 		}
 
-		return query
+		return query.Asc("id")
 	}
 
 	if maxResultCount == -1 {
@@ -98,16 +155,18 @@ func (Brand) GetAll(ctx context.Context, q, code, enable string, ids []int64, co
 	return totalCount, brands, nil
 }
 
+// GetOrCreate loads the brand of the tenant with the code of b, and creates b when there is none.
 func (b *Brand) GetOrCreate(ctx context.Context) error {
-	exist, err := factory.DB(ctx).Get(b)
+	db, err := tenantDB(ctx, "brand")
+	if err != nil {
+		return err
+	}
+	exist, err := db.And("code = ?", strings.TrimSpace(b.Code)).Get(b)
 	if err != nil {
 		return err
 	}
 	if !exist {
-		_, err = factory.DB(ctx).Insert(b)
-		if err != nil {
-			return err
-		}
+		return b.Create(ctx)
 	}
 	return nil
 }
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"context"
+	"math"
+	"sort"
+)
+
+// CostVisible tells whether the caller may see cost prices and margins.
+func CostVisible(ctx context.Context) bool {
+	return HasRole(ctx, RoleFinance)
+}
+
+// Margin is the gross margin of the price in effect over the cost price, Rate is its share of the price.
+type Margin struct {
+	Amount Money   `json:"amount"`
+	Rate   float64 `json:"rate"`
+}
+
+func newMargin(cost, price Money) *Margin {
+	if cost == 0 {
+		return nil
+	}
+	m := Margin{Amount: price - cost}
+	if price != 0 {
+		m.Rate = marginRate(m.Amount, price)
+	}
+	return &m
+}
+
+func marginRate(margin, price Money) float64 {
+	return math.Round(float64(margin)/float64(price)*10000) / 10000
+}
+
+// costPrice is the cost of the sku, the cost of its product unless the sku costs more or less.
+func (s Sku) costPrice() Money {
+	if s.CostPrice == 0 && s.Product != nil {
+		return s.Product.CostPrice
+	}
+	return s.CostPrice
+}
+
+func (p Product) withoutCost() Product {
+	p.CostPrice, p.Margin = 0, nil
+	if len(p.Skus) != 0 {
+		skus := make([]Sku, len(p.Skus))
+		for i := range p.Skus {
+			skus[i] = p.Skus[i].withoutCost()
+		}
+		p.Skus = skus
+	}
+	return p
+}
+
+func (s Sku) withoutCost() Sku {
+	s.CostPrice, s.Margin = 0, nil
+	if s.Product != nil {
+		p := s.Product.withoutCost()
+		s.Product = &p
+	}
+	return s
+}
+
+// HideCost returns v without its cost prices and margins when the caller may not see them.
+// v is left as it is, products and skus are copied.
+func HideCost(ctx context.Context, v interface{}) interface{} {
+	if CostVisible(ctx) {
+		return v
+	}
+	switch t := v.(type) {
+	case Product:
+		return t.withoutCost()
+	case *Product:
+		if t == nil {
+			return t
+		}
+		p := t.withoutCost()
+		return &p
+	case []Product:
+		products := make([]Product, len(t))
+		for i := range t {
+			products[i] = t[i].withoutCost()
+		}
+		return products
+	case ProductList:
+		return HideCost(ctx, []Product(t))
+	case Sku:
+		return t.withoutCost()
+	case *Sku:
+		if t == nil {
+			return t
+		}
+		s := t.withoutCost()
+		return &s
+	case []Sku:
+		skus := make([]Sku, len(t))
+		for i := range t {
+			skus[i] = t[i].withoutCost()
+		}
+		return skus
+	case SkuList:
+		return HideCost(ctx, []Sku(t))
+	}
+	return v
+}
+
+// costColumns are written only by a caller who may see them.
+func costColumns(ctx context.Context) []string {
+	if CostVisible(ctx) {
+		return []string{"cost_price"}
+	}
+	return nil
+}
+
+// MarginReportData is the margin of the products of a brand which have a cost price, sold one of each at the price in effect.
+// Amounts are in Currency, the currency of the products.
+type MarginReportData struct {
+	BrandCode  string  `json:"brandCode"`
+	BrandName  string  `json:"brandName"`
+	Currency   string  `json:"currency"`
+	Count      int     `json:"count"`
+	CostTotal  Money   `json:"costTotal"`
+	SaleTotal  Money   `json:"saleTotal"`
+	Margin     Money   `json:"margin"`
+	MarginRate float64 `json:"marginRate"`
+}
+
+// MarginReport groups the margins of the products by brand, and by currency for a brand selling in several.
+func (Product) MarginReport(ctx context.Context) (interface{}, error) {
+	var products ProductList
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.
+		And("cost_price > 0").
+		Find(&products); err != nil {
+		return nil, err
+	}
+	if len(products) != 0 {
+		if err := products.LoadBrands(ctx); err != nil {
+			return nil, err
+		}
+		if err := products.LoadPrices(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	type key struct {
+		brandId  int64
+		currency string
+	}
+	groups := make(map[key]*MarginReportData)
+	var list []MarginReportData
+	var keys []key
+	var rates *exchangeRates
+	for _, p := range products {
+		// the cost is in the currency of the product, a price in another currency is converted into it
+		sale := p.Prices[0].SalePrice
+		if from := p.Prices[0].Currency; from != p.Currency {
+			if rates == nil {
+				r, err := loadExchangeRates(ctx)
+				if err != nil {
+					return nil, err
+				}
+				rates = &r
+			}
+			converted, err := rates.convert(sale, from, p.Currency)
+			if err != nil {
+				return nil, err
+			}
+			sale = converted.Amount
+		}
+		k := key{p.BrandId, p.Currency}
+		d, ok := groups[k]
+		if !ok {
+			d = &MarginReportData{BrandCode: p.Brand.Code, BrandName: p.Brand.Name, Currency: p.Currency}
+			groups[k] = d
+			keys = append(keys, k)
+		}
+		d.Count++
+		d.CostTotal += p.CostPrice
+		d.SaleTotal += sale
+	}
+	for _, k := range keys {
+		d := groups[k]
+		d.Margin = d.SaleTotal - d.CostTotal
+		if d.SaleTotal != 0 {
+			d.MarginRate = marginRate(d.Margin, d.SaleTotal)
+		}
+		list = append(list, *d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].BrandCode != list[j].BrandCode {
+			return list[i].BrandCode < list[j].BrandCode
+		}
+		return list[i].Currency < list[j].Currency
+	})
+
+	result := struct {
+		Data       []MarginReportData `json:"data"`
+		TotalCount int                `json:"totalCount"`
+	}{
+		Data:       list,
+		TotalCount: len(products),
+	}
+	return result, nil
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestHideCost(t *testing.T) {
+	p := Product{
+		Name:      "product",
+		ListPrice: 100 * MajorUnit,
+		CostPrice: 60 * MajorUnit,
+		Margin:    newMargin(60*MajorUnit, 80*MajorUnit),
+		Skus:      []Sku{{Code: "sku", CostPrice: 70 * MajorUnit}},
+	}
+	test.Equals(t, *p.Margin, Margin{Amount: 20 * MajorUnit, Rate: 0.25})
+
+	finance := WithRoles(context.Background(), []string{RoleFinance})
+	test.Equals(t, HideCost(finance, &p), interface{}(&p))
+
+	hidden := HideCost(context.Background(), []Product{p}).([]Product)
+	test.Equals(t, hidden[0].CostPrice, Money(0))
+	test.Equals(t, hidden[0].Margin == nil, true)
+	test.Equals(t, hidden[0].Skus[0].CostPrice, Money(0))
+	// the product itself is left as it is
+	test.Equals(t, p.Skus[0].CostPrice, 70*MajorUnit)
+
+	s := Sku{Code: "sku", Product: &p}
+	test.Equals(t, s.costPrice(), 60*MajorUnit)
+	for _, event := range []interface{}{p.ToEvent(finance), s.ToEvent(finance)} {
+		b, err := json.Marshal(event)
+		test.Ok(t, err)
+		test.Equals(t, strings.Contains(string(b), "costPrice"), false)
+		test.Equals(t, strings.Contains(string(b), "margin"), false)
+	}
+}
@@ -0,0 +1,232 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultCurrency is the currency of a tenant which has not set its own.
+const DefaultCurrency = "CNY"
+
+// priceCurrencyContext carries the currency in which prices are read.
+const priceCurrencyContext contextKey = "PriceCurrency"
+
+// WithPriceCurrency converts the prices read with ctx into currency.
+func WithPriceCurrency(ctx context.Context, currency string) context.Context {
+	return context.WithValue(ctx, priceCurrencyContext, currency)
+}
+
+var (
+	ErrInvalidCurrency      = errors.New("currency must be an ISO 4217 code")
+	ErrExchangeRateNotFound = errors.New("exchange rate not found")
+	ErrInvalidExchangeRate  = errors.New("rate must be positive")
+)
+
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// NormalizeCurrency upper-cases an ISO 4217 currency code.
+func NormalizeCurrency(currency string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if !currencyPattern.MatchString(currency) {
+		return "", ErrInvalidCurrency
+	}
+	return currency, nil
+}
+
+// TenantCurrency is the currency of prices and list prices entered without one,
+// and the rounding of amounts computed for the tenant.
+type TenantCurrency struct {
+	TenantCode string       `json:"-" xorm:"pk varchar(16)"`
+	Currency   string       `json:"currency" xorm:"varchar(3) notnull"`
+	Rounding   RoundingMode `json:"rounding" xorm:"varchar(16)"`
+	UpdatedAt  time.Time    `json:"updatedAt" xorm:"updated"`
+}
+
+// Load returns the settings of the tenant, defaults filled in.
+func (TenantCurrency) Load(ctx context.Context) (TenantCurrency, error) {
+	db, err := tenantDB(ctx, "tenant_currency")
+	if err != nil {
+		return TenantCurrency{}, err
+	}
+	c := TenantCurrency{TenantCode: tenantCode(ctx)}
+	if _, err := db.Get(&c); err != nil {
+		return TenantCurrency{}, err
+	}
+	if c.Currency == "" {
+		c.Currency = DefaultCurrency
+	}
+	if c.Rounding == "" {
+		c.Rounding = DefaultRoundingMode
+	}
+	return c, nil
+}
+
+func (TenantCurrency) Get(ctx context.Context) (string, error) {
+	c, err := TenantCurrency{}.Load(ctx)
+	return c.Currency, err
+}
+
+func (TenantCurrency) GetRounding(ctx context.Context) (RoundingMode, error) {
+	c, err := TenantCurrency{}.Load(ctx)
+	return c.Rounding, err
+}
+
+// Set changes the currency of the tenant, and its rounding unless rounding is empty.
+func (TenantCurrency) Set(ctx context.Context, currency string, rounding RoundingMode) (*TenantCurrency, error) {
+	currency, err := NormalizeCurrency(currency)
+	if err != nil {
+		return nil, err
+	}
+	c, err := TenantCurrency{}.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.Currency = currency
+	if rounding != "" {
+		if err := rounding.Validate(); err != nil {
+			return nil, err
+		}
+		c.Rounding = rounding
+	}
+	db, err := tenantDB(ctx, "tenant_currency")
+	if err != nil {
+		return nil, err
+	}
+	exist, err := db.Exist(&TenantCurrency{})
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		if db, err = tenantDB(ctx, "tenant_currency"); err != nil {
+			return nil, err
+		}
+		_, err = db.Cols("currency", "rounding").Update(&c)
+	} else {
+		err = tenantInsert(ctx, &c)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// ExchangeRate converts an amount of FromCurrency into ToCurrency by multiplying it by Rate.
+type ExchangeRate struct {
+	Id           int64     `json:"id"`
+	TenantCode   string    `json:"-" xorm:"unique(rate) varchar(16)"`
+	FromCurrency string    `json:"fromCurrency" xorm:"unique(rate) varchar(3)"`
+	ToCurrency   string    `json:"toCurrency" xorm:"unique(rate) varchar(3)"`
+	Rate         float64   `json:"rate"`
+	CreatedAt    time.Time `json:"createdAt" xorm:"created"`
+	UpdatedAt    time.Time `json:"updatedAt" xorm:"updated"`
+}
+
+// ConvertedAmount is an amount converted for reading, stored amounts stay in their own currency.
+type ConvertedAmount struct {
+	Currency string  `json:"currency"`
+	Amount   Money   `json:"amount"`
+	Rate     float64 `json:"rate"`
+}
+
+// Save loads rates, replacing the rate of the same pair of currencies.
+func (ExchangeRate) Save(ctx context.Context, rates []ExchangeRate) ([]ExchangeRate, error) {
+	for i := range rates {
+		r := &rates[i]
+		var err error
+		if r.FromCurrency, err = NormalizeCurrency(r.FromCurrency); err != nil {
+			return nil, err
+		}
+		if r.ToCurrency, err = NormalizeCurrency(r.ToCurrency); err != nil {
+			return nil, err
+		}
+		if r.Rate <= 0 {
+			return nil, ErrInvalidExchangeRate
+		}
+		db, err := tenantDB(ctx, "exchange_rate")
+		if err != nil {
+			return nil, err
+		}
+		var current ExchangeRate
+		exist, err := db.
+			And("from_currency = ?", r.FromCurrency).
+			And("to_currency = ?", r.ToCurrency).
+			Get(&current)
+		if err != nil {
+			return nil, err
+		}
+		if exist {
+			r.Id, r.CreatedAt = current.Id, current.CreatedAt
+			if db, err = tenantDB(ctx, "exchange_rate"); err != nil {
+				return nil, err
+			}
+			r.TenantCode = tenantCode(ctx)
+			_, err = db.ID(r.Id).Cols("rate").Update(r)
+		} else {
+			err = tenantInsert(ctx, r)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rates, nil
+}
+
+func (ExchangeRate) GetAll(ctx context.Context) ([]ExchangeRate, error) {
+	var rates []ExchangeRate
+	db, err := tenantDB(ctx, "exchange_rate")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.
+		Asc("from_currency", "to_currency").
+		Find(&rates); err != nil {
+		return nil, err
+	}
+	return rates, nil
+}
+
+// exchangeRates looks rates up by pair of currencies, the inverse rate standing in for a missing one.
+type exchangeRates struct {
+	rates    map[[2]string]float64
+	rounding RoundingMode
+}
+
+func loadExchangeRates(ctx context.Context) (exchangeRates, error) {
+	rates, err := ExchangeRate{}.GetAll(ctx)
+	if err != nil {
+		return exchangeRates{}, err
+	}
+	rounding, err := TenantCurrency{}.GetRounding(ctx)
+	if err != nil {
+		return exchangeRates{}, err
+	}
+	m := exchangeRates{rates: make(map[[2]string]float64), rounding: rounding}
+	for _, r := range rates {
+		m.rates[[2]string{r.FromCurrency, r.ToCurrency}] = r.Rate
+	}
+	return m, nil
+}
+
+func (m exchangeRates) convert(amount Money, from, to string) (*ConvertedAmount, error) {
+	if from == to {
+		return &ConvertedAmount{Currency: to, Amount: amount, Rate: 1}, nil
+	}
+	if r, ok := m.rates[[2]string{from, to}]; ok {
+		return &ConvertedAmount{Currency: to, Amount: amount.MulRate(r, m.rounding), Rate: r}, nil
+	}
+	if r, ok := m.rates[[2]string{to, from}]; ok {
+		return &ConvertedAmount{Currency: to, Amount: amount.DivRate(r, m.rounding), Rate: 1 / r}, nil
+	}
+	return nil, fmt.Errorf("%w: %s to %s", ErrExchangeRateNotFound, from, to)
+}
+
+func retrievePriceCurrency(ctx context.Context) string {
+	if v, ok := ctx.Value(priceCurrencyContext).(string); ok {
+		return v
+	}
+	return ""
+}
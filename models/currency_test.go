@@ -0,0 +1,48 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestExchangeRatesConvert(t *testing.T) {
+	rates := exchangeRates{
+		rates:    map[[2]string]float64{{"USD", "CNY"}: 7.1},
+		rounding: RoundingHalfUp,
+	}
+
+	converted, err := rates.convert(10*MajorUnit, "USD", "CNY")
+	test.Ok(t, err)
+	test.Equals(t, *converted, ConvertedAmount{Currency: "CNY", Amount: 71 * MajorUnit, Rate: 7.1})
+
+	converted, err = rates.convert(71*MajorUnit, "CNY", "USD")
+	test.Ok(t, err)
+	test.Equals(t, converted.Amount, 10*MajorUnit)
+
+	converted, err = rates.convert(10*MajorUnit, "CNY", "CNY")
+	test.Ok(t, err)
+	test.Equals(t, converted.Rate, float64(1))
+
+	_, err = rates.convert(10*MajorUnit, "EUR", "CNY")
+	test.Equals(t, errors.Is(err, ErrExchangeRateNotFound), true)
+}
+
+func TestExchangeRateSave(t *testing.T) {
+	_, err := ExchangeRate{}.Save(ctx, []ExchangeRate{{FromCurrency: "usd", ToCurrency: "CNY", Rate: 7}})
+	test.Ok(t, err)
+	_, err = ExchangeRate{}.Save(ctx, []ExchangeRate{{FromCurrency: "USD", ToCurrency: "CNY", Rate: 7.1}})
+	test.Ok(t, err)
+
+	rates, err := ExchangeRate{}.GetAll(ctx)
+	test.Ok(t, err)
+	test.Equals(t, len(rates), 1)
+	test.Equals(t, rates[0].FromCurrency, "USD")
+	test.Equals(t, rates[0].Rate, 7.1)
+
+	_, err = ExchangeRate{}.Save(ctx, []ExchangeRate{{FromCurrency: "US", ToCurrency: "CNY", Rate: 7.1}})
+	test.Equals(t, err, ErrInvalidCurrency)
+	_, err = ExchangeRate{}.Save(ctx, []ExchangeRate{{FromCurrency: "USD", ToCurrency: "CNY"}})
+	test.Equals(t, err, ErrInvalidExchangeRate)
+}
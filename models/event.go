@@ -12,6 +12,7 @@ const (
 	DataSourceHandle    DataSource = "handle"
 	DataSourceExcel     DataSource = "excel"
 	DataSourceInterface DataSource = "interface"
+	DataSourceSchedule  DataSource = "schedule"
 )
 
 func retrieveDataSource(ctx context.Context) DataSource {
@@ -23,6 +24,7 @@ func retrieveDataSource(ctx context.Context) DataSource {
 	return ds
 }
 
+// Events never carry cost prices, consumers of the event broker may not see them.
 type ProductEvent struct {
 	Product
 	DataSource DataSource `json:"dataSource"`
@@ -30,11 +32,29 @@ type ProductEvent struct {
 
 func (p Product) ToEvent(ctx context.Context) interface{} {
 	return ProductEvent{
-		Product:    p,
+		Product:    p.withoutCost(),
 		DataSource: retrieveDataSource(ctx),
 	}
 }
 
+type ProductChangedEvent struct {
+	ProductEvent
+	ChangedFields []string `json:"changedFields"`
+}
+
+// productChange is the payload of a partial update, which tells consumers which fields were touched.
+type productChange struct {
+	Product
+	ChangedFields []string
+}
+
+func (p productChange) ToEvent(ctx context.Context) interface{} {
+	return ProductChangedEvent{
+		ProductEvent:  ProductEvent{Product: p.Product.withoutCost(), DataSource: retrieveDataSource(ctx)},
+		ChangedFields: p.ChangedFields,
+	}
+}
+
 type SkuEvent struct {
 	Sku
 	DataSource DataSource `json:"dataSource"`
@@ -42,7 +62,7 @@ type SkuEvent struct {
 
 func (s Sku) ToEvent(ctx context.Context) interface{} {
 	return SkuEvent{
-		Sku:        s,
+		Sku:        s.withoutCost(),
 		DataSource: retrieveDataSource(ctx),
 	}
 }
@@ -59,6 +79,18 @@ func (p Price) ToEvent(ctx context.Context) interface{} {
 	}
 }
 
+type PriceBatchEvent struct {
+	Prices     []Price    `json:"prices"`
+	DataSource DataSource `json:"dataSource"`
+}
+
+func (b PriceBatch) ToEvent(ctx context.Context) interface{} {
+	return PriceBatchEvent{
+		Prices:     b,
+		DataSource: retrieveDataSource(ctx),
+	}
+}
+
 type ProductIdentifierEvent struct {
 	ProductIdentifier
 	DataSource DataSource `json:"dataSource"`
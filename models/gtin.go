@@ -0,0 +1,106 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidGTIN = errors.New("barcode must be an EAN-8, EAN-13, UPC-A, UPC-E or GTIN-14 with a valid check digit")
+
+// NormalizeGTIN validates the check digit of an EAN-8, EAN-13, UPC-A, UPC-E or GTIN-14
+// and returns it as the GTIN-14 it stands for, so the variants of one code are stored alike.
+// Spaces and hyphens are ignored. An 8-digit code of number system 0 or 1 is read as a UPC-E first,
+// as EAN-8 prefixes starting with 0 are for restricted circulation, and as an EAN-8 when it is not a valid UPC-E.
+func NormalizeGTIN(code string) (string, error) {
+	code = strings.NewReplacer(" ", "", "-", "").Replace(code)
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidGTIN
+		}
+	}
+	switch len(code) {
+	case 8:
+		if upcA, ok := expandUPCE(code); ok && validGTIN(padGTIN(upcA)) {
+			code = upcA
+		}
+	case 12, 13, 14:
+	default:
+		return "", ErrInvalidGTIN
+	}
+	gtin := padGTIN(code)
+	if !validGTIN(gtin) {
+		return "", ErrInvalidGTIN
+	}
+	return gtin, nil
+}
+
+// barcodeUids is the uids a barcode may be stored as: its GTIN-14 and, for codes entered before
+// barcodes were normalized, the code as given.
+func barcodeUids(code string) []string {
+	gtin, err := NormalizeGTIN(code)
+	if err != nil || gtin == code {
+		return []string{code}
+	}
+	return []string{gtin, code}
+}
+
+// normalizeBarcodes normalizes the barcodes among identifiers.
+func normalizeBarcodes(identifiers []SkuIdentifier) error {
+	for i := range identifiers {
+		if err := identifiers[i].normalize(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalize stores a barcode as its GTIN-14, identifiers of other sources are kept as they are.
+func (s *SkuIdentifier) normalize() error {
+	if s.Source != IdentifierSourceBarcode {
+		return nil
+	}
+	gtin, err := NormalizeGTIN(s.Uid)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, s.Uid)
+	}
+	s.Uid = gtin
+	return nil
+}
+
+func padGTIN(code string) string {
+	return strings.Repeat("0", 14-len(code)) + code
+}
+
+// validGTIN checks the last digit of a GTIN-14 against the others, weighted 3 and 1 from the right.
+func validGTIN(gtin string) bool {
+	var sum int
+	for i := 0; i < 13; i++ {
+		d := int(gtin[i] - '0')
+		if i%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10-sum%10)%10 == int(gtin[13]-'0')
+}
+
+// expandUPCE turns an 8-digit UPC-E, number system and check digit included, into its UPC-A.
+func expandUPCE(code string) (string, bool) {
+	if code[0] != '0' && code[0] != '1' {
+		return "", false
+	}
+	d := code[1:7]
+	var body string
+	switch d[5] {
+	case '0', '1', '2':
+		body = d[0:2] + d[5:6] + "0000" + d[2:5]
+	case '3':
+		body = d[0:3] + "00000" + d[3:5]
+	case '4':
+		body = d[0:4] + "00000" + d[4:5]
+	default:
+		body = d[0:5] + "0000" + d[5:6]
+	}
+	return code[0:1] + body + code[7:8], true
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/factory"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestNormalizeGTIN(t *testing.T) {
+	for _, c := range []struct {
+		code, gtin string
+	}{
+		{"96385074", "00000096385074"},        // EAN-8
+		{"4006381333931", "04006381333931"},   // EAN-13
+		{"036000291452", "00036000291452"},    // UPC-A
+		{"0 36000 29145 2", "00036000291452"}, // UPC-A as printed
+		{"01234565", "00012345000065"},        // UPC-E
+		{"10614141000415", "10614141000415"},  // GTIN-14
+	} {
+		gtin, err := NormalizeGTIN(c.code)
+		test.Ok(t, err)
+		test.Equals(t, gtin, c.gtin)
+	}
+
+	for _, code := range []string{"", "S101001", "4006381333932", "03600029145", "ABC12345", "96385075"} {
+		_, err := NormalizeGTIN(code)
+		test.Equals(t, err, ErrInvalidGTIN)
+	}
+
+	// the variants of one code are looked up alike
+	test.Equals(t, barcodeUids("036000291452"), []string{"00036000291452", "036000291452"})
+	test.Equals(t, barcodeUids("S101001"), []string{"S101001"})
+}
+
+func TestLegacyBarcodeIdentifier(t *testing.T) {
+	ctx := WithUserClaim(ctx, auth.UserClaim{TenantCode: "gtin"})
+	a, b := Sku{Code: "S970"}, Sku{Code: "S971"}
+	test.Ok(t, tenantInsert(ctx, &a, &b))
+	// a barcode of sku a stored as given, before barcodes were normalized
+	_, err := factory.DB(ctx).Insert(&SkuIdentifier{SkuId: a.Id, Uid: "4006381333931", Source: IdentifierSourceBarcode})
+	test.Ok(t, err)
+
+	identifier := SkuIdentifier{SkuId: b.Id, Uid: "4006381333931", Source: IdentifierSourceBarcode}
+	test.Equals(t, errors.Is(identifier.LoadOrCreate(ctx), ErrIdentifierExist), true)
+
+	identifier = SkuIdentifier{SkuId: a.Id, Uid: "4006381333931", Source: IdentifierSourceBarcode}
+	test.Ok(t, identifier.LoadOrCreate(ctx))
+	test.Equals(t, identifier.SkuId, a.Id)
+}
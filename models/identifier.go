@@ -2,6 +2,7 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,32 +10,45 @@ import (
 	"github.com/hublabs/product-api/factory"
 )
 
+var (
+	ErrIdentifierExist       = errors.New("identifier already exists")
+	ErrIdentifierSkuNotFound = errors.New("sku of identifier not found")
+)
+
 type ProductIdentifier struct {
-	Id        int64     `json:"-"`
-	ProductId int64     `json:"-" xorm:"index"`
-	Uid       string    `json:"uid" xorm:"index"`
-	Source    string    `json:"source" xorm:"index"`
-	CreatedAt time.Time `json:"-" xorm:"created"`
-	UpdatedAt time.Time `json:"-" xorm:"updated"`
+	Id          int64     `json:"-"`
+	ProductId   int64     `json:"-" xorm:"index"`
+	Uid         string    `json:"uid" xorm:"index"`
+	Source      string    `json:"source" xorm:"index"`
+	CreatedAt   time.Time `json:"-" xorm:"created"`
+	UpdatedAt   time.Time `json:"-" xorm:"updated"`
+	DeletedAt   time.Time `json:"-" xorm:"deleted index"`
+	DeleteBatch string    `json:"-" xorm:"index varchar(32)"`
 }
 
 type SkuIdentifier struct {
-	Id        int64     `json:"id,omitempty"`
-	SkuId     int64     `json:"skuId,omitempty" xorm:"index"`
-	ProductId int64     `json:"productId,omitempty" xorm:"-"`
-	Uid       string    `json:"uid" xorm:"index"`
-	Source    string    `json:"source,omitempty" xorm:"index"`
-	Enable    bool      `json:"enable"`
-	CreatedAt time.Time `json:"-" xorm:"created"`
-	UpdatedAt time.Time `json:"-" xorm:"updated"`
+	Id          int64     `json:"id,omitempty"`
+	SkuId       int64     `json:"skuId,omitempty" xorm:"index"`
+	ProductId   int64     `json:"productId,omitempty" xorm:"-"`
+	Uid         string    `json:"uid" xorm:"index"`
+	Source      string    `json:"source,omitempty" xorm:"index"`
+	Enable      bool      `json:"enable"`
+	CreatedAt   time.Time `json:"-" xorm:"created"`
+	UpdatedAt   time.Time `json:"-" xorm:"updated"`
+	DeletedAt   time.Time `json:"-" xorm:"deleted index"`
+	DeleteBatch string    `json:"-" xorm:"index varchar(32)"`
 }
 
 func (ProductIdentifier) GetByUidAndSource(ctx context.Context, uid, source string) (bool, ProductIdentifier, error) {
+	db, err := tenantChildDB(ctx, "product_identifier", "product_id", "product")
+	if err != nil {
+		return false, ProductIdentifier{}, err
+	}
 	p := ProductIdentifier{
 		Uid:    uid,
 		Source: source,
 	}
-	exist, err := factory.DB(ctx).Get(&p)
+	exist, err := db.Get(&p)
 	if err != nil {
 		return false, ProductIdentifier{}, err
 	}
@@ -45,11 +59,16 @@ func (ProductIdentifier) GetByUidAndSource(ctx context.Context, uid, source stri
 }
 
 func (SkuIdentifier) GetByUidAndSource(ctx context.Context, uid, source string) (bool, SkuIdentifier, error) {
-	s := SkuIdentifier{
-		Uid:    uid,
-		Source: source,
+	uids := []string{uid}
+	if source == IdentifierSourceBarcode {
+		uids = barcodeUids(uid)
 	}
-	exist, err := factory.DB(ctx).Get(&s)
+	db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return false, SkuIdentifier{}, err
+	}
+	var s SkuIdentifier
+	exist, err := db.Where("source = ?", source).In("uid", uids).Get(&s)
 	if err != nil {
 		return false, SkuIdentifier{}, err
 	}
@@ -61,7 +80,11 @@ func (SkuIdentifier) GetByUidAndSource(ctx context.Context, uid, source string)
 
 func (p *ProductIdentifier) CreateOrUpdate(ctx context.Context) (err error) {
 	var identifier ProductIdentifier
-	exist, err := factory.DB(ctx).Where("source = ?", p.Source).And("product_id = ?", p.ProductId).Get(&identifier)
+	db, err := tenantChildDB(ctx, "product_identifier", "product_id", "product")
+	if err != nil {
+		return err
+	}
+	exist, err := db.Where("source = ?", p.Source).And("product_id = ?", p.ProductId).Get(&identifier)
 	if err != nil {
 		return err
 	}
@@ -75,21 +98,37 @@ func (p *ProductIdentifier) CreateOrUpdate(ctx context.Context) (err error) {
 			return err
 		}
 	}
-	return adapters.MessagePublisher{}.Publish(ctx, *p, adapters.EventProductUidChanged)
+	return publishEvent(ctx, *p, adapters.EventProductUidChanged)
 }
 
 // Must be private because of event ProductUidChanged
 func (s *ProductIdentifier) update(ctx context.Context) (err error) {
-	if _, err = factory.DB(ctx).ID(s.Id).Update(s); err != nil {
+	db, err := tenantChildDB(ctx, "product_identifier", "product_id", "product")
+	if err != nil {
+		return err
+	}
+	if _, err = db.ID(s.Id).Update(s); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (s *SkuIdentifier) LoadOrCreate(ctx context.Context) (err error) {
+	// a barcode stored before barcodes were normalized is matched as it was given
+	uids := []string{s.Uid}
+	if s.Source == IdentifierSourceBarcode {
+		uids = barcodeUids(s.Uid)
+	}
+	if err := s.normalize(); err != nil {
+		return err
+	}
+	db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return err
+	}
 	var identifier SkuIdentifier
-	exist, err := factory.DB(ctx).Where("source = ?", s.Source).
-		And("uid = ?", s.Uid).
+	exist, err := db.Where("source = ?", s.Source).
+		In("uid", uids).
 		Get(&identifier)
 	if err != nil {
 		return err
@@ -97,29 +136,35 @@ func (s *SkuIdentifier) LoadOrCreate(ctx context.Context) (err error) {
 
 	if exist {
 		if s.SkuId != 0 && s.SkuId != identifier.SkuId {
-			return fmt.Errorf("Exist identifier(skuId:%d)", identifier.SkuId)
+			return fmt.Errorf("%w(skuId:%d)", ErrIdentifierExist, identifier.SkuId)
 		}
 
 		*s = identifier
 		return nil
 	}
 
-	if err := s.create(ctx); err != nil {
+	sku, err := Sku{}.Get(ctx, s.SkuId)
+	if err != nil {
 		return err
 	}
-
-	var sku Sku
-	if _, err := factory.DB(ctx).ID(s.SkuId).Get(&sku); err != nil {
+	if sku == nil {
+		return ErrIdentifierSkuNotFound
+	}
+	if err := s.create(ctx); err != nil {
 		return err
 	}
 	s.ProductId = sku.ProductId
 
-	return adapters.MessagePublisher{}.Publish(ctx, *s, adapters.EventSkuUidChanged)
+	return publishEvent(ctx, *s, adapters.EventSkuUidChanged)
 }
 
 // Must be private because of event SkuUidChanged
 func (s *SkuIdentifier) update(ctx context.Context) (err error) {
-	if _, err = factory.DB(ctx).ID(s.Id).Update(s); err != nil {
+	db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return err
+	}
+	if _, err = db.ID(s.Id).Update(s); err != nil {
 		return err
 	}
 	return nil
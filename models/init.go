@@ -1,10 +1,18 @@
 package models
 
 import (
+	"time"
+
 	"github.com/go-xorm/xorm"
 )
 
 func Init(db *xorm.Engine) error {
+	moneyInMinorUnits = db.DriverName() == "sqlite3"
+	// the columns of the schedule are added by the sync, so the prices entered before them are told apart first
+	unscheduled, err := unscheduledPrices(db)
+	if err != nil {
+		return err
+	}
 	if err := db.Sync(new(Product),
 		new(Sku),
 		new(Option),
@@ -14,10 +22,37 @@ func Init(db *xorm.Engine) error {
 		new(Brand),
 		new(Attribute),
 		new(AttributeValue),
+		new(OutboxEvent),
+		new(AuditLog),
+		new(TenantCurrency),
+		new(ExchangeRate),
+		new(PriceList),
+		new(Offer),
+		new(PriceApprovalPolicy),
+		new(PriceChange),
+		new(BarcodeAllocator),
+		new(BarcodeSequence),
+		new(LabelTemplate),
+		new(ApiKey),
 	); err != nil {
 		return err
 	}
-	return nil
+	// money columns created when money was a float would be read as minor units on SQLite
+	if _, err := MigrateMoney(db); err != nil {
+		return err
+	}
+	if err := clearOutboxTokens(db); err != nil {
+		return err
+	}
+	if err := normalizeBarcodeUids(db); err != nil {
+		return err
+	}
+	if unscheduled {
+		if err := activatePrices(db, time.Now()); err != nil {
+			return err
+		}
+	}
+	return uniqueBrandCodes(db)
 }
 
 func DropTables(db *xorm.Engine) error {
@@ -30,5 +65,17 @@ func DropTables(db *xorm.Engine) error {
 		new(Brand),
 		new(Attribute),
 		new(AttributeValue),
+		new(OutboxEvent),
+		new(AuditLog),
+		new(TenantCurrency),
+		new(ExchangeRate),
+		new(PriceList),
+		new(Offer),
+		new(PriceApprovalPolicy),
+		new(PriceChange),
+		new(BarcodeAllocator),
+		new(BarcodeSequence),
+		new(LabelTemplate),
+		new(ApiKey),
 	)
 }
@@ -5,6 +5,8 @@ import (
 	"runtime"
 
 	"github.com/go-xorm/xorm"
+	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/factory"
 	"github.com/pangpanglabs/goutils/echomiddleware"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -21,5 +23,8 @@ func init() {
 	if err := Init(xormEngine); err != nil {
 		panic(err)
 	}
+	factory.InitDB(xormEngine)
 	ctx = context.WithValue(context.Background(), echomiddleware.ContextDBName, xormEngine.NewSession())
+	// the claim auth.UserClaimMiddleware puts into the context of a request
+	ctx = WithUserClaim(ctx, auth.UserClaim{TenantCode: "test"})
 }
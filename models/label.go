@@ -0,0 +1,337 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type LabelFormat string
+
+const (
+	LabelFormatZPL LabelFormat = "zpl"
+	LabelFormatPDF LabelFormat = "pdf"
+)
+
+// DefaultLabelTemplateCode is the template of labels printed without one,
+// which is the built-in layout until the tenant saves its own under this code.
+const DefaultLabelTemplateCode = "default"
+
+// MaxLabels is the number of labels, copies included, printed by one request at most.
+const MaxLabels = 500
+
+var (
+	ErrInvalidLabelFormat    = errors.New("format must be one of zpl, pdf")
+	ErrInvalidLabelTemplate  = errors.New("invalid label template")
+	ErrLabelTemplateNotFound = errors.New("label template not found")
+	ErrLabelSkuNotFound      = errors.New("sku not found")
+	ErrLabelBarcodeNotFound  = errors.New("sku has no barcode to print")
+	ErrTooManyLabels         = fmt.Errorf("at most %d labels are printed at once", MaxLabels)
+)
+
+const labelTemplateMaxDimension = 200.0
+
+var (
+	defaultLabelTemplate = LabelTemplate{Code: DefaultLabelTemplateCode, Name: "50x30", Width: 50, Height: 30, Dpi: 203, ShowBrand: true, ShowOptions: true, ShowPrice: true}
+	labelTemplateDpis    = map[int]bool{203: true, 300: true, 600: true}
+)
+
+// LabelTemplate is a label size of a tenant, e.g. a shelf label or a hang tag, in millimetres.
+// Zpl is a text/template over LabelData which replaces the built-in ZPL layout when it is given,
+// e.g. to use a font with Chinese characters loaded on the printers of the tenant.
+type LabelTemplate struct {
+	Id          int64     `json:"id"`
+	TenantCode  string    `json:"-" xorm:"unique(code) varchar(16)"`
+	Code        string    `json:"code" xorm:"unique(code) varchar(32)"`
+	Name        string    `json:"name"`
+	Width       float64   `json:"width"`
+	Height      float64   `json:"height"`
+	Dpi         int       `json:"dpi"`
+	ShowBrand   bool      `json:"showBrand"`
+	ShowOptions bool      `json:"showOptions"`
+	ShowPrice   bool      `json:"showPrice"`
+	Zpl         string    `json:"zpl,omitempty" xorm:"text"`
+	CreatedAt   time.Time `json:"createdAt" xorm:"created"`
+	UpdatedAt   time.Time `json:"updatedAt" xorm:"updated"`
+}
+
+// LabelData is what a label shows of a sku. Barcode is the EAN-13 of the sku when it has one, its GTIN-14 otherwise.
+type LabelData struct {
+	SkuId     int64  `json:"skuId"`
+	SkuCode   string `json:"skuCode"`
+	Name      string `json:"name"`
+	BrandName string `json:"brandName"`
+	Color     string `json:"color"`
+	Size      string `json:"size"`
+	Options   string `json:"options"`
+	Barcode   string `json:"barcode"`
+	SalePrice Money  `json:"salePrice"`
+	Currency  string `json:"currency"`
+	Price     string `json:"price"`
+	Copies    int    `json:"copies"`
+}
+
+// LabelRequest is a sku to print labels of, once unless Copies is given.
+type LabelRequest struct {
+	SkuId  int64 `json:"skuId"`
+	Copies int   `json:"copies"`
+}
+
+func (LabelTemplate) GetAll(ctx context.Context) ([]LabelTemplate, error) {
+	var templates []LabelTemplate
+	db, err := tenantDB(ctx, "label_template")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Asc("code").Find(&templates); err != nil {
+		return nil, err
+	}
+	for _, t := range templates {
+		if t.Code == DefaultLabelTemplateCode {
+			return templates, nil
+		}
+	}
+	return append([]LabelTemplate{defaultLabelTemplate}, templates...), nil
+}
+
+// Get returns the template of the tenant, the built-in one for the default code when the tenant has not saved its own.
+func (LabelTemplate) Get(ctx context.Context, code string) (*LabelTemplate, error) {
+	if code == "" {
+		code = DefaultLabelTemplateCode
+	}
+	var t LabelTemplate
+	db, err := tenantDB(ctx, "label_template")
+	if err != nil {
+		return nil, err
+	}
+	exist, err := db.And("code = ?", code).Get(&t)
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return &t, nil
+	}
+	if code == DefaultLabelTemplateCode {
+		t = defaultLabelTemplate
+		return &t, nil
+	}
+	return nil, ErrLabelTemplateNotFound
+}
+
+// Save creates the template of its code, or replaces it.
+func (t *LabelTemplate) Save(ctx context.Context) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	db, err := tenantDB(ctx, "label_template")
+	if err != nil {
+		return err
+	}
+	var current LabelTemplate
+	exist, err := db.And("code = ?", t.Code).Get(&current)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		return tenantInsert(ctx, t)
+	}
+	if db, err = tenantDB(ctx, "label_template"); err != nil {
+		return err
+	}
+	t.Id, t.TenantCode, t.CreatedAt = current.Id, current.TenantCode, current.CreatedAt
+	_, err = db.ID(t.Id).
+		Cols("name", "width", "height", "dpi", "show_brand", "show_options", "show_price", "zpl").
+		Update(t)
+	return err
+}
+
+func (t *LabelTemplate) validate() error {
+	t.Code = strings.TrimSpace(t.Code)
+	if t.Code == "" {
+		return fmt.Errorf("%w: code is required", ErrInvalidLabelTemplate)
+	}
+	if t.Width <= 0 || t.Height <= 0 || t.Width > labelTemplateMaxDimension || t.Height > labelTemplateMaxDimension {
+		return fmt.Errorf("%w: width and height must be between 0 and %v mm", ErrInvalidLabelTemplate, labelTemplateMaxDimension)
+	}
+	if t.Dpi == 0 {
+		t.Dpi = defaultLabelTemplate.Dpi
+	}
+	if !labelTemplateDpis[t.Dpi] {
+		return fmt.Errorf("%w: dpi must be one of 203, 300, 600", ErrInvalidLabelTemplate)
+	}
+	if t.Zpl != "" {
+		if _, err := template.New(t.Code).Parse(t.Zpl); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidLabelTemplate, err)
+		}
+	}
+	return nil
+}
+
+// LoadLabels reads what the labels of the skus show, in the order asked for.
+// The sale price is the price in effect in the store and at the time of ctx, after offers when they are asked for.
+func (LabelData) LoadLabels(ctx context.Context, requests []LabelRequest) ([]LabelData, error) {
+	var total int
+	ids := make([]int64, len(requests))
+	for i, r := range requests {
+		ids[i] = r.SkuId
+		if r.Copies <= 0 {
+			requests[i].Copies = 1
+		}
+		total += requests[i].Copies
+	}
+	if total > MaxLabels {
+		return nil, ErrTooManyLabels
+	}
+	var skus SkuList
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.
+		And("("+excludeDeleted("sku")+")").
+		In("id", ids).
+		Find(&skus); err != nil {
+		return nil, err
+	}
+	if err := skus.LoadProducts(ctx, nil); err != nil {
+		return nil, err
+	}
+	if err := skus.LoadIdentifiers(ctx); err != nil {
+		return nil, err
+	}
+	if err := skus.LoadOptions(ctx); err != nil {
+		return nil, err
+	}
+
+	labels := make([]LabelData, len(requests))
+	for i, r := range requests {
+		s := skus.Find(r.SkuId)
+		if s == nil {
+			return nil, fmt.Errorf("%w: %d", ErrLabelSkuNotFound, r.SkuId)
+		}
+		l, err := newLabelData(*s)
+		if err != nil {
+			return nil, err
+		}
+		l.Copies = requests[i].Copies
+		labels[i] = l
+	}
+	return labels, nil
+}
+
+func newLabelData(s Sku) (LabelData, error) {
+	l := LabelData{SkuId: s.Id, SkuCode: s.Code, Name: s.Name}
+	for _, identifier := range s.Identifiers {
+		if identifier.Source == IdentifierSourceBarcode {
+			l.Barcode = identifier.Uid
+			if len(l.Barcode) == 14 && l.Barcode[0] == '0' {
+				l.Barcode = l.Barcode[1:]
+			}
+			break
+		}
+	}
+	if l.Barcode == "" {
+		return LabelData{}, fmt.Errorf("%w: %s", ErrLabelBarcodeNotFound, s.Code)
+	}
+	var options []string
+	for _, o := range s.Options {
+		switch o.Name {
+		case "color":
+			l.Color = o.Value
+		case "size":
+			l.Size = o.Value
+		}
+		options = append(options, o.Value)
+	}
+	l.Options = strings.Join(options, " / ")
+	if p := s.Product; p != nil {
+		if l.Name == "" {
+			l.Name = p.Name
+		}
+		l.BrandName = p.Brand.Name
+	}
+	if p := s.Price; p != nil {
+		l.SalePrice, l.Currency = p.SalePrice, p.Currency
+		if s.OfferPrice != nil {
+			l.SalePrice = *s.OfferPrice
+		}
+		if p.Converted != nil {
+			l.SalePrice, l.Currency = p.Converted.Amount, p.Converted.Currency
+		}
+		l.Price = l.Currency + " " + l.SalePrice.String()
+	}
+	return l, nil
+}
+
+// RenderZPL renders one label format per label, printed as many times as its copies.
+func (t LabelTemplate) RenderZPL(labels []LabelData) ([]byte, error) {
+	var buf bytes.Buffer
+	if t.Zpl != "" {
+		tmpl, err := template.New(t.Code).Parse(t.Zpl)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidLabelTemplate, err)
+		}
+		for _, l := range labels {
+			l = l.zplEscaped()
+			if err := tmpl.Execute(&buf, l); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidLabelTemplate, err)
+			}
+			buf.WriteString("\n")
+		}
+		return buf.Bytes(), nil
+	}
+
+	dots := func(mm float64) int { return int(mm * float64(t.Dpi) / 25.4) }
+	margin := dots(2)
+	line := dots(3)
+	module := dots(t.Width-4) / 95
+	if module < 1 {
+		module = 1
+	} else if module > 4 {
+		module = 4
+	}
+	for _, l := range labels {
+		l = l.zplEscaped()
+		fmt.Fprintf(&buf, "^XA^CI28^PW%d^LL%d\n", dots(t.Width), dots(t.Height))
+		y := margin
+		text := func(s string, height int) {
+			fmt.Fprintf(&buf, "^FO%d,%d^A0N,%d,%d^FD%s^FS\n", margin, y, height, height, s)
+			y += height + height/4
+		}
+		if t.ShowBrand && l.BrandName != "" {
+			text(l.BrandName, line)
+		}
+		text(l.Name, line*4/5)
+		if t.ShowOptions && l.Options != "" {
+			text(l.Options, line*4/5)
+		}
+		if t.ShowPrice && l.Price != "" {
+			text(l.Price, line*3/2)
+		}
+		barHeight := dots(t.Height) - y - margin - line
+		if barHeight < line {
+			barHeight = line
+		}
+		if len(l.Barcode) == 13 {
+			// ^BE takes the 12 digits before the check digit, which the printer adds
+			fmt.Fprintf(&buf, "^FO%d,%d^BY%d^BEN,%d,Y,N^FD%s^FS\n", margin, y, module, barHeight, l.Barcode[:12])
+		} else {
+			fmt.Fprintf(&buf, "^FO%d,%d^BY%d^BCN,%d,Y,N,N^FD%s^FS\n", margin, y, module, barHeight, l.Barcode)
+		}
+		fmt.Fprintf(&buf, "^PQ%d\n^XZ\n", l.Copies)
+	}
+	return buf.Bytes(), nil
+}
+
+// zplEscaped keeps the caret and the tilde, which start ZPL commands, out of the text of the label.
+func (l LabelData) zplEscaped() LabelData {
+	r := strings.NewReplacer("^", " ", "~", " ")
+	l.Name, l.BrandName = r.Replace(l.Name), r.Replace(l.BrandName)
+	l.Color, l.Size, l.Options = r.Replace(l.Color), r.Replace(l.Size), r.Replace(l.Options)
+	l.SkuCode = r.Replace(l.SkuCode)
+	return l
+}
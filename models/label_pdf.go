@@ -0,0 +1,193 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+var ErrUnprintableBarcode = errors.New("barcode is neither an EAN-13 nor a GTIN-14")
+
+var (
+	eanL = [10]string{"0001101", "0011001", "0010011", "0111101", "0100011", "0110001", "0101111", "0111011", "0110111", "0001011"}
+	eanG = [10]string{"0100111", "0110011", "0011011", "0100001", "0011101", "0111001", "0000101", "0010001", "0001001", "0010111"}
+	eanR = [10]string{"1110010", "1100110", "1101100", "1000010", "1011100", "1001110", "1010000", "1000100", "1001000", "1110100"}
+	// eanParity tells by the first digit of an EAN-13 which of the left digits are in set G
+	eanParity = [10]string{"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG", "LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL"}
+	// itfPatterns tell the narrow and wide elements of a digit of an ITF-14
+	itfPatterns = [10]string{"nnwwn", "wnnnw", "nwnnw", "wwnnn", "nnwnw", "wnwnn", "nwwnn", "nnnww", "wnnwn", "nwnwn"}
+)
+
+// ean13Modules is the 95 modules of an EAN-13, 1 for a bar and 0 for a space.
+func ean13Modules(code string) string {
+	var b strings.Builder
+	b.WriteString("101")
+	parity := eanParity[code[0]-'0']
+	for i := 1; i <= 6; i++ {
+		d := code[i] - '0'
+		if parity[i-1] == 'G' {
+			b.WriteString(eanG[d])
+		} else {
+			b.WriteString(eanL[d])
+		}
+	}
+	b.WriteString("01010")
+	for i := 7; i <= 12; i++ {
+		b.WriteString(eanR[code[i]-'0'])
+	}
+	b.WriteString("101")
+	return b.String()
+}
+
+// itf14Modules is the modules of an ITF-14 whose wide elements are 3 modules wide, 1 for a bar and 0 for a space.
+func itf14Modules(code string) string {
+	var b strings.Builder
+	b.WriteString("1010")
+	element := func(wide byte, bar bool) {
+		c := "0"
+		if bar {
+			c = "1"
+		}
+		if wide == 'w' {
+			b.WriteString(strings.Repeat(c, 3))
+		} else {
+			b.WriteString(c)
+		}
+	}
+	for i := 0; i < len(code); i += 2 {
+		bars, spaces := itfPatterns[code[i]-'0'], itfPatterns[code[i+1]-'0']
+		for j := 0; j < 5; j++ {
+			element(bars[j], true)
+			element(spaces[j], false)
+		}
+	}
+	b.WriteString("11101")
+	return b.String()
+}
+
+func barcodeModules(code string) (string, error) {
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("%w: %s", ErrUnprintableBarcode, code)
+		}
+	}
+	switch len(code) {
+	case 13:
+		return ean13Modules(code), nil
+	case 14:
+		return itf14Modules(code), nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnprintableBarcode, code)
+}
+
+// RenderPDF renders a page of the size of the template per label, repeated as many times as its copies.
+// Text is set in STSong-Light, a font every PDF reader has for Chinese, so the file embeds no font.
+func (t LabelTemplate) RenderPDF(labels []LabelData) ([]byte, error) {
+	const ptPerMm = 72 / 25.4
+	width, height := t.Width*ptPerMm, t.Height*ptPerMm
+	margin := 2 * ptPerMm
+
+	var pages []string
+	for _, l := range labels {
+		modules, err := barcodeModules(l.Barcode)
+		if err != nil {
+			return nil, err
+		}
+		var c bytes.Buffer
+		y := height - margin
+		text := func(s string, size float64) {
+			y -= size
+			fmt.Fprintf(&c, "BT /F1 %.2f Tf %.2f %.2f Td <%s> Tj ET\n", size, margin, y, pdfText(s))
+			y -= size / 4
+		}
+		if t.ShowBrand && l.BrandName != "" {
+			text(l.BrandName, 8)
+		}
+		text(l.Name, 7)
+		if t.ShowOptions && l.Options != "" {
+			text(l.Options, 7)
+		}
+		if t.ShowPrice && l.Price != "" {
+			text(l.Price, 11)
+		}
+
+		digits := 7.0
+		bottom := margin + digits + 1
+		barHeight := y - 2 - bottom
+		if barHeight < 10 {
+			barHeight = 10
+		}
+		module := (width - 2*margin) / float64(len(modules))
+		c.WriteString("0 g\n")
+		for i := 0; i < len(modules); {
+			if modules[i] != '1' {
+				i++
+				continue
+			}
+			j := i
+			for j < len(modules) && modules[j] == '1' {
+				j++
+			}
+			fmt.Fprintf(&c, "%.3f %.3f %.3f %.3f re f\n", margin+float64(i)*module, bottom, float64(j-i)*module, barHeight)
+			i = j
+		}
+		fmt.Fprintf(&c, "BT /F1 %.2f Tf %.2f %.2f Td <%s> Tj ET\n", digits, margin, margin, pdfText(l.Barcode))
+
+		for i := 0; i < l.Copies; i++ {
+			pages = append(pages, c.String())
+		}
+	}
+	return writePDF(width, height, pages), nil
+}
+
+// pdfText encodes s for the UniGB-UCS2-H encoding of the font, characters beyond it are printed as ?.
+func pdfText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r > 0xFFFF || utf16.IsSurrogate(r) {
+			r = '?'
+		}
+		fmt.Fprintf(&b, "%04X", r)
+	}
+	return b.String()
+}
+
+// writePDF writes a document of pages of one size, each given by its content stream.
+func writePDF(width, height float64, pages []string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // the pages, known once the pages are numbered
+		"<< /Type /Font /Subtype /Type0 /BaseFont /STSong-Light /Encoding /UniGB-UCS2-H /DescendantFonts [4 0 R] >>",
+		"<< /Type /Font /Subtype /CIDFontType0 /BaseFont /STSong-Light " +
+			"/CIDSystemInfo << /Registry (Adobe) /Ordering (GB1) /Supplement 4 >> /FontDescriptor 5 0 R /DW 1000 /W [1 95 500] >>",
+		"<< /Type /FontDescriptor /FontName /STSong-Light /Flags 6 /FontBBox [-25 -254 1000 880] " +
+			"/ItalicAngle 0 /Ascent 880 /Descent -120 /CapHeight 880 /StemV 93 >>",
+	}
+	var kids []string
+	for _, content := range pages {
+		page := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", width, height, page+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestBarcodeModules(t *testing.T) {
+	ean, err := barcodeModules("2001000000005")
+	test.Ok(t, err)
+	test.Equals(t, len(ean), 95)
+	test.Equals(t, ean[:3], "101")
+	test.Equals(t, ean[45:50], "01010")
+	test.Equals(t, ean[92:], "101")
+	// the first digit 2 sets the left digits in L, L, G, G, L, G
+	test.Equals(t, ean[3:10], eanL[0])
+	test.Equals(t, ean[17:24], eanG[1])
+
+	itf, err := barcodeModules("12001000000002")
+	test.Ok(t, err)
+	test.Equals(t, len(itf), 4+7*18+5)
+
+	for _, code := range []string{"20010000000", "ABC1000000005"} {
+		_, err := barcodeModules(code)
+		test.Equals(t, errors.Is(err, ErrUnprintableBarcode), true)
+	}
+}
+
+func TestRenderLabels(t *testing.T) {
+	labels := []LabelData{
+		{SkuCode: "S1", Name: "T恤^", BrandName: "hublabs", Options: "white / M", Barcode: "2001000000005", Price: "CNY 99.00", Copies: 2},
+		{SkuCode: "S2", Name: "bag", Barcode: "12001000000002", Copies: 1},
+	}
+
+	zpl, err := defaultLabelTemplate.RenderZPL(labels)
+	test.Ok(t, err)
+	test.Equals(t, bytes.Count(zpl, []byte("^XA")), 2)
+	test.Equals(t, bytes.Contains(zpl, []byte("^FDT恤 ^FS")), true)
+	test.Equals(t, bytes.Contains(zpl, []byte("^BEN")), true)
+	test.Equals(t, bytes.Contains(zpl, []byte("^PQ2")), true)
+
+	custom := LabelTemplate{Code: "small", Width: 30, Height: 20, Zpl: "^XA^FD{{.SkuCode}}|{{.Barcode}}^FS^XZ"}
+	test.Ok(t, custom.validate())
+	zpl, err = custom.RenderZPL(labels)
+	test.Ok(t, err)
+	test.Equals(t, string(zpl), "^XA^FDS1|2001000000005^FS^XZ\n^XA^FDS2|12001000000002^FS^XZ\n")
+
+	pdf, err := defaultLabelTemplate.RenderPDF(labels)
+	test.Ok(t, err)
+	test.Equals(t, strings.HasPrefix(string(pdf), "%PDF-1.4"), true)
+	test.Equals(t, strings.HasSuffix(string(pdf), "%%EOF\n"), true)
+	test.Equals(t, bytes.Contains(pdf, []byte("/Count 3")), true)
+	// T恤 in UTF-16BE
+	test.Equals(t, bytes.Contains(pdf, []byte("<00546064005E>")), true)
+
+	_, err = defaultLabelTemplate.RenderPDF([]LabelData{{Barcode: "A-1", Copies: 1}})
+	test.Equals(t, errors.Is(err, ErrUnprintableBarcode), true)
+
+	invalid := LabelTemplate{Code: "x", Width: 30, Height: 20, Zpl: "{{.SkuCode"}
+	test.Equals(t, errors.Is(invalid.validate(), ErrInvalidLabelTemplate), true)
+}
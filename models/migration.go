@@ -0,0 +1,285 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// moneyTables are the tables holding money columns.
+var moneyTables = map[string]interface{}{
+	"product": new(Product),
+	"sku":     new(Sku),
+	"price":   new(Price),
+}
+
+// MigrateMoney converts the money columns of tables created when money was a float, and skips the converted ones.
+// Init runs it, so that no amount is read before its column is converted.
+// MySQL columns are altered into DECIMAL(18,2). SQLite can not alter a column,
+// so the table is rebuilt with its amounts in minor units.
+func MigrateMoney(db *xorm.Engine) (map[string][]string, error) {
+	tables, err := db.DBMetas()
+	if err != nil {
+		return nil, err
+	}
+	migrated := make(map[string][]string)
+	for _, table := range tables {
+		var columns []string
+		for _, name := range moneyColumns[table.Name] {
+			if col := table.GetColumn(name); col != nil && isFloatType(col.SQLType.Name) {
+				columns = append(columns, name)
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		if db.DriverName() == "sqlite3" {
+			err = rebuildMoneyTable(db, table.Name, table.ColumnsSeq(), columns)
+		} else {
+			err = alterMoneyColumns(db, table.Name, columns)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("migrate %s: %w", table.Name, err)
+		}
+		migrated[table.Name] = columns
+	}
+	return migrated, nil
+}
+
+func isFloatType(name string) bool {
+	switch strings.ToUpper(name) {
+	case "REAL", "FLOAT", "DOUBLE":
+		return true
+	}
+	return false
+}
+
+func alterMoneyColumns(db *xorm.Engine, table string, columns []string) error {
+	for _, col := range columns {
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE `%s` MODIFY `%s` DECIMAL(18,2)", table, col)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func rebuildMoneyTable(db *xorm.Engine, table string, oldColumns, moneyColumns []string) error {
+	bean := moneyTables[table]
+	info := db.TableInfo(bean)
+	old := table + "_float"
+
+	session := db.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return err
+	}
+	// the indexes keep their names when the table is renamed, so they are dropped to be created again on the new table
+	indexes, err := db.Dialect().GetIndexes(table)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if _, err := session.Exec(db.Dialect().DropIndexSql(table, index)); err != nil {
+			return err
+		}
+	}
+	if _, err := session.Exec(fmt.Sprintf("ALTER TABLE `%s` RENAME TO `%s`", table, old)); err != nil {
+		return err
+	}
+	if err := session.CreateTable(bean); err != nil {
+		return err
+	}
+	if err := session.CreateIndexes(bean); err != nil {
+		return err
+	}
+	if err := session.CreateUniques(bean); err != nil {
+		return err
+	}
+
+	var columns, values []string
+	for _, col := range oldColumns {
+		if info.GetColumn(col) == nil {
+			continue
+		}
+		value := "`" + col + "`"
+		for _, money := range moneyColumns {
+			if col == money {
+				value = fmt.Sprintf("CAST(ROUND(`%s` * %d) AS INTEGER)", col, MajorUnit)
+			}
+		}
+		columns = append(columns, "`"+col+"`")
+		values = append(values, value)
+	}
+	if _, err := session.Exec(fmt.Sprintf("INSERT INTO `%s` (%s) SELECT %s FROM `%s`",
+		table, strings.Join(columns, ", "), strings.Join(values, ", "), old)); err != nil {
+		return err
+	}
+	if _, err := session.Exec(fmt.Sprintf("DROP TABLE `%s`", old)); err != nil {
+		return err
+	}
+	return session.Commit()
+}
+
+// clearOutboxTokens blanks the tokens of requests which the outbox kept with its events before it kept their tenant and colleague.
+func clearOutboxTokens(db *xorm.Engine) error {
+	tables, err := db.DBMetas()
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if table.Name == "outbox_event" && table.GetColumn("auth_token") != nil {
+			_, err := db.Exec("UPDATE `outbox_event` SET `auth_token` = NULL WHERE `auth_token` IS NOT NULL")
+			return err
+		}
+	}
+	return nil
+}
+
+// unscheduledPrices tells whether the price table was created before prices were announced on their start and their end.
+func unscheduledPrices(db *xorm.Engine) (bool, error) {
+	tables, err := db.DBMetas()
+	if err != nil {
+		return false, err
+	}
+	for _, table := range tables {
+		if table.Name == "price" {
+			return table.GetColumn("activated_at") == nil, nil
+		}
+	}
+	return false, nil
+}
+
+// activatePrices records the prices entered before prices were announced as started and ended by now,
+// so that the first run of the scheduler does not announce the whole catalogue again.
+// The prices starting or ending later are left to the scheduler.
+func activatePrices(db *xorm.Engine, now time.Time) error {
+	if _, err := db.Exec("UPDATE `price` SET `activated_at` = COALESCE(`start_at`, `created_at`) WHERE `start_at` IS NULL OR `start_at` <= ?", now); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE `price` SET `expired_at` = `end_at` WHERE `end_at` <= ?", now)
+	return err
+}
+
+// normalizeBarcodeUids rewrites the barcodes stored before barcodes were normalized, of skus and of the prices
+// targeting them, into the GTIN-14 they stand for, so that the variants of a code are found alike.
+// Codes which are no valid GTIN are left as they are.
+func normalizeBarcodeUids(db *xorm.Engine) error {
+	for _, c := range []struct {
+		table, column, source, value string
+	}{
+		{"sku_identifier", "uid", "source", IdentifierSourceBarcode},
+		{"price", "target_id", "target_type", string(PriceTargetTypeBarcode)},
+	} {
+		rows, err := db.QueryString(fmt.Sprintf("SELECT `id`, `%s` FROM `%s` WHERE `%s` = ?", c.column, c.table, c.source), c.value)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			gtin, err := NormalizeGTIN(row[c.column])
+			if err != nil || gtin == row[c.column] {
+				continue
+			}
+			if _, err := db.Exec(fmt.Sprintf("UPDATE `%s` SET `%s` = ? WHERE `id` = ?", c.table, c.column), gtin, row["id"]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// BrandSplit is a brand shared across tenants before brands were owned by tenants,
+// with the brand each tenant referencing it owns now.
+// A brand no product references, or whose tenants all own a brand of its code already, is left without a tenant and Unassigned.
+type BrandSplit struct {
+	BrandId    int64            `json:"brandId"`
+	Code       string           `json:"code"`
+	Tenants    map[string]int64 `json:"tenants"`
+	Unassigned bool             `json:"unassigned"`
+}
+
+// MigrateBrandTenants gives every brand without a tenant to the tenants whose products reference it.
+// The first tenant takes the brand itself, and every other one a copy of it, or the brand of the code it owns already,
+// with its products moved onto it. Brands split before are skipped, so it can be run again.
+// Products are moved without events, as neither the code nor the name of their brand changes.
+func MigrateBrandTenants(db *xorm.Engine) ([]BrandSplit, error) {
+	session := db.NewSession()
+	defer session.Close()
+	if err := session.Begin(); err != nil {
+		return nil, err
+	}
+
+	var brands []Brand
+	if err := session.Where("tenant_code IS NULL OR tenant_code = ''").Asc("id").Find(&brands); err != nil {
+		return nil, err
+	}
+	var splits []BrandSplit
+	for _, b := range brands {
+		var tenants []string
+		if err := session.Table("product").Distinct("tenant_code").
+			Where("brand_id = ? AND tenant_code IS NOT NULL AND tenant_code <> ''", b.Id).
+			Asc("tenant_code").
+			Find(&tenants); err != nil {
+			return nil, err
+		}
+		split := BrandSplit{BrandId: b.Id, Code: b.Code, Tenants: make(map[string]int64)}
+		claimed := false
+		for _, tenant := range tenants {
+			var owned Brand
+			exist, err := session.Where("tenant_code = ? AND code = ?", tenant, b.Code).Get(&owned)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case exist:
+			case !claimed:
+				if _, err := session.Exec("UPDATE `brand` SET `tenant_code` = ? WHERE `id` = ?", tenant, b.Id); err != nil {
+					return nil, err
+				}
+				owned, claimed = b, true
+			default:
+				owned = Brand{TenantCode: tenant, Code: b.Code, Name: b.Name, Enable: b.Enable}
+				if _, err := session.Insert(&owned); err != nil {
+					return nil, err
+				}
+			}
+			if owned.Id != b.Id {
+				if _, err := session.Exec("UPDATE `product` SET `brand_id` = ? WHERE `brand_id` = ? AND `tenant_code` = ?", owned.Id, b.Id, tenant); err != nil {
+					return nil, err
+				}
+			}
+			split.Tenants[tenant] = owned.Id
+		}
+		split.Unassigned = !claimed
+		splits = append(splits, split)
+	}
+	if err := session.Commit(); err != nil {
+		return nil, err
+	}
+	if err := uniqueBrandCodes(db); err != nil {
+		return nil, err
+	}
+	return splits, nil
+}
+
+// uniqueBrandCodes adds the unique index of the codes of a tenant's brands, unless two brands share a tenant and a code.
+// Brands shared across tenants may share a code until MigrateBrandTenants splits them, which adds the index then.
+func uniqueBrandCodes(db *xorm.Engine) error {
+	indexes, err := db.Dialect().GetIndexes("brand")
+	if err != nil {
+		return err
+	}
+	if _, ok := indexes["tenant_code_code"]; ok {
+		return nil
+	}
+	duplicates, err := db.QueryString("SELECT `tenant_code`, `code` FROM `brand` GROUP BY `tenant_code`, `code` HAVING COUNT(*) > 1")
+	if err != nil {
+		return err
+	}
+	if len(duplicates) != 0 {
+		return nil
+	}
+	_, err = db.Exec("CREATE UNIQUE INDEX `UQE_brand_tenant_code_code` ON `brand` (`tenant_code`, `code`)")
+	return err
+}
@@ -0,0 +1,268 @@
+package models
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	"github.com/pangpanglabs/goutils/echomiddleware"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestMigrateMoney(t *testing.T) {
+	dir, err := ioutil.TempDir("", "product")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "product.db"))
+	test.Ok(t, err)
+	defer db.Close()
+
+	// the price table as it was created when money was a float
+	_, err = db.Exec("CREATE TABLE `price` (`id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `tenant_code` TEXT NULL, `target_type` TEXT NULL, `target_id` TEXT NULL, `sale_price` REAL NULL, `created_at` DATETIME NULL, `updated_at` DATETIME NULL, `deleted_at` DATETIME NULL)")
+	test.Ok(t, err)
+	_, err = db.Exec("CREATE INDEX `IDX_price_target_id` ON `price` (`target_id`)")
+	test.Ok(t, err)
+	_, err = db.Exec("INSERT INTO `price` (`target_type`, `target_id`, `sale_price`) VALUES ('barcode', 'barcode#1', 198.05)")
+	test.Ok(t, err)
+
+	migrated, err := MigrateMoney(db)
+	test.Ok(t, err)
+	test.Equals(t, migrated, map[string][]string{"price": {"sale_price"}})
+
+	var prices []Price
+	test.Ok(t, db.Find(&prices))
+	test.Equals(t, len(prices), 1)
+	test.Equals(t, prices[0].TargetId, "barcode#1")
+	test.Equals(t, prices[0].SalePrice, 19805*MinorUnit)
+
+	tables, err := db.DBMetas()
+	test.Ok(t, err)
+	test.Equals(t, tables[0].GetColumn("sale_price").SQLType.Name, "NUMERIC")
+	test.Equals(t, tables[0].Indexes["target_id"] != nil, true)
+
+	migrated, err = MigrateMoney(db)
+	test.Ok(t, err)
+	test.Equals(t, len(migrated), 0)
+}
+
+func TestInitMigrateMoney(t *testing.T) {
+	dir, err := ioutil.TempDir("", "product")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "product.db"))
+	test.Ok(t, err)
+	defer db.Close()
+
+	_, err = db.Exec("CREATE TABLE `product` (`id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `tenant_code` TEXT NULL, `code` TEXT NULL, `list_price` REAL NULL)")
+	test.Ok(t, err)
+	_, err = db.Exec("INSERT INTO `product` (`tenant_code`, `code`, `list_price`) VALUES ('a', 'P1', 99.9)")
+	test.Ok(t, err)
+
+	// the service starts on the database of a version storing money as a float
+	test.Ok(t, Init(db))
+	var p Product
+	_, err = db.Where("code = ?", "P1").Get(&p)
+	test.Ok(t, err)
+	test.Equals(t, p.ListPrice, 9990*MinorUnit)
+}
+
+func TestInitClearOutboxTokens(t *testing.T) {
+	dir, err := ioutil.TempDir("", "product")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "product.db"))
+	test.Ok(t, err)
+	defer db.Close()
+
+	// the outbox as it was created when it kept the token of the request
+	_, err = db.Exec("CREATE TABLE `outbox_event` (`id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `tenant_code` TEXT NULL, `status` TEXT NULL, `auth_token` TEXT NULL, `payload` TEXT NULL)")
+	test.Ok(t, err)
+	_, err = db.Exec("INSERT INTO `outbox_event` (`tenant_code`, `status`, `auth_token`, `payload`) VALUES ('a', 'ProductChanged', 'Bearer token', '{}')")
+	test.Ok(t, err)
+
+	test.Ok(t, Init(db))
+	rows, err := db.QueryString("SELECT `auth_token` FROM `outbox_event`")
+	test.Ok(t, err)
+	test.Equals(t, rows[0]["auth_token"], "")
+}
+
+func TestInitNormalizeBarcodeUids(t *testing.T) {
+	dir, err := ioutil.TempDir("", "product")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "product.db"))
+	test.Ok(t, err)
+	defer db.Close()
+
+	// barcodes stored as given before barcodes were normalized
+	_, err = db.Exec("CREATE TABLE `sku_identifier` (`id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `sku_id` INTEGER NULL, `uid` TEXT NULL, `source` TEXT NULL)")
+	test.Ok(t, err)
+	_, err = db.Exec("INSERT INTO `sku_identifier` (`sku_id`, `uid`, `source`) VALUES (1, '4006381333931', 'Barcode'), (2, 'S101001', 'Barcode'), (3, '036000291452', 'Sku')")
+	test.Ok(t, err)
+	_, err = db.Exec("CREATE TABLE `price` (`id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `target_type` TEXT NULL, `target_id` TEXT NULL)")
+	test.Ok(t, err)
+	_, err = db.Exec("INSERT INTO `price` (`target_type`, `target_id`) VALUES ('barcode', '036000291452'), ('product', '036000291452')")
+	test.Ok(t, err)
+
+	test.Ok(t, Init(db))
+	identifiers, err := db.QueryString("SELECT `uid` FROM `sku_identifier` ORDER BY `id`")
+	test.Ok(t, err)
+	// a code which is no GTIN, and the uid of another source, are left as they are
+	test.Equals(t, []string{identifiers[0]["uid"], identifiers[1]["uid"], identifiers[2]["uid"]}, []string{"04006381333931", "S101001", "036000291452"})
+	prices, err := db.QueryString("SELECT `target_id` FROM `price` ORDER BY `id`")
+	test.Ok(t, err)
+	test.Equals(t, []string{prices[0]["target_id"], prices[1]["target_id"]}, []string{"00036000291452", "036000291452"})
+}
+
+func TestInitActivatePrices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "product")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "product.db"))
+	test.Ok(t, err)
+	defer db.Close()
+
+	// the prices entered before they were announced on their start and their end
+	_, err = db.Exec("CREATE TABLE `price` (`id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `tenant_code` TEXT NULL, `target_type` TEXT NULL, `target_id` TEXT NULL, `start_at` DATETIME NULL, `end_at` DATETIME NULL, `created_at` DATETIME NULL)")
+	test.Ok(t, err)
+	_, err = db.Exec("INSERT INTO `price` (`tenant_code`, `target_type`, `target_id`, `start_at`, `end_at`, `created_at`) VALUES " +
+		"('a', 'product', '1', NULL, NULL, '2020-01-01 00:00:00'), " +
+		"('a', 'product', '2', '2020-02-01 00:00:00', NULL, '2020-01-01 00:00:00'), " +
+		"('a', 'product', '3', '2020-02-01 00:00:00', '2020-03-01 00:00:00', '2020-01-01 00:00:00'), " +
+		"('a', 'product', '4', '2999-01-01 00:00:00', NULL, '2020-01-01 00:00:00')")
+	test.Ok(t, err)
+
+	test.Ok(t, Init(db))
+	prices, err := db.QueryString("SELECT `activated_at`, `expired_at` FROM `price` ORDER BY `id`")
+	test.Ok(t, err)
+	test.Equals(t, []string{prices[0]["activated_at"], prices[1]["activated_at"], prices[2]["activated_at"], prices[2]["expired_at"]},
+		[]string{"2020-01-01T00:00:00Z", "2020-02-01T00:00:00Z", "2020-02-01T00:00:00Z", "2020-03-01T00:00:00Z"})
+	// a price starting later is still announced by the scheduler
+	test.Equals(t, prices[3]["activated_at"], "")
+
+	session := db.NewSession()
+	defer session.Close()
+	n, err := Price{}.RunSchedule(context.WithValue(context.Background(), echomiddleware.ContextDBName, session), time.Now())
+	test.Ok(t, err)
+	test.Equals(t, n, 0)
+	count, err := db.Count(&OutboxEvent{})
+	test.Ok(t, err)
+	test.Equals(t, count, int64(0))
+
+	// the prices are told apart only once, as a price entered since is announced
+	_, err = db.Exec("UPDATE `price` SET `activated_at` = NULL WHERE `id` = 2")
+	test.Ok(t, err)
+	test.Ok(t, Init(db))
+	prices, err = db.QueryString("SELECT `activated_at` FROM `price` WHERE `id` = 2")
+	test.Ok(t, err)
+	test.Equals(t, prices[0]["activated_at"], "")
+}
+
+func TestMigrateBrandTenants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "product")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "product.db"))
+	test.Ok(t, err)
+	defer db.Close()
+
+	// the brand table as it was created when brands were shared across tenants
+	_, err = db.Exec("CREATE TABLE `brand` (`id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `code` TEXT NULL, `name` TEXT NULL, `enable` INTEGER NULL, `version` INTEGER NULL)")
+	test.Ok(t, err)
+	_, err = db.Exec("CREATE INDEX `IDX_brand_code` ON `brand` (`code`)")
+	test.Ok(t, err)
+	_, err = db.Exec("INSERT INTO `brand` (`code`, `name`, `enable`, `version`) VALUES ('EE', 'Eland', 1, 1), ('EA', 'Eland Accessory', 1, 1), ('XX', 'unused', 1, 1)")
+	test.Ok(t, err)
+	test.Ok(t, db.Sync2(new(Brand), new(Product)))
+
+	// tenant b has a brand of the code already
+	_, err = db.Insert(&Brand{TenantCode: "b", Code: "EA", Name: "Eland Accessory"})
+	test.Ok(t, err)
+	for _, p := range []Product{
+		{TenantCode: "a", Code: "P1", BrandId: 1},
+		{TenantCode: "b", Code: "P2", BrandId: 1},
+		{TenantCode: "c", Code: "P3", BrandId: 1},
+		{TenantCode: "b", Code: "P4", BrandId: 2},
+	} {
+		_, err := db.Insert(&p)
+		test.Ok(t, err)
+	}
+
+	splits, err := MigrateBrandTenants(db)
+	test.Ok(t, err)
+	test.Equals(t, len(splits), 3)
+	test.Equals(t, splits[0].Tenants, map[string]int64{"a": 1, "b": 5, "c": 6})
+	test.Equals(t, splits[1].Tenants, map[string]int64{"b": 4})
+	test.Equals(t, len(splits[2].Tenants), 0)
+	// brand EA is left to no tenant, as its only tenant owns a brand of its code already
+	test.Equals(t, []bool{splits[0].Unassigned, splits[1].Unassigned, splits[2].Unassigned}, []bool{false, true, true})
+	var unassigned []Brand
+	test.Ok(t, db.Where("tenant_code IS NULL OR tenant_code = ''").Asc("id").Find(&unassigned))
+	test.Equals(t, []int64{unassigned[0].Id, unassigned[1].Id}, []int64{splits[1].BrandId, splits[2].BrandId})
+
+	var products []Product
+	test.Ok(t, db.Asc("code").Find(&products))
+	for i, brandId := range []int64{1, 5, 6, 4} {
+		test.Equals(t, products[i].BrandId, brandId)
+	}
+	var brands []Brand
+	test.Ok(t, db.Where("code = ?", "EE").Asc("id").Find(&brands))
+	test.Equals(t, len(brands), 3)
+	test.Equals(t, brands[1].TenantCode, "b")
+	test.Equals(t, brands[1].Name, "Eland")
+
+	// a tenant can not own two brands of a code
+	_, err = db.Insert(&Brand{TenantCode: "a", Code: "EE"})
+	test.Equals(t, err != nil, true)
+
+	splits, err = MigrateBrandTenants(db)
+	test.Ok(t, err)
+	test.Equals(t, len(splits), 2)
+	test.Equals(t, splits[0].Code, "EA")
+	test.Equals(t, len(splits[1].Tenants), 0)
+}
+
+func TestMigrateBrandTenantsDuplicateCodes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "product")
+	test.Ok(t, err)
+	defer os.RemoveAll(dir)
+	db, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "product.db"))
+	test.Ok(t, err)
+	defer db.Close()
+
+	// brands shared across tenants could share a code
+	_, err = db.Exec("CREATE TABLE `brand` (`id` INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL, `code` TEXT NULL, `name` TEXT NULL, `enable` INTEGER NULL, `version` INTEGER NULL)")
+	test.Ok(t, err)
+	_, err = db.Exec("CREATE INDEX `IDX_brand_code` ON `brand` (`code`)")
+	test.Ok(t, err)
+	_, err = db.Exec("INSERT INTO `brand` (`code`, `name`, `enable`, `version`) VALUES ('EE', 'Eland', 1, 1), ('EE', 'Eland', 1, 1)")
+	test.Ok(t, err)
+
+	// the service starts before the brands are split
+	test.Ok(t, Init(db))
+	indexes, err := db.Dialect().GetIndexes("brand")
+	test.Ok(t, err)
+	_, ok := indexes["tenant_code_code"]
+	test.Equals(t, ok, false)
+
+	for _, p := range []Product{
+		{TenantCode: "a", Code: "P1", BrandId: 1},
+		{TenantCode: "a", Code: "P2", BrandId: 2},
+	} {
+		_, err := db.Insert(&p)
+		test.Ok(t, err)
+	}
+	splits, err := MigrateBrandTenants(db)
+	test.Ok(t, err)
+	test.Equals(t, splits[0].Tenants, map[string]int64{"a": 1})
+	test.Equals(t, splits[1].Tenants, map[string]int64{"a": 1})
+	test.Equals(t, splits[1].Unassigned, true)
+
+	_, err = db.Insert(&Brand{TenantCode: "a", Code: "EE"})
+	test.Equals(t, err != nil, true)
+}
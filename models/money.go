@@ -0,0 +1,190 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Money is a fixed-point amount counted in minor units, i.e. hundredths of the currency unit.
+// It is DECIMAL(18,2) in MySQL and integer minor units in SQLite, and a number with two decimals in json.
+type Money int64
+
+const (
+	MinorUnit Money = 1
+	MajorUnit Money = 100
+
+	moneyScale = 2
+)
+
+var ErrInvalidMoney = errors.New("invalid money amount")
+
+// moneyInMinorUnits is set by Init after the database the money columns live in.
+var moneyInMinorUnits bool
+
+// moneyColumns lists the money columns by table.
+var moneyColumns = map[string][]string{
+	"product": {"list_price", "cost_price"},
+	"sku":     {"cost_price"},
+	"price":   {"sale_price"},
+}
+
+// RoundingMode rounds an amount which has more decimals than Money holds, e.g. a converted one.
+type RoundingMode string
+
+const (
+	RoundingHalfUp   RoundingMode = "half_up"
+	RoundingHalfEven RoundingMode = "half_even"
+	RoundingDown     RoundingMode = "down"
+	RoundingUp       RoundingMode = "up"
+)
+
+// DefaultRoundingMode is the rounding of a tenant which has not set its own.
+const DefaultRoundingMode = RoundingHalfUp
+
+var ErrInvalidRoundingMode = errors.New("rounding must be one of half_up, half_even, down, up")
+
+func (mode RoundingMode) Validate() error {
+	switch mode {
+	case RoundingHalfUp, RoundingHalfEven, RoundingDown, RoundingUp:
+		return nil
+	}
+	return ErrInvalidRoundingMode
+}
+
+// round rounds r to an integer, away from zero for RoundingUp and towards zero for RoundingDown.
+func (mode RoundingMode) round(r *big.Rat) int64 {
+	num, denom := new(big.Int).Set(r.Num()), r.Denom()
+	neg := num.Sign() < 0
+	num.Abs(num)
+	q, rem := new(big.Int).QuoRem(num, denom, new(big.Int))
+	if rem.Sign() != 0 {
+		half := new(big.Int).Mul(rem, big.NewInt(2)).Cmp(denom)
+		switch mode {
+		case RoundingUp:
+			q.Add(q, big.NewInt(1))
+		case RoundingDown:
+		case RoundingHalfEven:
+			if half > 0 || (half == 0 && q.Bit(0) == 1) {
+				q.Add(q, big.NewInt(1))
+			}
+		default:
+			if half >= 0 {
+				q.Add(q, big.NewInt(1))
+			}
+		}
+	}
+	if neg {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+func parseDecimal(s string) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.Contains(s, "/") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMoney, s)
+	}
+	return r, nil
+}
+
+// ParseMoney parses a decimal amount exactly, it fails when the amount has more than two decimals.
+func ParseMoney(s string) (Money, error) {
+	r, err := parseDecimal(s)
+	if err != nil {
+		return 0, err
+	}
+	r.Mul(r, big.NewRat(int64(MajorUnit), 1))
+	if !r.IsInt() {
+		return 0, fmt.Errorf("%w: %q has more than %d decimals", ErrInvalidMoney, s, moneyScale)
+	}
+	return Money(r.Num().Int64()), nil
+}
+
+// ParseMoneyRounded parses a decimal amount, rounding the decimals Money does not hold.
+func ParseMoneyRounded(s string, mode RoundingMode) (Money, error) {
+	r, err := parseDecimal(s)
+	if err != nil {
+		return 0, err
+	}
+	return Money(mode.round(r.Mul(r, big.NewRat(int64(MajorUnit), 1)))), nil
+}
+
+// MulRate multiplies m by a rate given as a float, e.g. an exchange rate, which is taken by its shortest decimal.
+func (m Money) MulRate(rate float64, mode RoundingMode) Money {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(rate, 'f', -1, 64))
+	return Money(mode.round(r.Mul(r, big.NewRat(int64(m), 1))))
+}
+
+// DivRate divides m by a rate given as a float, it is the inverse of MulRate.
+func (m Money) DivRate(rate float64, mode RoundingMode) Money {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(rate, 'f', -1, 64))
+	return Money(mode.round(new(big.Rat).Quo(big.NewRat(int64(m), 1), r)))
+}
+
+func (m Money) String() string {
+	v := int64(m)
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/int64(MajorUnit), v%int64(MajorUnit))
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a number or a string holding a number, and reads it as written instead of as a float.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	if bytes.Equal(b, []byte("null")) {
+		return nil
+	}
+	v, err := ParseMoney(string(bytes.Trim(b, `"`)))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+func (m Money) ToDB() ([]byte, error) {
+	if moneyInMinorUnits {
+		return []byte(strconv.FormatInt(int64(m), 10)), nil
+	}
+	return []byte(m.String()), nil
+}
+
+// FromDB reads integer minor units in SQLite and a decimal elsewhere.
+func (m *Money) FromDB(b []byte) error {
+	if len(b) == 0 {
+		*m = 0
+		return nil
+	}
+	if !moneyInMinorUnits {
+		v, err := ParseMoneyRounded(string(b), RoundingHalfUp)
+		*m = v
+		return err
+	}
+	r, err := parseDecimal(string(b))
+	if err != nil {
+		return err
+	}
+	*m = Money(RoundingHalfUp.round(r))
+	return nil
+}
+
+// dbValue is m as an argument of a raw query.
+func (m Money) dbValue() interface{} {
+	if moneyInMinorUnits {
+		return int64(m)
+	}
+	return m.String()
+}
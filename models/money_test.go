@@ -0,0 +1,67 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestParseMoney(t *testing.T) {
+	for s, expected := range map[string]Money{
+		"198.05": 19805,
+		"198.1":  19810,
+		"198":    19800,
+		"-0.5":   -50,
+		"1e2":    10000,
+	} {
+		m, err := ParseMoney(s)
+		test.Ok(t, err)
+		test.Equals(t, m, expected)
+	}
+
+	for _, s := range []string{"", "abc", "1/3", "0.001"} {
+		_, err := ParseMoney(s)
+		test.Equals(t, errors.Is(err, ErrInvalidMoney), true)
+	}
+}
+
+func TestMoneyRounding(t *testing.T) {
+	for _, c := range []struct {
+		s        string
+		mode     RoundingMode
+		expected Money
+	}{
+		{"0.125", RoundingHalfUp, 13},
+		{"0.125", RoundingHalfEven, 12},
+		{"0.135", RoundingHalfEven, 14},
+		{"0.129", RoundingDown, 12},
+		{"0.121", RoundingUp, 13},
+		{"-0.125", RoundingHalfUp, -13},
+		{"99.9900000001", RoundingHalfUp, 9999},
+	} {
+		m, err := ParseMoneyRounded(c.s, c.mode)
+		test.Ok(t, err)
+		test.Equals(t, m, c.expected)
+	}
+
+	test.Equals(t, (10*MajorUnit).MulRate(7.1, RoundingHalfUp), 71*MajorUnit)
+	test.Equals(t, (1*MajorUnit).DivRate(3, RoundingHalfUp), 33*MinorUnit)
+}
+
+func TestMoneyJSON(t *testing.T) {
+	var v struct {
+		Price Money `json:"price"`
+	}
+	test.Ok(t, json.Unmarshal([]byte(`{"price":0.1}`), &v))
+	test.Equals(t, v.Price, 10*MinorUnit)
+	test.Ok(t, json.Unmarshal([]byte(`{"price":"198.05"}`), &v))
+	test.Equals(t, v.Price, 19805*MinorUnit)
+	test.Equals(t, json.Unmarshal([]byte(`{"price":0.001}`), &v) != nil, true)
+
+	b, err := json.Marshal(v)
+	test.Ok(t, err)
+	test.Equals(t, string(b), `{"price":198.05}`)
+	test.Equals(t, (-5 * MinorUnit).String(), "-0.05")
+}
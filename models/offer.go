@@ -0,0 +1,388 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OfferType is how an offer takes its discount off the sale price.
+type OfferType string
+
+const (
+	// OfferTypePercentageOff takes PercentOff percent off the sale price.
+	OfferTypePercentageOff OfferType = "percentage_off"
+	// OfferTypeAmountOff takes AmountOff off the sale price.
+	OfferTypeAmountOff OfferType = "amount_off"
+	// OfferTypeBuyNPrice sells Quantity items for BundlePrice.
+	OfferTypeBuyNPrice OfferType = "buy_n_price"
+)
+
+// withOfferContext asks for the offers which apply to the products read, it holds a bool.
+const withOfferContext contextKey = "WithOffer"
+
+// WithOffers asks for the offers which apply to the products read with ctx.
+func WithOffers(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withOfferContext, true)
+}
+
+var (
+	ErrInvalidOfferType     = errors.New("type must be one of percentage_off, amount_off, buy_n_price")
+	ErrInvalidOfferPeriod   = errors.New("an offer needs startAt and endAt after startAt")
+	ErrInvalidOfferDiscount = errors.New("invalid discount of offer")
+	ErrInvalidOfferTarget   = errors.New("invalid target of offer")
+	ErrOfferCodeExists      = errors.New("offer code already exists")
+	ErrOfferNotFound        = errors.New("offer not found")
+)
+
+// Offer is a promotion which runs from StartAt until EndAt.
+// It targets the products matching all of BrandCodes, ProductCodes, Attributes and Filter, and all products when none is given.
+// It applies in StoreIds, or in every store when StoreIds is empty.
+// AmountOff and BundlePrice are in Currency, and the offer only applies to prices in the same currency.
+type Offer struct {
+	Id           int64               `json:"id"`
+	TenantCode   string              `json:"-" xorm:"index varchar(16)"`
+	Code         string              `json:"code" xorm:"index varchar(64)"`
+	Name         string              `json:"name"`
+	Type         OfferType           `json:"type" xorm:"varchar(16)"`
+	PercentOff   int                 `json:"percentOff,omitempty"`
+	AmountOff    Money               `json:"amountOff,omitempty" xorm:"decimal(18,2)"`
+	Quantity     int                 `json:"quantity,omitempty"`
+	BundlePrice  Money               `json:"bundlePrice,omitempty" xorm:"decimal(18,2)"`
+	Currency     string              `json:"currency" xorm:"varchar(3)"`
+	BrandCodes   []string            `json:"brandCodes,omitempty" xorm:"json"`
+	ProductCodes []string            `json:"productCodes,omitempty" xorm:"json"`
+	Attributes   map[string][]string `json:"attributes,omitempty" xorm:"json"`
+	Filter       Filter              `json:"filter,omitempty" xorm:"json"`
+	StoreIds     []int64             `json:"storeIds,omitempty" xorm:"json"`
+	StartAt      time.Time           `json:"startAt" xorm:"index"`
+	EndAt        time.Time           `json:"endAt" xorm:"index"`
+	Enable       bool                `json:"enable" xorm:"index"`
+	CreatedAt    time.Time           `json:"createdAt" xorm:"created"`
+	UpdatedAt    time.Time           `json:"updatedAt" xorm:"updated"`
+	DeletedAt    time.Time           `json:"-" xorm:"deleted index"`
+	Version      int                 `json:"version" xorm:"version"`
+}
+
+// AppliedOffer is an offer which applies to a product or a sku, Price is the price of one item under the offer.
+type AppliedOffer struct {
+	OfferId  int64     `json:"offerId"`
+	Code     string    `json:"code"`
+	Name     string    `json:"name"`
+	Type     OfferType `json:"type"`
+	Quantity int       `json:"quantity"`
+	Price    Money     `json:"price"`
+	EndAt    time.Time `json:"endAt"`
+}
+
+func (o *Offer) validate(ctx context.Context) error {
+	o.Code = strings.TrimSpace(o.Code)
+	switch o.Type {
+	case OfferTypePercentageOff:
+		if o.PercentOff <= 0 || o.PercentOff >= 100 {
+			return fmt.Errorf("%w: percentOff must be between 1 and 99", ErrInvalidOfferDiscount)
+		}
+		o.AmountOff, o.Quantity, o.BundlePrice = 0, 0, 0
+	case OfferTypeAmountOff:
+		if o.AmountOff <= 0 {
+			return fmt.Errorf("%w: amountOff must be positive", ErrInvalidOfferDiscount)
+		}
+		o.PercentOff, o.Quantity, o.BundlePrice = 0, 0, 0
+	case OfferTypeBuyNPrice:
+		if o.Quantity < 2 || o.BundlePrice <= 0 {
+			return fmt.Errorf("%w: quantity must be at least 2 and bundlePrice positive", ErrInvalidOfferDiscount)
+		}
+		o.PercentOff, o.AmountOff = 0, 0
+	default:
+		return ErrInvalidOfferType
+	}
+	if o.StartAt.IsZero() || !o.EndAt.After(o.StartAt) {
+		return ErrInvalidOfferPeriod
+	}
+	for k, v := range o.Filter {
+		if !IsValidConditionType(k) || len(v.Values) == 0 {
+			return fmt.Errorf("%w: condition %s", ErrInvalidOfferTarget, k)
+		}
+	}
+	if _, ok := o.Filter[ConditionTypeBrandCode]; ok && len(o.BrandCodes) != 0 {
+		return fmt.Errorf("%w: brandCodes and a brand_code condition", ErrInvalidOfferTarget)
+	}
+	if _, ok := o.Filter[ConditionTypeProductCode]; ok && len(o.ProductCodes) != 0 {
+		return fmt.Errorf("%w: productCodes and a product_code condition", ErrInvalidOfferTarget)
+	}
+	if o.Currency == "" {
+		currency, err := TenantCurrency{}.Get(ctx)
+		if err != nil {
+			return err
+		}
+		o.Currency = currency
+	} else {
+		currency, err := NormalizeCurrency(o.Currency)
+		if err != nil {
+			return err
+		}
+		o.Currency = currency
+	}
+	db, err := tenantDB(ctx, "offer")
+	if err != nil {
+		return err
+	}
+	exist, err := db.
+		And("code = ?", o.Code).
+		And("id <> ?", o.Id).
+		Exist(&Offer{})
+	if err != nil {
+		return err
+	}
+	if exist {
+		return ErrOfferCodeExists
+	}
+	return nil
+}
+
+func (o *Offer) Create(ctx context.Context) error {
+	if err := o.validate(ctx); err != nil {
+		return err
+	}
+	return tenantInsert(ctx, o)
+}
+
+func (o *Offer) Update(ctx context.Context) error {
+	current, err := Offer{}.Get(ctx, o.Id)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrOfferNotFound
+	}
+	if err := o.validate(ctx); err != nil {
+		return err
+	}
+	o.TenantCode, o.CreatedAt = current.TenantCode, current.CreatedAt
+	db, err := tenantDB(ctx, "offer")
+	if err != nil {
+		return err
+	}
+	affected, err := db.ID(o.Id).
+		Cols("code", "name", "type", "percent_off", "amount_off", "quantity", "bundle_price", "currency",
+			"brand_codes", "product_codes", "attributes", "filter", "store_ids", "start_at", "end_at", "enable").
+		Update(o)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (Offer) Get(ctx context.Context, id int64) (*Offer, error) {
+	var o Offer
+	db, err := tenantDB(ctx, "offer")
+	if err != nil {
+		return nil, err
+	}
+	exist, err := db.
+		And("id = ?", id).
+		Get(&o)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &o, nil
+}
+
+// GetAll returns the offers, only those running at the time given when it is not zero.
+func (Offer) GetAll(ctx context.Context, at time.Time, skipCount, maxResultCount int) (int64, []Offer, error) {
+	db, err := tenantDB(ctx, "offer")
+	if err != nil {
+		return 0, nil, err
+	}
+	query := db.session()
+	if !at.IsZero() {
+		query.And("start_at <= ?", at).And("end_at > ?", at)
+	}
+	var offers []Offer
+	totalCount, err := query.Desc("id").Limit(maxResultCount, skipCount).FindAndCount(&offers)
+	if err != nil {
+		return 0, nil, err
+	}
+	return totalCount, offers, nil
+}
+
+func (o Offer) appliesIn(storeId int64) bool {
+	if len(o.StoreIds) == 0 {
+		return true
+	}
+	for _, id := range o.StoreIds {
+		if id == storeId {
+			return true
+		}
+	}
+	return false
+}
+
+// filter is the Filter of o with its brand codes and product codes.
+func (o Offer) filter() Filter {
+	filter := make(Filter, len(o.Filter)+2)
+	for k, v := range o.Filter {
+		filter[k] = v
+	}
+	if len(o.BrandCodes) != 0 {
+		filter[ConditionTypeBrandCode] = FilterItem{Comparer: ComparerTypeInclude, Values: o.BrandCodes}
+	}
+	if len(o.ProductCodes) != 0 {
+		filter[ConditionTypeProductCode] = FilterItem{Comparer: ComparerTypeInclude, Values: o.ProductCodes}
+	}
+	return filter
+}
+
+// matchProducts returns which of the products are targeted by o.
+func (o Offer) matchProducts(ctx context.Context, productIds []interface{}) (map[int64]bool, error) {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
+	query := db.Table("product").Cols("product.id").In("product.id", productIds...)
+	filterQuery(query, o.filter())
+	for name, values := range o.Attributes {
+		if len(values) == 0 {
+			continue
+		}
+		query.And(fmt.Sprintf(`product.id IN (SELECT av.product_id FROM attribute AS a JOIN attribute_value AS av ON a.id = av.attribute_id WHERE a.name = ? AND av.value IN (%s))`, placeholder(len(values))),
+			appendStrArgs([]interface{}{name}, false, values...)...)
+	}
+	var ids []int64
+	if err := query.Find(&ids); err != nil {
+		return nil, err
+	}
+	matched := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		matched[id] = true
+	}
+	return matched, nil
+}
+
+// apply computes the price of one item bought under o, false when o does not lower price.
+func (o Offer) apply(price Price, rounding RoundingMode) (AppliedOffer, bool) {
+	if price.Currency != o.Currency {
+		return AppliedOffer{}, false
+	}
+	applied := AppliedOffer{OfferId: o.Id, Code: o.Code, Name: o.Name, Type: o.Type, Quantity: 1, EndAt: o.EndAt}
+	switch o.Type {
+	case OfferTypePercentageOff:
+		applied.Price = price.SalePrice.MulRate(float64(100-o.PercentOff)/100, rounding)
+	case OfferTypeAmountOff:
+		applied.Price = price.SalePrice - o.AmountOff
+		if applied.Price < 0 {
+			applied.Price = 0
+		}
+	case OfferTypeBuyNPrice:
+		applied.Quantity = o.Quantity
+		applied.Price = o.BundlePrice.DivRate(float64(o.Quantity), rounding)
+	}
+	return applied, applied.Price < price.SalePrice
+}
+
+// offerMatcher applies the offers running in the store of ctx to the products they target.
+type offerMatcher struct {
+	offers   []Offer
+	matched  []map[int64]bool
+	rounding RoundingMode
+}
+
+// loadOfferMatcher returns nil unless offers are asked for by ctx.
+func loadOfferMatcher(ctx context.Context, productIds []interface{}) (*offerMatcher, error) {
+	if withOffer, _ := ctx.Value(withOfferContext).(bool); !withOffer || len(productIds) == 0 {
+		return nil, nil
+	}
+	asOf := retrievePriceAsOf(ctx)
+	var offers []Offer
+	db, err := tenantDB(ctx, "offer")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.
+		And("enable = ?", true).
+		And("start_at <= ?", asOf).
+		And("end_at > ?", asOf).
+		Asc("id").
+		Find(&offers); err != nil {
+		return nil, err
+	}
+	rounding, err := TenantCurrency{}.GetRounding(ctx)
+	if err != nil {
+		return nil, err
+	}
+	scope, _ := retrievePriceScope(ctx)
+	m := offerMatcher{rounding: rounding}
+	for _, o := range offers {
+		if !o.appliesIn(scope.StoreId) {
+			continue
+		}
+		matched, err := o.matchProducts(ctx, productIds)
+		if err != nil {
+			return nil, err
+		}
+		m.offers = append(m.offers, o)
+		m.matched = append(m.matched, matched)
+	}
+	return &m, nil
+}
+
+// apply returns the offers which lower price for the product, and the lowest price of one item bought alone.
+func (m offerMatcher) apply(productId int64, price Price) ([]AppliedOffer, *Money) {
+	var (
+		applied    []AppliedOffer
+		offerPrice *Money
+	)
+	for i, o := range m.offers {
+		if !m.matched[i][productId] {
+			continue
+		}
+		a, ok := o.apply(price, m.rounding)
+		if !ok {
+			continue
+		}
+		applied = append(applied, a)
+		if a.Quantity == 1 && (offerPrice == nil || a.Price < *offerPrice) {
+			p := a.Price
+			offerPrice = &p
+		}
+	}
+	return applied, offerPrice
+}
+
+// loadOffers applies the offers to the products whose prices are loaded.
+func (products ProductList) loadOffers(ctx context.Context) error {
+	m, err := loadOfferMatcher(ctx, products.Ids())
+	if err != nil || m == nil {
+		return err
+	}
+	for i := range products {
+		if len(products[i].Prices) == 0 {
+			continue
+		}
+		products[i].Offers, products[i].OfferPrice = m.apply(products[i].Id, products[i].Prices[0])
+	}
+	return nil
+}
+
+// loadOffers applies the offers to the skus whose prices are loaded, an offer targets a sku through its product.
+func (skus SkuList) loadOffers(ctx context.Context) error {
+	m, err := loadOfferMatcher(ctx, skus.ProductIds())
+	if err != nil || m == nil {
+		return err
+	}
+	for i := range skus {
+		if skus[i].Price == nil {
+			continue
+		}
+		skus[i].Offers, skus[i].OfferPrice = m.apply(skus[i].ProductId, *skus[i].Price)
+	}
+	return nil
+}
@@ -2,15 +2,19 @@ package models
 
 import (
 	"context"
+	"time"
+
 	"github.com/hublabs/product-api/factory"
 )
 
 type Option struct {
-	Id    int64  `json:"id"`
-	SkuId int64  `json:"skuId" xorm:"index"`
-	Code  string `json:"-"`
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	Id          int64     `json:"id"`
+	SkuId       int64     `json:"skuId" xorm:"index"`
+	Code        string    `json:"-"`
+	Name        string    `json:"name"`
+	Value       string    `json:"value"`
+	DeletedAt   time.Time `json:"-" xorm:"deleted index"`
+	DeleteBatch string    `json:"-" xorm:"index varchar(32)"`
 }
 
 // Must be private because of event SkuAdded、SkuChanged
@@ -25,14 +29,22 @@ func (o *Option) update(ctx context.Context) (err error) {
 		"name", "code", "value",
 	}
 
-	if _, err = factory.DB(ctx).ID(o.Id).Cols(cols...).Update(o); err != nil {
+	db, err := tenantChildDB(ctx, "option", "sku_id", "sku")
+	if err != nil {
+		return err
+	}
+	if _, err = db.ID(o.Id).Cols(cols...).Update(o); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (o Option) UpdateBySkuId(ctx context.Context) error {
-	if _, err := factory.DB(ctx).Cols("value").Where("name = ?", o.Name).And("sku_id = ?", o.SkuId).Update(&o); err != nil {
+	db, err := tenantChildDB(ctx, "option", "sku_id", "sku")
+	if err != nil {
+		return err
+	}
+	if _, err := db.Cols("value").Where("name = ?", o.Name).And("sku_id = ?", o.SkuId).Update(&o); err != nil {
 		return err
 	}
 	return nil
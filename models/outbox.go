@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hublabs/product-api/adapters"
+	"github.com/hublabs/product-api/factory"
+)
+
+// OutboxEvent is a domain event waiting to be shipped to the event broker.
+// It is written in the same session as the entity, so the event exists if and only if the write is committed.
+// It keeps the tenant and the colleague of the write rather than the token of the request,
+// and a relay holds the events it ships under a lease, so that a relay running beside it does not ship them again.
+// An event failing to ship is retried with backoff until it is delivered, it is never given up.
+type OutboxEvent struct {
+	Id          int64     `json:"id"`
+	TenantCode  string    `json:"tenantCode" xorm:"index varchar(16)"`
+	ColleagueId int64     `json:"colleagueId"`
+	Status      string    `json:"status" xorm:"index varchar(32)"`
+	RequestId   string    `json:"requestId" xorm:"varchar(64)"`
+	Payload     string    `json:"payload" xorm:"mediumtext"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError" xorm:"text"`
+	RetryAt     int64     `json:"retryAt" xorm:"index"`
+	DeliveredAt time.Time `json:"deliveredAt" xorm:"index"`
+	LeaseOwner  string    `json:"-" xorm:"varchar(32)"`
+	LeaseUntil  int64     `json:"-" xorm:"index"`
+	CreatedAt   time.Time `json:"createdAt" xorm:"created"`
+	UpdatedAt   time.Time `json:"updatedAt" xorm:"updated"`
+}
+
+// outboxLease is how long a relay holds the events it claimed, after which another one may ship them if it crashed.
+const outboxLease = time.Minute
+
+func publishEvent(ctx context.Context, payload adapters.Payload, status string) error {
+	m, err := adapters.NewEventMessage(ctx, payload, status)
+	if err != nil {
+		return err
+	}
+	e := OutboxEvent{
+		TenantCode:  m.TenantCode,
+		ColleagueId: m.ColleagueId,
+		Status:      m.Status,
+		RequestId:   m.RequestId,
+		Payload:     string(m.Payload),
+		CreatedAt:   m.CreatedAt,
+	}
+	_, err = factory.DB(ctx).Insert(&e)
+	return err
+}
+
+func (e OutboxEvent) ToMessage() adapters.EventMessage {
+	return adapters.EventMessage{
+		TenantCode:  e.TenantCode,
+		ColleagueId: e.ColleagueId,
+		RequestId:   e.RequestId,
+		Status:      e.Status,
+		Payload:     json.RawMessage(e.Payload),
+		CreatedAt:   e.CreatedAt.UTC(),
+	}
+}
+
+// GetPending returns the events not delivered yet in insertion order, those failed as many times as they may included.
+func (OutboxEvent) GetPending(ctx context.Context, limit int) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	if err := factory.DB(ctx).
+		Where(excludeDelivered()).
+		Asc("id").
+		Limit(limit).
+		Find(&events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// claim leases the pending events to owner in order, up to the first one another relay holds
+// or which waits to be retried, so that the events after it are not shipped before it.
+func (OutboxEvent) claim(ctx context.Context, owner string, limit int) ([]OutboxEvent, error) {
+	events, err := OutboxEvent{}.GetPending(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range events {
+		if events[i].RetryAt > now.Unix() {
+			return events[:i], nil
+		}
+		events[i].LeaseOwner, events[i].LeaseUntil = owner, now.Add(outboxLease).Unix()
+		// the event may have been delivered by another relay since it was read
+		affected, err := factory.DB(ctx).ID(events[i].Id).
+			And(excludeDelivered()).
+			And("lease_until IS NULL OR lease_until < ?", now.Unix()).
+			Cols("lease_owner", "lease_until").
+			Update(&events[i])
+		if err != nil {
+			return nil, err
+		}
+		if affected == 0 {
+			return events[:i], nil
+		}
+	}
+	return events, nil
+}
+
+// release gives up the events of owner which were not delivered, so that they are retried on the next call.
+func (OutboxEvent) release(ctx context.Context, owner string) error {
+	_, err := factory.DB(ctx).
+		Where("lease_owner = ?", owner).
+		And(excludeDelivered()).
+		Cols("lease_owner", "lease_until").
+		Update(&OutboxEvent{})
+	return err
+}
+
+func (e *OutboxEvent) markDelivered(ctx context.Context) (err error) {
+	e.DeliveredAt = time.Now().UTC()
+	_, err = factory.DB(ctx).ID(e.Id).Cols("delivered_at").Update(e)
+	return
+}
+
+func (e *OutboxEvent) markFailed(ctx context.Context, sendErr error, maxBackoff time.Duration) (err error) {
+	e.Attempts++
+	e.LastError = sendErr.Error()
+	e.RetryAt = time.Now().Add(outboxBackoff(e.Attempts, maxBackoff)).Unix()
+	_, err = factory.DB(ctx).ID(e.Id).Cols("attempts", "last_error", "retry_at").Update(e)
+	return
+}
+
+// outboxBackoff is how long an event which failed attempts times waits to be retried,
+// a second doubled on each failure up to maxBackoff.
+func outboxBackoff(attempts int, maxBackoff time.Duration) time.Duration {
+	if attempts > 30 {
+		return maxBackoff
+	}
+	if backoff := time.Second << uint(attempts-1); backoff < maxBackoff {
+		return backoff
+	}
+	return maxBackoff
+}
+
+// Relay ships pending events in insertion order and returns how many were delivered.
+// It stops at the first failure so that consumers never receive events out of order;
+// the failed event is retried on a later call once its backoff, at most maxBackoff, has passed,
+// and the events after it wait for it. Events claimed by another relay are left to it, see claim.
+func (OutboxEvent) Relay(ctx context.Context, send func(adapters.EventMessage) error, maxBackoff time.Duration, batchSize int) (delivered int, err error) {
+	owner, err := randomId()
+	if err != nil {
+		return 0, err
+	}
+	events, err := OutboxEvent{}.claim(ctx, owner, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if releaseErr := (OutboxEvent{}).release(ctx, owner); err == nil {
+			err = releaseErr
+		}
+	}()
+	for i := range events {
+		if sendErr := send(events[i].ToMessage()); sendErr != nil {
+			if err := events[i].markFailed(ctx, sendErr, maxBackoff); err != nil {
+				return delivered, err
+			}
+			return delivered, fmt.Errorf("event %d failed %d times: %w", events[i].Id, events[i].Attempts, sendErr)
+		}
+		if err := events[i].markDelivered(ctx); err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+	return delivered, nil
+}
+
+func excludeDelivered() string {
+	return "delivered_at IS NULL OR delivered_at = '0001-01-01 00:00:00'"
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hublabs/product-api/adapters"
+	"github.com/hublabs/product-api/factory"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	test.Equals(t, outboxBackoff(1, time.Minute), time.Second)
+	test.Equals(t, outboxBackoff(3, time.Minute), 4*time.Second)
+	test.Equals(t, outboxBackoff(7, time.Minute), time.Minute)
+	test.Equals(t, outboxBackoff(100, time.Minute), time.Minute)
+}
+
+func TestOutboxRelay(t *testing.T) {
+	p := Price{TargetType: PriceTargetTypeProduct, TargetId: "9001", SalePrice: 10 * MajorUnit}
+	test.Ok(t, p.Create(ctx))
+	p = Price{TargetType: PriceTargetTypeProduct, TargetId: "9001", SalePrice: 20 * MajorUnit}
+	test.Ok(t, p.Create(ctx))
+
+	t.Run("StopOnFailure", func(t *testing.T) {
+		n, err := OutboxEvent{}.Relay(ctx, func(adapters.EventMessage) error {
+			return errors.New("broker down")
+		}, 0, 10)
+		test.Equals(t, err != nil, true)
+		test.Equals(t, n, 0)
+
+		events, err := OutboxEvent{}.GetPending(ctx, 10)
+		test.Ok(t, err)
+		test.Equals(t, len(events), 2)
+		test.Equals(t, events[0].Attempts, 1)
+		test.Equals(t, events[0].LastError, "broker down")
+		test.Equals(t, events[1].Attempts, 0)
+	})
+
+	t.Run("Backoff", func(t *testing.T) {
+		_, err := OutboxEvent{}.Relay(ctx, func(adapters.EventMessage) error {
+			return errors.New("broker down")
+		}, time.Minute, 10)
+		test.Equals(t, err != nil, true)
+
+		// the event waits to be retried, and the one after it waits for it
+		n, err := OutboxEvent{}.Relay(ctx, func(adapters.EventMessage) error {
+			t.Fatal("sent an event before its retry")
+			return nil
+		}, time.Minute, 10)
+		test.Ok(t, err)
+		test.Equals(t, n, 0)
+
+		// it is kept however many times it failed
+		events, err := OutboxEvent{}.GetPending(ctx, 10)
+		test.Ok(t, err)
+		test.Equals(t, len(events), 2)
+		test.Equals(t, events[0].Attempts, 2)
+		test.Equals(t, events[0].RetryAt > time.Now().Unix(), true)
+
+		// the backoff has passed
+		events[0].RetryAt = 0
+		_, err = factory.DB(ctx).ID(events[0].Id).Cols("retry_at").Update(&events[0])
+		test.Ok(t, err)
+	})
+
+	t.Run("ClaimedByOther", func(t *testing.T) {
+		claimed, err := OutboxEvent{}.claim(ctx, "other", 1)
+		test.Ok(t, err)
+		test.Equals(t, len(claimed), 1)
+
+		// the events after the one another relay holds wait for it, so that they are not shipped out of order
+		n, err := OutboxEvent{}.Relay(ctx, func(adapters.EventMessage) error {
+			t.Fatal("sent an event claimed by another relay")
+			return nil
+		}, 0, 10)
+		test.Ok(t, err)
+		test.Equals(t, n, 0)
+		test.Ok(t, OutboxEvent{}.release(ctx, "other"))
+	})
+
+	t.Run("Deliver", func(t *testing.T) {
+		var sent []adapters.EventMessage
+		n, err := OutboxEvent{}.Relay(ctx, func(m adapters.EventMessage) error {
+			sent = append(sent, m)
+			return nil
+		}, 0, 10)
+		test.Ok(t, err)
+		test.Equals(t, n, 2)
+		test.Equals(t, sent[0].Status, adapters.EventProductPriceChanged)
+		test.Equals(t, sent[0].TenantCode, "test")
+		test.Equals(t, sent[0].AuthToken, "")
+
+		events, err := OutboxEvent{}.GetPending(ctx, 10)
+		test.Ok(t, err)
+		test.Equals(t, len(events), 0)
+	})
+}
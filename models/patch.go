@@ -0,0 +1,292 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type PatchType string
+
+const (
+	PatchTypeMergePatch PatchType = "application/merge-patch+json" // RFC 7396
+	PatchTypeJSONPatch  PatchType = "application/json-patch+json"  // RFC 6902
+)
+
+var ErrInvalidPatch = errors.New("invalid patch")
+
+func applyPatch(doc []byte, patchType PatchType, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch patchType {
+	case PatchTypeJSONPatch:
+		result, err = jsonPatch(target, p)
+	default:
+		result = mergePatch(target, p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(result)
+}
+
+// mergePatch implements https://tools.ietf.org/html/rfc7396#section-2
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+type jsonPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from"`
+	Value interface{} `json:"value"`
+}
+
+// jsonPatch implements https://tools.ietf.org/html/rfc6902#section-4
+func jsonPatch(doc, patch interface{}) (interface{}, error) {
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	var operations []jsonPatchOperation
+	if err := json.Unmarshal(b, &operations); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	for _, o := range operations {
+		var err error
+		switch o.Op {
+		case "add":
+			doc, err = jsonPointerAdd(doc, o.Path, o.Value)
+		case "remove":
+			doc, _, err = jsonPointerRemove(doc, o.Path)
+		case "replace":
+			if doc, _, err = jsonPointerRemove(doc, o.Path); err == nil {
+				doc, err = jsonPointerAdd(doc, o.Path, o.Value)
+			}
+		case "move":
+			var v interface{}
+			if doc, v, err = jsonPointerRemove(doc, o.From); err == nil {
+				doc, err = jsonPointerAdd(doc, o.Path, v)
+			}
+		case "copy":
+			var v interface{}
+			if v, err = jsonPointerGet(doc, o.From); err == nil {
+				doc, err = jsonPointerAdd(doc, o.Path, deepCopy(v))
+			}
+		case "test":
+			var v interface{}
+			if v, err = jsonPointerGet(doc, o.Path); err == nil && !reflect.DeepEqual(v, o.Value) {
+				err = fmt.Errorf("%w: test failed at %s", ErrInvalidPatch, o.Path)
+			}
+		default:
+			err = fmt.Errorf("%w: unknown op %q", ErrInvalidPatch, o.Op)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: invalid path %q", ErrInvalidPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(tokens[i], "~1", "/", -1), "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > length || (i == length && !allowEnd) {
+		return 0, fmt.Errorf("%w: invalid array index %q", ErrInvalidPatch, token)
+	}
+	return i, nil
+}
+
+func jsonPointerGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := parseJSONPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	for _, token := range tokens {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if doc, ok = v[token]; !ok {
+				return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, pointer)
+			}
+		case []interface{}:
+			i, err := arrayIndex(token, len(v), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = v[i]
+		default:
+			return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, pointer)
+		}
+	}
+	return doc, nil
+}
+
+// jsonPointerUpdate walks to the parent of pointer and replaces it with the result of f.
+func jsonPointerUpdate(doc interface{}, tokens []string, f func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return f(doc, tokens[0])
+	}
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		child, ok := v[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, tokens[0])
+		}
+		child, err := jsonPointerUpdate(child, tokens[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		v[tokens[0]] = child
+		return v, nil
+	case []interface{}:
+		i, err := arrayIndex(tokens[0], len(v), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := jsonPointerUpdate(v[i], tokens[1:], f)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = child
+		return v, nil
+	}
+	return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, tokens[0])
+}
+
+func jsonPointerAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := parseJSONPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return jsonPointerUpdate(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			v[token] = value
+			return v, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(v), true)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, nil)
+			copy(v[i+1:], v[i:])
+			v[i] = value
+			return v, nil
+		}
+		return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, pointer)
+	})
+}
+
+func jsonPointerRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	tokens, err := parseJSONPointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, doc, nil
+	}
+	var removed interface{}
+	doc, err = jsonPointerUpdate(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch v := parent.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if removed, ok = v[token]; !ok {
+				return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, pointer)
+			}
+			delete(v, token)
+			return v, nil
+		case []interface{}:
+			i, err := arrayIndex(token, len(v), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = v[i]
+			return append(v[:i], v[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("%w: path %q not found", ErrInvalidPatch, pointer)
+	})
+	return doc, removed, err
+}
+
+func deepCopy(v interface{}) interface{} {
+	b, _ := json.Marshal(v)
+	var c interface{}
+	json.Unmarshal(b, &c)
+	return c
+}
+
+// changedFields lists the top level keys whose values differ between two json objects.
+func changedFields(before, after []byte) ([]string, error) {
+	var b, a map[string]interface{}
+	if err := json.Unmarshal(before, &b); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(after, &a); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	var fields []string
+	for k, v := range a {
+		if !reflect.DeepEqual(b[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
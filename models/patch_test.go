@@ -0,0 +1,63 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestApplyPatch(t *testing.T) {
+	doc := []byte(`{"name":"a","enable":true,"attributes":{"Year":"2018","Color":"red"},"skus":[{"name":"s1"},{"name":"s2"}]}`)
+
+	t.Run("MergePatch", func(t *testing.T) {
+		patched, err := applyPatch(doc, PatchTypeMergePatch, []byte(`{"name":"b","attributes":{"Color":null,"Size":"M"}}`))
+		test.Ok(t, err)
+		var v map[string]interface{}
+		test.Ok(t, json.Unmarshal(patched, &v))
+		test.Equals(t, v["name"], "b")
+		test.Equals(t, v["attributes"], map[string]interface{}{"Year": "2018", "Size": "M"})
+
+		fields, err := changedFields(doc, patched)
+		test.Ok(t, err)
+		test.Equals(t, fields, []string{"attributes", "name"})
+	})
+
+	t.Run("JSONPatch", func(t *testing.T) {
+		patched, err := applyPatch(doc, PatchTypeJSONPatch, []byte(`[
+			{"op":"test","path":"/name","value":"a"},
+			{"op":"replace","path":"/enable","value":false},
+			{"op":"add","path":"/skus/-","value":{"name":"s3"}},
+			{"op":"remove","path":"/skus/0"},
+			{"op":"copy","from":"/attributes/Year","path":"/attributes/Season"},
+			{"op":"move","from":"/attributes/Color","path":"/attributes/Colour"}
+		]`))
+		test.Ok(t, err)
+		var v map[string]interface{}
+		test.Ok(t, json.Unmarshal(patched, &v))
+		test.Equals(t, v["enable"], false)
+		test.Equals(t, v["skus"], []interface{}{
+			map[string]interface{}{"name": "s2"},
+			map[string]interface{}{"name": "s3"},
+		})
+		test.Equals(t, v["attributes"], map[string]interface{}{"Year": "2018", "Season": "2018", "Colour": "red"})
+
+		fields, err := changedFields(doc, patched)
+		test.Ok(t, err)
+		test.Equals(t, fields, []string{"attributes", "enable", "skus"})
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, p := range []string{
+			`[{"op":"test","path":"/name","value":"b"}]`,
+			`[{"op":"remove","path":"/unknown"}]`,
+			`[{"op":"add","path":"/skus/5","value":{}}]`,
+			`[{"op":"unknown","path":"/name"}]`,
+			`{"op":"replace"}`,
+		} {
+			_, err := applyPatch(doc, PatchTypeJSONPatch, []byte(p))
+			test.Equals(t, errors.Is(err, ErrInvalidPatch), true)
+		}
+	})
+}
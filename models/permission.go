@@ -0,0 +1,69 @@
+package models
+
+import (
+	"context"
+)
+
+// Permission is what a caller may do, each route needs one.
+type Permission string
+
+const (
+	PermissionCatalogRead  Permission = "catalog:read"
+	PermissionCatalogWrite Permission = "catalog:write"
+	PermissionPriceWrite   Permission = "price:write"
+	PermissionImportRun    Permission = "import:run"
+	PermissionApiKeyManage Permission = "api-key:manage"
+)
+
+// permissionsContext holds the permissions of the caller, which are read from the permissions claim of its token
+// and granted by its roles.
+const permissionsContext contextKey = "Permissions"
+
+// defaultPermissions are granted to every caller of a tenant.
+var defaultPermissions = []Permission{PermissionCatalogRead}
+
+// rolePermissions are the permissions granted by the roles.
+var rolePermissions = map[string][]Permission{
+	RoleCatalogEditor: {PermissionCatalogWrite},
+	RolePriceEditor:   {PermissionPriceWrite},
+	RolePriceApprover: {PermissionPriceWrite},
+	RoleImporter:      {PermissionImportRun},
+	RoleAdmin:         {PermissionCatalogWrite, PermissionPriceWrite, PermissionImportRun, PermissionApiKeyManage},
+}
+
+// GrantPermissions returns the permissions of a caller with the roles and permissions claimed by its token.
+func GrantPermissions(roles, permissions []string) []Permission {
+	granted := append([]Permission{}, defaultPermissions...)
+	for _, role := range roles {
+		granted = append(granted, rolePermissions[role]...)
+	}
+	for _, p := range permissions {
+		granted = append(granted, Permission(p))
+	}
+	return granted
+}
+
+// WithPermissions grants the caller of ctx permissions.
+func WithPermissions(ctx context.Context, permissions []Permission) context.Context {
+	return context.WithValue(ctx, permissionsContext, permissions)
+}
+
+// PermissionsGranted tells whether the permissions of the caller of ctx were granted already.
+func PermissionsGranted(ctx context.Context) bool {
+	_, ok := ctx.Value(permissionsContext).([]Permission)
+	return ok
+}
+
+// HasPermission tells whether the caller has permission p.
+func HasPermission(ctx context.Context, p Permission) bool {
+	granted, _ := ctx.Value(permissionsContext).([]Permission)
+	if granted == nil {
+		granted = defaultPermissions
+	}
+	for _, g := range granted {
+		if g == p {
+			return true
+		}
+	}
+	return false
+}
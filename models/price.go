@@ -2,48 +2,403 @@ package models
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/hublabs/common/auth"
 	"github.com/hublabs/product-api/adapters"
 	"github.com/hublabs/product-api/factory"
 )
 
 const (
 	PriceTargetTypeProduct = "product"
+	PriceTargetTypeSku     = "sku"
 	PriceTargetTypeBarcode = "barcode"
 )
 
+// priceAsOfContext carries the time at which prices are resolved, which is now by default.
+const priceAsOfContext contextKey = "PriceAsOf"
+
+// WithPriceAsOf resolves the prices read with ctx as of t.
+func WithPriceAsOf(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, priceAsOfContext, t)
+}
+
 type PriceTargetType string
 
+// Price is in effect from StartAt until EndAt, and forever when EndAt is nil.
+// ActivatedAt and ExpiredAt record when ProductPriceChanged was emitted for its start and its end.
+// Converted is the sale price in the currency asked for by the reader.
+// A price of a price list applies only where the list does, PriceListId is 0 for the regular price.
+// A tier price applies from MinQuantity items on, and only to customers of CustomerGroup when it is given.
+// PendingChangeId is the change a price needing approval is held as, the price is entered once it is approved.
 type Price struct {
-	Id         int64           `json:"id"`
-	TenantCode string          `json:"-" xorm:"index varchar(16)"`
-	TargetType PriceTargetType `json:"targetType" xorm:"index"`
-	TargetId   string          `json:"targetId" xorm:"index"`
-	SalePrice  float64         `json:"salePrice"`
-	CreatedAt  time.Time       `json:"createdAt" xorm:"created"`
-	UpdatedAt  time.Time       `json:"updatedAt" xorm:"updated"`
+	Id              int64            `json:"id"`
+	TenantCode      string           `json:"-" xorm:"index varchar(16)"`
+	TargetType      PriceTargetType  `json:"targetType" xorm:"index"`
+	TargetId        string           `json:"targetId" xorm:"index"`
+	PriceListId     int64            `json:"priceListId,omitempty" xorm:"index"`
+	MinQuantity     int              `json:"minQuantity,omitempty"`
+	CustomerGroup   string           `json:"customerGroup,omitempty" xorm:"index varchar(32)"`
+	SalePrice       Money            `json:"salePrice" xorm:"decimal(18,2)"`
+	Currency        string           `json:"currency" xorm:"varchar(3)"`
+	Converted       *ConvertedAmount `json:"converted,omitempty" xorm:"-"`
+	PendingChangeId int64            `json:"pendingChangeId,omitempty" xorm:"-"`
+	StartAt         time.Time        `json:"startAt" xorm:"index"`
+	EndAt           *time.Time       `json:"endAt,omitempty" xorm:"index"`
+	ActivatedAt     time.Time        `json:"-" xorm:"index"`
+	ExpiredAt       time.Time        `json:"-" xorm:"index"`
+	CreatedAt       time.Time        `json:"createdAt" xorm:"created"`
+	UpdatedAt       time.Time        `json:"updatedAt" xorm:"updated"`
+	DeletedAt       time.Time        `json:"-" xorm:"deleted index"`
+	DeleteBatch     string           `json:"-" xorm:"index varchar(32)"`
 }
 
+var (
+	ErrInvalidPriceSchedule = errors.New("endAt must be after startAt")
+	ErrInvalidPriceTier     = errors.New("minQuantity must not be negative")
+)
+
 type PriceSkuInfo struct {
 	SkuId     int64      `json:"skuId" xorm:"-"`
 	TargetId  string     `json:"targetId"`
-	SalePrice float64    `json:"salePrice"`
+	SalePrice Money      `json:"salePrice"`
 	CreatedAt time.Time  `json:"createdAt"`
 	MappedAt  *time.Time `json:"mappedAt,omitempty" xorm:"-"`
 }
 
+// Create starts the price now unless StartAt is given.
+// The event of a price starting in the future is emitted by the scheduler once it is in effect.
+// A price which needs approval is held as a pending change instead, whose id is PendingChangeId.
 func (p *Price) Create(ctx context.Context) error {
-	p.TenantCode = tenantCode(ctx)
-	if _, err := factory.DB(ctx).Insert(p); err != nil {
+	live, err := p.submit(ctx)
+	if err != nil || !live {
 		return err
 	}
-	return adapters.MessagePublisher{}.Publish(ctx, p, adapters.EventProductPriceChanged)
+	return publishEvent(ctx, p, adapters.EventProductPriceChanged)
+}
+
+// Must be private because of event ProductPriceChanged
+// submit enters the price unless it needs approval, and tells whether it is in effect now.
+func (p *Price) submit(ctx context.Context) (live bool, err error) {
+	if err := p.validate(ctx); err != nil {
+		return false, err
+	}
+	discount, needed, err := p.needsApproval(ctx)
+	if err != nil {
+		return false, err
+	}
+	if needed {
+		c := PriceChange{Price: *p, Discount: discount, Status: PriceChangeStatusPending}
+		if err := c.create(ctx); err != nil {
+			return false, err
+		}
+		p.PendingChangeId = c.Id
+		return false, nil
+	}
+	scheduled, err := p.insert(ctx)
+	return err == nil && !scheduled, err
+}
+
+// validate normalizes the currency and the customer group of the price, and checks its list, tier and schedule.
+func (p *Price) validate(ctx context.Context) error {
+	p.TenantCode = tenantCode(ctx)
+	if p.PriceListId != 0 {
+		l, err := PriceList{}.Get(ctx, p.PriceListId)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ErrPriceListNotFound
+		}
+	}
+	if p.Currency == "" {
+		currency, err := TenantCurrency{}.Get(ctx)
+		if err != nil {
+			return err
+		}
+		p.Currency = currency
+	} else {
+		currency, err := NormalizeCurrency(p.Currency)
+		if err != nil {
+			return err
+		}
+		p.Currency = currency
+	}
+	if p.MinQuantity < 0 {
+		return ErrInvalidPriceTier
+	}
+	// a barcode which is a GTIN is priced as its GTIN-14, so the price is found once the barcode is registered for a sku
+	if p.TargetType == PriceTargetTypeBarcode {
+		if gtin, err := NormalizeGTIN(p.TargetId); err == nil {
+			p.TargetId = gtin
+		}
+	}
+	p.CustomerGroup = strings.TrimSpace(p.CustomerGroup)
+	start := p.StartAt
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if p.EndAt != nil && !p.EndAt.After(start) {
+		return ErrInvalidPriceSchedule
+	}
+	return nil
+}
+
+// Must be private because of event ProductPriceChanged
+// insert enters a valid price and tells whether it starts in the future.
+func (p *Price) insert(ctx context.Context) (scheduled bool, err error) {
+	now := time.Now()
+	if p.StartAt.IsZero() {
+		p.StartAt = now
+	}
+	if p.EndAt != nil && !p.EndAt.After(p.StartAt) {
+		return false, ErrInvalidPriceSchedule
+	}
+	scheduled = p.StartAt.After(now)
+	if !scheduled {
+		p.ActivatedAt = now
+	}
+	if err := tenantInsert(ctx, p); err != nil {
+		return false, err
+	}
+	return scheduled, writeAudit(ctx, AuditEntityPrice, AuditActionCreated, nil, p.Id)
+}
+
+// fillCurrency sets the currency of prices entered before prices had one, which is the currency of the tenant.
+func fillCurrency(ctx context.Context, prices []Price) error {
+	var currency string
+	for i := range prices {
+		if prices[i].Currency != "" {
+			continue
+		}
+		if currency == "" {
+			var err error
+			if currency, err = (TenantCurrency{}).Get(ctx); err != nil {
+				return err
+			}
+		}
+		prices[i].Currency = currency
+	}
+	return nil
+}
+
+func retrievePriceAsOf(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(priceAsOfContext).(time.Time); ok {
+		return t
+	}
+	return time.Now()
+}
+
+// livePricesAt keeps the prices which were not deleted yet at t.
+func livePricesAt(prices []Price, t time.Time) []Price {
+	live := prices[:0]
+	for _, p := range prices {
+		if p.DeletedAt.IsZero() || p.DeletedAt.After(t) {
+			live = append(live, p)
+		}
+	}
+	return live
+}
+
+// startAt falls back to CreatedAt for prices entered before prices could be scheduled.
+func (p Price) startAt() time.Time {
+	if p.StartAt.IsZero() {
+		return p.CreatedAt
+	}
+	return p.StartAt
+}
+
+func (p Price) ActiveAt(t time.Time) bool {
+	return !p.startAt().After(t) && (p.EndAt == nil || p.EndAt.After(t))
+}
+
+// minQuantity is 1 for a price which is not a quantity tier.
+func (p Price) minQuantity() int {
+	if p.MinQuantity < 1 {
+		return 1
+	}
+	return p.MinQuantity
+}
+
+// appliesTo tells whether the tier of p is reached by quantity items bought by a customer of group.
+func (p Price) appliesTo(quantity int, group string) bool {
+	return p.minQuantity() <= quantity && (p.CustomerGroup == "" || p.CustomerGroup == group)
+}
+
+func (p Price) sameSchedule(o Price) bool {
+	if p.SalePrice != o.SalePrice || p.Currency != o.Currency || !p.startAt().Equal(o.startAt()) ||
+		p.minQuantity() != o.minQuantity() || p.CustomerGroup != o.CustomerGroup {
+		return false
+	}
+	if p.EndAt == nil || o.EndAt == nil {
+		return p.EndAt == nil && o.EndAt == nil
+	}
+	return p.EndAt.Equal(*o.EndAt)
+}
+
+// effectivePrice picks the price in effect at t among prices of one target for one item bought by anyone.
+// The price started last wins, so a markdown overrides the regular price and the regular price is back when it ends.
+func effectivePrice(prices []Price, t time.Time) *Price {
+	return effectiveTierPrice(prices, 1, "", t)
+}
+
+// effectiveTierPrice picks the price in effect at t for quantity items bought by a customer of group.
+// The tier of the group wins over a tier of everyone, then the tier of the largest quantity reached,
+// and the price started last within the tier.
+func effectiveTierPrice(prices []Price, quantity int, group string, t time.Time) *Price {
+	var effective *Price
+	for i := range prices {
+		p := &prices[i]
+		if !p.ActiveAt(t) || !p.appliesTo(quantity, group) {
+			continue
+		}
+		if effective == nil || p.wins(*effective) {
+			effective = p
+		}
+	}
+	return effective
+}
+
+func (p Price) wins(o Price) bool {
+	if (p.CustomerGroup != "") != (o.CustomerGroup != "") {
+		return p.CustomerGroup != ""
+	}
+	if p.minQuantity() != o.minQuantity() {
+		return p.minQuantity() > o.minQuantity()
+	}
+	if !p.startAt().Equal(o.startAt()) {
+		return p.startAt().After(o.startAt())
+	}
+	return p.Id > o.Id
+}
+
+// priceLevels lists the price lists in the order they win, then 0 for the regular prices.
+func priceLevels(lists []PriceList) []int64 {
+	levels := make([]int64, 0, len(lists)+1)
+	for _, l := range lists {
+		levels = append(levels, l.Id)
+	}
+	return append(levels, 0)
+}
+
+// resolvePrice picks the price in effect at t in the first level which has one.
+func resolvePrice(prices []Price, levels []int64, t time.Time) *Price {
+	for _, level := range levels {
+		if price := effectivePrice(priceListPrices(prices, level), t); price != nil {
+			return price
+		}
+	}
+	return nil
+}
+
+// RunSchedule emits ProductPriceChanged for the prices which started or ended since the last run,
+// and returns how many prices were handled.
+func (Price) RunSchedule(ctx context.Context, now time.Time) (int, error) {
+	var started []Price
+	if err := factory.DB(ctx).
+		Where("activated_at IS NULL OR activated_at = '0001-01-01 00:00:00'").
+		And("start_at <= ?", now).
+		Asc("id").
+		Find(&started); err != nil {
+		return 0, err
+	}
+	for i := range started {
+		started[i].ActivatedAt = now
+		if _, err := factory.DB(ctx).ID(started[i].Id).Cols("activated_at").Update(&started[i]); err != nil {
+			return 0, err
+		}
+		// a price whose whole schedule was missed never was in effect
+		if !started[i].ActiveAt(now) {
+			continue
+		}
+		// the price started may be superseded already, by a price started after it in the same run
+		effective, err := started[i].inEffect(ctx, now)
+		if err != nil {
+			return 0, err
+		}
+		if effective != nil {
+			if err := started[i].changed(ctx, *effective); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	var ended []Price
+	if err := factory.DB(ctx).
+		Where("expired_at IS NULL OR expired_at = '0001-01-01 00:00:00'").
+		And("end_at <= ?", now).
+		Asc("id").
+		Find(&ended); err != nil {
+		return 0, err
+	}
+	for i := range ended {
+		ended[i].ExpiredAt = now
+		if _, err := factory.DB(ctx).ID(ended[i].Id).Cols("expired_at").Update(&ended[i]); err != nil {
+			return 0, err
+		}
+		// the price which is back in effect
+		effective, err := ended[i].inEffect(ctx, now)
+		if err != nil {
+			return 0, err
+		}
+		if effective != nil {
+			if err := ended[i].changed(ctx, *effective); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(started) + len(ended), nil
+}
+
+// inEffect is the price in effect at now for the target of p, in the same price list and for the same buyers.
+func (p Price) inEffect(ctx context.Context, now time.Time) (*Price, error) {
+	_, prices, err := Price{}.GetByTarget(p.tenantContext(ctx), p.TargetType, p.TargetId, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return effectiveTierPrice(priceListPrices(prices, p.PriceListId), p.minQuantity(), p.CustomerGroup, now), nil
+}
+
+// tenantContext is the context of the tenant of p, because the scheduler runs for all tenants.
+func (p Price) tenantContext(ctx context.Context) context.Context {
+	return WithUserClaim(ctx, auth.UserClaim{TenantCode: p.TenantCode})
+}
+
+// changed emits the event for the price now in effect for the target of p.
+// The version of a product or a sku moves on as well, because its representation has changed.
+func (p Price) changed(ctx context.Context, effective Price) error {
+	ctx = context.WithValue(p.tenantContext(ctx), DataSourceContext, DataSourceSchedule)
+	switch p.TargetType {
+	case PriceTargetTypeProduct:
+		productId, err := strconv.ParseInt(p.TargetId, 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := (Product{}).touch(ctx, productId); err != nil {
+			return err
+		}
+	case PriceTargetTypeSku:
+		skuId, err := strconv.ParseInt(p.TargetId, 10, 64)
+		if err != nil {
+			return err
+		}
+		if err := (Sku{}).touch(ctx, skuId); err != nil {
+			return err
+		}
+	}
+	return publishEvent(ctx, effective, adapters.EventProductPriceChanged)
 }
 
 func (Price) Get(ctx context.Context, priceId int64) (*Price, error) {
+	db, err := tenantDB(ctx, "price")
+	if err != nil {
+		return nil, err
+	}
 	var p Price
-	exist, err := factory.DB(ctx).ID(priceId).Get(&p)
+	exist, err := db.ID(priceId).Get(&p)
 	if err != nil {
 		return nil, err
 	}
@@ -53,18 +408,77 @@ func (Price) Get(ctx context.Context, priceId int64) (*Price, error) {
 	return &p, nil
 }
 
-func (Price) GetByTarget(ctx context.Context, targetType PriceTargetType, targetId string) ([]Price, error) {
+// GetByTarget returns the prices of a target, the latest entered first.
+// All prices are returned when maxResultCount is 0.
+func (Price) GetByTarget(ctx context.Context, targetType PriceTargetType, targetId string, skipCount, maxResultCount int) (int64, []Price, error) {
+	scope, err := tenantDB(ctx, "price")
+	if err != nil {
+		return 0, nil, err
+	}
+	query := scope.session()
+	query.And("target_type = ?", targetType).
+		And("target_id = ?", targetId).
+		Desc("id")
+	if maxResultCount > 0 {
+		query.Limit(maxResultCount, skipCount)
+	}
 	var prices []Price
-	if err := factory.DB(ctx).
-		Where("target_type = ?", targetType).And("target_id = ?", targetId).Desc("id").
-		Find(&prices); err != nil {
+	totalCount, err := query.FindAndCount(&prices)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := fillCurrency(ctx, prices); err != nil {
+		return 0, nil, err
+	}
+	return totalCount, prices, nil
+}
+
+// GetEffective returns the sale price of each product at the given time.
+// The list price stands for the sale price of a product which had no price then.
+func (Price) GetEffective(ctx context.Context, productIds []int64, at time.Time) ([]Price, error) {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
+	var products ProductList
+	if err := db.Unscoped().
+		In("id", productIds).
+		Asc("id").
+		Find(&products); err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, nil
+	}
+	if err := products.LoadPrices(WithPriceAsOf(ctx, at)); err != nil {
 		return nil, err
 	}
+	prices := make([]Price, len(products))
+	for i, p := range products {
+		prices[i] = p.Prices[0]
+		prices[i].TargetType = PriceTargetTypeProduct
+		prices[i].TargetId = strconv.FormatInt(p.Id, 10)
+	}
 	return prices, nil
 }
 
-func (Price) GetAllBarcode(ctx context.Context, skipCount, maxResultCount int, sortby, order []string) (int64, []PriceSkuInfo, error) {
-	query := factory.DB(ctx).Table("price").Where("tenant_code = ? AND target_type = ?", tenantCode(ctx), PriceTargetTypeBarcode)
+// GetAllBarcode lists the prices of unregistered barcodes. mapped keeps those whose barcode is an identifier of a sku by now
+// when it is true, and those whose barcode is not when false.
+func (Price) GetAllBarcode(ctx context.Context, mapped string, skipCount, maxResultCount int, sortby, order []string) (int64, []PriceSkuInfo, error) {
+	scope, err := tenantDB(ctx, "price")
+	if err != nil {
+		return 0, nil, err
+	}
+	query := scope.session()
+	query.Table("price").And("target_type = ?", PriceTargetTypeBarcode)
+	if mapped != "" {
+		keyword := "EXISTS"
+		if b, _ := strconv.ParseBool(mapped); !b {
+			keyword = "NOT EXISTS"
+		}
+		query.And(keyword + " (SELECT 1 FROM sku_identifier AS si INNER JOIN sku ON sku.id = si.sku_id" +
+			" WHERE si.uid = price.target_id AND sku.tenant_code = price.tenant_code AND (" + excludeDeleted("si") + "))")
+	}
 	if err := setSortOrder(query, sortby, order); err != nil {
 		return 0, nil, err
 	}
@@ -83,8 +497,12 @@ func (Price) GetAllBarcode(ctx context.Context, skipCount, maxResultCount int, s
 		uids = append(uids, price.TargetId)
 	}
 
+	db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return 0, nil, err
+	}
 	var identifiers []SkuIdentifier
-	if err := factory.DB(ctx).In("uid", uids).Find(&identifiers); err != nil {
+	if err := db.In("uid", uids).Find(&identifiers); err != nil {
 		return 0, nil, err
 	}
 
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/hublabs/product-api/adapters"
+)
+
+var (
+	ErrBarcodePriceNotFound = errors.New("no price of an unregistered barcode")
+	ErrMapSkuNotFound       = errors.New("sku not found")
+)
+
+// BarcodeMapping is a barcode mapped to a sku, whose prices are now prices of the sku.
+type BarcodeMapping struct {
+	Barcode   string  `json:"barcode"`
+	SkuId     int64   `json:"skuId"`
+	ProductId int64   `json:"productId"`
+	PriceIds  []int64 `json:"priceIds"`
+}
+
+// BarcodeAutoMapResult tells which barcodes were mapped and which match the identifiers of several skus,
+// which are left for a person to map.
+type BarcodeAutoMapResult struct {
+	Mapped    []BarcodeMapping `json:"mapped"`
+	Ambiguous []string         `json:"ambiguous"`
+}
+
+// MapBarcode registers barcode as an identifier of the sku and turns the prices of the barcode into prices of the sku.
+// A barcode registered for another sku already gives ErrIdentifierExist.
+func (Price) MapBarcode(ctx context.Context, barcode string, skuId int64) (*BarcodeMapping, error) {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	var sku Sku
+	exist, err := db.And("id = ?", skuId).Get(&sku)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, ErrMapSkuNotFound
+	}
+	prices, err := barcodePrices(ctx, barcode)
+	if err != nil {
+		return nil, err
+	}
+	if len(prices) == 0 {
+		return nil, ErrBarcodePriceNotFound
+	}
+
+	identifier := SkuIdentifier{SkuId: sku.Id, Uid: barcode, Source: IdentifierSourceBarcode, Enable: true}
+	if err := identifier.LoadOrCreate(ctx); err != nil {
+		return nil, err
+	}
+	m := BarcodeMapping{Barcode: barcode, SkuId: sku.Id, ProductId: sku.ProductId}
+	if err := m.convert(ctx, prices); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// AutoMapBarcodes maps every unregistered barcode with prices which is an identifier of exactly one sku now.
+func (Price) AutoMapBarcodes(ctx context.Context) (*BarcodeAutoMapResult, error) {
+	var rows []struct {
+		Uid       string
+		SkuId     int64
+		ProductId int64
+	}
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Table("sku_identifier").
+		Select("DISTINCT sku_identifier.uid, sku_identifier.sku_id, sku.product_id").
+		Join("INNER", "sku", "sku.id = sku_identifier.sku_id").
+		Join("INNER", "price", "price.target_id = sku_identifier.uid AND price.tenant_code = sku.tenant_code").
+		And("price.target_type = ?", PriceTargetTypeBarcode).
+		And("("+excludeDeleted("price")+")").
+		And("("+excludeDeleted("sku_identifier")+")").
+		And("("+excludeDeleted("sku")+")").
+		Asc("sku_identifier.uid", "sku_identifier.sku_id").
+		Find(&rows); err != nil {
+		return nil, err
+	}
+
+	result := BarcodeAutoMapResult{Mapped: []BarcodeMapping{}, Ambiguous: []string{}}
+	for i := 0; i < len(rows); {
+		j := i + 1
+		for j < len(rows) && rows[j].Uid == rows[i].Uid {
+			j++
+		}
+		if j-i > 1 {
+			result.Ambiguous = append(result.Ambiguous, rows[i].Uid)
+			i = j
+			continue
+		}
+		prices, err := barcodePrices(ctx, rows[i].Uid)
+		if err != nil {
+			return nil, err
+		}
+		m := BarcodeMapping{Barcode: rows[i].Uid, SkuId: rows[i].SkuId, ProductId: rows[i].ProductId}
+		if err := m.convert(ctx, prices); err != nil {
+			return nil, err
+		}
+		result.Mapped = append(result.Mapped, m)
+		i = j
+	}
+	return &result, nil
+}
+
+func barcodePrices(ctx context.Context, barcode string) ([]Price, error) {
+	db, err := tenantDB(ctx, "price")
+	if err != nil {
+		return nil, err
+	}
+	var prices []Price
+	if err := db.
+		And("target_type = ?", PriceTargetTypeBarcode).
+		In("target_id", barcodeUids(barcode)).
+		Asc("id").
+		Find(&prices); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
+
+// Must be private because of event ProductPricesChanged
+// convert moves the prices of the barcode onto the sku, and emits one event for the prices in effect now.
+func (m *BarcodeMapping) convert(ctx context.Context, prices []Price) error {
+	m.PriceIds = make([]int64, len(prices))
+	for i, p := range prices {
+		m.PriceIds[i] = p.Id
+	}
+	if len(prices) == 0 {
+		return nil
+	}
+	before, err := auditSnapshot(ctx, AuditEntityPrice, m.PriceIds...)
+	if err != nil {
+		return err
+	}
+	targetId := strconv.FormatInt(m.SkuId, 10)
+	db, err := tenantDB(ctx, "price")
+	if err != nil {
+		return err
+	}
+	if _, err := db.In("id", m.PriceIds).
+		Cols("target_type", "target_id").
+		Update(&Price{TargetType: PriceTargetTypeSku, TargetId: targetId}); err != nil {
+		return err
+	}
+	if err := writeAudit(ctx, AuditEntityPrice, AuditActionUpdated, before, m.PriceIds...); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var batch PriceBatch
+	for _, p := range prices {
+		p.TargetType, p.TargetId = PriceTargetTypeSku, targetId
+		if !p.StartAt.After(now) && (p.EndAt == nil || p.EndAt.After(now)) {
+			batch = append(batch, p)
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return publishEvent(ctx, batch, adapters.EventProductPricesChanged)
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hublabs/product-api/adapters"
+)
+
+const (
+	PriceSheetStatusInsert = "Insert"
+	PriceSheetStatusUpdate = "Update"
+)
+
+var ErrInvalidPriceSheet = errors.New("price sheet has errors")
+
+// PriceSheetRow is a new regular price of a product, a sku or a barcode, which is given by exactly one of
+// ProductCode, SkuCode and Barcode. BrandCode tells apart products and skus of the same code in several brands.
+// A barcode of no sku is priced as an unregistered barcode.
+// Validation fills in the target the price is entered for, and ListPrice and CurrentPrice for the preview.
+type PriceSheetRow struct {
+	ProductCode  string          `json:"productCode"`
+	SkuCode      string          `json:"skuCode"`
+	Barcode      string          `json:"barcode"`
+	BrandCode    string          `json:"brandCode"`
+	SalePrice    Money           `json:"salePrice"`
+	Currency     string          `json:"currency"`
+	StartAt      *time.Time      `json:"startAt,omitempty"`
+	EndAt        *time.Time      `json:"endAt,omitempty"`
+	TargetType   PriceTargetType `json:"targetType,omitempty"`
+	TargetId     string          `json:"targetId,omitempty"`
+	ListPrice    *Money          `json:"listPrice,omitempty"`
+	CurrentPrice *Money          `json:"currentPrice,omitempty"`
+	ErrorList    []int           `json:"errorList"`
+	Status       string          `json:"status"`
+}
+
+// PriceBatch is the prices entered by one bulk update, which are sent as a single event.
+type PriceBatch []Price
+
+// Validate checks the rows of a price sheet, and tells for the valid ones whether a price is entered for the first time.
+func (PriceSheetRow) Validate(ctx context.Context, rows []PriceSheetRow) ([]PriceSheetRow, error) {
+	now := time.Now()
+	for i := range rows {
+		r := &rows[i]
+		r.ErrorList, r.Status = nil, ""
+		r.TargetType, r.TargetId, r.ListPrice, r.CurrentPrice = "", "", nil, nil
+
+		if r.SalePrice <= 0 {
+			r.ErrorList = append(r.ErrorList, 10007) //销售价
+		}
+		if r.Currency != "" {
+			currency, err := NormalizeCurrency(r.Currency)
+			if err != nil {
+				r.ErrorList = append(r.ErrorList, 10022) //币种
+			}
+			r.Currency = currency
+		}
+		if r.EndAt != nil && !r.EndAt.After(r.startAt(now)) {
+			r.ErrorList = append(r.ErrorList, 10024)
+		}
+
+		var targets int
+		for _, code := range []string{r.ProductCode, r.SkuCode, r.Barcode} {
+			if code != "" {
+				targets++
+			}
+		}
+		if targets != 1 {
+			r.ErrorList = append(r.ErrorList, 10018)
+			continue
+		}
+		if err := r.resolveTarget(ctx, now); err != nil {
+			return nil, err
+		}
+		if r.ListPrice != nil && *r.ListPrice < r.SalePrice {
+			r.ErrorList = append(r.ErrorList, 10008)
+		}
+	}
+
+	for i := range rows {
+		if rows[i].TargetId == "" {
+			continue
+		}
+		for j := range rows {
+			if i != j && rows[i].TargetType == rows[j].TargetType && rows[i].TargetId == rows[j].TargetId &&
+				rows[i].startAt(now).Equal(rows[j].startAt(now)) {
+				rows[i].ErrorList = append(rows[i].ErrorList, 10021)
+				break
+			}
+		}
+		if len(rows[i].ErrorList) != 0 {
+			continue
+		}
+		if rows[i].CurrentPrice == nil {
+			rows[i].Status = PriceSheetStatusInsert
+		} else {
+			rows[i].Status = PriceSheetStatusUpdate
+		}
+	}
+	return rows, nil
+}
+
+func (r PriceSheetRow) startAt(now time.Time) time.Time {
+	if r.StartAt == nil || r.StartAt.IsZero() {
+		return now
+	}
+	return *r.StartAt
+}
+
+// resolveTarget finds what the row prices, with its list price and the regular price in effect when the new one starts.
+// A row whose target is not found gets an error instead.
+func (r *PriceSheetRow) resolveTarget(ctx context.Context, now time.Time) error {
+	switch {
+	case r.ProductCode != "":
+		db, err := tenantDB(ctx, "product")
+		if err != nil {
+			return err
+		}
+		query := db.Table("product").Select("product.*").
+			And("product.code = ?", r.ProductCode).
+			And("(" + excludeDeleted("product") + ")")
+		if r.BrandCode != "" {
+			query.Join("INNER", "brand", "brand.id = product.brand_id").And("brand.code = ?", r.BrandCode)
+		}
+		var products []Product
+		if err := query.Find(&products); err != nil {
+			return err
+		}
+		if len(products) == 0 {
+			r.ErrorList = append(r.ErrorList, 10019)
+			return nil
+		} else if len(products) > 1 {
+			r.ErrorList = append(r.ErrorList, 10009)
+			return nil
+		}
+		p := products[0]
+		r.TargetType, r.TargetId = PriceTargetTypeProduct, strconv.FormatInt(p.Id, 10)
+		r.setListPrice(p)
+		_, prices, err := Price{}.GetByTarget(ctx, PriceTargetTypeProduct, r.TargetId, 0, 0)
+		if err != nil {
+			return err
+		}
+		if price := effectivePrice(priceListPrices(prices, 0), r.startAt(now)); price != nil {
+			r.CurrentPrice = &price.SalePrice
+		}
+		return nil
+	case r.SkuCode != "":
+		db, err := tenantDB(ctx, "sku")
+		if err != nil {
+			return err
+		}
+		query := db.Table("sku").Select("sku.*").
+			Join("INNER", "product", "product.id = sku.product_id").
+			And("sku.code = ?", r.SkuCode).
+			And("(" + excludeDeleted("sku") + ")")
+		if r.BrandCode != "" {
+			query.Join("INNER", "brand", "brand.id = product.brand_id").And("brand.code = ?", r.BrandCode)
+		}
+		var skus SkuList
+		if err := query.Find(&skus); err != nil {
+			return err
+		}
+		if len(skus) == 0 {
+			r.ErrorList = append(r.ErrorList, 10020)
+			return nil
+		} else if len(skus) > 1 {
+			r.ErrorList = append(r.ErrorList, 10025)
+			return nil
+		}
+		return r.setSku(ctx, skus[0], now)
+	default:
+		db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+		if err != nil {
+			return err
+		}
+		var skuIds []int64
+		if err := db.Table("sku_identifier").Select("sku_id").Distinct("sku_id").
+			In("uid", barcodeUids(r.Barcode)).
+			And("(" + excludeDeleted("sku_identifier") + ")").
+			Find(&skuIds); err != nil {
+			return err
+		}
+		var skus SkuList
+		if len(skuIds) != 0 {
+			if db, err = tenantDB(ctx, "sku"); err != nil {
+				return err
+			}
+			if err := db.In("id", skuIds).Find(&skus); err != nil {
+				return err
+			}
+		}
+		if len(skus) > 1 {
+			r.ErrorList = append(r.ErrorList, 10026)
+			return nil
+		} else if len(skus) == 1 {
+			return r.setSku(ctx, skus[0], now)
+		}
+		r.TargetType, r.TargetId = PriceTargetTypeBarcode, r.Barcode
+		if gtin, err := NormalizeGTIN(r.Barcode); err == nil {
+			r.TargetId = gtin
+		}
+		_, prices, err := Price{}.GetByTarget(ctx, PriceTargetTypeBarcode, r.TargetId, 0, 0)
+		if err != nil {
+			return err
+		}
+		if price := effectivePrice(priceListPrices(prices, 0), r.startAt(now)); price != nil {
+			r.CurrentPrice = &price.SalePrice
+		}
+		return nil
+	}
+}
+
+func (r *PriceSheetRow) setSku(ctx context.Context, s Sku, now time.Time) error {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return err
+	}
+	var p Product
+	exist, err := db.ID(s.ProductId).Get(&p)
+	if err != nil {
+		return err
+	}
+	if !exist {
+		r.ErrorList = append(r.ErrorList, 10020)
+		return nil
+	}
+	if p.Currency == "" {
+		if err := p.setCurrency(ctx); err != nil {
+			return err
+		}
+	}
+	s.Product = &p
+	r.TargetType, r.TargetId = PriceTargetTypeSku, strconv.FormatInt(s.Id, 10)
+	r.setListPrice(p)
+	prices, err := loadSkuPrices(ctx, SkuList{s})
+	if err != nil {
+		return err
+	}
+	if price, source := prices.resolve(s, []int64{0}, 1, "", r.startAt(now)); source != PriceSourceListPrice {
+		r.CurrentPrice = &price.SalePrice
+	}
+	return nil
+}
+
+// setListPrice keeps the list price of p to check the sale price against, unless the sale price is in another currency.
+func (r *PriceSheetRow) setListPrice(p Product) {
+	if r.Currency == "" || r.Currency == p.Currency {
+		r.ListPrice = &p.ListPrice
+	}
+}
+
+// Apply enters the prices of the rows, which are validated again, and emits one event for the prices in effect now.
+// Prices starting later are emitted by the scheduler, one by one, as a price created alone,
+// and prices which need approval are held as pending changes.
+func (PriceSheetRow) Apply(ctx context.Context, rows []PriceSheetRow) ([]Price, error) {
+	rows, err := PriceSheetRow{}.Validate(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range rows {
+		if len(r.ErrorList) != 0 {
+			return nil, fmt.Errorf("%w: row %d %v", ErrInvalidPriceSheet, i+1, r.ErrorList)
+		}
+	}
+
+	var prices []Price
+	var batch PriceBatch
+	for _, r := range rows {
+		p := Price{
+			TargetType: r.TargetType,
+			TargetId:   r.TargetId,
+			SalePrice:  r.SalePrice,
+			Currency:   r.Currency,
+			EndAt:      r.EndAt,
+		}
+		if r.StartAt != nil {
+			p.StartAt = *r.StartAt
+		}
+		live, err := p.submit(ctx)
+		if err != nil {
+			return nil, err
+		}
+		prices = append(prices, p)
+		if live {
+			batch = append(batch, p)
+		}
+	}
+	if len(batch) == 0 {
+		return prices, nil
+	}
+	if err := publishEvent(ctx, batch, adapters.EventProductPricesChanged); err != nil {
+		return nil, err
+	}
+	return prices, nil
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hublabs/product-api/adapters"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestPriceSheetApply(t *testing.T) {
+	later := time.Now().Add(30 * 24 * time.Hour)
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := PriceSheetRow{}.Apply(ctx, []PriceSheetRow{
+			{Barcode: "sheet#1", SalePrice: 10 * MajorUnit},
+			{Barcode: "sheet#2", SkuCode: "sheet#2", SalePrice: 10 * MajorUnit},
+		})
+		test.Equals(t, errors.Is(err, ErrInvalidPriceSheet), true)
+
+		_, prices, err := Price{}.GetByTarget(ctx, PriceTargetTypeBarcode, "sheet#1", 0, 0)
+		test.Ok(t, err)
+		test.Equals(t, len(prices), 0)
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		prices, err := PriceSheetRow{}.Apply(ctx, []PriceSheetRow{
+			{Barcode: "sheet#1", SalePrice: 10 * MajorUnit},
+			{Barcode: "sheet#2", SalePrice: 12 * MajorUnit},
+			{Barcode: "sheet#2", SalePrice: 11 * MajorUnit, StartAt: &later},
+		})
+		test.Ok(t, err)
+		test.Equals(t, len(prices), 3)
+
+		// one event for the prices in effect now, the scheduled one is left to the scheduler
+		events, err := OutboxEvent{}.GetPending(ctx, 100)
+		test.Ok(t, err)
+		var batches []OutboxEvent
+		for _, e := range events {
+			if e.Status == adapters.EventProductPricesChanged {
+				batches = append(batches, e)
+			}
+		}
+		test.Equals(t, len(batches), 1)
+		var payload PriceBatchEvent
+		test.Ok(t, json.Unmarshal([]byte(batches[0].Payload), &payload))
+		test.Equals(t, len(payload.Prices), 2)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		rows, err := PriceSheetRow{}.Validate(ctx, []PriceSheetRow{
+			{Barcode: "sheet#1", SalePrice: 9 * MajorUnit},
+			{Barcode: "sheet#3", SalePrice: 9 * MajorUnit},
+			{Barcode: "sheet#3", SalePrice: 8 * MajorUnit},
+			{SalePrice: 9 * MajorUnit},
+			{Barcode: "sheet#4"},
+		})
+		test.Ok(t, err)
+		test.Equals(t, rows[0].Status, PriceSheetStatusUpdate)
+		test.Equals(t, *rows[0].CurrentPrice, 10*MajorUnit)
+		test.Equals(t, rows[1].ErrorList, []int{10021})
+		test.Equals(t, rows[3].ErrorList, []int{10018})
+		test.Equals(t, rows[4].ErrorList, []int{10007})
+		test.Equals(t, rows[4].Status, "")
+	})
+}
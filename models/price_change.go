@@ -0,0 +1,416 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/adapters"
+)
+
+type PriceChangeStatus string
+
+const (
+	PriceChangeStatusDraft    PriceChangeStatus = "draft"
+	PriceChangeStatusPending  PriceChangeStatus = "pending"
+	PriceChangeStatusApproved PriceChangeStatus = "approved"
+	PriceChangeStatusRejected PriceChangeStatus = "rejected"
+)
+
+var (
+	ErrPriceChangeNotFound       = errors.New("price change not found")
+	ErrInvalidPriceChangeStatus  = errors.New("price change is not in a status it can move on from")
+	ErrInvalidDiscountThreshold  = errors.New("discountThreshold must be between 0 and 100")
+	ErrPriceChangeTargetRequired = errors.New("price change needs a product, a sku or a barcode")
+	ErrPriceChangeSelfApproval   = errors.New("price change can not be approved by the colleague who submitted it")
+)
+
+// PriceApprovalPolicy tells which price changes of a tenant need approval.
+// A price more than DiscountThreshold percent off the list price of its product needs approval, none does when it is 0.
+type PriceApprovalPolicy struct {
+	TenantCode        string    `json:"-" xorm:"pk varchar(16)"`
+	DiscountThreshold int       `json:"discountThreshold"`
+	UpdatedAt         time.Time `json:"updatedAt" xorm:"updated"`
+}
+
+func (PriceApprovalPolicy) Load(ctx context.Context) (PriceApprovalPolicy, error) {
+	db, err := tenantDB(ctx, "price_approval_policy")
+	if err != nil {
+		return PriceApprovalPolicy{}, err
+	}
+	p := PriceApprovalPolicy{TenantCode: tenantCode(ctx)}
+	if _, err := db.Get(&p); err != nil {
+		return PriceApprovalPolicy{}, err
+	}
+	return p, nil
+}
+
+func (PriceApprovalPolicy) Set(ctx context.Context, discountThreshold int) (*PriceApprovalPolicy, error) {
+	if discountThreshold < 0 || discountThreshold > 100 {
+		return nil, ErrInvalidDiscountThreshold
+	}
+	db, err := tenantDB(ctx, "price_approval_policy")
+	if err != nil {
+		return nil, err
+	}
+	p := PriceApprovalPolicy{DiscountThreshold: discountThreshold}
+	exist, err := db.Exist(&PriceApprovalPolicy{})
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		if db, err = tenantDB(ctx, "price_approval_policy"); err != nil {
+			return nil, err
+		}
+		p.TenantCode = tenantCode(ctx)
+		_, err = db.Cols("discount_threshold").Update(&p)
+	} else {
+		err = tenantInsert(ctx, &p)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// PriceChange is a price waiting to be entered, which is a draft until it is submitted.
+// A submitted change which needs approval is pending until an approver approves or rejects it,
+// and one which does not is approved at once. PriceId is the price entered once it is approved.
+// Discount is the percentage off the list price of the product, 0 when there is no list price to compare with.
+type PriceChange struct {
+	Id          int64             `json:"id"`
+	TenantCode  string            `json:"-" xorm:"index varchar(16)"`
+	TargetType  PriceTargetType   `json:"targetType" xorm:"index(target) varchar(16)"`
+	TargetId    string            `json:"targetId" xorm:"index(target)"`
+	Price       Price             `json:"price" xorm:"json"`
+	Discount    float64           `json:"discount"`
+	Status      PriceChangeStatus `json:"status" xorm:"index varchar(16)"`
+	PriceId     int64             `json:"priceId,omitempty"`
+	SubmittedBy int64             `json:"submittedBy,omitempty"`
+	ReviewedBy  int64             `json:"reviewedBy,omitempty"`
+	ReviewedAt  *time.Time        `json:"reviewedAt,omitempty"`
+	Comment     string            `json:"comment,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt" xorm:"created"`
+	UpdatedAt   time.Time         `json:"updatedAt" xorm:"updated"`
+	Version     int               `json:"version" xorm:"version"`
+}
+
+// listPrice is the list price of the product the price is for, in the currency of the product.
+// A barcode is priced for the product of the sku it is an identifier of, and has no list price until it is mapped to one.
+func (p Price) listPrice(ctx context.Context) (*Money, string, error) {
+	var productId int64
+	switch p.TargetType {
+	case PriceTargetTypeProduct:
+		id, err := strconv.ParseInt(p.TargetId, 10, 64)
+		if err != nil {
+			return nil, "", err
+		}
+		productId = id
+	case PriceTargetTypeSku, PriceTargetTypeBarcode:
+		var skuId int64
+		if p.TargetType == PriceTargetTypeBarcode {
+			exist, identifier, err := SkuIdentifier{}.GetByUidAndSource(ctx, p.TargetId, IdentifierSourceBarcode)
+			if err != nil || !exist {
+				return nil, "", err
+			}
+			skuId = identifier.SkuId
+		} else {
+			id, err := strconv.ParseInt(p.TargetId, 10, 64)
+			if err != nil {
+				return nil, "", err
+			}
+			skuId = id
+		}
+		db, err := tenantDB(ctx, "sku")
+		if err != nil {
+			return nil, "", err
+		}
+		var s Sku
+		exist, err := db.And("id = ?", skuId).Get(&s)
+		if err != nil || !exist {
+			return nil, "", err
+		}
+		productId = s.ProductId
+	default:
+		return nil, "", nil
+	}
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, "", err
+	}
+	var product Product
+	exist, err := db.And("id = ?", productId).Get(&product)
+	if err != nil || !exist {
+		return nil, "", err
+	}
+	if product.Currency == "" {
+		if err := product.setCurrency(ctx); err != nil {
+			return nil, "", err
+		}
+	}
+	return &product.ListPrice, product.Currency, nil
+}
+
+// discount is the percentage of the list price the price takes off, once converted into the currency of the list price.
+// known is false when there is no list price to compare with, for a barcode mapped to no sku
+// or a price in a currency without an exchange rate to that of the list price.
+func (p Price) discount(ctx context.Context) (discount float64, known bool, err error) {
+	listPrice, currency, err := p.listPrice(ctx)
+	if err != nil || listPrice == nil {
+		return 0, false, err
+	}
+	if *listPrice <= 0 {
+		return 0, true, nil
+	}
+	salePrice := p.SalePrice
+	if p.Currency != currency {
+		rates, err := loadExchangeRates(ctx)
+		if err != nil {
+			return 0, false, err
+		}
+		converted, err := rates.convert(p.SalePrice, p.Currency, currency)
+		if errors.Is(err, ErrExchangeRateNotFound) {
+			return 0, false, nil
+		} else if err != nil {
+			return 0, false, err
+		}
+		salePrice = converted.Amount
+	}
+	return math.Round(float64(*listPrice-salePrice)/float64(*listPrice)*10000) / 100, true, nil
+}
+
+// needsApproval tells whether the price takes more off its list price than the policy of the tenant lets it.
+// A price whose discount is not known needs approval under a policy, because it might take off any amount.
+func (p Price) needsApproval(ctx context.Context) (float64, bool, error) {
+	discount, known, err := p.discount(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	policy, err := PriceApprovalPolicy{}.Load(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	return discount, policy.DiscountThreshold > 0 && (!known || discount > float64(policy.DiscountThreshold)), nil
+}
+
+func (c *PriceChange) create(ctx context.Context) error {
+	c.TargetType, c.TargetId = c.Price.TargetType, c.Price.TargetId
+	if c.Status == PriceChangeStatusPending {
+		c.SubmittedBy = auth.UserClaim{}.FromCtx(ctx).ColleagueId
+	}
+	return tenantInsert(ctx, c)
+}
+
+// Create keeps price as a draft, which is submitted at once when submit is true.
+func (c *PriceChange) Create(ctx context.Context, submit bool) error {
+	if c.Price.TargetType == "" || c.Price.TargetId == "" {
+		return ErrPriceChangeTargetRequired
+	}
+	if err := c.Price.validate(ctx); err != nil {
+		return err
+	}
+	discount, _, err := c.Price.discount(ctx)
+	if err != nil {
+		return err
+	}
+	c.Discount = discount
+	c.Status = PriceChangeStatusDraft
+	if err := c.create(ctx); err != nil {
+		return err
+	}
+	if !submit {
+		return nil
+	}
+	result, err := PriceChange{}.Submit(ctx, c.Id, c.Version)
+	if err != nil {
+		return err
+	}
+	*c = *result
+	return nil
+}
+
+// Update changes the price of a draft.
+func (c *PriceChange) Update(ctx context.Context) error {
+	current, err := PriceChange{}.Get(ctx, c.Id)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrPriceChangeNotFound
+	}
+	if current.Status != PriceChangeStatusDraft {
+		return fmt.Errorf("%w: %s", ErrInvalidPriceChangeStatus, current.Status)
+	}
+	if c.Price.TargetType == "" || c.Price.TargetId == "" {
+		return ErrPriceChangeTargetRequired
+	}
+	if err := c.Price.validate(ctx); err != nil {
+		return err
+	}
+	if c.Discount, _, err = c.Price.discount(ctx); err != nil {
+		return err
+	}
+	c.TenantCode, c.CreatedAt, c.Status = current.TenantCode, current.CreatedAt, current.Status
+	c.TargetType, c.TargetId = c.Price.TargetType, c.Price.TargetId
+	db, err := tenantDB(ctx, "price_change")
+	if err != nil {
+		return err
+	}
+	affected, err := db.ID(c.Id).
+		Cols("target_type", "target_id", "price", "discount").
+		Update(c)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+func (PriceChange) Get(ctx context.Context, id int64) (*PriceChange, error) {
+	var c PriceChange
+	db, err := tenantDB(ctx, "price_change")
+	if err != nil {
+		return nil, err
+	}
+	exist, err := db.
+		And("id = ?", id).
+		Get(&c)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &c, nil
+}
+
+// GetAll returns the changes in status for a target, the latest first. Empty arguments match any.
+func (PriceChange) GetAll(ctx context.Context, status PriceChangeStatus, targetType PriceTargetType, targetId string, skipCount, maxResultCount int) (int64, []PriceChange, error) {
+	db, err := tenantDB(ctx, "price_change")
+	if err != nil {
+		return 0, nil, err
+	}
+	query := db.session()
+	if status != "" {
+		query.And("status = ?", status)
+	}
+	if targetType != "" {
+		query.And("target_type = ?", targetType)
+	}
+	if targetId != "" {
+		query.And("target_id = ?", targetId)
+	}
+	var changes []PriceChange
+	totalCount, err := query.Desc("id").Limit(maxResultCount, skipCount).FindAndCount(&changes)
+	if err != nil {
+		return 0, nil, err
+	}
+	return totalCount, changes, nil
+}
+
+// Submit sends a draft for approval, or enters its price when it does not need approval.
+func (PriceChange) Submit(ctx context.Context, id int64, version int) (*PriceChange, error) {
+	c, err := PriceChange{}.transition(ctx, id, version, PriceChangeStatusDraft)
+	if err != nil {
+		return nil, err
+	}
+	discount, needed, err := c.Price.needsApproval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.Discount = discount
+	c.SubmittedBy = auth.UserClaim{}.FromCtx(ctx).ColleagueId
+	if !needed {
+		return c, c.approve(ctx, "", "submitted_by", "discount")
+	}
+	c.Status = PriceChangeStatusPending
+	return c, c.update(ctx, "submitted_by", "discount")
+}
+
+// Approve enters the price of a pending change, which another colleague than its submitter must approve.
+func (PriceChange) Approve(ctx context.Context, id int64, version int, comment string) (*PriceChange, error) {
+	c, err := PriceChange{}.transition(ctx, id, version, PriceChangeStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	approver := auth.UserClaim{}.FromCtx(ctx).ColleagueId
+	if c.SubmittedBy == approver {
+		return nil, ErrPriceChangeSelfApproval
+	}
+	return c, c.approve(ctx, comment, "reviewed_by", "reviewed_at")
+}
+
+// Reject closes a pending change, its price is never entered.
+func (PriceChange) Reject(ctx context.Context, id int64, version int, comment string) (*PriceChange, error) {
+	c, err := PriceChange{}.transition(ctx, id, version, PriceChangeStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c.Status, c.Comment = PriceChangeStatusRejected, comment
+	c.ReviewedBy, c.ReviewedAt = auth.UserClaim{}.FromCtx(ctx).ColleagueId, &now
+	return c, c.update(ctx, "comment", "reviewed_by", "reviewed_at")
+}
+
+// transition reads the change which moves on from status, as of version.
+func (PriceChange) transition(ctx context.Context, id int64, version int, status PriceChangeStatus) (*PriceChange, error) {
+	c, err := PriceChange{}.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrPriceChangeNotFound
+	}
+	if c.Version != version {
+		return nil, ErrVersionConflict
+	}
+	if c.Status != status {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPriceChangeStatus, c.Status)
+	}
+	return c, nil
+}
+
+// Must be private because of event ProductPriceChanged
+// approve enters the price of the change, which starts now unless it was scheduled.
+func (c *PriceChange) approve(ctx context.Context, comment string, cols ...string) error {
+	now := time.Now()
+	c.Status, c.Comment = PriceChangeStatusApproved, comment
+	if HasRole(ctx, RolePriceApprover) {
+		c.ReviewedBy, c.ReviewedAt = auth.UserClaim{}.FromCtx(ctx).ColleagueId, &now
+	}
+	p := c.Price
+	p.Id, p.TenantCode, p.PendingChangeId = 0, c.TenantCode, 0
+	scheduled, err := p.insert(ctx)
+	if err != nil {
+		return err
+	}
+	c.Price, c.PriceId = p, p.Id
+	if err := c.update(ctx, append(cols, "price", "price_id", "comment")...); err != nil {
+		return err
+	}
+	if scheduled {
+		return nil
+	}
+	return publishEvent(ctx, p, adapters.EventProductPriceChanged)
+}
+
+func (c *PriceChange) update(ctx context.Context, cols ...string) error {
+	db, err := tenantDB(ctx, "price_change")
+	if err != nil {
+		return err
+	}
+	affected, err := db.ID(c.Id).Cols(append(cols, "status")...).Update(c)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/hublabs/common/auth"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestPriceApproval(t *testing.T) {
+	// a tenant of its own, so that its policy holds for no other test
+	approval := WithRoles(WithUserClaim(ctx, auth.UserClaim{TenantCode: "approval", ColleagueId: 7}), []string{RolePriceApprover})
+	_, err := PriceApprovalPolicy{}.Set(approval, 30)
+	test.Ok(t, err)
+	created, err := Product{}.CreateOrUpdate(approval, Product{
+		Code:      "P950",
+		Name:      "product#950",
+		ListPrice: 100 * MajorUnit,
+		Skus:      []Sku{{Code: "S950", Identifiers: []SkuIdentifier{{Uid: "2000009500011", Source: IdentifierSourceBarcode}}}},
+	})
+	test.Ok(t, err)
+	_, err = ExchangeRate{}.Save(approval, []ExchangeRate{{FromCurrency: "USD", ToCurrency: "CNY", Rate: 7.1}})
+	test.Ok(t, err)
+	productId := strconv.FormatInt(created.Id, 10)
+
+	for _, c := range []struct {
+		name    string
+		price   Price
+		pending bool
+	}{
+		{"ProductWithinThreshold", Price{TargetType: PriceTargetTypeProduct, TargetId: productId, SalePrice: 80 * MajorUnit}, false},
+		{"ProductBeyondThreshold", Price{TargetType: PriceTargetTypeProduct, TargetId: productId, SalePrice: 60 * MajorUnit}, true},
+		// a barcode of a sku is compared with the list price of the product of the sku
+		{"BarcodeWithinThreshold", Price{TargetType: PriceTargetTypeBarcode, TargetId: "2000009500011", SalePrice: 80 * MajorUnit}, false},
+		{"BarcodeBeyondThreshold", Price{TargetType: PriceTargetTypeBarcode, TargetId: "2000009500011", SalePrice: 60 * MajorUnit}, true},
+		// a barcode of no sku has no list price, its price may take off anything
+		{"UnmappedBarcode", Price{TargetType: PriceTargetTypeBarcode, TargetId: "2000009500028", SalePrice: 90 * MajorUnit}, true},
+		// 10 USD are 71 CNY, 29% off
+		{"CurrencyWithinThreshold", Price{TargetType: PriceTargetTypeProduct, TargetId: productId, Currency: "USD", SalePrice: 10 * MajorUnit}, false},
+		// 8 USD are 56.8 CNY, 43.2% off
+		{"CurrencyBeyondThreshold", Price{TargetType: PriceTargetTypeProduct, TargetId: productId, Currency: "USD", SalePrice: 8 * MajorUnit}, true},
+		{"CurrencyWithoutRate", Price{TargetType: PriceTargetTypeProduct, TargetId: productId, Currency: "EUR", SalePrice: 95 * MajorUnit}, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			p := c.price
+			test.Ok(t, p.Create(approval))
+			test.Equals(t, p.PendingChangeId != 0, c.pending)
+			test.Equals(t, p.Id == 0, c.pending)
+		})
+	}
+
+	t.Run("Discount", func(t *testing.T) {
+		discount, known, err := Price{TargetType: PriceTargetTypeProduct, TargetId: productId, Currency: "USD", SalePrice: 8 * MajorUnit}.discount(approval)
+		test.Ok(t, err)
+		test.Equals(t, known, true)
+		test.Equals(t, discount, 43.2)
+
+		_, known, err = Price{TargetType: PriceTargetTypeBarcode, TargetId: "2000009500028", SalePrice: 90 * MajorUnit}.discount(approval)
+		test.Ok(t, err)
+		test.Equals(t, known, false)
+	})
+}
+
+func TestPriceChangeVersionConflict(t *testing.T) {
+	approval := WithRoles(WithUserClaim(ctx, auth.UserClaim{TenantCode: "approval", ColleagueId: 7}), []string{RolePriceApprover})
+	created, err := Product{}.GetByCode(approval, "P950")
+	test.Ok(t, err)
+	draft := func(t *testing.T, salePrice Money, submit bool) *PriceChange {
+		c := PriceChange{Price: Price{TargetType: PriceTargetTypeProduct, TargetId: strconv.FormatInt(created.Id, 10), CustomerGroup: "review", SalePrice: salePrice}}
+		test.Ok(t, c.Create(approval, submit))
+		return &c
+	}
+
+	t.Run("SubmittedWithinThreshold", func(t *testing.T) {
+		c := draft(t, 80*MajorUnit, true)
+		test.Equals(t, c.Status, PriceChangeStatusApproved)
+		test.Equals(t, c.PriceId != 0, true)
+	})
+
+	t.Run("Submit", func(t *testing.T) {
+		c := draft(t, 60*MajorUnit, false)
+		_, err := PriceChange{}.Submit(approval, c.Id, c.Version+1)
+		test.Equals(t, err, ErrVersionConflict)
+		submitted, err := PriceChange{}.Submit(approval, c.Id, c.Version)
+		test.Ok(t, err)
+		test.Equals(t, submitted.Status, PriceChangeStatusPending)
+		_, err = PriceChange{}.Submit(approval, c.Id, c.Version)
+		test.Equals(t, err, ErrVersionConflict)
+	})
+
+	// another approver than the colleague who submitted the changes
+	reviewer := WithUserClaim(approval, auth.UserClaim{TenantCode: "approval", ColleagueId: 8})
+
+	t.Run("Approve", func(t *testing.T) {
+		c := draft(t, 60*MajorUnit, true)
+		test.Equals(t, c.Status, PriceChangeStatusPending)
+		// a reviewer who read the change before another approved it
+		stale, err := PriceChange{}.Get(approval, c.Id)
+		test.Ok(t, err)
+		approved, err := PriceChange{}.Approve(reviewer, c.Id, c.Version, "")
+		test.Ok(t, err)
+		test.Equals(t, approved.Status, PriceChangeStatusApproved)
+		test.Equals(t, approved.ReviewedBy, int64(8))
+
+		_, err = PriceChange{}.Approve(reviewer, c.Id, c.Version, "")
+		test.Equals(t, err, ErrVersionConflict)
+		stale.Status = PriceChangeStatusRejected
+		test.Equals(t, stale.update(approval, "comment"), ErrVersionConflict)
+	})
+
+	t.Run("ApproveOwn", func(t *testing.T) {
+		c := draft(t, 60*MajorUnit, true)
+		_, err := PriceChange{}.Approve(approval, c.Id, c.Version, "")
+		test.Equals(t, err, ErrPriceChangeSelfApproval)
+
+		got, err := PriceChange{}.Get(approval, c.Id)
+		test.Ok(t, err)
+		test.Equals(t, got.Status, PriceChangeStatusPending)
+		test.Equals(t, got.PriceId, int64(0))
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		c := draft(t, 60*MajorUnit, true)
+		_, err := PriceChange{}.Reject(approval, c.Id, c.Version-1, "too deep")
+		test.Equals(t, err, ErrVersionConflict)
+		rejected, err := PriceChange{}.Reject(approval, c.Id, c.Version, "too deep")
+		test.Ok(t, err)
+		test.Equals(t, rejected.Status, PriceChangeStatusRejected)
+		_, err = PriceChange{}.Approve(approval, c.Id, c.Version, "")
+		test.Equals(t, err, ErrVersionConflict)
+
+		got, err := PriceChange{}.Get(approval, c.Id)
+		test.Ok(t, err)
+		test.Equals(t, got.Status, PriceChangeStatusRejected)
+		test.Equals(t, got.PriceId, int64(0))
+	})
+}
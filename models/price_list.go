@@ -0,0 +1,252 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PriceListScope is where the prices of a price list apply.
+type PriceListScope string
+
+const (
+	PriceListScopeStore      PriceListScope = "store"
+	PriceListScopeStoreGroup PriceListScope = "store_group"
+	PriceListScopeChannel    PriceListScope = "channel"
+)
+
+// priceScopeContext carries the store and the sales channel in which prices are read.
+const priceScopeContext contextKey = "PriceScope"
+
+// WithPriceScope reads the prices with ctx in the store and the sales channel of scope.
+func WithPriceScope(ctx context.Context, scope PriceScope) context.Context {
+	return context.WithValue(ctx, priceScopeContext, scope)
+}
+
+var (
+	ErrPriceListNotFound      = errors.New("price list not found")
+	ErrInvalidPriceListScope  = errors.New("scope must be one of store, store_group, channel")
+	ErrInvalidPriceListStores = errors.New("a store price list has one store and a store group price list has at least one")
+	ErrPriceListChannelNeeded = errors.New("a channel price list needs a channel")
+	ErrPriceListCodeExists    = errors.New("price list code already exists")
+)
+
+// PriceScope is the store and the sales channel of a sale.
+type PriceScope struct {
+	StoreId int64
+	Channel string
+}
+
+// PriceList holds prices which replace the regular prices of products in a store, a group of stores or a sales channel.
+// When several lists apply, the list of the store wins over the list of a store group, which wins over the list of the channel.
+// Among lists of the same scope the higher Priority wins, then the list created last.
+// A product which has no price in effect in any of the lists keeps its regular price.
+type PriceList struct {
+	Id         int64          `json:"id"`
+	TenantCode string         `json:"-" xorm:"index varchar(16)"`
+	Code       string         `json:"code" xorm:"index varchar(64)"`
+	Name       string         `json:"name"`
+	Scope      PriceListScope `json:"scope" xorm:"index varchar(16)"`
+	StoreIds   []int64        `json:"storeIds,omitempty" xorm:"json"`
+	Channel    string         `json:"channel,omitempty" xorm:"index varchar(32)"`
+	Priority   int            `json:"priority"`
+	Enable     bool           `json:"enable" xorm:"index"`
+	CreatedAt  time.Time      `json:"createdAt" xorm:"created"`
+	UpdatedAt  time.Time      `json:"updatedAt" xorm:"updated"`
+	DeletedAt  time.Time      `json:"-" xorm:"deleted index"`
+	Version    int            `json:"version" xorm:"version"`
+}
+
+func (l *PriceList) validate(ctx context.Context) error {
+	l.Code = strings.TrimSpace(l.Code)
+	l.Channel = strings.TrimSpace(l.Channel)
+	switch l.Scope {
+	case PriceListScopeStore:
+		if len(l.StoreIds) != 1 {
+			return ErrInvalidPriceListStores
+		}
+		l.Channel = ""
+	case PriceListScopeStoreGroup:
+		if len(l.StoreIds) == 0 {
+			return ErrInvalidPriceListStores
+		}
+		l.Channel = ""
+	case PriceListScopeChannel:
+		if l.Channel == "" {
+			return ErrPriceListChannelNeeded
+		}
+		l.StoreIds = nil
+	default:
+		return ErrInvalidPriceListScope
+	}
+	db, err := tenantDB(ctx, "price_list")
+	if err != nil {
+		return err
+	}
+	exist, err := db.
+		And("code = ?", l.Code).
+		And("id <> ?", l.Id).
+		Exist(&PriceList{})
+	if err != nil {
+		return err
+	}
+	if exist {
+		return ErrPriceListCodeExists
+	}
+	return nil
+}
+
+func (l *PriceList) Create(ctx context.Context) error {
+	if err := l.validate(ctx); err != nil {
+		return err
+	}
+	if err := tenantInsert(ctx, l); err != nil {
+		return err
+	}
+	return writeAudit(ctx, AuditEntityPriceList, AuditActionCreated, nil, l.Id)
+}
+
+func (l *PriceList) Update(ctx context.Context) error {
+	current, err := PriceList{}.Get(ctx, l.Id)
+	if err != nil {
+		return err
+	}
+	if current == nil {
+		return ErrPriceListNotFound
+	}
+	if err := l.validate(ctx); err != nil {
+		return err
+	}
+	before, err := auditSnapshot(ctx, AuditEntityPriceList, l.Id)
+	if err != nil {
+		return err
+	}
+	l.TenantCode, l.CreatedAt = current.TenantCode, current.CreatedAt
+	db, err := tenantDB(ctx, "price_list")
+	if err != nil {
+		return err
+	}
+	affected, err := db.ID(l.Id).
+		Cols("code", "name", "scope", "store_ids", "channel", "priority", "enable").
+		Update(l)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	return writeAudit(ctx, AuditEntityPriceList, AuditActionUpdated, before, l.Id)
+}
+
+func (PriceList) Get(ctx context.Context, id int64) (*PriceList, error) {
+	var l PriceList
+	db, err := tenantDB(ctx, "price_list")
+	if err != nil {
+		return nil, err
+	}
+	exist, err := db.
+		And("id = ?", id).
+		Get(&l)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &l, nil
+}
+
+func (PriceList) GetAll(ctx context.Context, scope PriceListScope, skipCount, maxResultCount int) (int64, []PriceList, error) {
+	db, err := tenantDB(ctx, "price_list")
+	if err != nil {
+		return 0, nil, err
+	}
+	query := db.session()
+	if scope != "" {
+		query.And("scope = ?", scope)
+	}
+	var lists []PriceList
+	totalCount, err := query.Desc("id").Limit(maxResultCount, skipCount).FindAndCount(&lists)
+	if err != nil {
+		return 0, nil, err
+	}
+	return totalCount, lists, nil
+}
+
+func (l PriceList) rank() int {
+	switch l.Scope {
+	case PriceListScopeStore:
+		return 0
+	case PriceListScopeStoreGroup:
+		return 1
+	}
+	return 2
+}
+
+func (l PriceList) appliesTo(scope PriceScope) bool {
+	if l.Scope == PriceListScopeChannel {
+		return scope.Channel != "" && l.Channel == scope.Channel
+	}
+	for _, storeId := range l.StoreIds {
+		if scope.StoreId != 0 && storeId == scope.StoreId {
+			return true
+		}
+	}
+	return false
+}
+
+// applicablePriceLists returns the enabled lists which apply in the scope of ctx, the one which wins first.
+func applicablePriceLists(ctx context.Context) ([]PriceList, error) {
+	scope, ok := retrievePriceScope(ctx)
+	if !ok {
+		return nil, nil
+	}
+	var lists []PriceList
+	db, err := tenantDB(ctx, "price_list")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.
+		And("enable = ?", true).
+		Find(&lists); err != nil {
+		return nil, err
+	}
+	var applicable []PriceList
+	for _, l := range lists {
+		if l.appliesTo(scope) {
+			applicable = append(applicable, l)
+		}
+	}
+	sort.Slice(applicable, func(i, j int) bool {
+		a, b := applicable[i], applicable[j]
+		if a.rank() != b.rank() {
+			return a.rank() < b.rank()
+		}
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		return a.Id > b.Id
+	})
+	return applicable, nil
+}
+
+func retrievePriceScope(ctx context.Context) (PriceScope, bool) {
+	scope, ok := ctx.Value(priceScopeContext).(PriceScope)
+	if !ok || (scope.StoreId == 0 && scope.Channel == "") {
+		return PriceScope{}, false
+	}
+	return scope, true
+}
+
+// priceListPrices keeps the prices of one price list, the regular prices for id 0.
+func priceListPrices(prices []Price, priceListId int64) []Price {
+	var result []Price
+	for _, p := range prices {
+		if p.PriceListId == priceListId {
+			result = append(result, p)
+		}
+	}
+	return result
+}
@@ -2,57 +2,78 @@ package models
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/hublabs/product-api/adapters"
-	"github.com/hublabs/product-api/factory"
 
 	"github.com/go-xorm/xorm"
 )
 
 type Product struct {
-	Id           int64               `json:"id"`
-	TenantCode   string              `json:"-" xorm:"index varchar(16)"`
-	Code         string              `json:"code" xorm:"index varchar(64)"`
-	Name         string              `json:"name"`
-	BrandId      int64               `json:"-" xorm:"index"`
-	Brand        Brand               `json:"brand" xorm:"-"`
-	TitleImage   string              `json:"titleImage"`
-	ListPrice    float64             `json:"listPrice"`
-	SupGroupCode string              `json:"-"`
-	Prices       []Price             `json:"prices,omitempty" xorm:"-"`
-	Identifiers  []ProductIdentifier `json:"identifiers,omitempty" xorm:"-"`
-	Skus         []Sku               `json:"skus,omitempty" xorm:"-"`
-	Attributes   map[string]string   `json:"attributes,omitempty" xorm:"-"`
-	HasDigital   bool                `json:"hasDigital" xorm:"index"`
-	Enable       bool                `json:"enable" xorm:"index"`
-	CreatedAt    time.Time           `json:"createdAt" xorm:"created"`
-	UpdatedAt    time.Time           `json:"updatedAt" xorm:"updated"`
-	DeletedAt    time.Time           `json:"-" xorm:"deleted index"`
+	Id                 int64               `json:"id"`
+	TenantCode         string              `json:"-" xorm:"index varchar(16)"`
+	Code               string              `json:"code" xorm:"index varchar(64)"`
+	Name               string              `json:"name"`
+	BrandId            int64               `json:"-" xorm:"index"`
+	Brand              Brand               `json:"brand" xorm:"-"`
+	TitleImage         string              `json:"titleImage"`
+	ListPrice          Money               `json:"listPrice" xorm:"decimal(18,2)"`
+	CostPrice          Money               `json:"costPrice,omitempty" xorm:"decimal(18,2)"`
+	Currency           string              `json:"currency" xorm:"varchar(3)"`
+	ConvertedListPrice *ConvertedAmount    `json:"convertedListPrice,omitempty" xorm:"-"`
+	SupGroupCode       string              `json:"-"`
+	Prices             []Price             `json:"prices,omitempty" xorm:"-"`
+	Offers             []AppliedOffer      `json:"offers,omitempty" xorm:"-"`
+	OfferPrice         *Money              `json:"offerPrice,omitempty" xorm:"-"`
+	Margin             *Margin             `json:"margin,omitempty" xorm:"-"`
+	Identifiers        []ProductIdentifier `json:"identifiers,omitempty" xorm:"-"`
+	Skus               []Sku               `json:"skus,omitempty" xorm:"-"`
+	Attributes         map[string]string   `json:"attributes,omitempty" xorm:"-"`
+	HasDigital         bool                `json:"hasDigital" xorm:"index"`
+	Enable             bool                `json:"enable" xorm:"index"`
+	CreatedAt          time.Time           `json:"createdAt" xorm:"created"`
+	UpdatedAt          time.Time           `json:"updatedAt" xorm:"updated"`
+	DeletedAt          time.Time           `json:"-" xorm:"deleted index"`
+	DeleteBatch        string              `json:"-" xorm:"index varchar(32)"`
+	Version            int                 `json:"version" xorm:"version"`
 }
 
+var ErrProductDeleted = errors.New("product is deleted")
+
+// ProductImportTemplate is a row of an imported sheet.
+// SkuSalePrice is optional, it prices a sku which is not sold at the sale price of its product, e.g. a larger size.
 type ProductImportTemplate struct {
-	ProductName string  `json:"productName"`
-	ProductCode string  `json:"productCode"`
-	SkuCode     string  `json:"skuCode"`
-	SkuName     string  `json:"skuName"`
-	Color       string  `json:"color"`
-	Size        string  `json:"size"`
-	ListPrice   float64 `json:"listPrice"`
-	SalePrice   float64 `json:"salePrice"`
-	BarCode     string  `json:"barCode"`
-	ErrorList   []int   `json:"errorList"`
-	Status      string  `json:"status"`
-	BrandCode   string  `json:"brandCode"`
-	BrandName   string  `json:"brandName"`
+	ProductName  string `json:"productName"`
+	ProductCode  string `json:"productCode"`
+	SkuCode      string `json:"skuCode"`
+	SkuName      string `json:"skuName"`
+	Color        string `json:"color"`
+	Size         string `json:"size"`
+	ListPrice    Money  `json:"listPrice"`
+	SalePrice    Money  `json:"salePrice"`
+	SkuSalePrice Money  `json:"skuSalePrice"`
+	BarCode      string `json:"barCode"`
+	ErrorList    []int  `json:"errorList"`
+	Status       string `json:"status"`
+	BrandCode    string `json:"brandCode"`
+	BrandName    string `json:"brandName"`
 }
 
 // Must be private because of event ProductCreated
-func (p *Product) create(ctx context.Context) (err error) {
-	_, err = factory.DB(ctx).Insert(p)
-	return
+func (p *Product) create(ctx context.Context) error {
+	if !CostVisible(ctx) {
+		p.CostPrice = 0
+	}
+	if err := p.setCurrency(ctx); err != nil {
+		return err
+	}
+	if err := tenantInsert(ctx, p); err != nil {
+		return err
+	}
+	return writeAudit(ctx, AuditEntityProduct, AuditActionCreated, nil, p.Id)
 }
 
 // Must be private because of event ProductChanged
@@ -60,51 +81,137 @@ func (p *Product) update(ctx context.Context, hasDigital bool) (err error) {
 	cols := []string{
 		"code", "name", "list_price", "brand_id",
 	}
+	cols = append(cols, costColumns(ctx)...)
 	if p.TitleImage != "" {
 		cols = append(cols, "title_image")
 	}
 	if hasDigital {
 		cols = append(cols, "has_digital")
 	}
-	if _, err = factory.DB(ctx).ID(p.Id).Cols(cols...).Update(p); err != nil {
+	if p.Currency != "" {
+		if p.Currency, err = NormalizeCurrency(p.Currency); err != nil {
+			return err
+		}
+		cols = append(cols, "currency")
+	}
+	before, err := auditSnapshot(ctx, AuditEntityProduct, p.Id)
+	if err != nil {
+		return err
+	}
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return err
+	}
+	affected, err := db.ID(p.Id).Cols(cols...).Update(p)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	if err := writeAudit(ctx, AuditEntityProduct, AuditActionUpdated, before, p.Id); err != nil {
+		return err
+	}
+
+	if err := p.updateIdentifiers(ctx); err != nil {
 		return err
 	}
 
+	if err := (AttributeValue{}).CreateOrUpdates(ctx, p.Id, p.Attributes); err != nil {
+		return err
+	}
+
+	return p.updatePrices(ctx)
+}
+
+// setCurrency defaults the currency of the list price to the currency of the tenant.
+func (p *Product) setCurrency(ctx context.Context) (err error) {
+	if p.Currency == "" {
+		p.Currency, err = TenantCurrency{}.Get(ctx)
+		return err
+	}
+	p.Currency, err = NormalizeCurrency(p.Currency)
+	return err
+}
+
+// Must be private because of event ProductChanged
+func (p *Product) updateIdentifiers(ctx context.Context) error {
 	for i := range p.Identifiers {
 		p.Identifiers[i].ProductId = p.Id
 		if err := p.Identifiers[i].CreateOrUpdate(ctx); err != nil {
 			return err
 		}
 	}
+	return p.removeIdentifiersExcept(ctx, p.Identifiers)
+}
 
-	if err := (AttributeValue{}).CreateOrUpdates(ctx, p.Id, p.Attributes); err != nil {
+// Must be private because of event ProductChanged
+// updatePrices creates a price for every entry which is new or was changed, because prices are never updated in place.
+// An entry equal to the price in effect is not a change, but a price used before may be entered again.
+func (p *Product) updatePrices(ctx context.Context) error {
+	_, prices, err := Price{}.GetByTarget(ctx, PriceTargetTypeProduct, strconv.FormatInt(p.Id, 10), 0, 0)
+	if err != nil {
 		return err
 	}
-
-	if err := p.removeIdentifiersExcept(ctx, p.Identifiers); err != nil {
-		return err
+	prices = priceListPrices(prices, 0)
+	effective := effectivePrice(prices, time.Now())
+	currency := p.Currency
+	if currency == "" {
+		if currency, err = (TenantCurrency{}).Get(ctx); err != nil {
+			return err
+		}
 	}
-	prices, err := Price{}.GetByTarget(ctx, PriceTargetTypeProduct, strconv.FormatInt(p.Id, 10))
 
 PriceLoop:
-	for k, _ := range p.Prices {
-		for j, _ := range prices {
-			if p.Prices[k].SalePrice == prices[j].SalePrice {
-				// TODO 潜在的问题，如果price: 100 -> 200 -> 100，最后一次价格无法生成
+	for k := range p.Prices {
+		entry := p.Prices[k]
+		// prices of price lists are entered by POST /v1/prices, e.g. one read back in a store is left alone
+		if entry.PriceListId != 0 {
+			continue
+		}
+		if entry.Currency == "" {
+			entry.Currency = currency
+		}
+		price := Price{
+			TargetType: PriceTargetTypeProduct,
+			TargetId:   strconv.FormatInt(p.Id, 10),
+			SalePrice:  entry.SalePrice,
+			Currency:   entry.Currency,
+			StartAt:    entry.StartAt,
+			EndAt:      entry.EndAt,
+		}
+		for j := range prices {
+			if entry.Id != 0 && entry.Id == prices[j].Id {
+				if entry.sameSchedule(prices[j]) {
+					continue PriceLoop
+				}
+				// a changed price starts now unless it was rescheduled
+				if entry.StartAt.Equal(prices[j].StartAt) {
+					price.StartAt = time.Time{}
+				}
+			} else if entry.Id == 0 && !entry.StartAt.IsZero() && entry.sameSchedule(prices[j]) {
 				continue PriceLoop
 			}
 		}
-		p.Prices[k].TargetId = strconv.FormatInt(p.Id, 10)
-		if err := p.Prices[k].Create(ctx); err != nil {
+		if entry.Id == 0 && entry.StartAt.IsZero() && entry.EndAt == nil &&
+			effective != nil && effective.SalePrice == price.SalePrice && effective.Currency == price.Currency {
+			continue
+		}
+		if err := price.Create(ctx); err != nil {
 			return err
 		}
+		p.Prices[k] = price
 	}
 	return nil
 }
 
 func (Product) GetOne(ctx context.Context, id int64, fields FieldTypeList) (*Product, error) {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
 	var products ProductList
-	if err := factory.DB(ctx).Where("id = ?", id).Limit(1).Find(&products); err != nil {
+	if err := db.Where("id = ?", id).Limit(1).Find(&products); err != nil {
 		return nil, err
 	} else if len(products) == 0 {
 		return nil, nil
@@ -136,7 +243,11 @@ func (Product) GetOne(ctx context.Context, id int64, fields FieldTypeList) (*Pro
 }
 
 func (Product) GetAll(ctx context.Context, q, hasDigital, hasTitleImage, brandCode, enable string, codes []string, ids, brandIds []int64, skipCount, maxResultCount int, sortby, order []string, fields FieldTypeList, withHasMore bool) (bool, int64, []Product, error) {
-	query := factory.DB(ctx).Where("tenant_code = ?", tenantCode(ctx))
+	scope, err := tenantDB(ctx, "product")
+	if err != nil {
+		return false, 0, nil, err
+	}
+	query := scope.session()
 	if len(sortby) == 0 || len(order) == 0 {
 		sortby = []string{"id"}
 		order = []string{"desc"}
@@ -154,7 +265,7 @@ func (Product) GetAll(ctx context.Context, q, hasDigital, hasTitleImage, brandCo
 	}
 
 	if brandCode != "" {
-		query.Where("product.brand_id IN (SELECT id FROM brand WHERE brand.code = ?)", brandCode)
+		query.Where("product.brand_id IN (SELECT id FROM brand WHERE brand.code = ? AND brand.tenant_code = ?)", brandCode, tenantCode(ctx))
 	}
 
 	if hasDigital == "has" {
@@ -186,7 +297,6 @@ func (Product) GetAll(ctx context.Context, q, hasDigital, hasTitleImage, brandCo
 		products   ProductList
 		hasMore    bool
 		totalCount int64
-		err        error
 	)
 
 	if withHasMore {
@@ -234,9 +344,13 @@ func (Product) GetAll(ctx context.Context, q, hasDigital, hasTitleImage, brandCo
 }
 
 func (Product) CreateOrUpdate(ctx context.Context, product Product) (*Product, error) {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
 	var p Product
 	product.TenantCode = tenantCode(ctx)
-	exist, err := factory.DB(ctx).Where("id = ?", product.Id).Get(&p)
+	exist, err := db.Where("id = ?", product.Id).Get(&p)
 	if err != nil {
 		return nil, err
 	}
@@ -258,6 +372,9 @@ func (Product) CreateOrUpdate(ctx context.Context, product Product) (*Product, e
 		}
 		for k := range product.Prices {
 			product.Prices[k].TargetId = strconv.FormatInt(product.Id, 10)
+			if product.Prices[k].Currency == "" {
+				product.Prices[k].Currency = product.Currency
+			}
 			if err := product.Prices[k].Create(ctx); err != nil {
 				return nil, err
 			}
@@ -268,54 +385,98 @@ func (Product) CreateOrUpdate(ctx context.Context, product Product) (*Product, e
 				return nil, err
 			}
 		}
-		if err := (adapters.MessagePublisher{}).Publish(ctx, product, adapters.EventProductCreated); err != nil {
+		if err := publishEvent(ctx, product, adapters.EventProductCreated); err != nil {
 			return nil, err
 		}
 		return &product, nil
 	}
 
+	if product.Currency == "" {
+		product.Currency = p.Currency
+	}
 	if err := product.update(ctx, true); err != nil {
 		return nil, err
 	}
-	var skus []Sku
-	if err := factory.DB(ctx).Where("product_id = ?", product.Id).Find(&skus); err != nil {
+	if err := product.updateSkus(ctx); err != nil {
 		return nil, err
 	}
+	if err := publishEvent(ctx, product, adapters.EventProductChanged); err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+// Must be private because of event ProductChanged
+func (p *Product) updateSkus(ctx context.Context) error {
+	var skus []Sku
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return err
+	}
+	if err := db.Where("product_id = ?", p.Id).Find(&skus); err != nil {
+		return err
+	}
 SkuLoop:
-	for i, sku := range product.Skus {
+	for i, sku := range p.Skus {
 		sku.ProductId = p.Id
 		for j := range skus {
 			if sku.Id == skus[j].Id {
 				if err := sku.Update(ctx); err != nil {
-					return nil, err
+					return err
 				}
+				p.Skus[i].Version = sku.Version
 				continue SkuLoop
 			}
 		}
 		if err := sku.Create(ctx); err != nil {
-			return nil, nil
+			return err
 		}
-		product.Skus[i].Id = sku.Id
+		p.Skus[i].Id = sku.Id
 	}
-	if err := p.removeSkusExcept(ctx, product.Skus); err != nil {
-		return nil, err
+	return p.removeSkusExcept(ctx, p.Skus)
+}
+
+// touch moves the version of a product on, for a change of its representation which is not a write to its row.
+func (Product) touch(ctx context.Context, id int64) error {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return err
 	}
-	if err := (adapters.MessagePublisher{}).Publish(ctx, product, adapters.EventProductChanged); err != nil {
-		return nil, err
+	var p Product
+	exist, err := db.Where("id = ?", id).Get(&p)
+	if err != nil || !exist {
+		return err
 	}
-	return &product, nil
+	_, err = db.ID(id).Cols("updated_at").Update(&p)
+	return err
+}
+
+func (Product) Exist(ctx context.Context, id int64) (bool, error) {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return false, err
+	}
+	return db.Where("id = ?", id).Exist(&Product{})
 }
 
 // 不删除以前Product下的Sku而现在不存在的数据
 func (Product) GetByCode(ctx context.Context, code string) (*Product, error) {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
 	var p Product
-	_, err := factory.DB(ctx).Where("code = ?", code).Get(&p)
+	_, err = db.Where("code = ?", code).Get(&p)
 	return &p, err
 }
 
 func (Product) GetByIdentifier(ctx context.Context, identifier, source string, brandId int64) (*Product, bool, error) {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, false, err
+	}
 	var p Product
-	exist, err := factory.DB(ctx).Where("product.id in (SELECT product_id from product_identifier WHERE uid = ? AND source = ?)", identifier, source).And("brand_id = ?", brandId).Get(&p)
+	exist, err := db.Where("product.id in (SELECT product_id from product_identifier WHERE uid = ? AND source = ?)", identifier, source).And("brand_id = ?", brandId).Get(&p)
 	if err != nil {
 		return nil, false, err
 	}
@@ -330,7 +491,11 @@ func (p Product) removeIdentifiersExcept(ctx context.Context, except []ProductId
 	for _, identifier := range except {
 		exceptIdentifilerIds = append(exceptIdentifilerIds, identifier.Id)
 	}
-	_, err = factory.DB(ctx).Where("product_id = ?", p.Id).NotIn("id", exceptIdentifilerIds).Delete(&ProductIdentifier{})
+	db, err := tenantChildDB(ctx, "product_identifier", "product_id", "product")
+	if err != nil {
+		return err
+	}
+	_, err = db.Unscoped().Where("product_id = ?", p.Id).NotIn("id", exceptIdentifilerIds).Delete(&ProductIdentifier{})
 	return
 }
 
@@ -340,30 +505,136 @@ func (p Product) removeSkusExcept(ctx context.Context, except []Sku) error {
 		exceptSkuIds = append(exceptSkuIds, s.Id)
 	}
 
-	var removeSkuIds []int64
-	if err := factory.DB(ctx).Table("sku").Select("id").
-		Where("product_id = ?", p.Id).NotIn("id", exceptSkuIds).
-		Find(&removeSkuIds); err != nil {
+	var removeSkus []Sku
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return err
+	}
+	if err := db.Where("product_id = ?", p.Id).NotIn("id", exceptSkuIds).
+		Find(&removeSkus); err != nil {
 		return err
 	}
 
-	if len(removeSkuIds) == 0 {
+	if len(removeSkus) == 0 {
 		return nil
 	}
 
-	if _, err := factory.DB(ctx).In("id", removeSkuIds).Delete(&Sku{}); err != nil {
+	skus := SkuList(removeSkus)
+	if err := skus.LoadIdentifiers(ctx); err != nil {
 		return err
 	}
-	if _, err := factory.DB(ctx).In("sku_id", removeSkuIds).Delete(&Option{}); err != nil {
+	if err := skus.LoadOptions(ctx); err != nil {
 		return err
 	}
-	if _, err := factory.DB(ctx).In("sku_id", removeSkuIds).Delete(&SkuIdentifier{}); err != nil {
+
+	d, err := newDeletion()
+	if err != nil {
 		return err
 	}
+	for i := range removeSkus {
+		if err := removeSkus[i].delete(ctx, d); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
+func (Product) Delete(ctx context.Context, id int64) (*Product, error) {
+	product, err := Product{}.GetOne(ctx, id, FieldTypeList{FieldTypeAttribute})
+	if err != nil {
+		return nil, err
+	}
+	if product == nil || product.TenantCode != tenantCode(ctx) {
+		return nil, nil
+	}
+
+	d, err := newDeletion()
+	if err != nil {
+		return nil, err
+	}
+	for i := range product.Skus {
+		if err := product.Skus[i].delete(ctx, d); err != nil {
+			return nil, err
+		}
+	}
+	if err := softDelete(ctx, &ProductIdentifier{DeletedAt: d.At, DeleteBatch: d.Batch}, "product_id = ?", product.Id); err != nil {
+		return nil, err
+	}
+	if err := softDelete(ctx, &AttributeValue{DeletedAt: d.At, DeleteBatch: d.Batch}, "product_id = ?", product.Id); err != nil {
+		return nil, err
+	}
+	if err := softDelete(ctx, &Price{DeletedAt: d.At, DeleteBatch: d.Batch}, "target_type = ? AND target_id = ?", PriceTargetTypeProduct, strconv.FormatInt(product.Id, 10)); err != nil {
+		return nil, err
+	}
+	if err := softDelete(ctx, &Product{DeletedAt: d.At, DeleteBatch: d.Batch, Version: product.Version}, "id = ?", product.Id); err != nil {
+		return nil, err
+	}
+
+	if err := publishEvent(ctx, *product, adapters.EventProductDeleted); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
+// Restore brings back the product together with the skus, identifiers, attributes and prices
+// which were removed when the product was deleted. Skus deleted on their own before stay deleted.
+func (Product) Restore(ctx context.Context, id int64) (*Product, error) {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
+	var p Product
+	exist, err := db.Unscoped().Where("id = ?", id).Get(&p)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	if p.DeletedAt.IsZero() {
+		return Product{}.GetOne(ctx, id, FieldTypeList{FieldTypeAttribute})
+	}
+
+	d := deletion{At: p.DeletedAt, Batch: p.DeleteBatch}
+	if db, err = tenantDB(ctx, "sku"); err != nil {
+		return nil, err
+	}
+	var skus []Sku
+	if err := db.Unscoped().Where("product_id = ?", p.Id).Find(&skus); err != nil {
+		return nil, err
+	}
+	for i := range skus {
+		if !d.removed(skus[i].DeletedAt, skus[i].DeleteBatch) {
+			continue
+		}
+		if err := skus[i].restore(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := restoreDeleted(ctx, &ProductIdentifier{}, d, "product_id = ?", p.Id); err != nil {
+		return nil, err
+	}
+	if err := restoreDeleted(ctx, &AttributeValue{}, d, "product_id = ?", p.Id); err != nil {
+		return nil, err
+	}
+	if err := restoreDeleted(ctx, &Price{}, d, "target_type = ? AND target_id = ?", PriceTargetTypeProduct, strconv.FormatInt(p.Id, 10)); err != nil {
+		return nil, err
+	}
+	if err := restoreDeleted(ctx, &Product{Version: p.Version}, d, "id = ?", p.Id); err != nil {
+		return nil, err
+	}
+
+	product, err := Product{}.GetOne(ctx, id, FieldTypeList{FieldTypeAttribute})
+	if err != nil {
+		return nil, err
+	}
+	if err := publishEvent(ctx, *product, adapters.EventProductRestored); err != nil {
+		return nil, err
+	}
+	return product, nil
+}
+
 type ProductList []Product
 
 func (products ProductList) Ids() (ids []interface{}) {
@@ -384,7 +655,11 @@ func (products ProductList) Find(id int64) *Product {
 
 func (products ProductList) LoadSkus(ctx context.Context) error {
 	var skus SkuList
-	if err := factory.DB(ctx).In("product_id", products.Ids()...).Find(&skus); err != nil {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return err
+	}
+	if err := db.In("product_id", products.Ids()...).Find(&skus); err != nil {
 		return err
 	}
 
@@ -411,14 +686,30 @@ func (products ProductList) LoadSkus(ctx context.Context) error {
 }
 
 func (products ProductList) LoadPrices(ctx context.Context) error {
+	db, err := tenantDB(ctx, "price")
+	if err != nil {
+		return err
+	}
+	query := db.session()
+	at, past := ctx.Value(priceAsOfContext).(time.Time)
+	if past {
+		// the prices deleted with their product since still answer for the time asked for
+		query = query.Unscoped()
+	}
 	var prices []Price
-	if err := factory.DB(ctx).
+	if err := query.
 		Where("target_type = ?", PriceTargetTypeProduct).
 		In("target_id", products.Ids()...).
 		Desc("id").
 		Find(&prices); err != nil {
 		return err
 	}
+	if past {
+		prices = livePricesAt(prices, at)
+	}
+	if err := fillCurrency(ctx, prices); err != nil {
+		return err
+	}
 	for _, price := range prices {
 		i, err := strconv.ParseInt(price.TargetId, 10, 64)
 		if err != nil {
@@ -433,22 +724,68 @@ func (products ProductList) LoadPrices(ctx context.Context) error {
 		}
 	}
 
+	lists, err := applicablePriceLists(ctx)
+	if err != nil {
+		return err
+	}
+	levels := priceLevels(lists)
+	asOf := retrievePriceAsOf(ctx)
 	for i := range products {
-		if len(products[i].Prices) == 0 {
-			products[i].Prices = []Price{
-				{SalePrice: products[i].ListPrice},
+		if products[i].Currency == "" {
+			if err := products[i].setCurrency(ctx); err != nil {
+				return err
 			}
+		}
+		if price := resolvePrice(products[i].Prices, levels, asOf); price != nil {
+			products[i].Prices = []Price{*price}
 		} else {
-			products[i].Prices = []Price{products[i].Prices[0]}
+			products[i].Prices = []Price{
+				{SalePrice: products[i].ListPrice, Currency: products[i].Currency},
+			}
 		}
+		if price := products[i].Prices[0]; price.Currency == products[i].Currency {
+			products[i].Margin = newMargin(products[i].CostPrice, price.SalePrice)
+		}
+	}
+
+	if err := products.convertPrices(ctx); err != nil {
+		return err
 	}
+	return products.loadOffers(ctx)
+}
 
+// convertPrices converts list prices and sale prices into the currency asked for by the reader,
+// which are ConvertedListPrice and Price.Converted.
+func (products ProductList) convertPrices(ctx context.Context) error {
+	currency := retrievePriceCurrency(ctx)
+	if currency == "" {
+		return nil
+	}
+	rates, err := loadExchangeRates(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		p := &products[i]
+		if p.ConvertedListPrice, err = rates.convert(p.ListPrice, p.Currency, currency); err != nil {
+			return err
+		}
+		for k := range p.Prices {
+			if p.Prices[k].Converted, err = rates.convert(p.Prices[k].SalePrice, p.Prices[k].Currency, currency); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
 func (products ProductList) LoadIdentifiers(ctx context.Context) error {
 	var identifiers []ProductIdentifier
-	if err := factory.DB(ctx).In("product_id", products.Ids()...).Find(&identifiers); err != nil {
+	db, err := tenantChildDB(ctx, "product_identifier", "product_id", "product")
+	if err != nil {
+		return err
+	}
+	if err := db.In("product_id", products.Ids()...).Find(&identifiers); err != nil {
 		return err
 	}
 	for _, identifier := range identifiers {
@@ -467,7 +804,11 @@ func (products ProductList) LoadBrands(ctx context.Context) error {
 	}
 
 	var brands []Brand
-	if err := factory.DB(ctx).In("id", brandIds...).Find(&brands); err != nil {
+	db, err := tenantDB(ctx, "brand")
+	if err != nil {
+		return err
+	}
+	if err := db.In("id", brandIds...).Find(&brands); err != nil {
 		return err
 	}
 
@@ -492,7 +833,11 @@ func (products ProductList) LoadBrands(ctx context.Context) error {
 
 func (product *Product) LoadAttributes(ctx context.Context) error {
 	var attrExtends []AttributeExtends
-	if err := factory.DB(ctx).Table("attribute_value").Select("attribute.*, attribute_value.*").
+	db, err := tenantChildDB(ctx, "attribute_value", "product_id", "product")
+	if err != nil {
+		return err
+	}
+	if err := db.Table("attribute_value").Select("attribute.*, attribute_value.*").
 		Join("INNER", "attribute", "attribute_value.attribute_id = attribute.id").
 		Where("attribute_value.product_id = ?", product.Id).Find(&attrExtends); err != nil {
 		return err
@@ -506,7 +851,11 @@ func (product *Product) LoadAttributes(ctx context.Context) error {
 
 func (products ProductList) LoadAttributes(ctx context.Context) error {
 	var attrExtends []AttributeExtends
-	if err := factory.DB(ctx).Table("attribute_value").Select("attribute.*, attribute_value.*").
+	db, err := tenantChildDB(ctx, "attribute_value", "product_id", "product")
+	if err != nil {
+		return err
+	}
+	if err := db.Table("attribute_value").Select("attribute.*, attribute_value.*").
 		Join("INNER", "attribute", "attribute_value.attribute_id = attribute.id").
 		In("attribute_value.product_id", products.Ids()...).Find(&attrExtends); err != nil {
 		return err
@@ -525,7 +874,11 @@ func (products ProductList) LoadAttributes(ctx context.Context) error {
 }
 
 func (Product) SearchAll(ctx context.Context, q, enable string, filter Filter, skipCount, maxResultCount int, sortby, order []string, fields FieldTypeList, withHasMore bool) (bool, int64, []Product, error) {
-	query := factory.DB(ctx).Where("tenant_code = ?", tenantCode(ctx))
+	scope, err := tenantDB(ctx, "product")
+	if err != nil {
+		return false, 0, nil, err
+	}
+	query := scope.session()
 	if q != "" {
 		query.Where("code LIKE ?", q+"%")
 	}
@@ -539,7 +892,6 @@ func (Product) SearchAll(ctx context.Context, q, enable string, filter Filter, s
 		products   ProductList
 		hasMore    bool
 		totalCount int64
-		err        error
 	)
 
 	if len(sortby) == 0 || len(order) == 0 {
@@ -597,22 +949,27 @@ func (Product) SearchAll(ctx context.Context, q, enable string, filter Filter, s
 
 func filterQuery(query *xorm.Session, filter Filter) {
 	condQuery := func(c ComparerType, v []string, conditionType string) (string, string, []interface{}) {
-		var isNum bool
-		if conditionType == ConditionTypeListPrice || conditionType == ConditionTypeProduct {
-			isNum = true
+		appendArgs := func(args []interface{}, ts ...string) []interface{} {
+			switch conditionType {
+			case ConditionTypeListPrice:
+				return appendMoneyArgs(args, ts...)
+			case ConditionTypeProduct:
+				return appendStrArgs(args, true, ts...)
+			}
+			return appendStrArgs(args, false, ts...)
 		}
 		var args []interface{}
 		switch c {
 		case ComparerTypeNotInclude:
-			return "NOT EXISTS", fmt.Sprintf("IN (%s)", placeholder(len(v))), appendStrArgs(args, isNum, v...)
+			return "NOT EXISTS", fmt.Sprintf("IN (%s)", placeholder(len(v))), appendArgs(args, v...)
 		case ComparerTypeGreaterThanEqual:
-			return "IN", ">= ?", appendStrArgs(args, isNum, v[0])
+			return "IN", ">= ?", appendArgs(args, v[0])
 		case ComparerTypeLessThanEqual:
-			return "IN", "<= ?", appendStrArgs(args, isNum, v[0])
+			return "IN", "<= ?", appendArgs(args, v[0])
 		case ComparerTypeBetween:
-			return "IN", "BETWEEN ? AND ?", appendStrArgs(args, isNum, v[0], v[1])
+			return "IN", "BETWEEN ? AND ?", appendArgs(args, v[0], v[1])
 		default:
-			return "IN", fmt.Sprintf("IN (%s)", placeholder(len(v))), appendStrArgs(args, isNum, v...)
+			return "IN", fmt.Sprintf("IN (%s)", placeholder(len(v))), appendArgs(args, v...)
 		}
 	}
 
@@ -626,7 +983,7 @@ func filterQuery(query *xorm.Session, filter Filter) {
 			if v.Comparer == ComparerTypeNotInclude {
 				query.And(fmt.Sprintf(`%v (SELECT 1 FROM brand WHERE product.brand_id = brand.id AND brand.code %v)`, keyword, clause), args...)
 			} else {
-				query.And(fmt.Sprintf(`product.brand_id %v (SELECT id FROM brand WHERE brand.code %v)`, keyword, clause), args...)
+				query.And(fmt.Sprintf(`product.brand_id %v (SELECT id FROM brand WHERE brand.tenant_code = product.tenant_code AND brand.code %v)`, keyword, clause), args...)
 			}
 		case ConditionTypeListPrice:
 			if v.Comparer == ComparerTypeNotInclude {
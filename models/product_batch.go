@@ -5,7 +5,6 @@ import (
 	"strconv"
 
 	"github.com/hublabs/product-api/adapters"
-	"github.com/hublabs/product-api/factory"
 )
 
 func (Product) CreateOrUpdateByCode(ctx context.Context, product Product) (*Product, error) {
@@ -32,6 +31,9 @@ func (Product) CreateOrUpdateByCode(ctx context.Context, product Product) (*Prod
 		}
 		for k := range product.Prices {
 			product.Prices[k].TargetId = strconv.FormatInt(product.Id, 10)
+			if product.Prices[k].Currency == "" {
+				product.Prices[k].Currency = product.Currency
+			}
 			if err := product.Prices[k].Create(ctx); err != nil {
 				return nil, err
 			}
@@ -41,13 +43,17 @@ func (Product) CreateOrUpdateByCode(ctx context.Context, product Product) (*Prod
 			if err := product.Skus[j].Create(ctx); err != nil {
 				return nil, err
 			}
+			if err := product.Skus[j].importPrice(ctx, product.Currency); err != nil {
+				return nil, err
+			}
 		}
-		if err := (adapters.MessagePublisher{}).Publish(ctx, product, adapters.EventProductCreated); err != nil {
+		if err := publishEvent(ctx, product, adapters.EventProductCreated); err != nil {
 			return nil, err
 		}
 		return &product, nil
 	}
 	product.Id = p.Id
+	product.Version = p.Version
 	if product.BrandId == 0 && p.BrandId != 0 {
 		product.BrandId = p.BrandId
 		brand, err := Brand{}.GetById(ctx, p.BrandId)
@@ -70,15 +76,31 @@ SkuLoop:
 		for j := range skus {
 			if sku.Code == skus[j].Code {
 				sku.Id = skus[j].Id
+				sku.Version = skus[j].Version
 				product.Skus[i].Id = skus[j].Id
 				//如果skuCode存在，只更新sku的名字和option,identifiers
-				if _, err = factory.DB(ctx).ID(sku.Id).Cols("name").Update(&sku); err != nil {
+				before, err := auditSnapshot(ctx, AuditEntitySku, sku.Id)
+				if err != nil {
+					return nil, err
+				}
+				db, err := tenantDB(ctx, "sku")
+				if err != nil {
+					return nil, err
+				}
+				if _, err = db.ID(sku.Id).Cols("name").Update(&sku); err != nil {
+					return nil, err
+				}
+				if err := writeAudit(ctx, AuditEntitySku, AuditActionUpdated, before, sku.Id); err != nil {
 					return nil, err
 				}
 				for _, identifier := range product.Skus[i].Identifiers {
 					identifier.SkuId = product.Skus[i].Id
+					db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+					if err != nil {
+						return nil, err
+					}
 					var d SkuIdentifier
-					exist, err := factory.DB(ctx).Where("uid = ?", identifier.Uid).Get(&d)
+					exist, err := db.Where("uid = ?", identifier.Uid).Get(&d)
 					if err != nil {
 						return nil, err
 					}
@@ -99,6 +121,9 @@ SkuLoop:
 						return nil, err
 					}
 				}
+				if err := product.Skus[i].importPrice(ctx, product.Currency); err != nil {
+					return nil, err
+				}
 				continue SkuLoop
 			}
 		}
@@ -106,15 +131,32 @@ SkuLoop:
 			return nil, nil
 		}
 		product.Skus[i].Id = sku.Id
+		if err := product.Skus[i].importPrice(ctx, product.Currency); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := (adapters.MessagePublisher{}).Publish(ctx, product, adapters.EventProductChanged); err != nil {
+	if err := publishEvent(ctx, product, adapters.EventProductChanged); err != nil {
 		return nil, err
 	}
 	return &product, nil
 }
 
-func (ProductImportTemplate) BatchImport(ctx context.Context, list []ProductImportTemplate) ([]Product, error) {
+// importPrice enters the sale price imported for the sku, in the currency of its product.
+func (s *Sku) importPrice(ctx context.Context, currency string) error {
+	if s.Price == nil {
+		return nil
+	}
+	price := *s.Price
+	if price.Currency == "" {
+		price.Currency = currency
+	}
+	return s.updatePrice(ctx, price)
+}
+
+// BatchImport creates or updates the products of the rows. With generateBarcodes, a row without a barcode
+// whose sku has none yet gets one issued by the barcode allocator of the tenant.
+func (ProductImportTemplate) BatchImport(ctx context.Context, list []ProductImportTemplate, generateBarcodes bool) ([]Product, error) {
 	var products []Product
 	tenantCode := tenantCode(ctx)
 	for i := range list {
@@ -126,6 +168,11 @@ func (ProductImportTemplate) BatchImport(ctx context.Context, list []ProductImpo
 		if err != nil {
 			return nil, err
 		}
+		if generateBarcodes && list[i].BarCode == "" {
+			if err := list[i].generateBarcode(ctx, brand.Id); err != nil {
+				return nil, err
+			}
+		}
 		p, err := list[i].ToProduct(tenantCode, brand.Id)
 		if err != nil {
 			return nil, err
@@ -140,6 +187,29 @@ func (ProductImportTemplate) BatchImport(ctx context.Context, list []ProductImpo
 	return products, nil
 }
 
+func (p *ProductImportTemplate) generateBarcode(ctx context.Context, brandId int64) error {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return err
+	}
+	count, err := db.
+		Join("INNER", "sku", "sku.id = sku_identifier.sku_id").
+		Join("INNER", "product", "product.id = sku.product_id").
+		And("product.brand_id = ?", brandId).
+		And("sku.code = ?", p.SkuCode).
+		And("sku_identifier.source = ?", IdentifierSourceBarcode).
+		Count(&SkuIdentifier{})
+	if err != nil || count != 0 {
+		return err
+	}
+	code, err := unusedBarcode(ctx)
+	if err != nil {
+		return err
+	}
+	p.BarCode = code
+	return nil
+}
+
 func (ProductImportTemplate) ValidateImport(ctx context.Context, list []ProductImportTemplate) ([]ProductImportTemplate, error) {
 ProductLoop:
 	for i := range list {
@@ -148,10 +218,14 @@ ProductLoop:
 			Sku     Sku     `xorm:"extends"`
 		}
 		if list[i].BrandCode != "" {
-			if err := factory.DB(ctx).Table("product").Select("product.*,sku.*").
+			db, err := tenantDB(ctx, "product")
+			if err != nil {
+				return list, err
+			}
+			if err := db.Table("product").Select("product.*,sku.*").
 				Join("left", "brand", "brand.id = product.brand_id").
 				Join("left", "sku", "sku.product_id = product.id").
-				Where("sku.code = ?", list[i].SkuCode).
+				And("sku.code = ?", list[i].SkuCode).
 				And("brand.code = ?", list[i].BrandCode).Find(&productList); err != nil {
 				return list, err
 			}
@@ -180,16 +254,23 @@ func (Product) StatisticsData(ctx context.Context) (interface{}, error) {
 		BrandName string `json:"brandName" xorm:"brandName"`
 		Count     int    `json:"count" xorm:"cnt"`
 	}
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
 	var list []Data
-	if err := factory.DB(ctx).Table("product").Select("brand.code AS brandCode, brand.name AS brandName, COUNT(*) AS cnt").
+	if err := db.Table("product").Select("brand.code AS brandCode, brand.name AS brandName, COUNT(*) AS cnt").
 		Join("INNER", "brand", "brand.id = product.brand_id").
-		Where(excludeDeleted("product")).
+		And("(" + excludeDeleted("product") + ")").
 		GroupBy("brand.code, brand.name").
 		Find(&list); err != nil {
 		return nil, err
 	}
 
-	count, err := factory.DB(ctx).Count(Product{})
+	if db, err = tenantDB(ctx, "product"); err != nil {
+		return nil, err
+	}
+	count, err := db.Count(Product{})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hublabs/product-api/adapters"
+)
+
+// productPatchColumns maps patchable json fields of Product to their columns.
+var productPatchColumns = map[string]string{
+	"code":       "code",
+	"name":       "name",
+	"titleImage": "title_image",
+	"listPrice":  "list_price",
+	"currency":   "currency",
+	"hasDigital": "has_digital",
+	"enable":     "enable",
+	"brand":      "brand_id",
+}
+
+var productReadonlyFields = []string{"id", "createdAt", "updatedAt", "version"}
+
+// Patch applies a RFC 7396 merge patch or a RFC 6902 json patch to the product representation,
+// and writes only the fields and child collections touched by the patch.
+// version must be the version the patch was made against.
+func (Product) Patch(ctx context.Context, id int64, version int, patchType PatchType, patch []byte) (*Product, error) {
+	current, err := Product{}.GetOne(ctx, id, FieldTypeList{FieldTypeAttribute})
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.TenantCode != tenantCode(ctx) {
+		return nil, nil
+	}
+	if current.Version != version {
+		return nil, ErrVersionConflict
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := applyPatch(doc, patchType, patch)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := changedFields(doc, patched)
+	if err != nil {
+		return nil, err
+	}
+
+	var product Product
+	if err := json.Unmarshal(patched, &product); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	product.Id = current.Id
+	product.TenantCode = current.TenantCode
+	product.Version = current.Version
+	product.BrandId = product.Brand.Id
+	if product.Currency != "" {
+		if product.Currency, err = NormalizeCurrency(product.Currency); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+	}
+
+	// the whole patched document is checked before anything is written,
+	// so that a patch rejected for one field changes none of the others
+	var cols []string
+	for _, field := range fields {
+		for _, readonly := range productReadonlyFields {
+			if field == readonly {
+				return nil, fmt.Errorf("%w: %s is readonly", ErrInvalidPatch, field)
+			}
+		}
+		if col, ok := productPatchColumns[field]; ok {
+			cols = append(cols, col)
+			continue
+		}
+		switch field {
+		case "costPrice":
+			if !CostVisible(ctx) {
+				return nil, fmt.Errorf("%w: %s needs the %s role", ErrInvalidPatch, field, RoleFinance)
+			}
+			cols = append(cols, "cost_price")
+		case "identifiers", "attributes", "prices", "skus":
+		default:
+			return nil, fmt.Errorf("%w: unknown field %s", ErrInvalidPatch, field)
+		}
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "identifiers":
+			if err := product.updateIdentifiers(ctx); err != nil {
+				return nil, err
+			}
+		case "attributes":
+			attributes := make(map[string]string)
+			for k, v := range product.Attributes {
+				attributes[k] = v
+			}
+			if err := (AttributeValue{}).CreateOrUpdates(ctx, product.Id, attributes); err != nil {
+				return nil, err
+			}
+		case "prices":
+			if err := product.updatePrices(ctx); err != nil {
+				return nil, err
+			}
+		case "skus":
+			if err := product.updateSkus(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(fields) == 0 {
+		return current, nil
+	}
+
+	// the product row is always touched, so that the version moves on when only child collections were patched
+	before, err := auditSnapshot(ctx, AuditEntityProduct, product.Id)
+	if err != nil {
+		return nil, err
+	}
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return nil, err
+	}
+	affected, err := db.ID(product.Id).Cols(append(cols, "updated_at")...).Update(&product)
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrVersionConflict
+	}
+	if err := writeAudit(ctx, AuditEntityProduct, AuditActionUpdated, before, product.Id); err != nil {
+		return nil, err
+	}
+	result, err := Product{}.GetOne(ctx, id, FieldTypeList{FieldTypeAttribute})
+	if err != nil {
+		return nil, err
+	}
+	if err := publishEvent(ctx, productChange{Product: *result, ChangedFields: fields}, adapters.EventProductChanged); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
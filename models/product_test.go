@@ -1,7 +1,15 @@
 package models
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
 	"testing"
+	"time"
+
+	"github.com/hublabs/product-api/adapters"
+	"github.com/hublabs/product-api/factory"
 
 	"github.com/pangpanglabs/goutils/test"
 )
@@ -43,5 +51,319 @@ func TestProductCRUD(t *testing.T) {
 		c, err := Product{}.GetOne(ctx, product.Id, nil)
 		test.Ok(t, err)
 		test.Equals(t, c.Name, "product#1-2")
+		test.Equals(t, c.Version, product.Version+1)
+	})
+	t.Run("UpdateConflict", func(t *testing.T) {
+		product.Name = "product#1-stale"
+		_, err := Product{}.CreateOrUpdate(ctx, product)
+		test.Equals(t, errors.Is(err, ErrVersionConflict), true)
+	})
+	t.Run("PatchVersion", func(t *testing.T) {
+		c, err := Product{}.GetOne(ctx, product.Id, nil)
+		test.Ok(t, err)
+
+		_, err = Product{}.Patch(ctx, product.Id, product.Version, PatchTypeMergePatch, []byte(`{"attributes":{"Year":"2020"}}`))
+		test.Equals(t, errors.Is(err, ErrVersionConflict), true)
+
+		p, err := Product{}.Patch(ctx, product.Id, c.Version, PatchTypeMergePatch, []byte(`{"attributes":{"Year":"2020"}}`))
+		test.Ok(t, err)
+		test.Equals(t, p.Attributes["Year"], "2020")
+		// the version moves on even if only a child collection was patched
+		test.Equals(t, p.Version, c.Version+1)
+	})
+}
+
+func TestProductDeleteRestore(t *testing.T) {
+	p := Product{
+		Code: "P101",
+		Name: "product#101",
+		Skus: []Sku{
+			{Code: "S101", Identifiers: []SkuIdentifier{{Uid: "2000001010013", Source: IdentifierSourceBarcode}}},
+			{Code: "S102", Identifiers: []SkuIdentifier{{Uid: "2000001020012", Source: IdentifierSourceBarcode}}},
+		},
+		Attributes: map[string]string{"Year": "2020"},
+		Prices:     []Price{{TargetType: PriceTargetTypeProduct, SalePrice: 90 * MajorUnit}},
+	}
+	created, err := Product{}.CreateOrUpdate(ctx, p)
+	test.Ok(t, err)
+	productId, skuId := created.Id, created.Skus[1].Id
+
+	t.Run("DeleteSku", func(t *testing.T) {
+		s, err := Sku{}.Delete(ctx, skuId)
+		test.Ok(t, err)
+		test.Equals(t, s.Code, "S102")
+
+		s, err = Sku{}.GetOne(ctx, skuId, nil)
+		test.Ok(t, err)
+		test.Equals(t, s == nil, true)
+	})
+
+	// the sku deleted on its own is likely deleted in the same second as the product
+	t.Run("DeleteProduct", func(t *testing.T) {
+		deleted, err := Product{}.Delete(ctx, productId)
+		test.Ok(t, err)
+		test.Equals(t, deleted.Code, "P101")
+
+		c, err := Product{}.GetOne(ctx, productId, nil)
+		test.Ok(t, err)
+		test.Equals(t, c == nil, true)
+
+		exist, _, err := SkuIdentifier{}.GetByUidAndSource(ctx, "2000001010013", IdentifierSourceBarcode)
+		test.Ok(t, err)
+		test.Equals(t, exist, false)
+	})
+
+	t.Run("RestoreProduct", func(t *testing.T) {
+		restored, err := Product{}.Restore(ctx, productId)
+		test.Ok(t, err)
+		test.Equals(t, restored.Attributes["Year"], "2020")
+		test.Equals(t, restored.Prices[0].SalePrice, 90*MajorUnit)
+		// the sku deleted on its own is not restored with the product
+		test.Equals(t, len(restored.Skus), 1)
+		test.Equals(t, restored.Skus[0].Code, "S101")
+		test.Equals(t, restored.Skus[0].Identifiers[0].Uid, "02000001010013")
+	})
+
+	t.Run("RestoreSku", func(t *testing.T) {
+		s, err := Sku{}.Restore(ctx, skuId)
+		test.Ok(t, err)
+		test.Equals(t, s.Code, "S102")
+		test.Equals(t, s.Identifiers[0].Uid, "02000001020012")
+	})
+
+	t.Run("RestoreSkuConflict", func(t *testing.T) {
+		_, err := Sku{}.Delete(ctx, skuId)
+		test.Ok(t, err)
+		s := Sku{ProductId: productId, Code: "S103", Identifiers: []SkuIdentifier{{Uid: "2000001020012", Source: IdentifierSourceBarcode}}}
+		test.Ok(t, s.Create(ctx))
+
+		_, err = Sku{}.Restore(ctx, skuId)
+		test.Equals(t, errors.Is(err, ErrIdentifierExist), true)
+	})
+
+	_, err = Product{}.Delete(ctx, productId)
+	test.Ok(t, err)
+}
+
+func TestDeletionRemoved(t *testing.T) {
+	at := time.Now()
+	d := deletion{At: at, Batch: "b1"}
+	test.Equals(t, d.removed(at, "b1"), true)
+	test.Equals(t, d.removed(at, "b2"), false)
+	test.Equals(t, d.removed(time.Time{}, ""), false)
+
+	// rows deleted before batches were recorded
+	legacy := deletion{At: at}
+	test.Equals(t, legacy.removed(at, ""), true)
+	test.Equals(t, legacy.removed(at.Add(time.Second), ""), false)
+	test.Equals(t, legacy.removed(at, "b1"), false)
+}
+
+func TestProductHistory(t *testing.T) {
+	created, err := Product{}.CreateOrUpdate(ctx, Product{
+		Code:       "P901",
+		Name:       "product#901",
+		ListPrice:  100 * MajorUnit,
+		Attributes: map[string]string{"Season": "SS"},
+	})
+	test.Ok(t, err)
+
+	created.Name = "product#901-2"
+	created.ListPrice = 120 * MajorUnit
+	created.Attributes = map[string]string{"Season": "FW"}
+	_, err = Product{}.CreateOrUpdate(ctx, *created)
+	test.Ok(t, err)
+
+	_, err = Product{}.Delete(ctx, created.Id)
+	test.Ok(t, err)
+
+	totalCount, logs, err := AuditLog{}.GetBySubject(ctx, AuditEntityProduct, created.Id, 0, 10)
+	test.Ok(t, err)
+	test.Equals(t, totalCount, int64(6))
+
+	test.Equals(t, logs[0].EntityType, AuditEntityProduct)
+	test.Equals(t, logs[0].Action, AuditActionDeleted)
+	test.Equals(t, logs[0].Changes[0].Field, "deleted_at")
+	test.Equals(t, logs[1].EntityType, AuditEntityAttributeValue)
+	test.Equals(t, logs[1].Action, AuditActionDeleted)
+
+	test.Equals(t, logs[2].EntityType, AuditEntityAttributeValue)
+	test.Equals(t, logs[2].Label, "Season")
+	test.Equals(t, logs[2].Changes, []FieldChange{{Field: "value", Before: "SS", After: "FW"}})
+	test.Equals(t, logs[3].EntityType, AuditEntityProduct)
+	test.Equals(t, logs[3].Changes, []FieldChange{
+		{Field: "list_price", Before: "100.00", After: "120.00"},
+		{Field: "name", Before: "product#901", After: "product#901-2"},
+	})
+
+	test.Equals(t, logs[4].Action, AuditActionCreated)
+	test.Equals(t, logs[5].Action, AuditActionCreated)
+	test.Equals(t, logs[5].EntityType, AuditEntityProduct)
+}
+
+func TestProductPriceSchedule(t *testing.T) {
+	created, err := Product{}.CreateOrUpdate(ctx, Product{
+		Code:      "P902",
+		Name:      "product#902",
+		ListPrice: 100 * MajorUnit,
+		Prices:    []Price{{TargetType: PriceTargetTypeProduct, SalePrice: 100 * MajorUnit}},
+	})
+	test.Ok(t, err)
+
+	salePrice := func(ctx context.Context) Money {
+		p, err := Product{}.GetOne(ctx, created.Id, nil)
+		test.Ok(t, err)
+		return p.Prices[0].SalePrice
+	}
+
+	t.Run("ReEnter", func(t *testing.T) {
+		for _, v := range []Money{200 * MajorUnit, 100 * MajorUnit} {
+			p, err := Product{}.GetOne(ctx, created.Id, nil)
+			test.Ok(t, err)
+			p.Prices = []Price{{SalePrice: v}}
+			_, err = Product{}.CreateOrUpdate(ctx, *p)
+			test.Ok(t, err)
+			test.Equals(t, salePrice(ctx), v)
+		}
+		totalCount, prices, err := Price{}.GetByTarget(ctx, PriceTargetTypeProduct, strconv.FormatInt(created.Id, 10), 0, 2)
+		test.Ok(t, err)
+		test.Equals(t, totalCount, int64(3))
+		test.Equals(t, prices[0].SalePrice, 100*MajorUnit)
+		test.Equals(t, prices[1].SalePrice, 200*MajorUnit)
+	})
+
+	now := time.Now()
+	startAt, endAt := now.Add(time.Hour), now.Add(2*time.Hour)
+	t.Run("Scheduled", func(t *testing.T) {
+		p, err := Product{}.GetOne(ctx, created.Id, nil)
+		test.Ok(t, err)
+		p.Prices = []Price{{SalePrice: 80 * MajorUnit, StartAt: startAt, EndAt: &endAt}}
+		_, err = Product{}.CreateOrUpdate(ctx, *p)
+		test.Ok(t, err)
+
+		test.Equals(t, salePrice(ctx), 100*MajorUnit)
+		test.Equals(t, salePrice(WithPriceAsOf(ctx, now.Add(90*time.Minute))), 80*MajorUnit)
+		test.Equals(t, salePrice(WithPriceAsOf(ctx, now.Add(3*time.Hour))), 100*MajorUnit)
+	})
+
+	t.Run("InvalidSchedule", func(t *testing.T) {
+		p := Price{TargetType: PriceTargetTypeProduct, TargetId: strconv.FormatInt(created.Id, 10), SalePrice: 70 * MajorUnit, StartAt: endAt, EndAt: &startAt}
+		test.Equals(t, p.Create(ctx), ErrInvalidPriceSchedule)
+	})
+
+	t.Run("GetEffective", func(t *testing.T) {
+		prices, err := Price{}.GetEffective(ctx, []int64{created.Id}, now.Add(90*time.Minute))
+		test.Ok(t, err)
+		test.Equals(t, len(prices), 1)
+		test.Equals(t, prices[0].TargetId, strconv.FormatInt(created.Id, 10))
+		test.Equals(t, prices[0].SalePrice, 80*MajorUnit)
+	})
+
+	t.Run("RunSchedule", func(t *testing.T) {
+		before, err := Product{}.GetOne(ctx, created.Id, nil)
+		test.Ok(t, err)
+
+		n, err := Price{}.RunSchedule(ctx, now.Add(90*time.Minute))
+		test.Ok(t, err)
+		test.Equals(t, n, 1)
+		started, err := Product{}.GetOne(ctx, created.Id, nil)
+		test.Ok(t, err)
+		test.Equals(t, started.Version, before.Version+1)
+
+		n, err = Price{}.RunSchedule(ctx, now.Add(90*time.Minute))
+		test.Ok(t, err)
+		test.Equals(t, n, 0)
+
+		n, err = Price{}.RunSchedule(ctx, now.Add(3*time.Hour))
+		test.Ok(t, err)
+		test.Equals(t, n, 1)
+	})
+}
+
+func TestPriceScheduleSuperseded(t *testing.T) {
+	now := time.Now()
+	created, err := Product{}.CreateOrUpdate(ctx, Product{Code: "P906", Name: "product#906", ListPrice: 100 * MajorUnit})
+	test.Ok(t, err)
+	var superseding Price
+	for _, p := range []Price{
+		{SalePrice: 80 * MajorUnit, StartAt: now.Add(time.Hour)},
+		{SalePrice: 70 * MajorUnit, StartAt: now.Add(90 * time.Minute)},
+	} {
+		p.TargetType, p.TargetId = PriceTargetTypeProduct, strconv.FormatInt(created.Id, 10)
+		test.Ok(t, p.Create(ctx))
+		superseding = p
+	}
+	var last OutboxEvent
+	_, err = factory.DB(ctx).Desc("id").Get(&last)
+	test.Ok(t, err)
+
+	// both prices start in a run which missed the start of the first
+	n, err := Price{}.RunSchedule(ctx, now.Add(2*time.Hour))
+	test.Ok(t, err)
+	test.Equals(t, n, 2)
+
+	var events []OutboxEvent
+	test.Ok(t, factory.DB(ctx).Where("id > ?", last.Id).And("status = ?", adapters.EventProductPriceChanged).Asc("id").Find(&events))
+	test.Equals(t, len(events), 2)
+	for _, e := range events {
+		var p Price
+		test.Ok(t, json.Unmarshal([]byte(e.Payload), &p))
+		test.Equals(t, p.Id, superseding.Id)
+		test.Equals(t, p.SalePrice, 70*MajorUnit)
+	}
+}
+
+func TestProductEffectivePriceDeleted(t *testing.T) {
+	now := time.Now()
+	created, err := Product{}.CreateOrUpdate(ctx, Product{
+		Code:      "P905",
+		Name:      "product#905",
+		ListPrice: 100 * MajorUnit,
+		Prices:    []Price{{TargetType: PriceTargetTypeProduct, SalePrice: 80 * MajorUnit, StartAt: now.Add(-2 * time.Hour)}},
+	})
+	test.Ok(t, err)
+	_, err = Product{}.Delete(ctx, created.Id)
+	test.Ok(t, err)
+
+	// a refund asks for the price the product was sold at before it was deleted
+	prices, err := Price{}.GetEffective(ctx, []int64{created.Id}, now.Add(-time.Hour))
+	test.Ok(t, err)
+	test.Equals(t, len(prices), 1)
+	test.Equals(t, prices[0].SalePrice, 80*MajorUnit)
+
+	prices, err = Price{}.GetEffective(ctx, []int64{created.Id}, now.Add(time.Hour))
+	test.Ok(t, err)
+	test.Equals(t, prices[0].SalePrice, 100*MajorUnit)
+}
+
+func TestProductCurrency(t *testing.T) {
+	created, err := Product{}.CreateOrUpdate(ctx, Product{
+		Code:      "P903",
+		Name:      "product#903",
+		ListPrice: 10 * MajorUnit,
+		Currency:  "usd",
+		Prices:    []Price{{TargetType: PriceTargetTypeProduct, SalePrice: 8 * MajorUnit}},
+	})
+	test.Ok(t, err)
+	test.Equals(t, created.Currency, "USD")
+	test.Equals(t, created.Prices[0].Currency, "USD")
+
+	_, err = ExchangeRate{}.Save(ctx, []ExchangeRate{{FromCurrency: "USD", ToCurrency: "CNY", Rate: 7.1}})
+	test.Ok(t, err)
+
+	p, err := Product{}.GetOne(WithPriceCurrency(ctx, "CNY"), created.Id, nil)
+	test.Ok(t, err)
+	test.Equals(t, p.ListPrice, 10*MajorUnit)
+	test.Equals(t, *p.ConvertedListPrice, ConvertedAmount{Currency: "CNY", Amount: 71 * MajorUnit, Rate: 7.1})
+	test.Equals(t, p.Prices[0].SalePrice, 8*MajorUnit)
+	test.Equals(t, p.Prices[0].Converted.Amount, 5680*MinorUnit)
+
+	_, err = Product{}.GetOne(WithPriceCurrency(ctx, "EUR"), created.Id, nil)
+	test.Equals(t, errors.Is(err, ErrExchangeRateNotFound), true)
+
+	t.Run("DefaultCurrency", func(t *testing.T) {
+		p, err := Product{}.CreateOrUpdate(ctx, Product{Code: "P904", Name: "product#904", ListPrice: 10 * MajorUnit})
+		test.Ok(t, err)
+		test.Equals(t, p.Currency, DefaultCurrency)
 	})
 }
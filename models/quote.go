@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalidQuoteQuantity = errors.New("quantity must be positive")
+	ErrQuoteSkuNotFound     = errors.New("sku not found")
+)
+
+// QuoteLine is a line of a cart and the price it is sold at.
+// UnitPrice is the price of one item in the tier reached by Quantity, and ExtendedPrice the price of the line.
+// Both are converted into the currency asked for, Currency tells which it is.
+type QuoteLine struct {
+	SkuId         int64       `json:"skuId"`
+	Quantity      int         `json:"quantity"`
+	PriceId       int64       `json:"priceId,omitempty"`
+	PriceSource   PriceSource `json:"priceSource"`
+	MinQuantity   int         `json:"minQuantity,omitempty"`
+	CustomerGroup string      `json:"customerGroup,omitempty"`
+	Currency      string      `json:"currency"`
+	UnitPrice     Money       `json:"unitPrice"`
+	ExtendedPrice Money       `json:"extendedPrice"`
+}
+
+// Quote prices the lines of a cart bought by a customer of group, in the store and at the time of ctx.
+func (Price) Quote(ctx context.Context, group string, lines []QuoteLine) ([]QuoteLine, error) {
+	if len(lines) == 0 {
+		return lines, nil
+	}
+	group = strings.TrimSpace(group)
+	var skuIds []interface{}
+	for _, l := range lines {
+		if l.Quantity < 1 {
+			return nil, fmt.Errorf("%w: sku %d", ErrInvalidQuoteQuantity, l.SkuId)
+		}
+		skuIds = append(skuIds, l.SkuId)
+	}
+
+	var skus SkuList
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.
+		In("id", skuIds...).
+		Find(&skus); err != nil {
+		return nil, err
+	}
+	var products ProductList
+	if len(skus) != 0 {
+		if db, err = tenantDB(ctx, "product"); err != nil {
+			return nil, err
+		}
+		if err := db.In("id", skus.ProductIds()...).Find(&products); err != nil {
+			return nil, err
+		}
+	}
+	for i := range skus {
+		skus[i].Product = products.Find(skus[i].ProductId)
+	}
+	for i := range products {
+		if products[i].Currency == "" {
+			if err := products[i].setCurrency(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	prices, err := loadSkuPrices(ctx, skus)
+	if err != nil {
+		return nil, err
+	}
+	lists, err := applicablePriceLists(ctx)
+	if err != nil {
+		return nil, err
+	}
+	levels := priceLevels(lists)
+	asOf := retrievePriceAsOf(ctx)
+
+	var rates exchangeRates
+	currency := retrievePriceCurrency(ctx)
+	if currency != "" {
+		if rates, err = loadExchangeRates(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range lines {
+		l := &lines[i]
+		s := skus.Find(l.SkuId)
+		if s == nil || s.Product == nil {
+			return nil, fmt.Errorf("%w: %d", ErrQuoteSkuNotFound, l.SkuId)
+		}
+		price, source := prices.resolve(*s, levels, l.Quantity, group, asOf)
+		l.PriceId, l.PriceSource = price.Id, source
+		l.MinQuantity, l.CustomerGroup = price.MinQuantity, price.CustomerGroup
+		l.Currency, l.UnitPrice = price.Currency, price.SalePrice
+		if currency != "" {
+			converted, err := rates.convert(price.SalePrice, price.Currency, currency)
+			if err != nil {
+				return nil, err
+			}
+			l.Currency, l.UnitPrice = converted.Currency, converted.Amount
+		}
+		l.ExtendedPrice = l.UnitPrice * Money(l.Quantity)
+	}
+	return lines, nil
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestEffectiveTierPrice(t *testing.T) {
+	now := time.Now()
+	prices := []Price{
+		{Id: 1, SalePrice: 100 * MajorUnit, StartAt: now.Add(-2 * time.Hour)},
+		{Id: 2, SalePrice: 90 * MajorUnit, MinQuantity: 12, StartAt: now.Add(-2 * time.Hour)},
+		{Id: 3, SalePrice: 80 * MajorUnit, MinQuantity: 48, StartAt: now.Add(-2 * time.Hour)},
+		{Id: 4, SalePrice: 85 * MajorUnit, CustomerGroup: "gold", StartAt: now.Add(-2 * time.Hour)},
+		// a markdown of the regular price only
+		{Id: 5, SalePrice: 95 * MajorUnit, StartAt: now.Add(-time.Hour)},
+	}
+	for _, c := range []struct {
+		quantity int
+		group    string
+		id       int64
+	}{
+		{1, "", 5},
+		{11, "", 5},
+		{12, "", 2},
+		{100, "", 3},
+		{1, "silver", 5},
+		{1, "gold", 4},
+		{48, "gold", 4},
+	} {
+		test.Equals(t, effectiveTierPrice(prices, c.quantity, c.group, now).Id, c.id)
+	}
+	test.Equals(t, effectivePrice(prices, now).Id, int64(5))
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"context"
+)
+
+// rolesContext holds the roles of the caller, which are read from the roles claim of its token.
+const rolesContext contextKey = "Roles"
+
+const (
+	// RoleFinance may read and write cost prices and margins.
+	RoleFinance = "finance"
+	// RolePriceApprover approves or rejects the price changes which need approval.
+	RolePriceApprover = "price_approver"
+	// RoleCatalogEditor maintains brands, products and skus.
+	RoleCatalogEditor = "catalog_editor"
+	// RolePriceEditor sets prices, price lists, offers and currencies.
+	RolePriceEditor = "price_editor"
+	// RoleImporter runs the imports of product sheets.
+	RoleImporter = "importer"
+	// RoleAdmin may do anything within its tenant.
+	RoleAdmin = "admin"
+)
+
+// WithRoles gives the caller of ctx roles.
+func WithRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, rolesContext, roles)
+}
+
+func retrieveRoles(ctx context.Context) []string {
+	v := ctx.Value(rolesContext)
+	if v == nil {
+		return nil
+	}
+	roles, _ := v.([]string)
+	return roles
+}
+
+// HasRole tells whether the caller has role.
+func HasRole(ctx context.Context, role string) bool {
+	for _, r := range retrieveRoles(ctx) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -13,6 +13,17 @@ import (
 	"github.com/hublabs/product-api/factory"
 )
 
+// PriceSource tells whose price a sku is sold at.
+type PriceSource string
+
+const (
+	PriceSourceSku       PriceSource = "sku"
+	PriceSourceProduct   PriceSource = "product"
+	PriceSourceListPrice PriceSource = "list_price"
+)
+
+// Price is the price the sku is sold at, resolved when its product is loaded, and PriceSource tells where it comes from.
+// Offers and OfferPrice are the offers which apply to the sku when they are asked for.
 type Sku struct {
 	Id          int64           `json:"id,omitempty"`
 	TenantCode  string          `json:"-" xorm:"index varchar(16)"`
@@ -20,14 +31,22 @@ type Sku struct {
 	Code        string          `json:"code" xorm:"index varchar(64)"`
 	Name        string          `json:"name,omitempty"`
 	Image       string          `json:"image,omitempty"`
+	CostPrice   Money           `json:"costPrice,omitempty" xorm:"decimal(18,2)"`
 	Identifiers []SkuIdentifier `json:"identifiers,omitempty" xorm:"-"`
 	Options     []Option        `json:"options,omitempty" xorm:"-"`
 	Product     *Product        `json:"product,omitempty" xorm:"-"`
+	Price       *Price          `json:"price,omitempty" xorm:"-"`
+	PriceSource PriceSource     `json:"priceSource,omitempty" xorm:"-"`
+	Offers      []AppliedOffer  `json:"offers,omitempty" xorm:"-"`
+	OfferPrice  *Money          `json:"offerPrice,omitempty" xorm:"-"`
+	Margin      *Margin         `json:"margin,omitempty" xorm:"-"`
 	Enable      bool            `json:"enable" xorm:"index"`
 	Saleable    bool            `json:"saleable" xorm:"index"`
 	CreatedAt   time.Time       `json:"createdAt,omitempty" xorm:"created"`
 	UpdatedAt   time.Time       `json:"updatedAt,omitempty" xorm:"updated"`
 	DeletedAt   time.Time       `json:"-" xorm:"deleted index"`
+	DeleteBatch string          `json:"-" xorm:"index varchar(32)"`
+	Version     int             `json:"version" xorm:"version"`
 }
 
 func (Sku) GetSimple(ctx context.Context, skuId int64, fields FieldTypeList) (*Sku, error) {
@@ -37,7 +56,11 @@ func (Sku) GetSimple(ctx context.Context, skuId int64, fields FieldTypeList) (*S
 		Brand   Brand   `xorm:"extends"`
 		Option  Option  `xorm:"extends"`
 	}
-	if err := factory.DB(ctx).Table("sku").
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Table("sku").
 		Join("INNER", "product", "sku.product_id = product.id").
 		Join("INNER", "brand", "product.brand_id = brand.id").
 		Join("LEFT", "`option`", "sku.id = `option`.sku_id").
@@ -68,9 +91,29 @@ func (Sku) GetSimple(ctx context.Context, skuId int64, fields FieldTypeList) (*S
 	return &sku, nil
 }
 
+func (Sku) Get(ctx context.Context, id int64) (*Sku, error) {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	var s Sku
+	exist, err := db.Where("id = ?", id).Get(&s)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+	return &s, nil
+}
+
 func (Sku) GetOne(ctx context.Context, id int64, fields FieldTypeList) (*Sku, error) {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
 	var skus SkuList
-	if err := factory.DB(ctx).Where("id = ?", id).Limit(1).Find(&skus); err != nil {
+	if err := db.Where("id = ?", id).Limit(1).Find(&skus); err != nil {
 		return nil, err
 	} else if len(skus) == 0 {
 		return nil, nil
@@ -95,6 +138,9 @@ func (Sku) GetAll(ctx context.Context, q, productCode, barcode, brandCode, enabl
 	query := fmt.Sprintf(`SELECT sku.* FROM sku
 INNER JOIN product ON sku.product_id = product.id
 WHERE sku.tenant_code = ? AND (%s)`, excludeDeleted("sku"))
+	if tenantCode(ctx) == "" {
+		return false, 0, nil, ErrTenantRequired
+	}
 	args := []interface{}{tenantCode(ctx)}
 	if len(ids) != 0 {
 		placeholder := strings.Repeat("?,", len(ids))
@@ -111,8 +157,8 @@ WHERE sku.tenant_code = ? AND (%s)`, excludeDeleted("sku"))
 		}
 	}
 	if brandCode != "" {
-		query = query + " AND product.brand_id IN (SELECT id FROM brand WHERE code = ?)"
-		args = append(args, brandCode)
+		query = query + " AND product.brand_id IN (SELECT id FROM brand WHERE code = ? AND tenant_code = ?)"
+		args = append(args, brandCode, tenantCode(ctx))
 	}
 	if productCode != "" {
 		query = query + " AND product.code = ?"
@@ -126,8 +172,13 @@ WHERE sku.tenant_code = ? AND (%s)`, excludeDeleted("sku"))
 		}
 	}
 	if barcode != "" {
-		query = query + " AND sku.id IN (SELECT sku_id FROM sku_identifier WHERE uid = ? AND source = ?)"
-		args = append(args, barcode, IdentifierSourceBarcode)
+		uids := barcodeUids(barcode)
+		placeholder := strings.Repeat("?,", len(uids))
+		query = fmt.Sprintf(query+" AND sku.id IN (SELECT sku_id FROM sku_identifier WHERE uid IN (%s) AND source = ?)", placeholder[:len(placeholder)-1])
+		for _, uid := range uids {
+			args = append(args, uid)
+		}
+		args = append(args, IdentifierSourceBarcode)
 	}
 	if enable != "" {
 		b, _ := strconv.ParseBool(enable)
@@ -211,9 +262,13 @@ WHERE sku.tenant_code = ? AND (%s)`, excludeDeleted("sku"))
 }
 
 func (Sku) GetByProductId(ctx context.Context, id int64) ([]Sku, error) {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
 	var skus []Sku
-	if err := factory.DB(ctx).Table("sku").
-		Where("product_id = ?", id).
+	if err := db.Table("sku").
+		And("product_id = ?", id).
 		Desc("id").
 		Find(&skus); err != nil {
 		return nil, err
@@ -222,14 +277,36 @@ func (Sku) GetByProductId(ctx context.Context, id int64) ([]Sku, error) {
 }
 
 func (s *Sku) Update(ctx context.Context) (err error) {
+	if err := normalizeBarcodes(s.Identifiers); err != nil {
+		return err
+	}
 	cols := []string{
 		"code", "name", "image",
 	}
-	if _, err = factory.DB(ctx).ID(s.Id).Cols(cols...).Update(s); err != nil {
-		return
+	cols = append(cols, costColumns(ctx)...)
+	before, err := auditSnapshot(ctx, AuditEntitySku, s.Id)
+	if err != nil {
+		return err
+	}
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return err
+	}
+	affected, err := db.ID(s.Id).Cols(cols...).Update(s)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	if err := writeAudit(ctx, AuditEntitySku, AuditActionUpdated, before, s.Id); err != nil {
+		return err
+	}
+	if db, err = tenantChildDB(ctx, "option", "sku_id", "sku"); err != nil {
+		return err
 	}
 	var options []Option
-	if err := factory.DB(ctx).Where("sku_id = ?", s.Id).Find(&options); err != nil {
+	if err := db.Where("sku_id = ?", s.Id).Find(&options); err != nil {
 		return err
 	}
 OptionLoop:
@@ -269,18 +346,30 @@ OptionLoop:
 		return err
 	}
 
-	return adapters.MessagePublisher{}.Publish(ctx, *s, adapters.EventSkuChanged)
+	return publishEvent(ctx, *s, adapters.EventSkuChanged)
 }
 
 func (s *Sku) Create(ctx context.Context) error {
-	s.TenantCode = tenantCode(ctx)
-	if _, err := factory.DB(ctx).Insert(s); err != nil {
+	if err := normalizeBarcodes(s.Identifiers); err != nil {
+		return err
+	}
+	if !CostVisible(ctx) {
+		s.CostPrice = 0
+	}
+	if err := tenantInsert(ctx, s); err != nil {
+		return err
+	}
+	if err := writeAudit(ctx, AuditEntitySku, AuditActionCreated, nil, s.Id); err != nil {
 		return err
 	}
 	for i := range s.Identifiers {
 		s.Identifiers[i].SkuId = s.Id
+		db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+		if err != nil {
+			return err
+		}
 		var d SkuIdentifier
-		exist, err := factory.DB(ctx).Where("uid = ?", s.Identifiers[i].Uid).And("source = ?", s.Identifiers[i].Source).Get(&d)
+		exist, err := db.Where("uid = ?", s.Identifiers[i].Uid).And("source = ?", s.Identifiers[i].Source).Get(&d)
 		if err != nil {
 			return err
 		}
@@ -301,7 +390,7 @@ func (s *Sku) Create(ctx context.Context) error {
 			return err
 		}
 	}
-	return adapters.MessagePublisher{}.Publish(ctx, *s, adapters.EventSkuAdded)
+	return publishEvent(ctx, *s, adapters.EventSkuAdded)
 }
 
 func (s Sku) removeOptionsExcept(ctx context.Context, except []Option) (err error) {
@@ -311,7 +400,11 @@ func (s Sku) removeOptionsExcept(ctx context.Context, except []Option) (err erro
 	}
 
 	if len(exceptOptionIds) > 0 {
-		if _, err := factory.DB(ctx).Where("sku_id = ?", s.Id).NotIn("id", exceptOptionIds).Delete(&Option{}); err != nil {
+		db, err := tenantChildDB(ctx, "option", "sku_id", "sku")
+		if err != nil {
+			return err
+		}
+		if _, err := db.Unscoped().Where("sku_id = ?", s.Id).NotIn("id", exceptOptionIds).Delete(&Option{}); err != nil {
 			return err
 		}
 	}
@@ -323,13 +416,195 @@ func (s Sku) removeIdentifiersExcept(ctx context.Context, except []SkuIdentifier
 	for _, identifier := range except {
 		exceptIdentifilerIds = append(exceptIdentifilerIds, identifier.Id)
 	}
-	_, err = factory.DB(ctx).Where("sku_id = ?", s.Id).NotIn("id", exceptIdentifilerIds).Delete(&SkuIdentifier{})
+	db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return err
+	}
+	_, err = db.Unscoped().Where("sku_id = ?", s.Id).NotIn("id", exceptIdentifilerIds).Delete(&SkuIdentifier{})
 	return
 }
 
+func (Sku) UpdateEnable(ctx context.Context, id int64, version int, enable bool) (*Sku, error) {
+	return Sku{}.updateStatus(ctx, id, "enable", Sku{Enable: enable, Version: version})
+}
+
+func (Sku) UpdateSaleable(ctx context.Context, id int64, version int, saleable bool) (*Sku, error) {
+	return Sku{}.updateStatus(ctx, id, "saleable", Sku{Saleable: saleable, Version: version})
+}
+
+func (Sku) updateStatus(ctx context.Context, id int64, col string, v Sku) (*Sku, error) {
+	if s, err := (Sku{}).Get(ctx, id); err != nil || s == nil {
+		return nil, err
+	}
+	before, err := auditSnapshot(ctx, AuditEntitySku, id)
+	if err != nil {
+		return nil, err
+	}
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	affected, err := db.ID(id).Cols(col).Update(&v)
+	if err != nil {
+		return nil, err
+	}
+	if affected == 0 {
+		return nil, ErrVersionConflict
+	}
+	if err := writeAudit(ctx, AuditEntitySku, AuditActionUpdated, before, id); err != nil {
+		return nil, err
+	}
+	s, err := Sku{}.GetOne(ctx, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := publishEvent(ctx, *s, adapters.EventSkuChanged); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (Sku) Delete(ctx context.Context, id int64) (*Sku, error) {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	var skus SkuList
+	if err := db.Where("id = ?", id).Limit(1).Find(&skus); err != nil {
+		return nil, err
+	} else if len(skus) == 0 {
+		return nil, nil
+	}
+
+	if err := skus.LoadIdentifiers(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := skus.LoadOptions(ctx); err != nil {
+		return nil, err
+	}
+
+	d, err := newDeletion()
+	if err != nil {
+		return nil, err
+	}
+	if err := skus[0].delete(ctx, d); err != nil {
+		return nil, err
+	}
+	return &skus[0], nil
+}
+
+// Must be private because of event SkuRemoved
+func (s *Sku) delete(ctx context.Context, d deletion) error {
+	if err := softDelete(ctx, &Option{DeletedAt: d.At, DeleteBatch: d.Batch}, "sku_id = ?", s.Id); err != nil {
+		return err
+	}
+	if err := softDelete(ctx, &SkuIdentifier{DeletedAt: d.At, DeleteBatch: d.Batch}, "sku_id = ?", s.Id); err != nil {
+		return err
+	}
+	if err := softDelete(ctx, &Price{DeletedAt: d.At, DeleteBatch: d.Batch}, "target_type = ? AND target_id = ?", PriceTargetTypeSku, strconv.FormatInt(s.Id, 10)); err != nil {
+		return err
+	}
+	if err := softDelete(ctx, &Sku{DeletedAt: d.At, DeleteBatch: d.Batch, Version: s.Version}, "id = ?", s.Id); err != nil {
+		return err
+	}
+	return publishEvent(ctx, *s, adapters.EventSkuRemoved)
+}
+
+func (Sku) Restore(ctx context.Context, id int64) (*Sku, error) {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
+	var s Sku
+	exist, err := db.Unscoped().Where("id = ?", id).Get(&s)
+	if err != nil {
+		return nil, err
+	}
+	if !exist {
+		return nil, nil
+	}
+
+	if !s.DeletedAt.IsZero() {
+		productExist, err := Product{}.Exist(ctx, s.ProductId)
+		if err != nil {
+			return nil, err
+		}
+		if !productExist {
+			return nil, ErrProductDeleted
+		}
+		if err := s.restore(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return Sku{}.GetOne(ctx, id, nil)
+}
+
+// Must be private because of event SkuRestored
+func (s *Sku) restore(ctx context.Context) error {
+	d := deletion{At: s.DeletedAt, Batch: s.DeleteBatch}
+	var identifiers []SkuIdentifier
+	db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return err
+	}
+	if err := db.Unscoped().Where("sku_id = ?", s.Id).Find(&identifiers); err != nil {
+		return err
+	}
+	for _, identifier := range identifiers {
+		if !d.removed(identifier.DeletedAt, identifier.DeleteBatch) {
+			continue
+		}
+		db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+		if err != nil {
+			return err
+		}
+		uids := []string{identifier.Uid}
+		if identifier.Source == IdentifierSourceBarcode {
+			uids = barcodeUids(identifier.Uid)
+		}
+		exist, err := db.In("uid", uids).And("source = ?", identifier.Source).Exist(&SkuIdentifier{})
+		if err != nil {
+			return err
+		}
+		if exist {
+			return fmt.Errorf("%w: %s", ErrIdentifierExist, identifier.Uid)
+		}
+	}
+
+	if err := restoreDeleted(ctx, &Option{}, d, "sku_id = ?", s.Id); err != nil {
+		return err
+	}
+	if err := restoreDeleted(ctx, &SkuIdentifier{}, d, "sku_id = ?", s.Id); err != nil {
+		return err
+	}
+	if err := restoreDeleted(ctx, &Price{}, d, "target_type = ? AND target_id = ?", PriceTargetTypeSku, strconv.FormatInt(s.Id, 10)); err != nil {
+		return err
+	}
+	if err := restoreDeleted(ctx, &Sku{Version: s.Version}, d, "id = ?", s.Id); err != nil {
+		return err
+	}
+
+	skus := SkuList{*s}
+	if err := skus.LoadIdentifiers(ctx); err != nil {
+		return err
+	}
+	if err := skus.LoadOptions(ctx); err != nil {
+		return err
+	}
+	*s = skus[0]
+	s.DeletedAt, s.DeleteBatch = time.Time{}, ""
+	return publishEvent(ctx, *s, adapters.EventSkuRestored)
+}
+
 func (Sku) SearchAll(ctx context.Context, q, enable, saleable string, filter Filter, skipCount, maxResultCount int, sortby, order []string, fields FieldTypeList, withHasMore bool) (bool, int64, []Sku, error) {
-	query := factory.DB(ctx).Table("sku").Select("sku.*").Join("INNER", "product", "product.id = sku.product_id").
-		Where("sku.tenant_code = ?", tenantCode(ctx))
+	scope, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return false, 0, nil, err
+	}
+	query := scope.session()
+	query.Table("sku").Select("sku.*").Join("INNER", "product", "product.id = sku.product_id")
 	if q != "" {
 		query.Where(`sku.id IN ( SELECT id FROM (
     SELECT id FROM sku WHERE code LIKE ?
@@ -351,7 +626,6 @@ func (Sku) SearchAll(ctx context.Context, q, enable, saleable string, filter Fil
 		skus       SkuList
 		hasMore    bool
 		totalCount int64
-		err        error
 	)
 
 	if len(sortby) == 0 || len(order) == 0 {
@@ -395,9 +669,22 @@ func (Sku) SearchAll(ctx context.Context, q, enable, saleable string, filter Fil
 	return hasMore, totalCount, skus, nil
 }
 
+// GetByUids finds the skus of the identifiers. A barcode is found by its GTIN-14 whichever variant it is given as.
 func (Sku) GetByUids(ctx context.Context, source string, fields FieldTypeList, uids ...string) ([]Sku, error) {
+	if source == "" || source == IdentifierSourceBarcode {
+		var all []string
+		for _, uid := range uids {
+			all = append(all, barcodeUids(uid)...)
+		}
+		uids = all
+	}
 	var skuIds []int64
-	query := factory.DB(ctx).Table("sku_identifier").Select("sku_id").Distinct("sku_id").In("uid", uids)
+	scope, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return nil, err
+	}
+	query := scope.session()
+	query.Table("sku_identifier").Select("sku_id").Distinct("sku_id").In("uid", uids)
 	if source != "" {
 		query.Where("source = ?", source)
 	}
@@ -408,9 +695,12 @@ func (Sku) GetByUids(ctx context.Context, source string, fields FieldTypeList, u
 		return nil, nil
 	}
 
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return nil, err
+	}
 	var skus SkuList
-	if err := factory.DB(ctx).Table("sku").
-		Where("tenant_code = ?", tenantCode(ctx)).
+	if err := db.Table("sku").
 		In("id", skuIds).
 		Find(&skus); err != nil {
 		return nil, err
@@ -472,7 +762,11 @@ func (skus SkuList) FindByProductId(productId int64) []*Sku {
 
 func (skus SkuList) LoadOptions(ctx context.Context) error {
 	var options []Option
-	if err := factory.DB(ctx).In("sku_id", skus.Ids()...).Find(&options); err != nil {
+	db, err := tenantChildDB(ctx, "option", "sku_id", "sku")
+	if err != nil {
+		return err
+	}
+	if err := db.In("sku_id", skus.Ids()...).Find(&options); err != nil {
 		return err
 	}
 	for _, option := range options {
@@ -485,8 +779,12 @@ func (skus SkuList) LoadOptions(ctx context.Context) error {
 }
 
 func (skus SkuList) LoadProducts(ctx context.Context, fields FieldTypeList) error {
+	db, err := tenantDB(ctx, "product")
+	if err != nil {
+		return err
+	}
 	var products ProductList
-	if err := factory.DB(ctx).In("id", skus.ProductIds()...).Find(&products); err != nil {
+	if err := db.In("id", skus.ProductIds()...).Find(&products); err != nil {
 		return err
 	}
 
@@ -511,12 +809,158 @@ func (skus SkuList) LoadProducts(ctx context.Context, fields FieldTypeList) erro
 		}
 	}
 
+	return skus.loadPrices(ctx)
+}
+
+// loadPrices resolves the price of each sku whose product is loaded.
+func (skus SkuList) loadPrices(ctx context.Context) error {
+	if len(skus) == 0 {
+		return nil
+	}
+	prices, err := loadSkuPrices(ctx, skus)
+	if err != nil {
+		return err
+	}
+	lists, err := applicablePriceLists(ctx)
+	if err != nil {
+		return err
+	}
+	levels := priceLevels(lists)
+	asOf := retrievePriceAsOf(ctx)
+	for i := range skus {
+		s := &skus[i]
+		if s.Product == nil {
+			continue
+		}
+		s.Price, s.PriceSource = prices.resolve(*s, levels, 1, "", asOf)
+		if s.Price.Currency == s.Product.Currency {
+			s.Margin = newMargin(s.costPrice(), s.Price.SalePrice)
+		}
+	}
+	if err := skus.convertPrices(ctx); err != nil {
+		return err
+	}
+	return skus.loadOffers(ctx)
+}
+
+// skuPrices holds the prices of skus and of their products by target.
+type skuPrices map[string][]Price
+
+func loadSkuPrices(ctx context.Context, skus SkuList) (skuPrices, error) {
+	db, err := tenantDB(ctx, "price")
+	if err != nil {
+		return nil, err
+	}
+	var prices []Price
+	if err := db.
+		And("target_type = ?", PriceTargetTypeSku).
+		In("target_id", skus.Ids()...).
+		Find(&prices); err != nil {
+		return nil, err
+	}
+	if db, err = tenantDB(ctx, "price"); err != nil {
+		return nil, err
+	}
+	var productPrices []Price
+	if err := db.
+		And("target_type = ?", PriceTargetTypeProduct).
+		In("target_id", skus.ProductIds()...).
+		Find(&productPrices); err != nil {
+		return nil, err
+	}
+	prices = append(prices, productPrices...)
+	if err := fillCurrency(ctx, prices); err != nil {
+		return nil, err
+	}
+	m := make(skuPrices)
+	for _, price := range prices {
+		key := string(price.TargetType) + ":" + price.TargetId
+		m[key] = append(m[key], price)
+	}
+	return m, nil
+}
+
+// resolve picks the price at t of quantity items of s bought by a customer of group.
+// The price lists win over the regular prices as for a product, and at each of these levels
+// a price of the sku wins over a price of its product. The list price of the product is the last resort.
+func (m skuPrices) resolve(s Sku, levels []int64, quantity int, group string, t time.Time) (*Price, PriceSource) {
+	skuPrices := m[PriceTargetTypeSku+":"+strconv.FormatInt(s.Id, 10)]
+	productPrices := m[PriceTargetTypeProduct+":"+strconv.FormatInt(s.ProductId, 10)]
+	for _, level := range levels {
+		if price := effectiveTierPrice(priceListPrices(skuPrices, level), quantity, group, t); price != nil {
+			return price, PriceSourceSku
+		}
+		if price := effectiveTierPrice(priceListPrices(productPrices, level), quantity, group, t); price != nil {
+			return price, PriceSourceProduct
+		}
+	}
+	return &Price{SalePrice: s.Product.ListPrice, Currency: s.Product.Currency}, PriceSourceListPrice
+}
+
+// convertPrices converts the prices of skus into the currency asked for by the reader.
+func (skus SkuList) convertPrices(ctx context.Context) error {
+	currency := retrievePriceCurrency(ctx)
+	if currency == "" {
+		return nil
+	}
+	rates, err := loadExchangeRates(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range skus {
+		if p := skus[i].Price; p != nil {
+			if p.Converted, err = rates.convert(p.SalePrice, p.Currency, currency); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+// Must be private because of event ProductPriceChanged
+// updatePrice enters price as the regular price of the sku, unless it is the price in effect already.
+func (s *Sku) updatePrice(ctx context.Context, price Price) error {
+	_, prices, err := Price{}.GetByTarget(ctx, PriceTargetTypeSku, strconv.FormatInt(s.Id, 10), 0, 0)
+	if err != nil {
+		return err
+	}
+	if price.Currency == "" {
+		if price.Currency, err = (TenantCurrency{}).Get(ctx); err != nil {
+			return err
+		}
+	}
+	effective := effectivePrice(priceListPrices(prices, 0), time.Now())
+	if effective != nil && effective.SalePrice == price.SalePrice && effective.Currency == price.Currency {
+		return nil
+	}
+	price.TargetType = PriceTargetTypeSku
+	price.TargetId = strconv.FormatInt(s.Id, 10)
+	price.PriceListId = 0
+	return price.Create(ctx)
+}
+
+// touch moves the version of a sku on, for a change of its representation which is not a write to its row.
+func (Sku) touch(ctx context.Context, id int64) error {
+	db, err := tenantDB(ctx, "sku")
+	if err != nil {
+		return err
+	}
+	var s Sku
+	exist, err := db.Where("id = ?", id).Get(&s)
+	if err != nil || !exist {
+		return err
+	}
+	_, err = db.ID(id).Cols("updated_at").Update(&s)
+	return err
+}
+
 func (skus SkuList) LoadIdentifiers(ctx context.Context) error {
 	var identifiers []SkuIdentifier
-	if err := factory.DB(ctx).In("sku_id", skus.Ids()...).Find(&identifiers); err != nil {
+	db, err := tenantChildDB(ctx, "sku_identifier", "sku_id", "sku")
+	if err != nil {
+		return err
+	}
+	if err := db.In("sku_id", skus.Ids()...).Find(&identifiers); err != nil {
 		return err
 	}
 	for _, identifier := range identifiers {
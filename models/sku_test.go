@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strconv"
 	"testing"
 
 	"github.com/pangpanglabs/goutils/test"
@@ -9,24 +10,29 @@ import (
 func TestSkuCRUD(t *testing.T) {
 	var id int64
 	t.Run("Create", func(t *testing.T) {
+		p1, err := Product{}.GetByCode(ctx, "P001")
+		test.Ok(t, err)
+		p2, err := Product{}.GetByCode(ctx, "P002")
+		test.Ok(t, err)
+
 		s1 := Sku{
-			ProductId: 1,
+			ProductId: p1.Id,
 			Code:      "S001",
 			Name:      "sku#1",
 			Identifiers: []SkuIdentifier{
 				{
-					Uid:    "S001001",
+					Uid:    "2000000010014",
 					Source: IdentifierSourceBarcode,
 				},
 			},
 		}
-		err := s1.Create(ctx)
+		err = s1.Create(ctx)
 		test.Ok(t, err)
 		test.Equals(t, s1.Id > 0, true)
 		id = s1.Id
 
 		s2 := Sku{
-			ProductId: 2,
+			ProductId: p2.Id,
 			Code:      "S002",
 			Name:      "sku#2",
 			Enable:    true,
@@ -46,12 +52,12 @@ func TestSkuCRUD(t *testing.T) {
 		test.Ok(t, err)
 		test.Equals(t, hasMore, true)
 
-		_, count, skus, err = Sku{}.GetAll(ctx, "", "", "S001001", "", "", "", nil, nil, nil, 0, 10, nil, nil, nil, false)
+		_, count, skus, err = Sku{}.GetAll(ctx, "", "", "2000000010014", "", "", "", nil, nil, nil, 0, 10, nil, nil, nil, false)
 		test.Ok(t, err)
 		test.Equals(t, count, int64(1))
 		test.Equals(t, skus[0].Id, id)
 
-		_, count, skus, err = Sku{}.GetAll(ctx, "", "", "S001001", "", "true", "", nil, nil, nil, 0, 10, nil, nil, nil, false)
+		_, count, skus, err = Sku{}.GetAll(ctx, "", "", "2000000010014", "", "true", "", nil, nil, nil, 0, 10, nil, nil, nil, false)
 		test.Ok(t, err)
 		test.Equals(t, count, int64(0))
 
@@ -71,4 +77,18 @@ func TestSkuCRUD(t *testing.T) {
 		test.Equals(t, s.Code, "S001")
 		test.Equals(t, s.Name, "sku#1")
 	})
+
+	t.Run("GetWithSkuPrice", func(t *testing.T) {
+		s, err := Sku{}.GetOne(ctx, id, nil)
+		test.Ok(t, err)
+		test.Equals(t, s.PriceSource == PriceSourceSku, false)
+
+		p := Price{TargetType: PriceTargetTypeSku, TargetId: strconv.FormatInt(id, 10), SalePrice: 120 * MajorUnit}
+		test.Ok(t, p.Create(ctx))
+
+		s, err = Sku{}.GetOne(ctx, id, nil)
+		test.Ok(t, err)
+		test.Equals(t, s.PriceSource, PriceSourceSku)
+		test.Equals(t, s.Price.SalePrice, 120*MajorUnit)
+	})
 }
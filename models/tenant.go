@@ -0,0 +1,176 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/factory"
+
+	"github.com/go-xorm/xorm"
+	"xorm.io/builder"
+	"xorm.io/core"
+)
+
+var ErrTenantRequired = errors.New("no tenant in the request")
+
+// contextKey is the type of the keys of the values the package puts into a context.
+type contextKey string
+
+// userClaimContextName is the key auth.UserClaimMiddleware puts the claim of a token under.
+// hublabs/common has no setter for it, TestWithUserClaim tells when it no longer matches.
+const userClaimContextName = "userClaim"
+
+// WithUserClaim puts claim into ctx as auth.UserClaimMiddleware does,
+// for the callers known otherwise than by a token and for the jobs run for a tenant.
+func WithUserClaim(ctx context.Context, claim auth.UserClaim) context.Context {
+	return context.WithValue(ctx, userClaimContextName, claim)
+}
+
+// tenantScope is factory.DB(ctx) limited to the rows of a tenant. The limit is put on the session of ctx
+// only when a query is started on the scope, so that a scope made and left unused does not limit the next query run on the session,
+// and a condition left on the session by another query does not hold for the scope either.
+type tenantScope struct {
+	ctx  context.Context
+	cond builder.Cond
+}
+
+// session starts a query on the session of ctx, on which the conditions are reset once a query runs.
+func (s tenantScope) session() *xorm.Session {
+	return factory.DB(s.ctx).Where(s.cond)
+}
+
+func (s tenantScope) Where(query interface{}, args ...interface{}) *xorm.Session {
+	return s.session().And(query, args...)
+}
+
+func (s tenantScope) And(query interface{}, args ...interface{}) *xorm.Session {
+	return s.session().And(query, args...)
+}
+
+func (s tenantScope) ID(id interface{}) *xorm.Session {
+	return s.session().ID(id)
+}
+
+func (s tenantScope) In(column string, args ...interface{}) *xorm.Session {
+	return s.session().In(column, args...)
+}
+
+func (s tenantScope) NotIn(column string, args ...interface{}) *xorm.Session {
+	return s.session().NotIn(column, args...)
+}
+
+func (s tenantScope) Table(tableNameOrBean interface{}) *xorm.Session {
+	return s.session().Table(tableNameOrBean)
+}
+
+func (s tenantScope) Join(operator string, table interface{}, condition string, args ...interface{}) *xorm.Session {
+	return s.session().Join(operator, table, condition, args...)
+}
+
+func (s tenantScope) Select(columns string) *xorm.Session {
+	return s.session().Select(columns)
+}
+
+func (s tenantScope) Cols(columns ...string) *xorm.Session {
+	return s.session().Cols(columns...)
+}
+
+func (s tenantScope) Asc(columns ...string) *xorm.Session {
+	return s.session().Asc(columns...)
+}
+
+func (s tenantScope) Desc(columns ...string) *xorm.Session {
+	return s.session().Desc(columns...)
+}
+
+func (s tenantScope) Unscoped() *xorm.Session {
+	return s.session().Unscoped()
+}
+
+func (s tenantScope) Get(bean interface{}) (bool, error) {
+	return s.session().Get(bean)
+}
+
+func (s tenantScope) Exist(beans ...interface{}) (bool, error) {
+	return s.session().Exist(beans...)
+}
+
+func (s tenantScope) Find(beans interface{}, conditions ...interface{}) error {
+	return s.session().Find(beans, conditions...)
+}
+
+func (s tenantScope) Count(beans ...interface{}) (int64, error) {
+	return s.session().Count(beans...)
+}
+
+func (s tenantScope) Update(bean interface{}, conditions ...interface{}) (int64, error) {
+	return s.session().Update(bean, conditions...)
+}
+
+func (s tenantScope) Delete(bean interface{}) (int64, error) {
+	return s.session().Delete(bean)
+}
+
+// tenantDB is factory.DB(ctx) limited to the rows of the tenant of ctx in table, which may be joined with other tables.
+// It fails closed with ErrTenantRequired when ctx has no tenant, rather than reading or writing the rows of every tenant.
+func tenantDB(ctx context.Context, table string) (tenantScope, error) {
+	code := tenantCode(ctx)
+	if code == "" {
+		return tenantScope{}, ErrTenantRequired
+	}
+	return tenantScope{ctx: ctx, cond: builder.Eq{table + ".tenant_code": code}}, nil
+}
+
+// tenantChildDB is factory.DB(ctx) limited to the rows of table which belong by column to a row of the tenant of ctx in parent,
+// for the tables of rows owned through a product or a sku. The names are quoted, as option is a keyword of MySQL.
+func tenantChildDB(ctx context.Context, table, column, parent string) (tenantScope, error) {
+	code := tenantCode(ctx)
+	if code == "" {
+		return tenantScope{}, ErrTenantRequired
+	}
+	return tenantScope{ctx: ctx, cond: builder.Expr(fmt.Sprintf("`%s`.`%s` IN (SELECT `id` FROM `%s` WHERE `tenant_code` = ?)", table, column, parent), code)}, nil
+}
+
+// tenantChildTables are the tables of rows owned through a product or a sku, with the column and the table of their owner.
+var tenantChildTables = map[string][2]string{
+	"option":             {"sku_id", "sku"},
+	"sku_identifier":     {"sku_id", "sku"},
+	"product_identifier": {"product_id", "product"},
+	"attribute_value":    {"product_id", "product"},
+}
+
+// tenantTableDB is tenantChildDB of the tables of rows owned through a product or a sku, and tenantDB of the others.
+func tenantTableDB(ctx context.Context, table string) (tenantScope, error) {
+	if owner, ok := tenantChildTables[table]; ok {
+		return tenantChildDB(ctx, table, owner[0], owner[1])
+	}
+	return tenantDB(ctx, table)
+}
+
+// tableName is the table of bean, named by the default mapper of xorm.
+func tableName(bean interface{}) string {
+	return core.SnakeMapper{}.Obj2Table(reflect.Indirect(reflect.ValueOf(bean)).Type().Name())
+}
+
+// tenantInsert inserts the beans as rows of the tenant of ctx, whatever tenant they were given.
+// Inserts are not run on tenantDB, as xorm inserts only when the conditions of the session hold.
+func tenantInsert(ctx context.Context, beans ...interface{}) error {
+	code := tenantCode(ctx)
+	if code == "" {
+		return ErrTenantRequired
+	}
+	for _, bean := range beans {
+		v := reflect.Indirect(reflect.ValueOf(bean))
+		if v.Kind() != reflect.Struct {
+			continue
+		}
+		if f := v.FieldByName("TenantCode"); f.IsValid() && f.CanSet() && f.Kind() == reflect.String {
+			f.SetString(code)
+		}
+	}
+	_, err := factory.DB(ctx).Insert(beans...)
+	return err
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-xorm/xorm"
+	"github.com/hublabs/common/auth"
+	"github.com/hublabs/product-api/factory"
+	"github.com/pangpanglabs/goutils/echomiddleware"
+
+	"github.com/pangpanglabs/goutils/test"
+)
+
+func TestTenantDB(t *testing.T) {
+	brand := Brand{Code: "tenant#1", Name: "tenant#1"}
+	test.Ok(t, brand.Create(ctx))
+	test.Equals(t, brand.TenantCode, "test")
+
+	other := WithUserClaim(ctx, auth.UserClaim{TenantCode: "other"})
+	b, err := Brand{}.GetById(other, brand.Id)
+	test.Ok(t, err)
+	test.Assert(t, b == nil, "the brand of tenant test is read by tenant other")
+
+	// a brand given another tenant is still created for the tenant of ctx
+	stamped := Brand{TenantCode: "test", Code: "tenant#1", Name: "tenant#1"}
+	test.Ok(t, stamped.Create(other))
+	test.Equals(t, stamped.TenantCode, "other")
+
+	none := WithUserClaim(ctx, auth.UserClaim{})
+	_, err = Brand{}.GetById(none, brand.Id)
+	test.Assert(t, errors.Is(err, ErrTenantRequired), "a request without a tenant is not refused")
+	_, _, _, err = Sku{}.GetAll(none, "", "", "", "", "", "", nil, nil, nil, 0, 10, nil, nil, nil, false)
+	test.Assert(t, errors.Is(err, ErrTenantRequired), "a request without a tenant is not refused")
+	test.Assert(t, errors.Is((&Brand{Code: "tenant#2"}).Create(none), ErrTenantRequired), "a brand is created without a tenant")
+}
+
+func TestWithUserClaim(t *testing.T) {
+	claim := auth.UserClaim{TenantCode: "other", ColleagueId: 7}
+	ctx := WithUserClaim(context.Background(), claim)
+	// the claim is where hublabs/common reads it
+	test.Equals(t, auth.UserClaim{}.FromCtx(ctx), claim)
+	test.Equals(t, tenantCode(ctx), "other")
+}
+
+// sqlRecorder is a logger keeping the statements an engine runs.
+type sqlRecorder struct {
+	xorm.DiscardLogger
+	statements []string
+}
+
+func (r *sqlRecorder) Infof(format string, v ...interface{}) {
+	if strings.HasPrefix(format, "[SQL]") {
+		r.statements = append(r.statements, fmt.Sprint(v[0]))
+	}
+}
+
+func (r *sqlRecorder) IsShowSQL() bool {
+	return true
+}
+
+var tableOfStatement = regexp.MustCompile("(?i)\\b(?:from|join|update)\\s+`?(\\w+)`?")
+
+// unscoped returns the statements reading, updating or deleting rows of a tenant without a condition on a tenant.
+func (r *sqlRecorder) unscoped() (statements []string) {
+	for _, s := range r.statements {
+		if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(s)), "INSERT") || strings.Contains(s, "tenant_code") {
+			continue
+		}
+		for _, m := range tableOfStatement.FindAllStringSubmatch(s, -1) {
+			if _, ok := tenantChildTables[m[1]]; ok || map[string]bool{"brand": true, "product": true, "sku": true, "price": true, "audit_log": true}[m[1]] {
+				statements = append(statements, s)
+				break
+			}
+		}
+	}
+	return
+}
+
+func TestTenantScopedQueries(t *testing.T) {
+	db, closeDB := barcodeDB(t)
+	defer closeDB()
+	session := db.NewSession()
+	defer session.Close()
+	ctx := WithUserClaim(context.WithValue(context.Background(), echomiddleware.ContextDBName, session), auth.UserClaim{TenantCode: "scope"})
+
+	brand := Brand{Code: "scope#1", Name: "scope#1"}
+	test.Ok(t, brand.Create(ctx))
+	other := Brand{Code: "scope#1", Name: "scope#1"}
+	test.Ok(t, other.Create(WithUserClaim(ctx, auth.UserClaim{TenantCode: "other"})))
+
+	recorder := &sqlRecorder{}
+	db.SetLogger(recorder)
+
+	product, err := Product{}.CreateOrUpdate(ctx, Product{
+		Code:        "P801",
+		Name:        "product#801",
+		Brand:       brand,
+		ListPrice:   100 * MajorUnit,
+		Attributes:  map[string]string{"Season": "SS"},
+		Identifiers: []ProductIdentifier{{Uid: "P801", Source: "erp"}},
+		Prices:      []Price{{TargetType: PriceTargetTypeProduct, SalePrice: 100 * MajorUnit}},
+		Skus: []Sku{{
+			Code:        "S801",
+			Name:        "sku#801",
+			Identifiers: []SkuIdentifier{{Uid: "2000000080017", Source: IdentifierSourceBarcode}},
+			Options:     []Option{{Name: "Color", Value: "Red"}},
+		}},
+	})
+	test.Ok(t, err)
+	product, err = Product{}.GetOne(ctx, product.Id, FieldTypeList{"attribute", "sku"})
+	test.Ok(t, err)
+	product.Name = "product#801-2"
+	product.Attributes = map[string]string{"Season": "FW"}
+	product.Skus[0].Options = []Option{{Name: "Color", Value: "Blue"}}
+	_, err = Product{}.CreateOrUpdate(ctx, *product)
+	test.Ok(t, err)
+
+	_, count, _, err := Product{}.GetAll(ctx, "", "", "", "scope#1", "", nil, nil, nil, 0, 10, nil, nil, nil, false)
+	test.Ok(t, err)
+	test.Equals(t, count, int64(1))
+	_, count, _, err = Sku{}.GetAll(ctx, "", "", "", "scope#1", "", "", nil, nil, nil, 0, 10, nil, nil, nil, false)
+	test.Ok(t, err)
+	test.Equals(t, count, int64(1))
+
+	_, err = Sku{}.Delete(ctx, product.Skus[0].Id)
+	test.Ok(t, err)
+	_, err = Sku{}.Restore(ctx, product.Skus[0].Id)
+	test.Ok(t, err)
+	_, err = Product{}.Delete(ctx, product.Id)
+	test.Ok(t, err)
+	_, err = Product{}.Restore(ctx, product.Id)
+	test.Ok(t, err)
+
+	test.Assert(t, len(recorder.statements) > 0, "no statement is recorded")
+	test.Equals(t, recorder.unscoped(), []string(nil))
+
+	// a scope which is not queried leaves the next query of the request alone
+	_, err = tenantDB(ctx, "brand")
+	test.Ok(t, err)
+	count, err = factory.DB(ctx).Where("code = ?", "scope#1").Count(&Brand{})
+	test.Ok(t, err)
+	test.Equals(t, count, int64(2))
+}
@@ -2,10 +2,13 @@ package models
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hublabs/common/auth"
 
@@ -135,6 +138,112 @@ func excludeDeleted(table string) string {
 	return fmt.Sprintf("`%s`.`deleted_at` IS NULL OR `%s`.`deleted_at`= '0001-01-01 00:00:00'", table, table)
 }
 
+// ErrVersionConflict is returned when a versioned row was changed by someone else since it was read.
+var ErrVersionConflict = errors.New("version conflict")
+
+// deletion is one delete of a row together with the rows removed with it,
+// whose batch is recorded on each of them so that they are restored together and nothing else is.
+type deletion struct {
+	At    time.Time
+	Batch string
+}
+
+func newDeletion() (deletion, error) {
+	batch, err := randomId()
+	if err != nil {
+		return deletion{}, err
+	}
+	return deletion{At: time.Now(), Batch: batch}, nil
+}
+
+// randomId is 32 hex digits, unique without asking the database.
+func randomId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// softDelete marks the live rows matching query as deleted.
+// bean carries the time and the batch of the deletion, which are written to every row it removes.
+func softDelete(ctx context.Context, bean interface{}, query string, args ...interface{}) error {
+	db, err := tenantTableDB(ctx, tableName(bean))
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	if err := db.Unscoped().Table(bean).Cols("id").Where(query, args...).
+		And("deleted_at IS NULL OR deleted_at = '0001-01-01 00:00:00'").
+		Find(&ids); err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return updateDeletedAt(ctx, bean, AuditActionDeleted, ids)
+}
+
+// restoreDeleted restores the rows matching query which were removed by deletion d.
+// Rows deleted before batches were recorded have none, and are told apart by the time they were deleted at.
+func restoreDeleted(ctx context.Context, bean interface{}, d deletion, query string, args ...interface{}) error {
+	var rows []struct {
+		Id          int64
+		DeletedAt   time.Time
+		DeleteBatch string
+	}
+	db, err := tenantTableDB(ctx, tableName(bean))
+	if err != nil {
+		return err
+	}
+	if err := db.Unscoped().Table(bean).Select("id, deleted_at, delete_batch").Where(query, args...).Find(&rows); err != nil {
+		return err
+	}
+	var ids []int64
+	for _, row := range rows {
+		if d.removed(row.DeletedAt, row.DeleteBatch) {
+			ids = append(ids, row.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return updateDeletedAt(ctx, bean, AuditActionRestored, ids)
+}
+
+// removed tells whether a row deleted at deletedAt in batch was removed by d, a live row was not.
+func (d deletion) removed(deletedAt time.Time, batch string) bool {
+	if deletedAt.IsZero() {
+		return false
+	}
+	if d.Batch == "" {
+		return batch == "" && deletedAt.Equal(d.At)
+	}
+	return batch == d.Batch
+}
+
+func updateDeletedAt(ctx context.Context, bean interface{}, action AuditAction, ids []int64) error {
+	entity, audited := auditEntityOf(bean)
+	var before auditRows
+	if audited {
+		var err error
+		if before, err = auditSnapshot(ctx, entity, ids...); err != nil {
+			return err
+		}
+	}
+	db, err := tenantTableDB(ctx, tableName(bean))
+	if err != nil {
+		return err
+	}
+	if _, err := db.Unscoped().In("id", ids).Cols("deleted_at", "delete_batch").Update(bean); err != nil {
+		return err
+	}
+	if !audited {
+		return nil
+	}
+	return writeAudit(ctx, entity, action, before, ids...)
+}
+
 func tenantCode(ctx context.Context) string {
 	user := auth.UserClaim{}.FromCtx(ctx)
 	return user.TenantCode
@@ -152,6 +261,15 @@ func appendStrArgs(args []interface{}, isNum bool, ts ...string) []interface{} {
 	return args
 }
 
+// appendMoneyArgs compares money columns with exact amounts, an amount which does not parse is compared as 0 like a number.
+func appendMoneyArgs(args []interface{}, ts ...string) []interface{} {
+	for _, t := range ts {
+		m, _ := ParseMoneyRounded(t, RoundingHalfUp)
+		args = append(args, m.dbValue())
+	}
+	return args
+}
+
 func placeholder(length int) string {
 	placeholder := strings.Repeat("?,", length)
 	return placeholder[:len(placeholder)-1]
@@ -180,6 +298,13 @@ func (p ProductImportTemplate) ToProduct(tenantCode string, brandId int64) (Prod
 		Identifiers: identifiers,
 		Options:     options,
 	}
+	if p.SkuSalePrice != 0 {
+		sku.Price = &Price{
+			TenantCode: tenantCode,
+			TargetType: PriceTargetTypeSku,
+			SalePrice:  p.SkuSalePrice,
+		}
+	}
 	price := Price{
 		TenantCode: tenantCode,
 		TargetType: PriceTargetTypeProduct,